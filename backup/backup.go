@@ -36,20 +36,26 @@ func initializeFlags(cmd *cobra.Command) {
 func SetFlagDefaults(flagSet *pflag.FlagSet) {
 	flagSet.String(utils.BACKUP_DIR, "", "The absolute path of the directory to which all backup files will be written")
 	flagSet.Int(utils.COMPRESSION_LEVEL, 1, "Level of compression to use during data backup. Valid values are between 1 and 9.")
+	flagSet.Int(utils.COMPRESSION_WORKERS, 1, "The number of goroutines each gpbackup_helper uses to compress data with --single-data-file")
 	flagSet.Bool(utils.DATA_ONLY, false, "Only back up data, do not back up metadata")
 	flagSet.String(utils.DBNAME, "", "The database to be backed up")
 	flagSet.Bool(utils.DEBUG, false, "Print verbose and debug log messages")
+	flagSet.StringSlice(utils.EXCLUDE_OBJECT_TYPE, []string{}, "Back up all metadata except objects of the specified type(s), e.g. TRIGGER. --exclude-object-type can be specified multiple times.")
 	flagSet.StringSlice(utils.EXCLUDE_SCHEMA, []string{}, "Back up all metadata except objects in the specified schema(s). --exclude-schema can be specified multiple times.")
 	flagSet.StringSlice(utils.EXCLUDE_RELATION, []string{}, "Back up all metadata except the specified table(s). --exclude-table can be specified multiple times.")
 	flagSet.String(utils.EXCLUDE_RELATION_FILE, "", "A file containing a list of fully-qualified tables to be excluded from the backup")
 	flagSet.String(utils.FROM_TIMESTAMP, "", "A timestamp to use to base the current incremental backup off")
 	flagSet.Bool("help", false, "Help for gpbackup")
+	flagSet.StringSlice(utils.INCLUDE_OBJECT_TYPE, []string{}, "Back up only metadata for objects of the specified type(s), e.g. FUNCTION. --include-object-type can be specified multiple times.")
 	flagSet.StringSlice(utils.INCLUDE_SCHEMA, []string{}, "Back up only the specified schema(s). --include-schema can be specified multiple times.")
 	flagSet.StringArray(utils.INCLUDE_RELATION, []string{}, "Back up only the specified table(s). --include-table can be specified multiple times.")
 	flagSet.String(utils.INCLUDE_RELATION_FILE, "", "A file containing a list of fully-qualified tables to be included in the backup")
 	flagSet.Bool(utils.INCREMENTAL, false, "Only back up data for AO tables that have been modified since the last backup")
 	flagSet.Int(utils.JOBS, 1, "The number of parallel connections to use when backing up data")
 	flagSet.Bool(utils.LEAF_PARTITION_DATA, false, "For partition tables, create one data file per leaf partition instead of one data file for the whole table")
+	flagSet.Int(utils.LOCK_BATCH_SIZE, 1, "The number of tables to lock with each LOCK TABLE statement")
+	flagSet.Int(utils.LOCK_RETRIES, 0, "The number of times to retry locking a batch of tables that could not be locked within --lock-wait-timeout")
+	flagSet.Int(utils.LOCK_WAIT_TIMEOUT, 0, "The number of seconds to wait to lock a batch of tables before reporting the blocking sessions and retrying or failing. The default of 0 waits indefinitely.")
 	flagSet.Bool(utils.METADATA_ONLY, false, "Only back up metadata, do not back up data")
 	flagSet.Bool(utils.NO_COMPRESSION, false, "Disable compression of data files")
 	flagSet.String(utils.PLUGIN_CONFIG, "", "The configuration file to use for a plugin")
@@ -154,6 +160,7 @@ func DoBackup() {
 
 	gplog.Info("Gathering table state information")
 	metadataTables, dataTables := RetrieveAndProcessTables()
+	backupReport.SynchronizedSnapshot = SynchronizeSnapshots()
 	if !(MustGetFlagBool(utils.METADATA_ONLY) || MustGetFlagBool(utils.DATA_ONLY)) {
 		BackupIncrementalMetadata()
 	}
@@ -221,16 +228,30 @@ func DoBackup() {
 func backupGlobal(metadataFile *utils.FileWithByteCount) {
 	gplog.Info("Writing global database metadata")
 
-	BackupResourceQueues(metadataFile)
-	if connectionPool.Version.AtLeast("5") {
+	if shouldBackupObjectType("RESOURCE QUEUE") {
+		BackupResourceQueues(metadataFile)
+	}
+	if connectionPool.Version.AtLeast("5") && shouldBackupObjectType("RESOURCE GROUP") {
 		BackupResourceGroups(metadataFile)
 	}
-	BackupRoles(metadataFile)
-	BackupRoleGrants(metadataFile)
-	BackupTablespaces(metadataFile)
-	BackupCreateDatabase(metadataFile)
-	BackupDatabaseGUCs(metadataFile)
-	BackupRoleGUCs(metadataFile)
+	if shouldBackupObjectType("ROLE") {
+		BackupRoles(metadataFile)
+	}
+	if shouldBackupObjectType("ROLE GRANT") {
+		BackupRoleGrants(metadataFile)
+	}
+	if shouldBackupObjectType("TABLESPACE") {
+		BackupTablespaces(metadataFile)
+	}
+	if shouldBackupObjectType("DATABASE") {
+		BackupCreateDatabase(metadataFile)
+	}
+	if shouldBackupObjectType("DATABASE GUC") {
+		BackupDatabaseGUCs(metadataFile)
+	}
+	if shouldBackupObjectType("ROLE GUCS") {
+		BackupRoleGUCs(metadataFile)
+	}
 
 	if wasTerminated {
 		gplog.Info("Global database metadata backup incomplete")
@@ -255,19 +276,22 @@ func backupPredata(metadataFile *utils.FileWithByteCount, tables []Table, tableO
 	funcInfoMap := GetFunctionOidToInfoMap(connectionPool)
 
 	if !tableOnly {
-		BackupSchemas(metadataFile)
-		if len(MustGetFlagStringSlice(utils.INCLUDE_SCHEMA)) == 0 && connectionPool.Version.AtLeast("5") {
+		if shouldBackupObjectType("SCHEMA") {
+			BackupSchemas(metadataFile)
+		}
+		if len(MustGetFlagStringSlice(utils.INCLUDE_SCHEMA)) == 0 && connectionPool.Version.AtLeast("5") &&
+			shouldBackupObjectType("EXTENSION") {
 			BackupExtensions(metadataFile)
 		}
 
-		if connectionPool.Version.AtLeast("6") {
+		if connectionPool.Version.AtLeast("6") && shouldBackupObjectType("COLLATION") {
 			BackupCollations(metadataFile)
 		}
 
 		procLangs := GetProceduralLanguages(connectionPool)
 		langFuncs, functionMetadata := RetrieveFunctions(&sortables, metadataMap, procLangs)
 
-		if len(MustGetFlagStringSlice(utils.INCLUDE_SCHEMA)) == 0 {
+		if len(MustGetFlagStringSlice(utils.INCLUDE_SCHEMA)) == 0 && shouldBackupObjectType("LANGUAGE") {
 			BackupProceduralLanguages(metadataFile, procLangs, langFuncs, functionMetadata, funcInfoMap)
 		}
 		RetrieveAndBackupTypes(metadataFile, &sortables, metadataMap)
@@ -287,7 +311,9 @@ func backupPredata(metadataFile *utils.FileWithByteCount, tables []Table, tableO
 			RetrieveTSTemplates(&sortables, metadataMap)
 			RetrieveTSDictionaries(&sortables, metadataMap)
 
-			BackupOperatorFamilies(metadataFile)
+			if shouldBackupObjectType("OPERATOR FAMILY") {
+				BackupOperatorFamilies(metadataFile)
+			}
 		}
 
 		RetrieveOperators(&sortables, metadataMap)
@@ -297,16 +323,31 @@ func backupPredata(metadataFile *utils.FileWithByteCount, tables []Table, tableO
 	}
 
 	RetrieveViews(&sortables)
+	if connectionPool.Version.AtLeast("6") {
+		RetrieveMaterializedViews(&sortables)
+	}
 	sequences, sequenceOwnerColumns := RetrieveSequences()
-	BackupCreateSequences(metadataFile, sequences, relationMetadata)
+	if shouldBackupObjectType("SEQUENCE") {
+		BackupCreateSequences(metadataFile, sequences, relationMetadata)
+	}
 	constraints, conMetadata := RetrieveConstraints()
 
+	sortables = FilterSortablesByObjectType(sortables, getObjectTypeFilterSet())
 	BackupDependentObjects(metadataFile, tables, protocols, metadataMap, constraints, sortables, funcInfoMap, tableOnly)
 
-	PrintAlterSequenceStatements(metadataFile, globalTOC, sequences, sequenceOwnerColumns)
+	if shouldBackupObjectType("SEQUENCE OWNER") {
+		PrintAlterSequenceStatements(metadataFile, globalTOC, sequences, sequenceOwnerColumns)
+	}
+	if shouldBackupObjectType("TABLE") {
+		BackupColumnDefaultSequenceDependencies()
+	}
 
-	BackupConversions(metadataFile)
-	BackupConstraints(metadataFile, constraints, conMetadata)
+	if shouldBackupObjectType("CONVERSION") {
+		BackupConversions(metadataFile)
+	}
+	if shouldBackupObjectType("CONSTRAINT") {
+		BackupConstraints(metadataFile, constraints, conMetadata)
+	}
 	if wasTerminated {
 		gplog.Info("Pre-data metadata backup incomplete")
 	} else {
@@ -337,9 +378,11 @@ func backupData(tables []Table) {
 		if MustGetFlagBool(utils.NO_COMPRESSION) {
 			compressStr = " --compression-level 0"
 		}
+		compressStr += fmt.Sprintf(" --compression-workers %d", MustGetFlagInt(utils.COMPRESSION_WORKERS))
 		// Do not pass through the --on-error-continue flag because it does not apply to gpbackup
 		utils.StartGpbackupHelpers(globalCluster, globalFPInfo, "--backup-agent",
-			MustGetFlagString(utils.PLUGIN_CONFIG), compressStr, false)
+			MustGetFlagString(utils.PLUGIN_CONFIG), compressStr, false, false, 0, 0)
+		helperAgents = utils.ConnectToHelperAgents(globalCluster, globalFPInfo, 1)
 	}
 	gplog.Info("Writing data to file")
 	rowsCopiedMaps := BackupDataForAllTables(tables)
@@ -360,12 +403,20 @@ func backupPostdata(metadataFile *utils.FileWithByteCount) {
 	}
 	gplog.Info("Writing post-data metadata")
 
-	BackupIndexes(metadataFile)
-	BackupRules(metadataFile)
-	BackupTriggers(metadataFile)
+	if shouldBackupObjectType("INDEX") {
+		BackupIndexes(metadataFile)
+	}
+	if shouldBackupObjectType("RULE") {
+		BackupRules(metadataFile)
+	}
+	if shouldBackupObjectType("TRIGGER") {
+		BackupTriggers(metadataFile)
+	}
 	if connectionPool.Version.AtLeast("6") {
-		BackupDefaultPrivileges(metadataFile)
-		if len(MustGetFlagStringSlice(utils.INCLUDE_SCHEMA)) == 0 {
+		if shouldBackupObjectType("DEFAULT PRIVILEGES") {
+			BackupDefaultPrivileges(metadataFile)
+		}
+		if len(MustGetFlagStringSlice(utils.INCLUDE_SCHEMA)) == 0 && shouldBackupObjectType("EVENT TRIGGER") {
 			BackupEventTriggers(metadataFile)
 		}
 	}
@@ -483,6 +534,9 @@ func DoCleanup(backupFailed bool) {
 	gplog.Verbose("Beginning cleanup")
 	if globalFPInfo.Timestamp != "" {
 		if MustGetFlagBool(utils.SINGLE_DATA_FILE) {
+			if helperAgents != nil {
+				helperAgents.Stop()
+			}
 			if backupFailed {
 				// Cleanup only if terminated or fataled
 				utils.CleanUpSegmentHelperProcesses(globalCluster, globalFPInfo, "backup")
@@ -491,7 +545,7 @@ func DoCleanup(backupFailed bool) {
 				// It is possible for the COPY command to become orphaned if an agent process is killed
 				utils.TerminateHangingCopySessions(connectionPool, globalFPInfo, "gpbackup")
 			}
-			utils.CleanUpHelperFilesOnAllHosts(globalCluster, globalFPInfo)
+			utils.CleanUpHelperFilesOnAllHosts(globalCluster, globalFPInfo, 1)
 		}
 	}
 	err := backupLockFile.Unlock()
@@ -2,6 +2,7 @@ package backup
 
 import (
 	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/utils"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/gbytes"
@@ -21,4 +22,35 @@ var _ = Describe("backup internal tests", func() {
 			Expect(string(log.Contents())).To(ContainSubstring("Data backup complete"))
 		})
 	})
+	Describe("MetadataParams.flagSet", func() {
+		It("sets the flags for the filters", func() {
+			params := MetadataParams{
+				ExcludeObjectTypes: []string{"TRIGGER", "RULE"},
+				ExcludeSchemas:     []string{"schema1"},
+				IncludeRelations:   []string{"public.foo", "public.\"comma,table\""},
+			}
+
+			flagSet := params.flagSet()
+
+			Expect(utils.MustGetFlagStringSlice(flagSet, utils.EXCLUDE_OBJECT_TYPE)).To(Equal([]string{"TRIGGER", "RULE"}))
+			Expect(utils.MustGetFlagStringSlice(flagSet, utils.EXCLUDE_SCHEMA)).To(Equal([]string{"schema1"}))
+			Expect(utils.MustGetFlagStringArray(flagSet, utils.INCLUDE_RELATION)).To(Equal([]string{"public.foo", "public.\"comma,table\""}))
+			Expect(utils.MustGetFlagStringSlice(flagSet, utils.INCLUDE_SCHEMA)).To(BeEmpty())
+		})
+	})
+	Describe("restorePackageState", func() {
+		It("restores the package variables set for WriteDatabaseMetadata", func() {
+			toc := &utils.TOC{}
+			globalTOC = toc
+			filterRelationClause = "AND c.oid IN (1)"
+			state := savePackageState()
+			globalTOC = &utils.TOC{}
+			filterRelationClause = ""
+
+			restorePackageState(state)
+
+			Expect(globalTOC).To(BeIdenticalTo(toc))
+			Expect(filterRelationClause).To(Equal("AND c.oid IN (1)"))
+		})
+	})
 })
@@ -42,7 +42,7 @@ func AddTableDataEntriesToTOC(tables []Table, rowsCopiedMaps []map[uint32]int64)
 				}
 			}
 			attributes := ConstructTableAttributesList(table.ColumnDefs)
-			globalTOC.AddMasterDataEntry(table.Schema, table.Name, table.Oid, attributes, rowsCopied, table.PartitionLevelInfo.RootName)
+			globalTOC.AddMasterDataEntry(table.Schema, table.Name, table.Oid, attributes, rowsCopied, table.PartitionLevelInfo.RootName, table.DistPolicy == "DISTRIBUTED REPLICATED")
 		}
 	}
 }
@@ -157,7 +157,12 @@ func BackupDataForAllTables(tables []Table) []map[uint32]int64 {
 
 	var agentErr error
 	if MustGetFlagBool(utils.SINGLE_DATA_FILE) {
-		agentErr = utils.CheckAgentErrorsOnSegments(globalCluster, globalFPInfo)
+		// An agent waits for the next table forever if a COPY failed or was canceled
+		if copyErr == nil && !wasTerminated {
+			agentErr = helperAgents.WaitForAgents()
+		} else {
+			agentErr = helperAgents.CheckErrors()
+		}
 	}
 
 	if copyErr != nil && agentErr != nil {
@@ -185,6 +190,10 @@ func printDataBackupWarnings(numExtTables int64) {
 }
 
 func CheckTablesContainData(tables []Table) {
+	if !backupReport.MetadataOnly && !shouldBackupObjectType("TABLE") {
+		gplog.Info("TABLE objects are excluded by the object type filter. Performing metadata-only backup instead.")
+		backupReport.MetadataOnly = true
+	}
 	if !backupReport.MetadataOnly {
 		for _, table := range tables {
 			if !table.SkipDataBackup() {
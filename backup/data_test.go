@@ -220,5 +220,15 @@ var _ = Describe("backup/data tests", func() {
 			backup.CheckTablesContainData([]backup.Table{testTable})
 			Expect(backup.GetReport().BackupConfig.MetadataOnly).To(BeFalse())
 		})
+		It("changes backup type to metadata if TABLE is excluded by the object type filter", func() {
+			_ = cmdFlags.Set(utils.EXCLUDE_OBJECT_TYPE, "TABLE")
+			backup.CheckTablesContainData([]backup.Table{testTable})
+			Expect(backup.GetReport().BackupConfig.MetadataOnly).To(BeTrue())
+		})
+		It("changes backup type to metadata if TABLE is not among the included object types", func() {
+			_ = cmdFlags.Set(utils.INCLUDE_OBJECT_TYPE, "FUNCTION,VIEW")
+			backup.CheckTablesContainData([]backup.Table{testTable})
+			Expect(backup.GetReport().BackupConfig.MetadataOnly).To(BeTrue())
+		})
 	})
 })
@@ -0,0 +1,141 @@
+package backup
+
+/*
+ * This file contains functions that generate the metadata of a database in
+ * memory, with the same queries and statements as a backup but without
+ * writing any files, so that it can be compared to the metadata of a backup.
+ */
+
+import (
+	"io"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gpbackup/backup_history"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/spf13/pflag"
+)
+
+/*
+ * MetadataParams holds the filters that determine which objects' metadata
+ * WriteDatabaseMetadata writes, as the flags with the same names do for a
+ * backup.
+ */
+type MetadataParams struct {
+	ExcludeObjectTypes []string
+	ExcludeRelations   []string
+	ExcludeSchemas     []string
+	IncludeObjectTypes []string
+	IncludeRelations   []string
+	IncludeSchemas     []string
+}
+
+/*
+ * Returns the filters with which the backup described by config was taken,
+ * so that the metadata of the database is filtered in the same way as that
+ * of the backup.
+ */
+func NewMetadataParams(config *backup_history.BackupConfig) MetadataParams {
+	return MetadataParams{
+		ExcludeObjectTypes: config.ExcludeObjectTypes,
+		ExcludeRelations:   config.ExcludeRelations,
+		ExcludeSchemas:     config.ExcludeSchemas,
+		IncludeObjectTypes: config.IncludeObjectTypes,
+		IncludeRelations:   config.IncludeRelations,
+		IncludeSchemas:     config.IncludeSchemas,
+	}
+}
+
+func (params MetadataParams) flagSet() *pflag.FlagSet {
+	flagSet := pflag.NewFlagSet("gpbackup", pflag.ContinueOnError)
+	SetFlagDefaults(flagSet)
+	sliceFlags := map[string][]string{
+		utils.EXCLUDE_OBJECT_TYPE: params.ExcludeObjectTypes,
+		utils.EXCLUDE_RELATION:    params.ExcludeRelations,
+		utils.EXCLUDE_SCHEMA:      params.ExcludeSchemas,
+		utils.INCLUDE_OBJECT_TYPE: params.IncludeObjectTypes,
+		utils.INCLUDE_SCHEMA:      params.IncludeSchemas,
+	}
+	for flagName, values := range sliceFlags {
+		if len(values) > 0 {
+			err := flagSet.Set(flagName, strings.Join(values, ","))
+			gplog.FatalOnError(err)
+		}
+	}
+	// Table names may contain commas, so each one is added to the array separately
+	for _, relation := range params.IncludeRelations {
+		err := flagSet.Set(utils.INCLUDE_RELATION, relation)
+		gplog.FatalOnError(err)
+	}
+	return flagSet
+}
+
+/*
+ * Writes the metadata of the database to writer as a metadata-only backup
+ * with the given filters would, and returns the table of contents describing
+ * it.  The metadata is read in a single transaction, which is committed once
+ * the metadata has been written, and no tables are locked, as no data is
+ * read.
+ *
+ * The functions shared with gpbackup read the connection, flags and table of
+ * contents from package variables, so these are set only for the duration of
+ * the call and the previous values are restored before it returns.
+ */
+func WriteDatabaseMetadata(conn *dbconn.DBConn, params MetadataParams, writer io.Writer) *utils.TOC {
+	defer restorePackageState(savePackageState())
+	connectionPool = conn
+	cmdFlags = params.flagSet()
+	filterRelationClause = ""
+	objectCounts = make(map[string]int)
+	toc := &utils.TOC{}
+	toc.InitializeMetadataEntryMap()
+	globalTOC = toc
+
+	InitializeMetadataParams(connectionPool)
+	connectionPool.MustBegin(0)
+	SetSessionGUCs(0)
+	opts, err := options.NewOptions(cmdFlags)
+	gplog.FatalOnError(err)
+	err = opts.ExpandIncludesForPartitions(connectionPool, cmdFlags)
+	gplog.FatalOnError(err)
+
+	metadataTables, _ := retrieveTables(false)
+	metadataFile := utils.NewFileWithByteCount(writer)
+	BackupSessionGUCs(metadataFile)
+	tableOnly := len(MustGetFlagStringArray(utils.INCLUDE_RELATION)) > 0
+	if !tableOnly {
+		backupGlobal(metadataFile)
+	}
+	backupPredata(metadataFile, metadataTables, tableOnly)
+	backupPostdata(metadataFile)
+	connectionPool.MustCommit(0)
+	return toc
+}
+
+type packageState struct {
+	connectionPool       *dbconn.DBConn
+	cmdFlags             *pflag.FlagSet
+	filterRelationClause string
+	objectCounts         map[string]int
+	globalTOC            *utils.TOC
+}
+
+func savePackageState() packageState {
+	return packageState{
+		connectionPool:       connectionPool,
+		cmdFlags:             cmdFlags,
+		filterRelationClause: filterRelationClause,
+		objectCounts:         objectCounts,
+		globalTOC:            globalTOC,
+	}
+}
+
+func restorePackageState(state packageState) {
+	connectionPool = state.connectionPool
+	cmdFlags = state.cmdFlags
+	filterRelationClause = state.filterRelationClause
+	objectCounts = state.objectCounts
+	globalTOC = state.globalTOC
+}
@@ -0,0 +1,27 @@
+package backup_test
+
+import (
+	"github.com/greenplum-db/gpbackup/backup"
+	"github.com/greenplum-db/gpbackup/backup_history"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("backup/database_metadata tests", func() {
+	Describe("NewMetadataParams", func() {
+		It("takes the filters with which the backup was taken", func() {
+			config := &backup_history.BackupConfig{
+				ExcludeObjectTypes: []string{"TRIGGER", "RULE"},
+				ExcludeSchemas:     []string{"schema1"},
+				IncludeRelations:   []string{"public.foo", "public.\"comma,table\""},
+			}
+
+			params := backup.NewMetadataParams(config)
+
+			Expect(params.ExcludeObjectTypes).To(Equal([]string{"TRIGGER", "RULE"}))
+			Expect(params.ExcludeSchemas).To(Equal([]string{"schema1"}))
+			Expect(params.IncludeRelations).To(Equal([]string{"public.foo", "public.\"comma,table\""}))
+			Expect(params.IncludeSchemas).To(BeEmpty())
+		})
+	})
+})
@@ -18,6 +18,7 @@ import (
  *   - Types
  *   - Tables
  *   - Protocols
+ *   - Views and materialized views
  */
 func AddProtocolDependenciesForGPDB4(depMap DependencyMap, tables []Table, protocols []ExternalProtocol) {
 	protocolMap := make(map[string]UniqueID, len(protocols))
@@ -162,6 +163,25 @@ func TopologicalSort(slice []Sortable, dependencies DependencyMap) []Sortable {
 	return sorted
 }
 
+/*
+ * Objects excluded by the --include-object-type and --exclude-object-type flags
+ * are removed before sorting, so that they are neither printed nor considered
+ * when resolving dependencies among the remaining objects.
+ */
+func FilterSortablesByObjectType(sortables []Sortable, objectSet *utils.FilterSet) []Sortable {
+	filteredSortables := make([]Sortable, 0)
+	for _, sortable := range sortables {
+		if tocObject, ok := sortable.(utils.TOCObject); ok {
+			_, entry := tocObject.GetMetadataEntry()
+			if !objectSet.MatchesFilter(entry.ObjectType) {
+				continue
+			}
+		}
+		filteredSortables = append(filteredSortables, sortable)
+	}
+	return filteredSortables
+}
+
 type DependencyMap map[UniqueID]map[UniqueID]bool
 
 type UniqueID struct {
@@ -235,6 +255,46 @@ AND typelem != 0`)
 	return dependencyMap
 }
 
+/*
+ * The dependency graph used for sorting is also written to the TOC, so that
+ * gprestore --include-dependencies can find the objects that a filtered set
+ * of tables needs in order to be created.  Objects that do not have a TOC
+ * entry of their own are left out.
+ */
+func AddDependenciesToTOC(toc *utils.TOC, sortables []Sortable, dependencies DependencyMap) {
+	keyForUniqueID := make(map[UniqueID]string, len(sortables))
+	for _, sortable := range sortables {
+		if tocObject, ok := sortable.(utils.TOCObject); ok {
+			_, entry := tocObject.GetMetadataEntry()
+			keyForUniqueID[sortable.GetUniqueID()] = entry.DependencyKey()
+		}
+	}
+	for object, references := range dependencies {
+		objectKey, ok := keyForUniqueID[object]
+		if !ok {
+			continue
+		}
+		for reference := range references {
+			if referenceKey, ok := keyForUniqueID[reference]; ok {
+				toc.AddDependency(objectKey, referenceKey)
+			}
+		}
+	}
+}
+
+/*
+ * Sequences are created before the sorted objects and are not part of the
+ * dependency graph, so tables using them in column defaults are recorded
+ * separately.
+ */
+func AddColumnDefaultSequenceDependenciesToTOC(toc *utils.TOC, defaultSequences []ColumnDefaultSequence) {
+	for _, defaultSequence := range defaultSequences {
+		tableKey := utils.MetadataEntry{Schema: defaultSequence.TableSchema, Name: defaultSequence.TableName, ObjectType: "TABLE"}.DependencyKey()
+		sequenceKey := utils.MetadataEntry{Schema: defaultSequence.SequenceSchema, Name: defaultSequence.SequenceName, ObjectType: "SEQUENCE"}.DependencyKey()
+		toc.AddDependency(tableKey, sequenceKey)
+	}
+}
+
 func breakCircularDependencies(depMap DependencyMap) {
 	for entry, deps := range depMap {
 		for dep := range deps {
@@ -275,6 +335,8 @@ func PrintDependentObjectStatements(metadataFile *utils.FileWithByteCount, toc *
 			PrintCreateExternalProtocolStatement(metadataFile, toc, obj, funcInfoMap, objMetadata)
 		case View:
 			PrintCreateViewStatement(metadataFile, toc, obj, objMetadata)
+		case MaterializedView:
+			PrintCreateMaterializedViewStatement(metadataFile, toc, obj, objMetadata)
 		case TextSearchParser:
 			PrintCreateTextSearchParserStatement(metadataFile, toc, obj, objMetadata)
 		case TextSearchConfiguration:
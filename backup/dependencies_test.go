@@ -4,6 +4,7 @@ import (
 	"github.com/greenplum-db/gp-common-go-libs/testhelper"
 	"github.com/greenplum-db/gpbackup/backup"
 	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/utils"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -94,6 +95,60 @@ var _ = Describe("backup/dependencies tests", func() {
 			sortable = backup.TopologicalSort(sortable, depMap)
 		})
 	})
+	Describe("FilterSortablesByObjectType", func() {
+		function := backup.Function{Oid: 1, Schema: "public", Name: "function"}
+		view := backup.View{Oid: 2, Schema: "public", Name: "view"}
+		table := backup.Table{Relation: backup.Relation{Oid: 3, Schema: "public", Name: "table"}}
+		sortables := []backup.Sortable{function, view, table}
+
+		It("returns all objects if no object types are specified", func() {
+			result := backup.FilterSortablesByObjectType(sortables, utils.NewObjectTypeFilterSet([]string{}, []string{}))
+
+			Expect(result).To(Equal([]backup.Sortable{function, view, table}))
+		})
+		It("returns only objects with an included object type", func() {
+			result := backup.FilterSortablesByObjectType(sortables, utils.NewObjectTypeFilterSet([]string{"FUNCTION", "VIEW"}, []string{}))
+
+			Expect(result).To(Equal([]backup.Sortable{function, view}))
+		})
+		It("returns only objects without an excluded object type", func() {
+			result := backup.FilterSortablesByObjectType(sortables, utils.NewObjectTypeFilterSet([]string{}, []string{"TABLE"}))
+
+			Expect(result).To(Equal([]backup.Sortable{function, view}))
+		})
+	})
+	Describe("AddDependenciesToTOC", func() {
+		It("records dependencies between objects using their TOC entries", func() {
+			function := backup.Function{Oid: 1, Schema: "public", Name: "function", IdentArgs: "integer"}
+			baseType := backup.BaseType{Oid: 2, Schema: "public", Name: "base_type"}
+			view := backup.View{Oid: 3, Schema: "public", Name: "view"}
+			sortables := []backup.Sortable{function, baseType, view, relation1}
+			deps := backup.DependencyMap{
+				function.GetUniqueID():  {baseType.GetUniqueID(): true},
+				view.GetUniqueID():      {function.GetUniqueID(): true, relation1.GetUniqueID(): true},
+				relation1.GetUniqueID(): {baseType.GetUniqueID(): true},
+			}
+
+			backup.AddDependenciesToTOC(toc, sortables, deps)
+
+			Expect(toc.Dependencies).To(Equal(map[string][]string{
+				"FUNCTION public.function(integer)": {"TYPE public.base_type"},
+				"VIEW public.view":                  {"FUNCTION public.function(integer)"},
+			}))
+		})
+	})
+	Describe("AddColumnDefaultSequenceDependenciesToTOC", func() {
+		It("records a dependency from each table on the sequences used in its column defaults", func() {
+			defaultSequences := []backup.ColumnDefaultSequence{
+				{TableSchema: "public", TableName: "table1", SequenceSchema: "public", SequenceName: "seq1"},
+				{TableSchema: "public", TableName: "table1", SequenceSchema: "other", SequenceName: "seq2"},
+			}
+
+			backup.AddColumnDefaultSequenceDependenciesToTOC(toc, defaultSequences)
+
+			Expect(toc.Dependencies).To(Equal(map[string][]string{"TABLE public.table1": {"SEQUENCE other.seq2", "SEQUENCE public.seq1"}}))
+		})
+	})
 	Describe("ConstructDependentObjectMetadataMap", func() {
 		It("composes metadata maps for functions, types, and tables into one map", func() {
 			funcMap := backup.MetadataMap{backup.UniqueID{Oid: 1}: backup.ObjectMetadata{Comment: "function"}}
@@ -2,6 +2,7 @@ package backup
 
 import (
 	"sync"
+	"time"
 
 	"github.com/greenplum-db/gp-common-go-libs/cluster"
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
@@ -26,6 +27,7 @@ var (
 	globalCluster        *cluster.Cluster
 	globalFPInfo         backup_filepath.FilePathInfo
 	globalTOC            *utils.TOC
+	helperAgents         *utils.HelperAgents
 	objectCounts         map[string]int
 	pluginConfig         *utils.PluginConfig
 	version              string
@@ -86,6 +88,10 @@ func SetVersion(v string) {
 	version = v
 }
 
+func SetLockRetryDelay(delay time.Duration) {
+	lockRetryDelay = delay
+}
+
 func SetFilterRelationClause(v string) {
 	filterRelationClause = v
 }
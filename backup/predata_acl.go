@@ -218,7 +218,7 @@ func ParseACL(aclStr string, quotedRoleNames map[string]string) *ACL {
 func (obj ObjectMetadata) GetPrivilegesStatements(objectName string, objectType string, columnName ...string) string {
 	statements := []string{}
 	typeStr := fmt.Sprintf("%s ", objectType)
-	if objectType == "VIEW" || objectType == "FOREIGN TABLE" {
+	if objectType == "VIEW" || objectType == "MATERIALIZED VIEW" || objectType == "FOREIGN TABLE" {
 		typeStr = ""
 	} else if objectType == "COLUMN" {
 		typeStr = "TABLE "
@@ -306,7 +306,7 @@ func createPrivilegeStrings(acl ACL, objectType string) (string, string) {
 	case "TYPE":
 		hasAllPrivileges = acl.Usage
 		hasAllPrivilegesWithGrant = acl.UsageWithGrant
-	case "VIEW":
+	case "VIEW", "MATERIALIZED VIEW":
 		hasAllPrivileges = acl.Select && acl.Insert && acl.Update && acl.Delete && acl.Truncate && acl.References && acl.Trigger
 		hasAllPrivilegesWithGrant = acl.SelectWithGrant && acl.InsertWithGrant && acl.UpdateWithGrant && acl.DeleteWithGrant &&
 			acl.TruncateWithGrant && acl.ReferencesWithGrant && acl.TriggerWithGrant
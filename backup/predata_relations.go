@@ -334,3 +334,27 @@ func PrintCreateViewStatement(metadataFile *utils.FileWithByteCount, toc *utils.
 	toc.AddMetadataEntry(section, entry, start, metadataFile.ByteCount)
 	PrintObjectMetadata(metadataFile, toc, viewMetadata, view, "")
 }
+
+/*
+ * Materialized views are always created without data, as their data is not
+ * backed up; gprestore --refresh-materialized-views populates them once the
+ * tables they are based on have been restored.
+ */
+func PrintCreateMaterializedViewStatement(metadataFile *utils.FileWithByteCount, toc *utils.TOC, mview MaterializedView, mviewMetadata ObjectMetadata) {
+	start := metadataFile.ByteCount
+	tablespaceStr := ""
+	if mview.Tablespace != "" {
+		tablespaceStr = fmt.Sprintf(" TABLESPACE %s", mview.Tablespace)
+	}
+	distPolicyStr := ""
+	if mview.DistPolicy != "" {
+		distPolicyStr = fmt.Sprintf(" %s", mview.DistPolicy)
+	}
+	// The definition ends with a semicolon, which has to come after the WITH NO DATA clause
+	definition := strings.TrimSuffix(strings.TrimSpace(mview.Definition), ";")
+	metadataFile.MustPrintf("\n\nCREATE MATERIALIZED VIEW %s%s%s AS %s\nWITH NO DATA%s;\n", mview.FQN(), mview.Options, tablespaceStr, definition, distPolicyStr)
+
+	section, entry := mview.GetMetadataEntry()
+	toc.AddMetadataEntry(section, entry, start, metadataFile.ByteCount)
+	PrintObjectMetadata(metadataFile, toc, mviewMetadata, mview, "")
+}
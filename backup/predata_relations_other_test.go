@@ -252,6 +252,48 @@ GRANT ALL ON shamwow.shazam TO testrole;`,
 				`CREATE VIEW shamwow.shazam WITH (security_barrier=true) AS SELECT count(*) FROM pg_tables;`)
 		})
 	})
+	Describe("PrintCreateMaterializedViewStatement", func() {
+		var (
+			mview         backup.MaterializedView
+			emptyMetadata backup.ObjectMetadata
+		)
+		BeforeEach(func() {
+			testhelper.SetDBVersion(connectionPool, "6.0.0")
+			mview = backup.MaterializedView{Oid: 1, Schema: "shamwow", Name: "shazam", Definition: " SELECT count(*) FROM pg_tables;", DistPolicy: "DISTRIBUTED RANDOMLY"}
+			emptyMetadata = backup.ObjectMetadata{}
+		})
+		AfterEach(func() {
+			testhelper.SetDBVersion(connectionPool, "5.1.0")
+		})
+		It("can print a basic materialized view without data", func() {
+			backup.PrintCreateMaterializedViewStatement(backupfile, toc, mview, emptyMetadata)
+			testutils.ExpectEntry(toc.PredataEntries, 0, "shamwow", "", "shazam", "MATERIALIZED VIEW")
+			testutils.AssertBufferContents(toc.PredataEntries, buffer,
+				`CREATE MATERIALIZED VIEW shamwow.shazam AS SELECT count(*) FROM pg_tables
+WITH NO DATA DISTRIBUTED RANDOMLY;`)
+		})
+		It("can print a materialized view with options and a tablespace", func() {
+			mview.Options = " WITH (fillfactor=50)"
+			mview.Tablespace = "test_tablespace"
+			backup.PrintCreateMaterializedViewStatement(backupfile, toc, mview, emptyMetadata)
+			testutils.AssertBufferContents(toc.PredataEntries, buffer,
+				`CREATE MATERIALIZED VIEW shamwow.shazam WITH (fillfactor=50) TABLESPACE test_tablespace AS SELECT count(*) FROM pg_tables
+WITH NO DATA DISTRIBUTED RANDOMLY;`)
+		})
+		It("can print a materialized view with privileges, an owner, security label, and a comment", func() {
+			mviewMetadata := testutils.DefaultMetadata("MATERIALIZED VIEW", true, true, true, true)
+			backup.PrintCreateMaterializedViewStatement(backupfile, toc, mview, mviewMetadata)
+			expectedEntries := []string{`CREATE MATERIALIZED VIEW shamwow.shazam AS SELECT count(*) FROM pg_tables
+WITH NO DATA DISTRIBUTED RANDOMLY;`,
+				"COMMENT ON MATERIALIZED VIEW shamwow.shazam IS 'This is a materialized view comment.';",
+				"ALTER MATERIALIZED VIEW shamwow.shazam OWNER TO testrole;",
+				`REVOKE ALL ON shamwow.shazam FROM PUBLIC;
+REVOKE ALL ON shamwow.shazam FROM testrole;
+GRANT ALL ON shamwow.shazam TO testrole;`,
+				"SECURITY LABEL FOR dummy ON MATERIALIZED VIEW shamwow.shazam IS 'unclassified';"}
+			testutils.AssertBufferContents(toc.PredataEntries, buffer, expectedEntries...)
+		})
+	})
 	Describe("PrintAlterSequenceStatements", func() {
 		baseSequence := backup.Relation{Schema: "public", Name: "seq_name"}
 		seqDefault := backup.Sequence{Relation: baseSequence, SequenceDefinition: backup.SequenceDefinition{LastVal: 7, Increment: 1, MaxVal: math.MaxInt64, MinVal: 1, CacheVal: 5, LogCnt: 42, IsCycled: false, IsCalled: true}}
@@ -0,0 +1,208 @@
+package backup
+
+/*
+ * This file contains structs and functions related to acquiring the ACCESS
+ * SHARE locks that keep tables from being altered or dropped while they are
+ * backed up, and to reporting the sessions that prevent those locks from
+ * being acquired.
+ */
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/jackc/pgx"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Raised when lock_timeout expires, on GPDB 6 and later
+	LOCK_NOT_AVAILABLE = "55P03"
+	// Raised when statement_timeout expires, on earlier versions
+	QUERY_CANCELED = "57014"
+
+	lockSavepoint = "gpbackup_lock_tables"
+)
+
+// The delay before the first retry, which doubles with each further retry
+var lockRetryDelay = 10 * time.Second
+
+/*
+ * A WaitTimeout of 0 waits indefinitely for each batch of locks, in which
+ * case there is nothing to retry and Retries is ignored.
+ */
+type LockStrategy struct {
+	BatchSize   int
+	WaitTimeout int
+	Retries     int
+}
+
+func NewLockStrategyFromFlags() LockStrategy {
+	return LockStrategy{
+		BatchSize:   MustGetFlagInt(utils.LOCK_BATCH_SIZE),
+		WaitTimeout: MustGetFlagInt(utils.LOCK_WAIT_TIMEOUT),
+		Retries:     MustGetFlagInt(utils.LOCK_RETRIES),
+	}
+}
+
+type LockBlocker struct {
+	TableName  string
+	Pid        int
+	Username   string
+	Granted    bool
+	Query      string
+	QueryStart string
+}
+
+/*
+ * Tables are locked in batches of one LOCK TABLE statement each.  When a
+ * batch cannot be locked within the wait timeout, its savepoint is rolled
+ * back so that the locks already held by the transaction are kept, the
+ * sessions blocking it are logged, and it is retried after a delay.
+ */
+func LockTables(connectionPool *dbconn.DBConn, tables []Relation, strategy LockStrategy) {
+	gplog.Info("Acquiring ACCESS SHARE locks on tables")
+	if strategy.WaitTimeout > 0 {
+		setLockWaitTimeout(connectionPool, strategy.WaitTimeout*1000)
+	}
+	progressBar := utils.NewProgressBar(len(tables), "Locks acquired: ", utils.PB_VERBOSE)
+	progressBar.Start()
+	for start := 0; start < len(tables); start += strategy.BatchSize {
+		end := start + strategy.BatchSize
+		if end > len(tables) {
+			end = len(tables)
+		}
+		gplog.Debug("Locking tables %d to %d of %d", start+1, end, len(tables))
+		err := lockTableBatch(connectionPool, tables[start:end], strategy)
+		gplog.FatalOnError(err)
+		for i := start; i < end; i++ {
+			progressBar.Increment()
+		}
+	}
+	progressBar.Finish()
+	if strategy.WaitTimeout > 0 {
+		setLockWaitTimeout(connectionPool, 0)
+	}
+}
+
+// lock_timeout does not exist before GPDB 6, so statement_timeout is used instead
+func usesStatementTimeout(connectionPool *dbconn.DBConn) bool {
+	return connectionPool.Version.Before("6")
+}
+
+func setLockWaitTimeout(connectionPool *dbconn.DBConn, milliseconds int) {
+	timeoutGUC := "lock_timeout"
+	if usesStatementTimeout(connectionPool) {
+		timeoutGUC = "statement_timeout"
+	}
+	connectionPool.MustExec(fmt.Sprintf("SET %s = %d", timeoutGUC, milliseconds))
+}
+
+func lockTableBatch(connectionPool *dbconn.DBConn, tables []Relation, strategy LockStrategy) error {
+	tableFQNs := make([]string, len(tables))
+	for i, table := range tables {
+		tableFQNs[i] = table.FQN()
+	}
+	lockQuery := fmt.Sprintf("LOCK TABLE %s IN ACCESS SHARE MODE", strings.Join(tableFQNs, ", "))
+	if strategy.WaitTimeout == 0 {
+		_, err := connectionPool.Exec(lockQuery)
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		connectionPool.MustExec(fmt.Sprintf("SAVEPOINT %s", lockSavepoint))
+		_, err := connectionPool.Exec(lockQuery)
+		if err == nil {
+			connectionPool.MustExec(fmt.Sprintf("RELEASE SAVEPOINT %s", lockSavepoint))
+			return nil
+		}
+		if !IsLockTimeoutError(err, usesStatementTimeout(connectionPool)) {
+			return err
+		}
+		connectionPool.MustExec(fmt.Sprintf("ROLLBACK TO SAVEPOINT %s", lockSavepoint))
+		connectionPool.MustExec(fmt.Sprintf("RELEASE SAVEPOINT %s", lockSavepoint))
+		gplog.Warn("Unable to acquire ACCESS SHARE locks on %d tables within %d seconds", len(tables), strategy.WaitTimeout)
+		LogLockBlockers(GetLockBlockers(connectionPool, tables))
+		if attempt > strategy.Retries {
+			return errors.Errorf("Unable to acquire ACCESS SHARE locks on tables after %d attempts", attempt)
+		}
+		delay := lockRetryDelay * time.Duration(1<<uint(attempt-1))
+		gplog.Warn("Retrying in %v (retry %d of %d)", delay, attempt, strategy.Retries)
+		time.Sleep(delay)
+	}
+}
+
+/*
+ * A canceled statement is treated as a lock timeout only if the wait timeout
+ * was set as the statement_timeout.  The message text is not checked, as it
+ * depends on lc_messages.
+ */
+func IsLockTimeoutError(err error, statementTimeoutSet bool) bool {
+	pgErr, ok := err.(pgx.PgError)
+	if !ok {
+		return false
+	}
+	if pgErr.Code == QUERY_CANCELED {
+		return statementTimeoutSet
+	}
+	return pgErr.Code == LOCK_NOT_AVAILABLE
+}
+
+/*
+ * Only an ACCESS EXCLUSIVE lock conflicts with an ACCESS SHARE lock.  Sessions
+ * still waiting for one are reported as well, as a LOCK TABLE queues behind
+ * them even while they are themselves blocked by another session.
+ */
+func GetLockBlockers(connectionPool *dbconn.DBConn, tables []Relation) []LockBlocker {
+	tableOids := make([]string, len(tables))
+	for i, table := range tables {
+		tableOids[i] = fmt.Sprintf("%d", table.Oid)
+	}
+	pidColumn := "a.pid"
+	queryColumn := "a.query"
+	if connectionPool.Version.Before("6") {
+		pidColumn = "a.procpid"
+		queryColumn = "a.current_query"
+	}
+	query := fmt.Sprintf(`
+SELECT DISTINCT
+	quote_ident(n.nspname) || '.' || quote_ident(c.relname) AS tablename,
+	%s AS pid,
+	coalesce(a.usename, '') AS username,
+	l.granted,
+	coalesce(%s, '') AS query,
+	coalesce(to_char(a.query_start, 'YYYY-MM-DD HH24:MI:SS'), '') AS querystart
+FROM pg_locks l
+JOIN pg_class c ON c.oid = l.relation
+JOIN pg_namespace n ON n.oid = c.relnamespace
+JOIN pg_stat_activity a ON %s = l.pid
+WHERE l.relation IN (%s)
+AND l.mode = 'AccessExclusiveLock'
+AND %s <> pg_backend_pid()
+ORDER BY tablename, pid;`, pidColumn, queryColumn, pidColumn, strings.Join(tableOids, ", "), pidColumn)
+
+	results := make([]LockBlocker, 0)
+	err := connectionPool.Select(&results, query)
+	if err != nil {
+		gplog.Warn("Unable to retrieve the sessions blocking the locks: %v", err)
+	}
+	return results
+}
+
+func LogLockBlockers(blockers []LockBlocker) {
+	if len(blockers) == 0 {
+		gplog.Warn("No session holding or waiting for an ACCESS EXCLUSIVE lock on these tables was found; the conflicting lock may have since been released")
+		return
+	}
+	for _, blocker := range blockers {
+		lockState := "holds"
+		if !blocker.Granted {
+			lockState = "is waiting for"
+		}
+		gplog.Warn("Session %d of user %s %s an ACCESS EXCLUSIVE lock on table %s, running since %s: %s",
+			blocker.Pid, blocker.Username, lockState, blocker.TableName, blocker.QueryStart, blocker.Query)
+	}
+}
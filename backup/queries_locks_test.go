@@ -0,0 +1,147 @@
+package backup_test
+
+import (
+	"regexp"
+
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/backup"
+	"github.com/jackc/pgx"
+	"github.com/pkg/errors"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("backup/queries_locks tests", func() {
+	tables := []backup.Relation{
+		{Oid: 1, Schema: "public", Name: "foo"},
+		{Oid: 2, Schema: "public", Name: "bar"},
+		{Oid: 3, Schema: "public", Name: "baz"},
+	}
+	lockTimeoutErr := pgx.PgError{Severity: "ERROR", Code: backup.LOCK_NOT_AVAILABLE, Message: "canceling statement due to lock timeout"}
+	blockerColumns := []string{"tablename", "pid", "username", "granted", "query", "querystart"}
+	BeforeEach(func() {
+		backup.SetLockRetryDelay(0)
+	})
+	Describe("LockTables", func() {
+		It("locks tables in batches", func() {
+			mock.ExpectExec(regexp.QuoteMeta("LOCK TABLE public.foo, public.bar IN ACCESS SHARE MODE")).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(regexp.QuoteMeta("LOCK TABLE public.baz IN ACCESS SHARE MODE")).WillReturnResult(sqlmock.NewResult(0, 0))
+
+			backup.LockTables(connectionPool, tables, backup.LockStrategy{BatchSize: 2})
+
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("locks each batch in a savepoint with a lock timeout on GPDB 6", func() {
+			testhelper.SetDBVersion(connectionPool, "6.0.0")
+			mock.ExpectExec("SET lock_timeout = 5000").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("SAVEPOINT gpbackup_lock_tables").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(regexp.QuoteMeta("LOCK TABLE public.foo, public.bar, public.baz IN ACCESS SHARE MODE")).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("RELEASE SAVEPOINT gpbackup_lock_tables").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("SET lock_timeout = 0").WillReturnResult(sqlmock.NewResult(0, 0))
+
+			backup.LockTables(connectionPool, tables, backup.LockStrategy{BatchSize: 100, WaitTimeout: 5})
+
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("uses statement_timeout as the lock timeout before GPDB 6", func() {
+			testhelper.SetDBVersion(connectionPool, "5.0.0")
+			mock.ExpectExec("SET statement_timeout = 5000").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("SAVEPOINT gpbackup_lock_tables").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(regexp.QuoteMeta("LOCK TABLE public.foo, public.bar, public.baz IN ACCESS SHARE MODE")).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("RELEASE SAVEPOINT gpbackup_lock_tables").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("SET statement_timeout = 0").WillReturnResult(sqlmock.NewResult(0, 0))
+
+			backup.LockTables(connectionPool, tables, backup.LockStrategy{BatchSize: 100, WaitTimeout: 5})
+
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("reports the blocking sessions and retries a batch that times out", func() {
+			testhelper.SetDBVersion(connectionPool, "6.0.0")
+			blockers := sqlmock.NewRows(blockerColumns).AddRow("public.foo", 1234, "testrole", true, "ALTER TABLE public.foo ADD COLUMN k int", "2019-01-01 01:01:01")
+			mock.ExpectExec("SET lock_timeout = 5000").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("SAVEPOINT gpbackup_lock_tables").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("LOCK TABLE").WillReturnError(lockTimeoutErr)
+			mock.ExpectExec("ROLLBACK TO SAVEPOINT gpbackup_lock_tables").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("RELEASE SAVEPOINT gpbackup_lock_tables").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectQuery("SELECT (.*) FROM pg_locks").WillReturnRows(blockers)
+			mock.ExpectExec("SAVEPOINT gpbackup_lock_tables").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("LOCK TABLE").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("RELEASE SAVEPOINT gpbackup_lock_tables").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("SET lock_timeout = 0").WillReturnResult(sqlmock.NewResult(0, 0))
+
+			backup.LockTables(connectionPool, tables, backup.LockStrategy{BatchSize: 100, WaitTimeout: 5, Retries: 1})
+
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+			testhelper.ExpectRegexp(logfile, "Unable to acquire ACCESS SHARE locks on 3 tables within 5 seconds")
+			testhelper.ExpectRegexp(logfile, "Session 1234 of user testrole holds an ACCESS EXCLUSIVE lock on table public.foo, running since 2019-01-01 01:01:01: ALTER TABLE public.foo ADD COLUMN k int")
+			testhelper.ExpectRegexp(logfile, "Retrying in 0s (retry 1 of 1)")
+		})
+		It("panics when a batch still times out after all retries", func() {
+			testhelper.SetDBVersion(connectionPool, "6.0.0")
+			mock.ExpectExec("SET lock_timeout = 5000").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("SAVEPOINT gpbackup_lock_tables").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("LOCK TABLE").WillReturnError(lockTimeoutErr)
+			mock.ExpectExec("ROLLBACK TO SAVEPOINT gpbackup_lock_tables").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("RELEASE SAVEPOINT gpbackup_lock_tables").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectQuery("SELECT (.*) FROM pg_locks").WillReturnRows(sqlmock.NewRows(blockerColumns))
+
+			defer testhelper.ShouldPanicWithMessage("Unable to acquire ACCESS SHARE locks on tables after 1 attempts")
+			backup.LockTables(connectionPool, tables, backup.LockStrategy{BatchSize: 100, WaitTimeout: 5})
+		})
+		It("panics without retrying when a batch is canceled on GPDB 6", func() {
+			testhelper.SetDBVersion(connectionPool, "6.0.0")
+			mock.ExpectExec("SET lock_timeout = 5000").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("SAVEPOINT gpbackup_lock_tables").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("LOCK TABLE").WillReturnError(pgx.PgError{Severity: "ERROR", Code: backup.QUERY_CANCELED, Message: "canceling statement due to user request"})
+
+			defer testhelper.ShouldPanicWithMessage("canceling statement due to user request")
+			backup.LockTables(connectionPool, tables, backup.LockStrategy{BatchSize: 100, WaitTimeout: 5, Retries: 1})
+		})
+	})
+	Describe("IsLockTimeoutError", func() {
+		canceledErr := pgx.PgError{Severity: "ERROR", Code: backup.QUERY_CANCELED, Message: "Anweisung wegen Statement-Timeout abgebrochen"}
+		It("treats a lock_timeout error as a lock timeout", func() {
+			Expect(backup.IsLockTimeoutError(lockTimeoutErr, false)).To(BeTrue())
+		})
+		It("treats a canceled statement as a lock timeout, whatever its message, when the wait timeout was set as the statement_timeout", func() {
+			Expect(backup.IsLockTimeoutError(canceledErr, true)).To(BeTrue())
+		})
+		It("does not treat a canceled statement as a lock timeout when the wait timeout was set as the lock_timeout", func() {
+			Expect(backup.IsLockTimeoutError(canceledErr, false)).To(BeFalse())
+		})
+		It("does not treat other errors as lock timeouts", func() {
+			Expect(backup.IsLockTimeoutError(errors.New("connection reset"), true)).To(BeFalse())
+		})
+	})
+	Describe("GetLockBlockers", func() {
+		It("queries the sessions using procpid before GPDB 6", func() {
+			testhelper.SetDBVersion(connectionPool, "5.0.0")
+			mock.ExpectQuery("a.procpid AS pid").WillReturnRows(sqlmock.NewRows(blockerColumns))
+
+			blockers := backup.GetLockBlockers(connectionPool, tables)
+
+			Expect(blockers).To(BeEmpty())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("queries the sessions using pid on GPDB 6", func() {
+			testhelper.SetDBVersion(connectionPool, "6.0.0")
+			mock.ExpectQuery(regexp.QuoteMeta("WHERE l.relation IN (1, 2, 3)")).WillReturnRows(sqlmock.NewRows(blockerColumns).AddRow("public.bar", 42, "testrole", false, "DROP TABLE public.bar", "2019-01-01 01:01:01"))
+
+			blockers := backup.GetLockBlockers(connectionPool, tables)
+
+			Expect(blockers).To(Equal([]backup.LockBlocker{{TableName: "public.bar", Pid: 42, Username: "testrole", Granted: false, Query: "DROP TABLE public.bar", QueryStart: "2019-01-01 01:01:01"}}))
+		})
+	})
+	Describe("LogLockBlockers", func() {
+		It("logs a session waiting for a conflicting lock", func() {
+			backup.LogLockBlockers([]backup.LockBlocker{{TableName: "public.bar", Pid: 42, Username: "testrole", Granted: false, Query: "DROP TABLE public.bar", QueryStart: "2019-01-01 01:01:01"}})
+			testhelper.ExpectRegexp(logfile, "Session 42 of user testrole is waiting for an ACCESS EXCLUSIVE lock on table public.bar")
+		})
+		It("logs that no blocking session was found", func() {
+			backup.LogLockBlockers([]backup.LockBlocker{})
+			testhelper.ExpectRegexp(logfile, "No session holding or waiting for an ACCESS EXCLUSIVE lock on these tables was found")
+		})
+	})
+})
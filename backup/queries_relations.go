@@ -278,6 +278,41 @@ AND %s;`, relationAndSchemaFilterClause())
 	return sequenceOwnerTables, sequenceOwnerColumns
 }
 
+type ColumnDefaultSequence struct {
+	TableSchema    string
+	TableName      string
+	SequenceSchema string
+	SequenceName   string
+}
+
+// Only the tables included in the backup by the schema and relation filters are returned
+func GetColumnDefaultSequences(connectionPool *dbconn.DBConn) []ColumnDefaultSequence {
+	query := fmt.Sprintf(`SELECT DISTINCT
+	quote_ident(n.nspname) AS tableschema,
+	quote_ident(c.relname) AS tablename,
+	quote_ident(sn.nspname) AS sequenceschema,
+	quote_ident(s.relname) AS sequencename
+FROM pg_attrdef ad
+JOIN pg_depend d
+	ON d.objid = ad.oid AND d.classid = 'pg_attrdef'::regclass AND d.refclassid = 'pg_class'::regclass
+JOIN pg_class s
+	ON s.oid = d.refobjid
+JOIN pg_namespace sn
+	ON sn.oid = s.relnamespace
+JOIN pg_class c
+	ON c.oid = ad.adrelid
+JOIN pg_namespace n
+	ON n.oid = c.relnamespace
+WHERE s.relkind = 'S'
+AND %s
+ORDER BY tableschema, tablename, sequenceschema, sequencename;`, relationAndSchemaFilterClause())
+
+	results := make([]ColumnDefaultSequence, 0)
+	err := connectionPool.Select(&results, query)
+	gplog.FatalOnError(err)
+	return results
+}
+
 type View struct {
 	Oid        uint32
 	Schema     string
@@ -329,13 +364,59 @@ AND %s;`, optionsStr, relationAndSchemaFilterClause(), ExtensionFilterClause("c"
 	return results
 }
 
-func LockTables(connectionPool *dbconn.DBConn, tables []Relation) {
-	gplog.Info("Acquiring ACCESS SHARE locks on tables")
-	progressBar := utils.NewProgressBar(len(tables), "Locks acquired: ", utils.PB_VERBOSE)
-	progressBar.Start()
-	for _, table := range tables {
-		connectionPool.MustExec(fmt.Sprintf("LOCK TABLE %s IN ACCESS SHARE MODE", table.FQN()))
-		progressBar.Increment()
-	}
-	progressBar.Finish()
+type MaterializedView struct {
+	Oid        uint32
+	Schema     string
+	Name       string
+	Options    string
+	Tablespace string
+	Definition string
+	DistPolicy string
+}
+
+func (mv MaterializedView) GetMetadataEntry() (string, utils.MetadataEntry) {
+	return "predata",
+		utils.MetadataEntry{
+			Schema:          mv.Schema,
+			Name:            mv.Name,
+			ObjectType:      "MATERIALIZED VIEW",
+			ReferenceObject: "",
+			StartByte:       0,
+			EndByte:         0,
+		}
+}
+
+func (mv MaterializedView) GetUniqueID() UniqueID {
+	return UniqueID{ClassID: PG_CLASS_OID, Oid: mv.Oid}
+}
+
+func (mv MaterializedView) FQN() string {
+	return utils.MakeFQN(mv.Schema, mv.Name)
+}
+
+/*
+ * Materialized views were introduced in GPDB 6.  Like views, they depend on
+ * the relations and functions in their definition through their rewrite rule,
+ * so they are sorted along with views by GetDependencies.
+ */
+func GetMaterializedViews(connectionPool *dbconn.DBConn) []MaterializedView {
+	results := make([]MaterializedView, 0)
+	query := fmt.Sprintf(`
+SELECT
+	c.oid AS oid,
+	quote_ident(n.nspname) AS schema,
+	quote_ident(c.relname) AS name,
+	coalesce(' WITH (' || array_to_string(c.reloptions, ', ') || ')', '') AS options,
+	coalesce(quote_ident(t.spcname), '') AS tablespace,
+	pg_get_viewdef(c.oid) AS definition,
+	coalesce(pg_catalog.pg_get_table_distributedby(c.oid), '') AS distpolicy
+FROM pg_class c
+LEFT JOIN pg_namespace n ON n.oid = c.relnamespace
+LEFT JOIN pg_tablespace t ON t.oid = c.reltablespace
+WHERE c.relkind = 'm'::"char"
+AND %s
+AND %s;`, relationAndSchemaFilterClause(), ExtensionFilterClause("c"))
+	err := connectionPool.Select(&results, query)
+	gplog.FatalOnError(err)
+	return results
 }
@@ -101,6 +101,7 @@ func DBValidate(conn *dbconn.DBConn, tableList []string, excludeSet bool) {
 func ValidateFlagCombinations(flags *pflag.FlagSet) {
 	utils.CheckExclusiveFlags(flags, utils.DEBUG, utils.QUIET, utils.VERBOSE)
 	utils.CheckExclusiveFlags(flags, utils.DATA_ONLY, utils.METADATA_ONLY, utils.INCREMENTAL)
+	utils.CheckExclusiveFlags(flags, utils.DATA_ONLY, utils.INCLUDE_OBJECT_TYPE, utils.EXCLUDE_OBJECT_TYPE)
 	utils.CheckExclusiveFlags(flags, utils.INCLUDE_SCHEMA, utils.INCLUDE_RELATION, utils.INCLUDE_RELATION_FILE)
 	utils.CheckExclusiveFlags(flags, utils.EXCLUDE_SCHEMA, utils.INCLUDE_SCHEMA)
 	utils.CheckExclusiveFlags(flags, utils.EXCLUDE_SCHEMA, utils.EXCLUDE_RELATION, utils.INCLUDE_RELATION, utils.EXCLUDE_RELATION_FILE, utils.INCLUDE_RELATION_FILE)
@@ -115,6 +116,12 @@ func ValidateFlagCombinations(flags *pflag.FlagSet) {
 	if MustGetFlagBool(utils.INCREMENTAL) && !MustGetFlagBool(utils.LEAF_PARTITION_DATA) {
 		gplog.Fatal(errors.Errorf("--leaf-partition-data must be specified with --incremental"), "")
 	}
+	if flags.Changed(utils.COMPRESSION_WORKERS) && !MustGetFlagBool(utils.SINGLE_DATA_FILE) {
+		gplog.Fatal(errors.Errorf("--compression-workers must be specified with --single-data-file"), "")
+	}
+	if flags.Changed(utils.LOCK_RETRIES) && !flags.Changed(utils.LOCK_WAIT_TIMEOUT) {
+		gplog.Fatal(errors.Errorf("--lock-retries must be specified with --lock-wait-timeout"), "")
+	}
 }
 
 func ValidateFlagValues() {
@@ -123,6 +130,12 @@ func ValidateFlagValues() {
 	err = utils.ValidateFullPath(MustGetFlagString(utils.PLUGIN_CONFIG))
 	gplog.FatalOnError(err)
 	ValidateCompressionLevel(MustGetFlagInt(utils.COMPRESSION_LEVEL))
+	ValidateCompressionWorkers(MustGetFlagInt(utils.COMPRESSION_WORKERS))
+	ValidateLockStrategy(NewLockStrategyFromFlags())
+	err = utils.ValidateObjectTypes(MustGetFlagStringSlice(utils.INCLUDE_OBJECT_TYPE))
+	gplog.FatalOnError(err)
+	err = utils.ValidateObjectTypes(MustGetFlagStringSlice(utils.EXCLUDE_OBJECT_TYPE))
+	gplog.FatalOnError(err)
 	if MustGetFlagString(utils.FROM_TIMESTAMP) != "" && !backup_filepath.IsValidTimestamp(MustGetFlagString(utils.FROM_TIMESTAMP)) {
 		gplog.Fatal(errors.Errorf("Timestamp %s is invalid.  Timestamps must be in the format YYYYMMDDHHMMSS.",
 			MustGetFlagString(utils.FROM_TIMESTAMP)), "")
@@ -135,6 +148,24 @@ func ValidateCompressionLevel(compressionLevel int) {
 	}
 }
 
+func ValidateCompressionWorkers(compressionWorkers int) {
+	if compressionWorkers < 1 {
+		gplog.Fatal(errors.Errorf("Compression workers must be at least 1"), "")
+	}
+}
+
+func ValidateLockStrategy(strategy LockStrategy) {
+	if strategy.BatchSize < 1 {
+		gplog.Fatal(errors.Errorf("Lock batch size must be at least 1"), "")
+	}
+	if strategy.WaitTimeout < 0 {
+		gplog.Fatal(errors.Errorf("Lock wait timeout must not be negative"), "")
+	}
+	if strategy.Retries < 0 {
+		gplog.Fatal(errors.Errorf("Lock retries must not be negative"), "")
+	}
+}
+
 func ValidateFromTimestamp(fromTimestamp string) {
 	fromTimestampFPInfo := backup_filepath.NewFilePathInfo(globalCluster, globalFPInfo.UserSpecifiedBackupDir,
 		fromTimestamp, globalFPInfo.UserSpecifiedSegPrefix)
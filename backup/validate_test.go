@@ -193,4 +193,30 @@ var _ = Describe("backup/validate tests", func() {
 			backup.ValidateCompressionLevel(compressLevel)
 		})
 	})
+	Describe("ValidateLockStrategy", func() {
+		It("validates a positive batch size with no timeout or retries", func() {
+			backup.ValidateLockStrategy(backup.LockStrategy{BatchSize: 1})
+		})
+		It("panics if given a batch size of less than 1", func() {
+			defer testhelper.ShouldPanicWithMessage("Lock batch size must be at least 1")
+			backup.ValidateLockStrategy(backup.LockStrategy{BatchSize: 0})
+		})
+		It("panics if given a negative wait timeout", func() {
+			defer testhelper.ShouldPanicWithMessage("Lock wait timeout must not be negative")
+			backup.ValidateLockStrategy(backup.LockStrategy{BatchSize: 1, WaitTimeout: -1})
+		})
+		It("panics if given a negative number of retries", func() {
+			defer testhelper.ShouldPanicWithMessage("Lock retries must not be negative")
+			backup.ValidateLockStrategy(backup.LockStrategy{BatchSize: 1, WaitTimeout: 1, Retries: -1})
+		})
+	})
+	Describe("ValidateCompressionWorkers", func() {
+		It("validates a positive number of compression workers", func() {
+			backup.ValidateCompressionWorkers(4)
+		})
+		It("panics if given fewer than 1 compression worker", func() {
+			defer testhelper.ShouldPanicWithMessage("Compression workers must be at least 1")
+			backup.ValidateCompressionWorkers(0)
+		})
+	})
 })
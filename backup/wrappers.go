@@ -24,6 +24,9 @@ import (
  * overall backup flow.
  */
 
+// The earliest version whose pg_export_snapshot() exports a distributed snapshot
+const SNAPSHOT_GPDB_MIN_VERSION = "6.21.0"
+
 /*
  * Setup and validation wrapper functions
  */
@@ -50,6 +53,72 @@ func InitializeConnectionPool() {
 	}
 }
 
+/*
+ * Called once connection 0 holds the table locks, so that the snapshot it
+ * exports cannot miss a change committed before the locks were granted.
+ * Each other connection restarts its transaction to import the snapshot, and
+ * this returns whether all of them share it.  Once a connection fails to
+ * import the snapshot, the remaining connections keep their own.
+ */
+func SynchronizeSnapshots() bool {
+	snapshotID := ExportSnapshot()
+	if snapshotID == "" {
+		return false
+	}
+	for connNum := 1; connNum < connectionPool.NumConns; connNum++ {
+		restartTransaction(connNum)
+		imported := ImportSnapshot(snapshotID, connNum)
+		// Rolling back the transaction also reverted the session GUCs set in it
+		SetSessionGUCs(connNum)
+		if !imported {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+ * On GPDB 6.21.0 and later, which can export a distributed snapshot,
+ * connection 0 exports its snapshot so that the other connections can import
+ * it, and every COPY then sees the database as of the same point in time.  On
+ * earlier versions, each connection keeps the snapshot of its own
+ * transaction, as before.  A failed export aborts the transaction of
+ * connection 0 and so releases its table locks, which cannot be recovered.
+ */
+func ExportSnapshot() string {
+	if connectionPool.NumConns == 1 || connectionPool.Version.Before(SNAPSHOT_GPDB_MIN_VERSION) {
+		return ""
+	}
+	snapshotID := ""
+	err := connectionPool.Get(&snapshotID, "SELECT pg_export_snapshot()", 0)
+	if err != nil {
+		gplog.Fatal(err, "Unable to export a snapshot for the backup connections to share")
+	}
+	gplog.Verbose("Exported snapshot %s for the backup connections to share", snapshotID)
+	return snapshotID
+}
+
+/*
+ * SET TRANSACTION SNAPSHOT must be the first statement run in the
+ * transaction, so this is called right after the transaction begins.
+ */
+func ImportSnapshot(snapshotID string, connNum int) bool {
+	_, err := connectionPool.Exec(fmt.Sprintf("SET TRANSACTION SNAPSHOT '%s'", snapshotID), connNum)
+	if err != nil {
+		gplog.Warn("Unable to import snapshot %s on connection %d; each connection will use its own snapshot: %v", snapshotID, connNum, err)
+		restartTransaction(connNum)
+		return false
+	}
+	return true
+}
+
+// A failed statement aborts the transaction, so it is rolled back and begun again
+func restartTransaction(connNum int) {
+	err := connectionPool.Rollback(connNum)
+	gplog.FatalOnError(err)
+	connectionPool.MustBegin(connNum)
+}
+
 func SetSessionGUCs(connNum int) {
 	// These GUCs ensure the dumps portability accross systems
 	connectionPool.MustExec("SET search_path TO pg_catalog", connNum)
@@ -82,10 +151,12 @@ func NewBackupConfig(dbName string, dbVersion string, backupVersion string, plug
 		DatabaseName:          dbName,
 		DatabaseVersion:       dbVersion,
 		DataOnly:              MustGetFlagBool(utils.DATA_ONLY),
+		ExcludeObjectTypes:    MustGetFlagStringSlice(utils.EXCLUDE_OBJECT_TYPE),
 		ExcludeRelations:      MustGetFlagStringSlice(utils.EXCLUDE_RELATION),
 		ExcludeSchemaFiltered: len(MustGetFlagStringSlice(utils.EXCLUDE_SCHEMA)) > 0,
 		ExcludeSchemas:        MustGetFlagStringSlice(utils.EXCLUDE_SCHEMA),
 		ExcludeTableFiltered:  len(MustGetFlagStringSlice(utils.EXCLUDE_RELATION)) > 0,
+		IncludeObjectTypes:    MustGetFlagStringSlice(utils.INCLUDE_OBJECT_TYPE),
 		IncludeRelations:      opts.GetOriginalIncludedTables(),
 		IncludeSchemaFiltered: len(MustGetFlagStringSlice(utils.INCLUDE_SCHEMA)) > 0,
 		IncludeSchemas:        MustGetFlagStringSlice(utils.INCLUDE_SCHEMA),
@@ -110,6 +181,8 @@ func InitializeBackupReport(opts options.Options) {
 	}
 	config := NewBackupConfig(escapedDBName, connectionPool.Version.VersionString, version,
 		plugin, globalFPInfo.Timestamp, opts)
+	// The cluster map includes the master, which does not hold any table data
+	config.SegmentCount = len(globalCluster.Segments) - 1
 
 	isFilteredBackup := config.IncludeTableFiltered || config.IncludeSchemaFiltered ||
 		config.ExcludeTableFiltered || config.ExcludeSchemaFiltered
@@ -121,8 +194,9 @@ func InitializeBackupReport(opts options.Options) {
 	}
 
 	backupReport = &utils.Report{
-		DatabaseSize: dbSize,
-		BackupConfig: *config,
+		DatabaseSize:    dbSize,
+		ConnectionCount: connectionPool.NumConns,
+		BackupConfig:    *config,
 	}
 	backupReport.ConstructBackupParamsString()
 }
@@ -135,6 +209,14 @@ func InitializeFilterLists() {
 	}
 }
 
+func getObjectTypeFilterSet() *utils.FilterSet {
+	return utils.NewObjectTypeFilterSet(MustGetFlagStringSlice(utils.INCLUDE_OBJECT_TYPE), MustGetFlagStringSlice(utils.EXCLUDE_OBJECT_TYPE))
+}
+
+func shouldBackupObjectType(objectType string) bool {
+	return getObjectTypeFilterSet().MatchesFilter(objectType)
+}
+
 func CreateBackupLockFile(timestamp string) {
 	var err error
 	timestampLockFile := fmt.Sprintf("/tmp/%s.lck", timestamp)
@@ -161,11 +243,18 @@ func CreateBackupDirectoriesOnAllHosts() {
  */
 
 func RetrieveAndProcessTables() ([]Table, []Table) {
+	return retrieveTables(true)
+}
+
+// The tables are locked only when their data is to be read
+func retrieveTables(lockTables bool) ([]Table, []Table) {
 	quotedIncludeRelations, err := options.QuoteTableNames(connectionPool, MustGetFlagStringArray(utils.INCLUDE_RELATION))
 	gplog.FatalOnError(err)
 
 	tableRelations := GetIncludedUserTableRelations(connectionPool, quotedIncludeRelations)
-	LockTables(connectionPool, tableRelations)
+	if lockTables {
+		LockTables(connectionPool, tableRelations, NewLockStrategyFromFlags())
+	}
 
 	if connectionPool.Version.AtLeast("6") {
 		tableRelations = append(tableRelations, GetForeignTableRelations(connectionPool)...)
@@ -204,9 +293,11 @@ func RetrieveAndBackupTypes(metadataFile *utils.FileWithByteCount, sortables *[]
 	}
 	typeMetadata := GetMetadataForObjectType(connectionPool, TYPE_TYPE)
 
-	BackupShellTypes(metadataFile, shells, bases, rangeTypes)
-	if connectionPool.Version.AtLeast("5") {
-		BackupEnumTypes(metadataFile, typeMetadata)
+	if shouldBackupObjectType("TYPE") {
+		BackupShellTypes(metadataFile, shells, bases, rangeTypes)
+		if connectionPool.Version.AtLeast("5") {
+			BackupEnumTypes(metadataFile, typeMetadata)
+		}
 	}
 
 	objectCounts["Types"] += len(shells)
@@ -255,6 +346,14 @@ func RetrieveViews(sortables *[]Sortable) {
 	*sortables = append(*sortables, convertToSortableSlice(views)...)
 }
 
+func RetrieveMaterializedViews(sortables *[]Sortable) {
+	gplog.Verbose("Retrieving materialized views")
+	mviews := GetMaterializedViews(connectionPool)
+	objectCounts["Materialized Views"] = len(mviews)
+
+	*sortables = append(*sortables, convertToSortableSlice(mviews)...)
+}
+
 func RetrieveTSParsers(sortables *[]Sortable, metadataMap MetadataMap) {
 	gplog.Verbose("Retrieving Text Search Parsers")
 	parsers := GetTextSearchParsers(connectionPool)
@@ -526,15 +625,21 @@ func BackupDependentObjects(metadataFile *utils.FileWithByteCount, tables []Tabl
 		AddProtocolDependenciesForGPDB4(relevantDeps, tables, protocols)
 	}
 	sortedSlice := TopologicalSort(sortables, relevantDeps)
+	AddDependenciesToTOC(globalTOC, sortables, relevantDeps)
 
 	PrintDependentObjectStatements(metadataFile, globalTOC, sortedSlice, filteredMetadata, constraints, funcInfoMap)
 	extPartInfo, partInfoMap := GetExternalPartitionInfo(connectionPool)
-	if len(extPartInfo) > 0 {
+	if len(extPartInfo) > 0 && shouldBackupObjectType("EXCHANGE PARTITION") {
 		gplog.Verbose("Writing EXCHANGE PARTITION statements to metadata file")
 		PrintExchangeExternalPartitionStatements(metadataFile, globalTOC, extPartInfo, partInfoMap, tables)
 	}
 }
 
+func BackupColumnDefaultSequenceDependencies() {
+	gplog.Verbose("Writing column default sequence dependencies to TOC")
+	AddColumnDefaultSequenceDependenciesToTOC(globalTOC, GetColumnDefaultSequences(connectionPool))
+}
+
 func BackupConversions(metadataFile *utils.FileWithByteCount) {
 	gplog.Verbose("Writing CREATE CONVERSION statements to metadata file")
 	conversions := GetConversions(connectionPool)
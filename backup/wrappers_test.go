@@ -0,0 +1,115 @@
+package backup_test
+
+import (
+	"regexp"
+
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/backup"
+	"github.com/pkg/errors"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("backup/wrappers tests", func() {
+	snapshotRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"pg_export_snapshot"}).AddRow("00000003-00000002-1")
+	}
+	expectSessionGUCs := func() {
+		mock.ExpectExec("SET search_path TO pg_catalog").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("SET statement_timeout = 0").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("SET DATESTYLE = ISO").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("SET standard_conforming_strings = 1").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta("SELECT set_config('extra_float_digits'")).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("SET synchronize_seqscans TO off").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("SET INTERVALSTYLE = POSTGRES").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("SET lock_timeout = 0").WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	BeforeEach(func() {
+		connectionPool, mock = testhelper.CreateAndConnectMockDB(2)
+		backup.SetConnection(connectionPool)
+		testhelper.SetDBVersion(connectionPool, "6.21.0")
+	})
+	Describe("ExportSnapshot", func() {
+		It("exports the snapshot of the first connection", func() {
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT pg_export_snapshot()")).WillReturnRows(snapshotRows())
+
+			Expect(backup.ExportSnapshot()).To(Equal("00000003-00000002-1"))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("does not export a snapshot before GPDB 6.21.0", func() {
+			testhelper.SetDBVersion(connectionPool, "6.20.0")
+
+			Expect(backup.ExportSnapshot()).To(Equal(""))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("does not export a snapshot for a single connection", func() {
+			connectionPool.NumConns = 1
+
+			Expect(backup.ExportSnapshot()).To(Equal(""))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("panics if the export fails, as the transaction no longer holds the table locks", func() {
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT pg_export_snapshot()")).WillReturnError(errors.New("function pg_export_snapshot() does not exist"))
+
+			defer testhelper.ShouldPanicWithMessage("Unable to export a snapshot for the backup connections to share")
+			backup.ExportSnapshot()
+		})
+	})
+	Describe("ImportSnapshot", func() {
+		It("imports the snapshot on the given connection", func() {
+			mock.ExpectExec(regexp.QuoteMeta("SET TRANSACTION SNAPSHOT '00000003-00000002-1'")).WillReturnResult(sqlmock.NewResult(0, 0))
+
+			Expect(backup.ImportSnapshot("00000003-00000002-1", 1)).To(BeTrue())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("restarts the transaction and returns false if the import fails", func() {
+			mock.ExpectBegin()
+			connectionPool.MustBegin(1)
+			mock.ExpectExec(regexp.QuoteMeta("SET TRANSACTION SNAPSHOT '00000003-00000002-1'")).WillReturnError(errors.New("invalid snapshot identifier"))
+			mock.ExpectRollback()
+			mock.ExpectBegin()
+
+			Expect(backup.ImportSnapshot("00000003-00000002-1", 1)).To(BeFalse())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+			testhelper.ExpectRegexp(logfile, "Unable to import snapshot 00000003-00000002-1 on connection 1")
+		})
+	})
+	Describe("SynchronizeSnapshots", func() {
+		BeforeEach(func() {
+			mock.ExpectBegin()
+			mock.ExpectBegin()
+			connectionPool.MustBegin(0)
+			connectionPool.MustBegin(1)
+		})
+		It("imports the snapshot of the first connection into a new transaction on the others", func() {
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT pg_export_snapshot()")).WillReturnRows(snapshotRows())
+			mock.ExpectRollback()
+			mock.ExpectBegin()
+			mock.ExpectExec(regexp.QuoteMeta("SET TRANSACTION SNAPSHOT '00000003-00000002-1'")).WillReturnResult(sqlmock.NewResult(0, 0))
+			expectSessionGUCs()
+
+			Expect(backup.SynchronizeSnapshots()).To(BeTrue())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("falls back to a snapshot per connection if a connection cannot import the snapshot", func() {
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT pg_export_snapshot()")).WillReturnRows(snapshotRows())
+			mock.ExpectRollback()
+			mock.ExpectBegin()
+			mock.ExpectExec(regexp.QuoteMeta("SET TRANSACTION SNAPSHOT '00000003-00000002-1'")).WillReturnError(errors.New("invalid snapshot identifier"))
+			mock.ExpectRollback()
+			mock.ExpectBegin()
+			expectSessionGUCs()
+
+			Expect(backup.SynchronizeSnapshots()).To(BeFalse())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("leaves every transaction as it is if the snapshot cannot be exported", func() {
+			testhelper.SetDBVersion(connectionPool, "6.20.0")
+
+			Expect(backup.SynchronizeSnapshots()).To(BeFalse())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+})
@@ -22,6 +22,7 @@ import (
 
 type FilePathInfo struct {
 	PID                    int
+	HelperIndex            int
 	SegDirMap              map[int]string
 	Timestamp              string
 	UserSpecifiedBackupDir string
@@ -74,7 +75,7 @@ func (backupFPInfo *FilePathInfo) GetSegmentPipeFilePath(contentID int) string {
 }
 
 func (backupFPInfo *FilePathInfo) GetSegmentPipePathForCopyCommand() string {
-	return fmt.Sprintf("<SEG_DATA_DIR>/gpbackup_<SEGID>_%s_pipe_%d", backupFPInfo.Timestamp, backupFPInfo.PID)
+	return fmt.Sprintf("<SEG_DATA_DIR>/gpbackup_<SEGID>_%s_pipe_%s", backupFPInfo.Timestamp, backupFPInfo.getHelperID())
 }
 
 func (backupFPInfo *FilePathInfo) GetTableBackupFilePath(contentID int, tableOid uint32, extension string, singleDataFile bool) string {
@@ -96,6 +97,25 @@ func (backupFPInfo *FilePathInfo) GetTableBackupFilePathForCopyCommand(tableOid
 	return path.Join(baseDir, "backups", backupFPInfo.Timestamp[0:8], backupFPInfo.Timestamp, backupFilePath)
 }
 
+/*
+ * Converts the path of a file belonging to one segment into the path of the
+ * corresponding file belonging to another segment, for backup files stored
+ * in a user-specified backup directory.  Both the segment directory and the
+ * content ID in the file name are replaced.
+ */
+func ReplaceContentInFilePath(filePath string, oldContentID int, newContentID int) string {
+	oldSegDir := fmt.Sprintf("%d/backups/", oldContentID)
+	if index := strings.LastIndex(filePath, oldSegDir); index != -1 {
+		filePath = filePath[:index] + fmt.Sprintf("%d/backups/", newContentID) + filePath[index+len(oldSegDir):]
+	}
+	dir, filename := path.Split(filePath)
+	oldPrefix := fmt.Sprintf("gpbackup_%d_", oldContentID)
+	if strings.HasPrefix(filename, oldPrefix) {
+		filename = fmt.Sprintf("gpbackup_%d_", newContentID) + filename[len(oldPrefix):]
+	}
+	return dir + filename
+}
+
 var metadataFilenameMap = map[string]string{
 	"config":            "config.yaml",
 	"metadata":          "metadata.sql",
@@ -147,7 +167,39 @@ func (backupFPInfo *FilePathInfo) GetPluginConfigPath() string {
 }
 
 func (backupFPInfo *FilePathInfo) GetSegmentHelperFilePath(contentID int, suffix string) string {
-	return path.Join(backupFPInfo.SegDirMap[contentID], fmt.Sprintf("gpbackup_%d_%s_%s_%d", contentID, backupFPInfo.Timestamp, suffix, backupFPInfo.PID))
+	return path.Join(backupFPInfo.SegDirMap[contentID], fmt.Sprintf("gpbackup_%d_%s_%s_%s", contentID, backupFPInfo.Timestamp, suffix, backupFPInfo.getHelperID()))
+}
+
+/*
+ * The control sockets of the helper agents are kept in /tmp rather than in
+ * the segment data directory, as the path of a Unix socket is limited to
+ * around 100 characters.  They are put in a directory that only the agents'
+ * user can access, which the agents check before listening.
+ */
+func (backupFPInfo *FilePathInfo) GetSegmentHelperSocketDir() string {
+	return fmt.Sprintf("/tmp/gpbackup_%s_%d", backupFPInfo.Timestamp, backupFPInfo.PID)
+}
+
+func (backupFPInfo *FilePathInfo) GetSegmentHelperSocketPath(contentID int) string {
+	return path.Join(backupFPInfo.GetSegmentHelperSocketDir(), fmt.Sprintf("%d_%s.sock", contentID, backupFPInfo.getHelperID()))
+}
+
+/*
+ * When gprestore starts several gpbackup_helper agents on each segment, each
+ * agent has its own pipes, oid list, and control socket, which are named
+ * using the FilePathInfo returned by ForHelper.  The files of the first agent
+ * have no index in their names.
+ */
+func (backupFPInfo FilePathInfo) ForHelper(helperIndex int) FilePathInfo {
+	backupFPInfo.HelperIndex = helperIndex
+	return backupFPInfo
+}
+
+func (backupFPInfo *FilePathInfo) getHelperID() string {
+	if backupFPInfo.HelperIndex == 0 {
+		return strconv.Itoa(backupFPInfo.PID)
+	}
+	return fmt.Sprintf("%d_%d", backupFPInfo.PID, backupFPInfo.HelperIndex)
 }
 
 func (backupFPInfo *FilePathInfo) GetHelperLogPath() string {
@@ -110,6 +110,48 @@ var _ = Describe("filepath tests", func() {
 			Expect(fpInfo.GetTableBackupFilePath(-1, 1234, "", true)).To(Equal("/foo/bar/gpseg-1/backups/20170101/20170101010101/gpbackup_-1_20170101010101"))
 		})
 	})
+	Describe("GetSegmentHelperSocketPath", func() {
+		It("returns a socket path outside of the segment data directory", func() {
+			fpInfo := backup_filepath.NewFilePathInfo(c, "/foo/bar", "20170101010101", "gpseg")
+			fpInfo.PID = 1234
+			Expect(fpInfo.GetSegmentHelperSocketDir()).To(Equal("/tmp/gpbackup_20170101010101_1234"))
+			Expect(fpInfo.GetSegmentHelperSocketPath(0)).To(Equal("/tmp/gpbackup_20170101010101_1234/0_1234.sock"))
+		})
+	})
+	Describe("ForHelper", func() {
+		var fpInfo backup_filepath.FilePathInfo
+		BeforeEach(func() {
+			fpInfo = backup_filepath.NewFilePathInfo(c, "", "20170101010101", "gpseg")
+			fpInfo.PID = 1234
+		})
+		It("does not change the file names of the first helper", func() {
+			helperFPInfo := fpInfo.ForHelper(0)
+			Expect(helperFPInfo.GetSegmentPipeFilePath(-1)).To(Equal("/data/gpseg-1/gpbackup_-1_20170101010101_pipe_1234"))
+			Expect(helperFPInfo.GetSegmentHelperFilePath(-1, "oid")).To(Equal("/data/gpseg-1/gpbackup_-1_20170101010101_oid_1234"))
+			Expect(helperFPInfo.GetSegmentHelperSocketPath(-1)).To(Equal("/tmp/gpbackup_20170101010101_1234/-1_1234.sock"))
+		})
+		It("gives each other helper its own pipes, oid list, and socket", func() {
+			helperFPInfo := fpInfo.ForHelper(2)
+			Expect(helperFPInfo.GetSegmentPipeFilePath(-1)).To(Equal("/data/gpseg-1/gpbackup_-1_20170101010101_pipe_1234_2"))
+			Expect(helperFPInfo.GetSegmentHelperFilePath(-1, "oid")).To(Equal("/data/gpseg-1/gpbackup_-1_20170101010101_oid_1234_2"))
+			Expect(helperFPInfo.GetSegmentHelperSocketPath(-1)).To(Equal("/tmp/gpbackup_20170101010101_1234/-1_1234_2.sock"))
+			Expect(fpInfo.HelperIndex).To(Equal(0))
+		})
+	})
+	Describe("ReplaceContentInFilePath", func() {
+		It("replaces the content ID in a data file path", func() {
+			filePath := "/foo/bar/gpseg1/backups/20170101/20170101010101/gpbackup_1_20170101010101.gz"
+			Expect(backup_filepath.ReplaceContentInFilePath(filePath, 1, 13)).To(Equal("/foo/bar/gpseg13/backups/20170101/20170101010101/gpbackup_13_20170101010101.gz"))
+		})
+		It("replaces the content ID in a segment TOC file path", func() {
+			filePath := "/foo/bar/gpseg2/backups/20170101/20170101010101/gpbackup_2_20170101010101_toc.yaml"
+			Expect(backup_filepath.ReplaceContentInFilePath(filePath, 2, 6)).To(Equal("/foo/bar/gpseg6/backups/20170101/20170101010101/gpbackup_6_20170101010101_toc.yaml"))
+		})
+		It("does not replace digits in the backup directory", func() {
+			filePath := "/backups1/gpseg1/backups/20170101/20170101010101/gpbackup_1_20170101010101"
+			Expect(backup_filepath.ReplaceContentInFilePath(filePath, 1, 5)).To(Equal("/backups1/gpseg5/backups/20170101/20170101010101/gpbackup_5_20170101010101"))
+		})
+	})
 	Describe("ParseSegPrefix", func() {
 		AfterEach(func() {
 			operating.System.Glob = filepath.Glob
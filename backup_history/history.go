@@ -22,14 +22,17 @@ type BackupConfig struct {
 	BackupDir             string
 	BackupVersion         string
 	Compressed            bool
+	CopiedFrom            string
 	DatabaseName          string
 	DatabaseVersion       string
 	DataOnly              bool
 	DateDeleted           string
+	ExcludeObjectTypes    []string
 	ExcludeRelations      []string
 	ExcludeSchemaFiltered bool
 	ExcludeSchemas        []string
 	ExcludeTableFiltered  bool
+	IncludeObjectTypes    []string
 	IncludeRelations      []string
 	IncludeSchemaFiltered bool
 	IncludeSchemas        []string
@@ -40,7 +43,9 @@ type BackupConfig struct {
 	Plugin                string
 	PluginVersion         string
 	RestorePlan           []RestorePlanEntry
+	SegmentCount          int
 	SingleDataFile        bool
+	SynchronizedSnapshot  bool
 	Timestamp             string
 	EndTime               string
 	WithStatistics        bool
@@ -122,6 +127,33 @@ func WriteBackupHistory(historyFilePath string, currentBackupConfig *BackupConfi
 	return history.WriteToFileAndMakeReadOnly(historyFilePath)
 }
 
+/*
+ * Adds entries for backups that were not just taken, such as a copy of a
+ * backup in another location, keeping the end times in their configs.  A
+ * backup already in the history at the same location is not added again.
+ */
+func AddBackupHistoryEntries(historyFilePath string, backupConfigs ...*BackupConfig) error {
+	lock := lockHistoryFile()
+	defer func() {
+		_ = lock.Unlock()
+	}()
+
+	history := &History{BackupConfigs: make([]BackupConfig, 0)}
+	if iohelper.FileExistsAndIsReadable(historyFilePath) {
+		var err error
+		history, err = NewHistory(historyFilePath)
+		if err != nil {
+			return err
+		}
+	}
+	for _, backupConfig := range backupConfigs {
+		if history.FindBackupConfigAtLocation(backupConfig.Timestamp, backupConfig.BackupDir, backupConfig.Plugin) == nil {
+			history.AddBackupConfig(backupConfig)
+		}
+	}
+	return history.WriteToFileAndMakeReadOnly(historyFilePath)
+}
+
 func (history *History) RewriteHistoryFile(historyFilePath string) error {
 	lock := lockHistoryFile()
 	defer func() {
@@ -181,3 +213,12 @@ func (history *History) FindBackupConfig(timestamp string) *BackupConfig {
 	}
 	return nil
 }
+
+func (history *History) FindBackupConfigAtLocation(timestamp string, backupDir string, plugin string) *BackupConfig {
+	for _, backupConfig := range history.BackupConfigs {
+		if backupConfig.Timestamp == timestamp && backupConfig.BackupDir == backupDir && backupConfig.Plugin == plugin {
+			return &backupConfig
+		}
+	}
+	return nil
+}
@@ -25,31 +25,37 @@ var _ = Describe("backup/history tests", func() {
 
 	BeforeEach(func() {
 		testConfig1 = backup_history.BackupConfig{
-			DatabaseName:     "testdb1",
-			ExcludeRelations: []string{},
-			ExcludeSchemas:   []string{},
-			IncludeRelations: []string{"testschema.testtable1", "testschema.testtable2"},
-			IncludeSchemas:   []string{},
-			RestorePlan:      []backup_history.RestorePlanEntry{},
-			Timestamp:        "timestamp1",
+			DatabaseName:       "testdb1",
+			ExcludeObjectTypes: []string{},
+			ExcludeRelations:   []string{},
+			ExcludeSchemas:     []string{},
+			IncludeObjectTypes: []string{},
+			IncludeRelations:   []string{"testschema.testtable1", "testschema.testtable2"},
+			IncludeSchemas:     []string{},
+			RestorePlan:        []backup_history.RestorePlanEntry{},
+			Timestamp:          "timestamp1",
 		}
 		testConfig2 = backup_history.BackupConfig{
-			DatabaseName:     "testdb2",
-			ExcludeRelations: []string{},
-			ExcludeSchemas:   []string{"public"},
-			IncludeRelations: []string{},
-			IncludeSchemas:   []string{},
-			RestorePlan:      []backup_history.RestorePlanEntry{},
-			Timestamp:        "timestamp2",
+			DatabaseName:       "testdb2",
+			ExcludeObjectTypes: []string{},
+			ExcludeRelations:   []string{},
+			ExcludeSchemas:     []string{"public"},
+			IncludeObjectTypes: []string{},
+			IncludeRelations:   []string{},
+			IncludeSchemas:     []string{},
+			RestorePlan:        []backup_history.RestorePlanEntry{},
+			Timestamp:          "timestamp2",
 		}
 		testConfig3 = backup_history.BackupConfig{
-			DatabaseName:     "testdb3",
-			ExcludeRelations: []string{},
-			ExcludeSchemas:   []string{"public"},
-			IncludeRelations: []string{},
-			IncludeSchemas:   []string{},
-			RestorePlan:      []backup_history.RestorePlanEntry{},
-			Timestamp:        "timestamp3",
+			DatabaseName:       "testdb3",
+			ExcludeObjectTypes: []string{},
+			ExcludeRelations:   []string{},
+			ExcludeSchemas:     []string{"public"},
+			IncludeObjectTypes: []string{},
+			IncludeRelations:   []string{},
+			IncludeSchemas:     []string{},
+			RestorePlan:        []backup_history.RestorePlanEntry{},
+			Timestamp:          "timestamp3",
 		}
 		_ = os.Remove(historyFilePath)
 	})
@@ -219,6 +225,37 @@ var _ = Describe("backup/history tests", func() {
 			Expect(testConfig3.EndTime).To(Equal(simulatedEndTime.Format("20060102150405")))
 		})
 	})
+	Describe("AddBackupHistoryEntries", func() {
+		It("adds a copy of a backup at another location, keeping its end time", func() {
+			testConfig1.EndTime = "20170101010101"
+			err := backup_history.WriteBackupHistory(historyFilePath, &testConfig2)
+			Expect(err).ToNot(HaveOccurred())
+			copyConfig := testConfig2
+			copyConfig.Plugin = "/usr/local/bin/gpbackup_s3_plugin"
+			copyConfig.CopiedFrom = "/backups"
+			copyConfig.EndTime = "20170102010101"
+
+			err = backup_history.AddBackupHistoryEntries(historyFilePath, &testConfig1, &copyConfig)
+			Expect(err).ToNot(HaveOccurred())
+
+			resultHistory, err := backup_history.NewHistory(historyFilePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resultHistory.BackupConfigs).To(HaveLen(3))
+			Expect(resultHistory.FindBackupConfigAtLocation("timestamp1", "", "")).To(Equal(&testConfig1))
+			Expect(resultHistory.FindBackupConfigAtLocation("timestamp2", "", "/usr/local/bin/gpbackup_s3_plugin")).To(Equal(&copyConfig))
+		})
+		It("does not add a backup that is already in the history at the same location", func() {
+			err := backup_history.WriteBackupHistory(historyFilePath, &testConfig1)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = backup_history.AddBackupHistoryEntries(historyFilePath, &testConfig1)
+			Expect(err).ToNot(HaveOccurred())
+
+			resultHistory, err := backup_history.NewHistory(historyFilePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resultHistory.BackupConfigs).To(HaveLen(1))
+		})
+	})
 	Describe("FindBackupConfig", func() {
 		var resultHistory *backup_history.History
 		BeforeEach(func() {
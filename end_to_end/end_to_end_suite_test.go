@@ -128,12 +128,11 @@ func assertArtifactsCleaned(conn *dbconn.DBConn, timestamp string) {
 	fpInfo := backup_filepath.NewFilePathInfo(backupCluster, "", timestamp, backup_filepath.GetSegPrefix(conn))
 	description := "Checking if helper files are cleaned up properly"
 	cleanupFunc := func(contentID int) string {
-		errorFile := fmt.Sprintf("%s_error", fpInfo.GetSegmentPipeFilePath(contentID))
 		oidFile := fpInfo.GetSegmentHelperFilePath(contentID, "oid")
-		scriptFile := fpInfo.GetSegmentHelperFilePath(contentID, "script")
+		socketFile := fpInfo.GetSegmentHelperSocketPath(contentID)
 		pipeFile := fpInfo.GetSegmentPipeFilePath(contentID)
 
-		return fmt.Sprintf("! ls %s && ! ls %s && ! ls %s && ! ls %s*", errorFile, oidFile, scriptFile, pipeFile)
+		return fmt.Sprintf("! ls %s && ! ls %s && ! ls %s*", oidFile, socketFile, pipeFile)
 	}
 	remoteOutput := backupCluster.GenerateAndExecuteCommand(description, cleanupFunc, cluster.ON_SEGMENTS_AND_MASTER)
 	if remoteOutput.NumErrors != 0 {
@@ -500,6 +499,15 @@ var _ = Describe("backup end to end integration tests", func() {
 				assertDataRestored(restoreConn, schema2TupleCounts)
 				assertArtifactsCleaned(restoreConn, timestamp)
 			})
+			It("runs gpbackup and gprestore with single-data-file flag and jobs flag", func() {
+				timestamp := gpbackup(gpbackupPath, backupHelperPath, "--single-data-file", "--backup-dir", backupDir)
+				gprestore(gprestorePath, restoreHelperPath, timestamp, "--redirect-db", "restoredb", "--backup-dir", backupDir, "--jobs", "4")
+
+				assertRelationsCreated(restoreConn, TOTAL_RELATIONS)
+				assertDataRestored(restoreConn, publicSchemaTupleCounts)
+				assertDataRestored(restoreConn, schema2TupleCounts)
+				assertArtifactsCleaned(restoreConn, timestamp)
+			})
 			It("runs gpbackup and gprestore on database with all objects", func() {
 				testhelper.AssertQueryRuns(backupConn, "DROP SCHEMA IF EXISTS schema2 CASCADE; DROP SCHEMA public CASCADE; CREATE SCHEMA public; DROP PROCEDURAL LANGUAGE IF EXISTS plpythonu;")
 				defer testutils.ExecuteSQLFile(backupConn, "test_tables_data.sql")
@@ -986,6 +994,18 @@ var _ = Describe("backup end to end integration tests", func() {
 			assertDataRestored(restoreConn, schema2TupleCounts)
 
 		})
+		It("runs gpbackup and gprestore without writing data files when TABLE is excluded by object type", func() {
+			timestamp := gpbackup(gpbackupPath, backupHelperPath, "--backup-dir", backupDir, "--exclude-object-type", "TABLE")
+			dataFiles, err := filepath.Glob(filepath.Join(backupDir, "*/backups/*", timestamp, fmt.Sprintf("gpbackup_*_%s_*", timestamp)))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dataFiles).To(BeEmpty())
+			configFile, err := filepath.Glob(filepath.Join(backupDir, "*-1/backups/*", timestamp, "*config.yaml"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(configFile).To(HaveLen(1))
+			contents, err := ioutil.ReadFile(configFile[0])
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(contents)).To(ContainSubstring("metadataonly: true"))
+		})
 		It("runs gpbackup and gprestore with jobs flag", func() {
 			skipIfOldBackupVersionBefore("1.3.0")
 			timestamp := gpbackup(gpbackupPath, backupHelperPath, "--backup-dir", backupDir, "--jobs", "4")
@@ -0,0 +1,31 @@
+// +build gpbackup_manager
+
+package main
+
+import (
+	"os"
+
+	. "github.com/greenplum-db/gpbackup/manager"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/spf13/cobra"
+)
+
+func main() {
+	var rootCmd = &cobra.Command{
+		Use:     "gpbackup_manager",
+		Short:   "gpbackup_manager operates on backups taken by gpbackup",
+		Args:    cobra.NoArgs,
+		Version: GetVersion(),
+	}
+	rootCmd.AddCommand(NewConvertToSQLCommand())
+	rootCmd.AddCommand(NewCopyBackupCommand())
+	rootCmd.AddCommand(NewDiffBackupsCommand())
+	rootCmd.AddCommand(NewDiffDatabaseCommand())
+	rootCmd.AddCommand(NewExtractDataCommand())
+	rootCmd.AddCommand(NewExtractSQLCommand())
+	rootCmd.SetArgs(utils.HandleSingleDashes(os.Args[1:]))
+	DoInit(rootCmd)
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(2)
+	}
+}
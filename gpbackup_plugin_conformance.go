@@ -0,0 +1,11 @@
+// +build gpbackup_plugin_conformance
+
+package main
+
+import (
+	. "github.com/greenplum-db/gpbackup/plugins/conformance"
+)
+
+func main() {
+	DoConformance()
+}
@@ -6,8 +6,6 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
-	"strings"
 
 	"github.com/greenplum-db/gpbackup/utils"
 	"github.com/pkg/errors"
@@ -20,11 +18,9 @@ import (
 func doBackupAgent() error {
 	var lastRead uint64
 	var (
-		finalWriter io.Writer
-		gzipWriter  *gzip.Writer
+		dataWriter  *countingWriter
 		bufIoWriter *bufio.Writer
 		writeHandle io.WriteCloser
-		writeCmd    *exec.Cmd
 	)
 	toc := &utils.SegmentTOC{}
 	toc.DataEntries = make(map[uint]utils.SegmentDataEntry)
@@ -59,21 +55,38 @@ func doBackupAgent() error {
 			return err
 		}
 		if i == 0 {
-			finalWriter, gzipWriter, bufIoWriter, writeHandle, writeCmd, err = getBackupPipeWriter(*compressionLevel)
+			bufIoWriter, writeHandle, err = getBackupPipeWriter()
 			if err != nil {
 				return err
 			}
+			dataWriter = &countingWriter{writer: bufIoWriter}
 		}
 
 		log(fmt.Sprintf("Backing up table with oid %d\n", oid))
-		numBytes, err := io.Copy(finalWriter, reader)
+		agentProgress.StartTable(uint32(oid))
+		frameStart := dataWriter.count
+		finalWriter, gzipWriter, err := getTableWriter(dataWriter, *compressionLevel)
 		if err != nil {
-			return errors.Wrap(err, strings.Trim(errBuf.String(), "\x00"))
+			agentProgress.FinishTable(uint32(oid), err)
+			return err
+		}
+		numBytes, err := io.Copy(progressWriter{oid: uint32(oid), writer: finalWriter}, reader)
+		if err == nil && gzipWriter != nil {
+			err = gzipWriter.Close()
 		}
+		if err != nil {
+			agentProgress.FinishTable(uint32(oid), err)
+			return err
+		}
+		agentProgress.FinishTable(uint32(oid), nil)
 		log(fmt.Sprintf("Read %d bytes\n", numBytes))
 
 		lastProcessed := lastRead + uint64(numBytes)
-		toc.AddSegmentDataEntry(uint(oid), lastRead, lastProcessed)
+		if gzipWriter != nil {
+			toc.AddSegmentDataEntry(uint(oid), lastRead, lastProcessed, frameStart, dataWriter.count)
+		} else {
+			toc.AddSegmentDataEntry(uint(oid), lastRead, lastProcessed, 0, 0)
+		}
 		lastRead = lastProcessed
 
 		lastPipe = currentPipe
@@ -89,11 +102,7 @@ func doBackupAgent() error {
 	 * The order for flushing and closing the writers below is very specific
 	 * to ensure all data is written to the file and file handles are not leaked.
 	 */
-	if gzipWriter != nil {
-		_ = gzipWriter.Close()
-	}
 	_ = bufIoWriter.Flush()
-	_ = writeHandle.Close()
 	if *pluginConfigFile != "" {
 		/*
 		 * When using a plugin, the agent may take longer to finish than the
@@ -103,10 +112,12 @@ func doBackupAgent() error {
 		 * written to verify the agent completed.
 		 */
 		log("Uploading remaining data to plugin destination")
-		err := writeCmd.Wait()
+		err := writeHandle.Close()
 		if err != nil {
-			return errors.Wrap(err, strings.Trim(errBuf.String(), "\x00"))
+			return err
 		}
+	} else {
+		_ = writeHandle.Close()
 	}
 	err = toc.WriteToFileAndMakeReadOnly(*tocFile)
 	if err != nil {
@@ -128,49 +139,63 @@ func getBackupPipeReader(currentPipe string) (io.Reader, io.ReadCloser, error) {
 	return reader, readHandle, nil
 }
 
-func getBackupPipeWriter(compressLevel int) (io.Writer, *gzip.Writer, *bufio.Writer, io.WriteCloser, *exec.Cmd, error) {
+func getBackupPipeWriter() (*bufio.Writer, io.WriteCloser, error) {
 	var writeHandle io.WriteCloser
 	var err error
-	var writeCmd *exec.Cmd
 	if *pluginConfigFile != "" {
-		writeCmd, writeHandle, err = startBackupPluginCommand()
+		writeHandle, err = getBackupPluginWriter()
 	} else {
 		writeHandle, err = os.Create(*dataFile)
 	}
 	if err != nil {
-		return nil, nil, nil, nil, nil, err
+		return nil, nil, err
 	}
+	return bufio.NewWriter(writeHandle), writeHandle, nil
+}
 
-	var finalWriter io.Writer
-	var gzipWriter *gzip.Writer
-	bufIoWriter := bufio.NewWriter(writeHandle)
-	finalWriter = bufIoWriter
-	if compressLevel > 0 {
-		gzipWriter, err = gzip.NewWriterLevel(bufIoWriter, compressLevel)
+/*
+ * Each table is compressed as a separate gzip member, so that the restore
+ * agent can start decompressing at any table.  A file made up of several
+ * members is still a valid gzip file.
+ */
+func getTableWriter(writer io.Writer, compressLevel int) (io.Writer, io.WriteCloser, error) {
+	if compressLevel == 0 {
+		return writer, nil, nil
+	}
+	if *compressionWorkers > 1 {
+		gzipWriter, err := utils.NewParallelGzipWriter(writer, compressLevel, *compressionWorkers)
 		if err != nil {
-			return nil, nil, nil, nil, nil, err
+			return nil, nil, err
 		}
-		finalWriter = gzipWriter
+		return gzipWriter, gzipWriter, nil
 	}
-	return finalWriter, gzipWriter, bufIoWriter, writeHandle, writeCmd, nil
-}
-
-func startBackupPluginCommand() (*exec.Cmd, io.WriteCloser, error) {
-	pluginConfig, err := utils.ReadPluginConfig(*pluginConfigFile)
+	gzipWriter, err := gzip.NewWriterLevel(writer, compressLevel)
 	if err != nil {
 		return nil, nil, err
 	}
-	cmdStr := fmt.Sprintf("%s backup_data %s %s", pluginConfig.ExecutablePath, pluginConfig.ConfigPath, *dataFile)
-	writeCmd := exec.Command("bash", "-c", cmdStr)
+	return gzipWriter, gzipWriter, nil
+}
+
+// Counts the bytes written to the data file, to record where each table's gzip member starts
+type countingWriter struct {
+	writer io.Writer
+	count  uint64
+}
+
+func (counter *countingWriter) Write(p []byte) (int, error) {
+	numBytes, err := counter.writer.Write(p)
+	counter.count += uint64(numBytes)
+	return numBytes, err
+}
 
-	writeHandle, err := writeCmd.StdinPipe()
+func getBackupPluginWriter() (io.WriteCloser, error) {
+	pluginConfig, err := utils.ReadPluginConfig(*pluginConfigFile)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	writeCmd.Stderr = &errBuf
-	err = writeCmd.Start()
+	backend, err := pluginConfig.GetStorageBackend()
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	return writeCmd, writeHandle, nil
+	return backend.PutStream(*dataFile)
 }
@@ -0,0 +1,66 @@
+package helper
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/greenplum-db/gpbackup/storage"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+/*
+ * Copy specific functions
+ */
+
+/*
+ * The copy agent copies the backup files of one segment between a backup
+ * directory and a plugin destination, or between two plugin destinations.
+ * The copy list holds one JSON-encoded storage.CopyEntry per line, and the
+ * result of each copy is written to stdout in the same form, so that
+ * gpbackup_manager can record the checksums of every segment's files.
+ */
+func doCopyAgent() error {
+	source, err := getCopyBackend(*pluginConfigFile)
+	if err != nil {
+		return err
+	}
+	dest, err := getCopyBackend(*destPluginConfigFile)
+	if err != nil {
+		return err
+	}
+	listFile, err := os.Open(*copyList)
+	if err != nil {
+		return err
+	}
+	defer listFile.Close()
+	scanner := bufio.NewScanner(listFile)
+	for scanner.Scan() {
+		entry := storage.CopyEntry{}
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return errors.Wrapf(err, "Invalid copy list entry %s", scanner.Text())
+		}
+		result, err := storage.CopyBackupFile(source, dest, entry)
+		if err != nil {
+			return errors.Wrapf(err, "Unable to copy %s to %s", entry.SourcePath, entry.DestPath)
+		}
+		output, _ := json.Marshal(result)
+		fmt.Println(string(output))
+	}
+	return scanner.Err()
+}
+
+// Files are read from and written to their local paths when no plugin is used
+func getCopyBackend(configFile string) (storage.StorageBackend, error) {
+	if configFile == "" {
+		return &storage.FilesystemBackend{}, nil
+	}
+	pluginConfig, err := utils.ReadPluginConfig(configFile)
+	if err != nil {
+		return nil, err
+	}
+	return pluginConfig.GetStorageBackend()
+}
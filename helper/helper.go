@@ -2,9 +2,10 @@ package helper
 
 import (
 	"bufio"
-	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"runtime/debug"
@@ -13,20 +14,29 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
-	"github.com/greenplum-db/gp-common-go-libs/iohelper"
 	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/storage"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
 )
 
+const builtinPluginName = "gpbackup_builtin_plugin"
+
+// How long a finished agent waits to be stopped before exiting on its own
+const agentIdleTimeout = 5 * time.Minute
+
 /*
  * Non-flag variables
  */
 
 var (
+	agentProgress *utils.AgentProgress
+	agentServer   *utils.AgentServer
 	CleanupGroup  *sync.WaitGroup
 	currentPipe   string
-	errBuf        bytes.Buffer
 	lastPipe      string
 	nextPipe      string
 	version       string
@@ -39,17 +49,27 @@ var (
  * Command-line flags
  */
 var (
-	backupAgent      *bool
-	compressionLevel *int
-	content          *int
-	dataFile         *string
-	oidFile          *string
-	onErrorContinue  *bool
-	pipeFile         *string
-	pluginConfigFile *string
-	printVersion     *bool
-	restoreAgent     *bool
-	tocFile          *string
+	backupAgent          *bool
+	builtinPlugin        *bool
+	compressionLevel     *int
+	compressionWorkers   *int
+	content              *int
+	controlSocket        *string
+	copyAgent            *bool
+	copyList             *string
+	dataFile             *string
+	decompressionBlocks  *int
+	destPluginConfigFile *string
+	destSegCount         *int
+	oidFile              *string
+	onErrorContinue      *bool
+	origSegCount         *int
+	pipeFile             *string
+	pluginConfigFile     *string
+	printVersion         *bool
+	resizeCluster        *bool
+	restoreAgent         *bool
+	tocFile              *string
 )
 
 func DoHelper() {
@@ -77,15 +97,28 @@ func DoHelper() {
 		}
 	}()
 
+	if *controlSocket != "" {
+		agentServer, err = utils.ServeAgentControl(*controlSocket, agentProgress)
+		if err != nil {
+			gplog.Error(fmt.Sprintf("Unable to listen on control socket %s: %v", *controlSocket, err))
+			return
+		}
+		go handleStopRequest()
+	}
+
 	if *backupAgent {
 		err = doBackupAgent()
 	} else if *restoreAgent {
 		err = doRestoreAgent()
+	} else if *copyAgent {
+		err = doCopyAgent()
 	}
 	if err != nil {
 		gplog.Error(fmt.Sprintf("%v: %s", err, debug.Stack()))
-		handle, _ := iohelper.OpenFileForWriting(fmt.Sprintf("%s_error", *pipeFile))
-		_ = handle.Close()
+	}
+	agentProgress.Finish(err)
+	if agentServer != nil {
+		waitForStopRequest()
 	}
 }
 
@@ -95,14 +128,24 @@ func InitializeGlobals() {
 	gplog.InitializeLogging("gpbackup_helper", "")
 
 	backupAgent = flag.Bool("backup-agent", false, "Use gpbackup_helper as an agent for backup")
+	builtinPlugin = flag.Bool("builtin-plugin", false, "Run the given plugin command using the built-in backend specified in the plugin config")
 	content = flag.Int("content", -2, "Content ID of the corresponding segment")
+	controlSocket = flag.String("control-socket", "", "Absolute path to the Unix socket on which to serve status and stop requests")
+	copyAgent = flag.Bool("copy-agent", false, "Use gpbackup_helper as an agent for copying a backup")
+	copyList = flag.String("copy-list", "", "Absolute path to the file containing a list of files to copy, used with --copy-agent")
 	compressionLevel = flag.Int("compression-level", 0, "The level of compression to use with gzip. O indicates no compression.")
+	compressionWorkers = flag.Int("compression-workers", 1, "The number of goroutines to use to compress data, used with --backup-agent")
 	dataFile = flag.String("data-file", "", "Absolute path to the data file")
+	decompressionBlocks = flag.Int("decompression-blocks", 1, "The number of blocks to read ahead of the data being restored, used with --restore-agent")
+	destPluginConfigFile = flag.String("dest-plugin-config", "", "The configuration file to use for the plugin to copy to, used with --copy-agent")
+	destSegCount = flag.Int("dest-seg-count", 0, "The number of segments in the restore cluster, used with --resize-cluster")
 	oidFile = flag.String("oid-file", "", "Absolute path to the file containing a list of oids to restore")
 	onErrorContinue = flag.Bool("on-error-continue", false, "Continue restore even when encountering an error")
+	origSegCount = flag.Int("orig-seg-count", 0, "The number of segments in the backup cluster, used with --resize-cluster")
 	pipeFile = flag.String("pipe-file", "", "Absolute path to the pipe file")
 	pluginConfigFile = flag.String("plugin-config", "", "The configuration file to use for a plugin")
 	printVersion = flag.Bool("version", false, "Print version number and exit")
+	resizeCluster = flag.Bool("resize-cluster", false, "Restore the data of every backup segment assigned to this segment when the backup and restore clusters differ in size")
 	restoreAgent = flag.Bool("restore-agent", false, "Use gpbackup_helper as an agent for restore")
 	tocFile = flag.String("toc-file", "", "Absolute path to the table of contents file")
 
@@ -113,16 +156,104 @@ func InitializeGlobals() {
 
 	flag.Parse()
 	if *printVersion {
-		fmt.Printf("gpbackup_helper version %s\n", version)
+		if *builtinPlugin {
+			fmt.Printf("%s version %s\n", builtinPluginName, version)
+		} else {
+			fmt.Printf("gpbackup_helper version %s\n", version)
+		}
 		os.Exit(0)
 	}
 	operating.InitializeSystemFunctions()
+	if *builtinPlugin {
+		err := runBuiltinPluginCommand(flag.Args())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, storage.FormatPluginError(err))
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	agentProgress = utils.NewAgentProgress(*content)
 }
 
 /*
  * Shared functions
  */
 
+/*
+ * A stop request for an agent that is still running comes from a gpbackup
+ * or gprestore process that is cleaning up after a failure, so the agent
+ * aborts as it would on a termination signal.
+ */
+func handleStopRequest() {
+	<-agentServer.StopRequested()
+	if agentProgress.Status().State == utils.AgentRunning {
+		gplog.Warn("Received a stop request, aborting helper agent on segment %d", *content)
+		wasTerminated = true
+		DoCleanup()
+		os.Exit(2)
+	}
+}
+
+/*
+ * A finished agent keeps serving its final status until it is stopped, and
+ * exits on its own if nothing has called it for a while in case the process
+ * that started it has gone away.
+ */
+func waitForStopRequest() {
+	finishTime := time.Now()
+	for {
+		lastActivity := agentProgress.LastCallTime()
+		if lastActivity.Before(finishTime) {
+			lastActivity = finishTime
+		}
+		idleTime := time.Since(lastActivity)
+		if idleTime >= agentIdleTimeout {
+			log("No requests received for %s, exiting", agentIdleTimeout)
+			return
+		}
+		select {
+		case <-agentServer.StopRequested():
+			return
+		case <-time.After(agentIdleTimeout - idleTime):
+		}
+	}
+}
+
+type progressWriter struct {
+	oid    uint32
+	writer io.Writer
+}
+
+func (progress progressWriter) Write(p []byte) (int, error) {
+	numBytes, err := progress.writer.Write(p)
+	agentProgress.AddBytes(progress.oid, int64(numBytes))
+	return numBytes, err
+}
+
+func runBuiltinPluginCommand(args []string) error {
+	if len(args) > 0 && args[0] == "plugin_api_version" {
+		fmt.Println(utils.PluginAPIVersion2)
+		return nil
+	}
+	if len(args) > 0 && args[0] == "plugin_capabilities" {
+		output, _ := json.Marshal(storage.BuiltinCapabilities)
+		fmt.Println(string(output))
+		return nil
+	}
+	if len(args) < 2 {
+		return errors.New("No plugin config specified")
+	}
+	pluginConfig, err := utils.ReadPluginConfig(args[1])
+	if err != nil {
+		return err
+	}
+	backend, err := pluginConfig.GetStorageBackend()
+	if err != nil {
+		return err
+	}
+	return storage.RunPluginCommand(backend, args, os.Stdin, os.Stdout)
+}
+
 func createPipe(pipe string) error {
 	err := syscall.Mkfifo(pipe, 0777)
 	return err
@@ -182,14 +313,15 @@ func removeFileIfExists(filename string) error {
 
 func DoCleanup() {
 	defer CleanupGroup.Done()
-	if wasTerminated {
+	if agentServer != nil {
 		/*
-		 * If the agent dies during the last table copy, it can still report
-		 * success, so we create an error file and check for its presence in
-		 * gprestore after the COPYs are finished.
+		 * Removing the socket of an agent that was terminated tells gpbackup or
+		 * gprestore that it did not finish, even if it was copying the last table.
 		 */
-		handle, _ := iohelper.OpenFileForWriting(fmt.Sprintf("%s_error", *pipeFile))
-		_ = handle.Close()
+		err := agentServer.Close()
+		if err != nil {
+			log("Encountered error during cleanup: %v", err)
+		}
 	}
 	err := flushAndCloseRestoreWriter()
 	if err != nil {
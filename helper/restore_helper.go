@@ -6,9 +6,10 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"strings"
 
+	"github.com/greenplum-db/gpbackup/backup_filepath"
+	"github.com/greenplum-db/gpbackup/storage"
 	"github.com/greenplum-db/gpbackup/utils"
 	"github.com/pkg/errors"
 )
@@ -17,9 +18,22 @@ import (
  * Restore specific functions
  */
 
+/*
+ * Skipping less data than this is cheaper than opening the data file again,
+ * which with a plugin starts another plugin process.
+ */
+const minSeekDistance = 16 * 1024 * 1024
+
+type restoreReader struct {
+	tocEntries   map[uint]utils.SegmentDataEntry
+	dataFilename string
+	backend      storage.StorageBackend
+	readHandle   io.ReadCloser
+	reader       *bufio.Reader
+	lastByte     uint64
+}
+
 func doRestoreAgent() error {
-	tocEntries := utils.NewSegmentTOC(*tocFile).DataEntries
-	var lastByte uint64
 	var bytesRead int64
 	var start uint64
 	var end uint64
@@ -32,7 +46,7 @@ func doRestoreAgent() error {
 		return err
 	}
 
-	reader, err := getRestoreDataReader()
+	readers, err := getRestoreReaders()
 	if err != nil {
 		return err
 	}
@@ -43,6 +57,7 @@ func doRestoreAgent() error {
 		}
 
 		currentPipe = fmt.Sprintf("%s_%d", *pipeFile, oidList[i])
+		agentProgress.StartTable(uint32(oid))
 		if i < len(oidList)-1 {
 			nextPipe = fmt.Sprintf("%s_%d", *pipeFile, oidList[i+1])
 			log(fmt.Sprintf("Creating pipe for oid %d: %s", oidList[i+1], nextPipe))
@@ -55,9 +70,6 @@ func doRestoreAgent() error {
 			}
 		}
 
-		start = tocEntries[uint(oid)].StartByte
-		end = tocEntries[uint(oid)].EndByte
-
 		log(fmt.Sprintf("Opening pipe for oid %d: %s", oid, currentPipe))
 		writer, writeHandle, err = getRestorePipeWriter(currentPipe)
 		if err != nil {
@@ -68,27 +80,52 @@ func doRestoreAgent() error {
 			return err
 		}
 
-		log(fmt.Sprintf("Data Reader - Start Byte: %d; End Byte: %d; Last Byte: %d", start, end, lastByte))
-		numDiscarded, err = reader.Discard(int(start - lastByte))
-		if err != nil {
-			// Always hard quit if data reader has issues
-			_ = removeFileIfExists(currentPipe)
-			return err
-		}
-		log(fmt.Sprintf("Data Reader discarded %d bytes", numDiscarded))
+		/*
+		 * Each reader holds the data of one segment of the backup cluster, so
+		 * when restoring to a smaller cluster the data of every original
+		 * segment assigned to this segment is written to the pipe in turn.
+		 */
+		for _, r := range readers {
+			entry, ok := r.tocEntries[uint(oid)]
+			if !ok {
+				log(fmt.Sprintf("No data for oid %d in %s", oid, r.dataFilename))
+				continue
+			}
+			start = entry.StartByte
+			end = entry.EndByte
 
-		log(fmt.Sprintf("Restoring table with oid %d", oid))
-		bytesRead, err = io.CopyN(writer, reader, int64(end-start))
-		if err != nil {
-			// In case COPY FROM or copyN fails in the middle of a load. We
-			// need to update the lastByte with the amount of bytes that was
-			// copied before it errored out
-			lastByte += uint64(bytesRead)
-			err = errors.Wrap(err, strings.Trim(errBuf.String(), "\x00"))
-			goto LoopEnd
+			log(fmt.Sprintf("Data Reader - Start Byte: %d; End Byte: %d; Last Byte: %d", start, end, r.lastByte))
+			if start < r.lastByte {
+				// Always hard quit if data reader has issues
+				_ = removeFileIfExists(currentPipe)
+				return errors.Errorf("Data for oid %d starts at byte %d of %s, but the data has already been read up to byte %d", oid, start, r.dataFilename, r.lastByte)
+			}
+			err = r.seekTo(entry)
+			if err != nil {
+				// Always hard quit if data reader has issues
+				_ = removeFileIfExists(currentPipe)
+				return err
+			}
+			numDiscarded, err = r.reader.Discard(int(start - r.lastByte))
+			if err != nil {
+				// Always hard quit if data reader has issues
+				_ = removeFileIfExists(currentPipe)
+				return err
+			}
+			log(fmt.Sprintf("Data Reader discarded %d bytes", numDiscarded))
+
+			log(fmt.Sprintf("Restoring table with oid %d", oid))
+			bytesRead, err = io.CopyN(progressWriter{oid: uint32(oid), writer: writer}, r.reader, int64(end-start))
+			if err != nil {
+				// In case COPY FROM or copyN fails in the middle of a load. We
+				// need to update the lastByte with the amount of bytes that was
+				// copied before it errored out
+				r.lastByte += uint64(bytesRead)
+				goto LoopEnd
+			}
+			r.lastByte = end
+			log(fmt.Sprintf("Copied %d bytes into the pipe", bytesRead))
 		}
-		lastByte = end
-		log(fmt.Sprintf("Copied %d bytes into the pipe", bytesRead))
 
 		log(fmt.Sprintf("Closing pipe for oid %d: %s", oid, currentPipe))
 		err = flushAndCloseRestoreWriter()
@@ -97,6 +134,7 @@ func doRestoreAgent() error {
 		}
 
 	LoopEnd:
+		agentProgress.FinishTable(uint32(oid), err)
 		log(fmt.Sprintf("Removing pipe for oid %d: %s", oid, currentPipe))
 		errRemove = removeFileIfExists(currentPipe)
 		if errRemove != nil {
@@ -119,61 +157,110 @@ func doRestoreAgent() error {
 	return lastError
 }
 
-func getRestoreDataReader() (*bufio.Reader, error) {
-	var readHandle io.Reader
-	var err error
-	if *pluginConfigFile != "" {
-		readHandle, err = startRestorePluginCommand()
-	} else {
-		readHandle, err = os.Open(*dataFile)
+/*
+ * When restoring to a cluster with a different number of segments, this
+ * segment restores the data of every original segment whose content ID is
+ * congruent to its own modulo the number of segments in the restore cluster.
+ */
+func getRestoreContentIDs() []int {
+	if !*resizeCluster {
+		return []int{*content}
+	}
+	contentIDs := make([]int, 0)
+	for contentID := *content; contentID < *origSegCount; contentID += *destSegCount {
+		contentIDs = append(contentIDs, contentID)
 	}
+	return contentIDs
+}
+
+func getRestoreReaders() ([]*restoreReader, error) {
+	backend, err := getRestoreBackend()
 	if err != nil {
 		return nil, err
 	}
-
-	var bufIoReader *bufio.Reader
-	if strings.HasSuffix(*dataFile, ".gz") {
-		gzipReader, err := gzip.NewReader(readHandle)
+	readers := make([]*restoreReader, 0)
+	for _, contentID := range getRestoreContentIDs() {
+		tocFilename := backup_filepath.ReplaceContentInFilePath(*tocFile, *content, contentID)
+		dataFilename := backup_filepath.ReplaceContentInFilePath(*dataFile, *content, contentID)
+		log(fmt.Sprintf("Reading data for segment %d from %s", contentID, dataFilename))
+		reader := &restoreReader{tocEntries: utils.NewSegmentTOC(tocFilename).DataEntries, dataFilename: dataFilename, backend: backend}
+		err = reader.openAt(0, 0)
 		if err != nil {
 			return nil, err
 		}
-		bufIoReader = bufio.NewReader(gzipReader)
-	} else {
-		bufIoReader = bufio.NewReader(readHandle)
-	}
-	// Check that no error has occurred in plugin command
-	errMsg := strings.Trim(errBuf.String(), "\x00")
-	if len(errMsg) != 0 {
-		return nil, errors.New(errMsg)
+		readers = append(readers, reader)
 	}
-	return bufIoReader, nil
+	return readers, nil
 }
 
-func getRestorePipeWriter(currentPipe string) (*bufio.Writer, *os.File, error) {
-	// Opening this pipe will block until a reader connects to the pipe
-	fileHandle, err := os.OpenFile(currentPipe, os.O_WRONLY, os.ModeNamedPipe)
-	if err != nil {
-		return nil, nil, err
+func getRestoreBackend() (storage.StorageBackend, error) {
+	if *pluginConfigFile == "" {
+		return &storage.FilesystemBackend{}, nil
 	}
-	pipeWriter := bufio.NewWriter(fileHandle)
-	return pipeWriter, fileHandle, nil
-}
-
-func startRestorePluginCommand() (io.Reader, error) {
 	pluginConfig, err := utils.ReadPluginConfig(*pluginConfigFile)
 	if err != nil {
 		return nil, err
 	}
-	cmdStr := fmt.Sprintf("%s restore_data %s %s", pluginConfig.ExecutablePath, pluginConfig.ConfigPath, *dataFile)
-	cmd := exec.Command("bash", "-c", cmdStr)
+	return pluginConfig.GetStorageBackend()
+}
 
-	readHandle, err := cmd.StdoutPipe()
+// Opens the data file at fileOffset, which is at lastByte in the uncompressed data
+func (r *restoreReader) openAt(fileOffset uint64, lastByte uint64) error {
+	if r.readHandle != nil {
+		_ = r.readHandle.Close()
+	}
+	readHandle, err := storage.GetStreamAt(r.backend, r.dataFilename, int64(fileOffset))
 	if err != nil {
-		return nil, err
+		return err
 	}
-	cmd.Stderr = &errBuf
+	r.readHandle = readHandle
 
-	err = cmd.Start()
-	return readHandle, err
+	var dataReader io.Reader = readHandle
+	if r.isCompressed() {
+		if *decompressionBlocks > 1 {
+			dataReader, err = utils.NewReadAheadGzipReader(readHandle, *decompressionBlocks)
+		} else {
+			dataReader, err = gzip.NewReader(readHandle)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	r.reader = bufio.NewReader(dataReader)
+	r.lastByte = lastByte
+	return nil
+}
 
+func (r *restoreReader) isCompressed() bool {
+	return strings.HasSuffix(r.dataFilename, ".gz")
+}
+
+/*
+ * A table far enough ahead in the data file is read by opening the file again
+ * at the start of the table's data, rather than by reading and discarding all
+ * of the data before it.  This is only possible for compressed files if each
+ * table was compressed separately.
+ */
+func (r *restoreReader) seekTo(entry utils.SegmentDataEntry) error {
+	if entry.StartByte < r.lastByte+minSeekDistance || !storage.SupportsOffsets(r.backend) {
+		return nil
+	}
+	if !r.isCompressed() {
+		log(fmt.Sprintf("Data Reader seeking to byte %d", entry.StartByte))
+		return r.openAt(entry.StartByte, entry.StartByte)
+	} else if entry.HasFrame() {
+		log(fmt.Sprintf("Data Reader seeking to compressed byte %d", entry.FrameStartByte))
+		return r.openAt(entry.FrameStartByte, entry.StartByte)
+	}
+	return nil
+}
+
+func getRestorePipeWriter(currentPipe string) (*bufio.Writer, *os.File, error) {
+	// Opening this pipe will block until a reader connects to the pipe
+	fileHandle, err := os.OpenFile(currentPipe, os.O_WRONLY, os.ModeNamedPipe)
+	if err != nil {
+		return nil, nil, err
+	}
+	pipeWriter := bufio.NewWriter(fileHandle)
+	return pipeWriter, fileHandle, nil
 }
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"math"
+	"net/rpc"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -14,6 +15,7 @@ import (
 	"time"
 
 	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/utils"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -26,7 +28,7 @@ var (
 	pipeFile         = fmt.Sprintf("%s/test_pipe", testDir)
 	dataFileFullPath = filepath.Join(testDir, "test_data")
 	pluginBackupPath = filepath.Join(pluginDir, "test_data")
-	errorFile        = fmt.Sprintf("%s_error", pipeFile)
+	socketFile       = "/tmp/helper_test_agent/agent.sock"
 	pluginConfigPath = fmt.Sprintf("%s/go/src/github.com/greenplum-db/gpbackup/plugins/example_plugin_config.yaml", os.Getenv("HOME"))
 )
 
@@ -50,13 +52,34 @@ here is some data
 )
 
 func gpbackupHelper(helperPath string, args ...string) *exec.Cmd {
-	args = append([]string{"--toc-file", tocFile, "--oid-file", oidFile, "--pipe-file", pipeFile, "--content", "1"}, args...)
+	args = append([]string{"--toc-file", tocFile, "--oid-file", oidFile, "--pipe-file", pipeFile, "--content", "1", "--control-socket", socketFile}, args...)
 	command := exec.Command(helperPath, args...)
 	err := command.Start()
 	Expect(err).ToNot(HaveOccurred())
 	return command
 }
 
+/*
+ * The agent waits to be stopped once it has finished, so we wait for it to
+ * finish through its control socket, then stop it and wait for it to exit.
+ */
+func finishHelper(helperCmd *exec.Cmd) (utils.AgentStatus, error) {
+	var client *rpc.Client
+	Eventually(func() error {
+		var err error
+		client, err = utils.DialAgent(socketFile)
+		return err
+	}, 5*time.Second, 100*time.Millisecond).Should(Succeed())
+	defer client.Close()
+	status := utils.AgentStatus{}
+	Eventually(func() string {
+		status, _ = utils.GetAgentStatus(client)
+		return status.State
+	}, 10*time.Second, 100*time.Millisecond).ShouldNot(Equal(utils.AgentRunning))
+	Expect(utils.StopAgent(client)).To(Succeed())
+	return status, helperCmd.Wait()
+}
+
 func buildAndInstallBinaries() string {
 	_ = os.Chdir("..")
 	command := exec.Command("make", "build")
@@ -94,43 +117,51 @@ var _ = Describe("gpbackup_helper end to end integration tests", func() {
 		It("runs backup gpbackup_helper without compression", func() {
 			helperCmd := gpbackupHelper(gpbackupHelperPath, "--backup-agent", "--compression-level", "0", "--data-file", dataFileFullPath)
 			writeToPipes(defaultData)
-			err := helperCmd.Wait()
+			status, err := finishHelper(helperCmd)
 			printHelperLogOnError(err)
 			Expect(err).ToNot(HaveOccurred())
-			assertBackupArtifacts(false, false)
+			assertBackupArtifacts(status, false, false)
 		})
 		It("runs backup gpbackup_helper with data exceeding pipe buffer size", func() {
 			helperCmd := gpbackupHelper(gpbackupHelperPath, "--backup-agent", "--compression-level", "0", "--data-file", dataFileFullPath)
 			writeToPipes(strings.Repeat("a", int(math.Pow(2, 17))))
-			err := helperCmd.Wait()
+			_, err := finishHelper(helperCmd)
 			printHelperLogOnError(err)
 			Expect(err).ToNot(HaveOccurred())
 		})
 		It("runs backup gpbackup_helper with compression", func() {
 			helperCmd := gpbackupHelper(gpbackupHelperPath, "--backup-agent", "--compression-level", "1", "--data-file", dataFileFullPath+".gz")
 			writeToPipes(defaultData)
-			err := helperCmd.Wait()
+			status, err := finishHelper(helperCmd)
+			printHelperLogOnError(err)
+			Expect(err).ToNot(HaveOccurred())
+			assertBackupArtifacts(status, true, false)
+		})
+		It("runs backup gpbackup_helper with parallel compression", func() {
+			helperCmd := gpbackupHelper(gpbackupHelperPath, "--backup-agent", "--compression-level", "1", "--compression-workers", "4", "--data-file", dataFileFullPath+".gz")
+			writeToPipes(defaultData)
+			status, err := finishHelper(helperCmd)
 			printHelperLogOnError(err)
 			Expect(err).ToNot(HaveOccurred())
-			assertBackupArtifacts(true, false)
+			assertBackupArtifacts(status, true, false)
 		})
 		It("runs backup gpbackup_helper without compression with plugin", func() {
 			helperCmd := gpbackupHelper(gpbackupHelperPath, "--backup-agent", "--compression-level", "0", "--data-file", dataFileFullPath, "--plugin-config", pluginConfigPath)
 			writeToPipes(defaultData)
-			err := helperCmd.Wait()
+			status, err := finishHelper(helperCmd)
 			printHelperLogOnError(err)
 			Expect(err).ToNot(HaveOccurred())
-			assertBackupArtifacts(false, true)
+			assertBackupArtifacts(status, false, true)
 		})
 		It("runs backup gpbackup_helper with compression with plugin", func() {
 			helperCmd := gpbackupHelper(gpbackupHelperPath, "--backup-agent", "--compression-level", "1", "--data-file", dataFileFullPath+".gz", "--plugin-config", pluginConfigPath)
 			writeToPipes(defaultData)
-			err := helperCmd.Wait()
+			status, err := finishHelper(helperCmd)
 			printHelperLogOnError(err)
 			Expect(err).ToNot(HaveOccurred())
-			assertBackupArtifacts(true, true)
+			assertBackupArtifacts(status, true, true)
 		})
-		It("Generates error file when backup agent interrupted", func() {
+		It("Removes its control socket when backup agent interrupted", func() {
 			helperCmd := gpbackupHelper(gpbackupHelperPath, "--backup-agent", "--compression-level", "0", "--data-file", dataFileFullPath)
 			time.Sleep(200 * time.Millisecond)
 			err := helperCmd.Process.Signal(os.Interrupt)
@@ -148,10 +179,10 @@ var _ = Describe("gpbackup_helper end to end integration tests", func() {
 				contents, _ := ioutil.ReadFile(fmt.Sprintf("%s_%d", pipeFile, i))
 				Expect(string(contents)).To(Equal("here is some data\n"))
 			}
-			err := helperCmd.Wait()
+			status, err := finishHelper(helperCmd)
 			printHelperLogOnError(err)
 			Expect(err).ToNot(HaveOccurred())
-			assertNoErrors()
+			assertNoErrors(status)
 		})
 		It("runs restore gpbackup_helper with compression", func() {
 			setupRestoreFiles(true, false)
@@ -160,10 +191,22 @@ var _ = Describe("gpbackup_helper end to end integration tests", func() {
 				contents, _ := ioutil.ReadFile(fmt.Sprintf("%s_%d", pipeFile, i))
 				Expect(string(contents)).To(Equal("here is some data\n"))
 			}
-			err := helperCmd.Wait()
+			status, err := finishHelper(helperCmd)
 			printHelperLogOnError(err)
 			Expect(err).ToNot(HaveOccurred())
-			assertNoErrors()
+			assertNoErrors(status)
+		})
+		It("runs restore gpbackup_helper with decompression ahead of the pipes", func() {
+			setupRestoreFiles(true, false)
+			helperCmd := gpbackupHelper(gpbackupHelperPath, "--restore-agent", "--decompression-blocks", "4", "--data-file", dataFileFullPath+".gz")
+			for _, i := range []int{1, 3} {
+				contents, _ := ioutil.ReadFile(fmt.Sprintf("%s_%d", pipeFile, i))
+				Expect(string(contents)).To(Equal("here is some data\n"))
+			}
+			status, err := finishHelper(helperCmd)
+			printHelperLogOnError(err)
+			Expect(err).ToNot(HaveOccurred())
+			assertNoErrors(status)
 		})
 		It("runs restore gpbackup_helper without compression with plugin", func() {
 			setupRestoreFiles(false, true)
@@ -172,10 +215,10 @@ var _ = Describe("gpbackup_helper end to end integration tests", func() {
 				contents, _ := ioutil.ReadFile(fmt.Sprintf("%s_%d", pipeFile, i))
 				Expect(string(contents)).To(Equal("here is some data\n"))
 			}
-			err := helperCmd.Wait()
+			status, err := finishHelper(helperCmd)
 			printHelperLogOnError(err)
 			Expect(err).ToNot(HaveOccurred())
-			assertNoErrors()
+			assertNoErrors(status)
 		})
 		It("runs restore gpbackup_helper with compression with plugin", func() {
 			setupRestoreFiles(true, true)
@@ -184,12 +227,12 @@ var _ = Describe("gpbackup_helper end to end integration tests", func() {
 				contents, _ := ioutil.ReadFile(fmt.Sprintf("%s_%d", pipeFile, i))
 				Expect(string(contents)).To(Equal("here is some data\n"))
 			}
-			err := helperCmd.Wait()
+			status, err := finishHelper(helperCmd)
 			printHelperLogOnError(err)
 			Expect(err).ToNot(HaveOccurred())
-			assertNoErrors()
+			assertNoErrors(status)
 		})
-		It("Generates error file when restore agent interrupted", func() {
+		It("Removes its control socket when restore agent interrupted", func() {
 			setupRestoreFiles(true, false)
 			helperCmd := gpbackupHelper(gpbackupHelperPath, "--restore-agent", "--data-file", dataFileFullPath+".gz")
 			time.Sleep(200 * time.Millisecond)
@@ -253,14 +296,18 @@ var _ = Describe("gpbackup_helper end to end integration tests", func() {
 			}
 
 			// Block here until gpbackup_helper finishes (cleaning up pipes)
-			_ = helperCmd.Wait()
+			status, _ := finishHelper(helperCmd)
 			for _, i := range []int{1, 2, 3} {
 				currentPipe := fmt.Sprintf("%s_%d", pipeFile, i)
 				Expect(currentPipe).ToNot(BeAnExistingFile())
 			}
 
-			// Check that an error file was created
-			Expect(errorFile).To(BeAnExistingFile())
+			// Check that the error was reported for the table that could not be restored
+			Expect(status.State).To(Equal(utils.AgentFailed))
+			Expect(status.Tables).To(HaveLen(3))
+			Expect(status.Tables[0].Error).To(BeEmpty())
+			Expect(status.Tables[1].Error).ToNot(BeEmpty())
+			Expect(status.Tables[2].Error).To(BeEmpty())
 		})
 	})
 })
@@ -286,20 +333,21 @@ func setupRestoreFiles(withCompression bool, withPlugin bool) {
 	_, _ = f.WriteString(expectedTOC)
 }
 
-func assertNoErrors() {
-	Expect(errorFile).To(Not(BeARegularFile()))
+func assertNoErrors(status utils.AgentStatus) {
+	Expect(status.State).To(Equal(utils.AgentFinished))
+	Expect(socketFile).ToNot(BeAnExistingFile())
 	pipes, err := filepath.Glob(pipeFile + "_[1-9]*")
 	Expect(err).ToNot(HaveOccurred())
 	Expect(pipes).To(BeEmpty())
 }
 
 func assertErrorsHandled() {
-	Expect(errorFile).To(BeARegularFile())
+	Expect(socketFile).ToNot(BeAnExistingFile())
 	pipes, err := filepath.Glob(pipeFile + "_[1-9]*")
 	Expect(err).ToNot(HaveOccurred())
 	Expect(pipes).To(BeEmpty())
 }
-func assertBackupArtifacts(withCompression bool, withPlugin bool) {
+func assertBackupArtifacts(status utils.AgentStatus, withCompression bool, withPlugin bool) {
 	var contents []byte
 	var err error
 	dataFile := dataFileFullPath
@@ -318,10 +366,34 @@ func assertBackupArtifacts(withCompression bool, withPlugin bool) {
 	}
 	Expect(string(contents)).To(Equal(expectedData))
 
-	contents, err = ioutil.ReadFile(tocFile)
+	if withCompression {
+		assertTableFrames(dataFile + ".gz")
+	} else {
+		contents, err = ioutil.ReadFile(tocFile)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(contents)).To(Equal(expectedTOC))
+	}
+	assertNoErrors(status)
+}
+
+// Each table is compressed separately, so it can be decompressed starting from its frame
+func assertTableFrames(dataFile string) {
+	contents, err := ioutil.ReadFile(dataFile)
 	Expect(err).ToNot(HaveOccurred())
-	Expect(string(contents)).To(Equal(expectedTOC))
-	assertNoErrors()
+	toc := utils.NewSegmentTOC(tocFile)
+	var frameStart uint64
+	for _, oid := range []uint{1, 2, 3} {
+		entry := toc.DataEntries[oid]
+		Expect(entry.EndByte - entry.StartByte).To(Equal(uint64(len(defaultData))))
+		Expect(entry.FrameStartByte).To(Equal(frameStart))
+		r, err := gzip.NewReader(bytes.NewReader(contents[entry.FrameStartByte:entry.FrameEndByte]))
+		Expect(err).ToNot(HaveOccurred())
+		tableData, err := ioutil.ReadAll(r)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(tableData)).To(Equal(defaultData))
+		frameStart = entry.FrameEndByte
+	}
+	Expect(frameStart).To(Equal(uint64(len(contents))))
 }
 
 func printHelperLogOnError(helperErr error) {
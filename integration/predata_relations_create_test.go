@@ -451,6 +451,43 @@ SET SUBPARTITION TEMPLATE ` + `
 			structmatcher.ExpectStructsToMatch(&view, &resultViews[0])
 		})
 	})
+	Describe("PrintCreateMaterializedViewStatement", func() {
+		BeforeEach(func() {
+			testutils.SkipIfBefore6(connectionPool)
+		})
+		It("creates a materialized view with privileges, owner, and comment", func() {
+			mview := backup.MaterializedView{Oid: 1, Schema: "public", Name: "simplemview", Definition: " SELECT 1 AS a;", DistPolicy: "DISTRIBUTED BY (a)"}
+			mviewMetadata := testutils.DefaultMetadata("MATERIALIZED VIEW", true, true, true, false)
+
+			backup.PrintCreateMaterializedViewStatement(backupfile, toc, mview, mviewMetadata)
+
+			testhelper.AssertQueryRuns(connectionPool, buffer.String())
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP MATERIALIZED VIEW public.simplemview")
+
+			resultMViews := backup.GetMaterializedViews(connectionPool)
+			resultMetadataMap := backup.GetMetadataForObjectType(connectionPool, backup.TYPE_RELATION)
+
+			mview.Oid = testutils.OidFromObjectName(connectionPool, "public", "simplemview", backup.TYPE_RELATION)
+			Expect(resultMViews).To(HaveLen(1))
+			resultMetadata := resultMetadataMap[mview.GetUniqueID()]
+			structmatcher.ExpectStructsToMatch(&mview, &resultMViews[0])
+			structmatcher.ExpectStructsToMatch(&mviewMetadata, &resultMetadata)
+		})
+		It("creates a materialized view with options", func() {
+			mview := backup.MaterializedView{Oid: 1, Schema: "public", Name: "simplemview", Options: " WITH (fillfactor=50)", Definition: " SELECT 1 AS a;", DistPolicy: "DISTRIBUTED BY (a)"}
+
+			backup.PrintCreateMaterializedViewStatement(backupfile, toc, mview, backup.ObjectMetadata{})
+
+			testhelper.AssertQueryRuns(connectionPool, buffer.String())
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP MATERIALIZED VIEW public.simplemview")
+
+			resultMViews := backup.GetMaterializedViews(connectionPool)
+
+			mview.Oid = testutils.OidFromObjectName(connectionPool, "public", "simplemview", backup.TYPE_RELATION)
+			Expect(resultMViews).To(HaveLen(1))
+			structmatcher.ExpectStructsToMatch(&mview, &resultMViews[0])
+		})
+	})
 	Describe("PrintCreateSequenceStatements", func() {
 		var (
 			sequence            backup.Relation
@@ -346,6 +346,28 @@ PARTITION BY LIST (gender)
 			Expect(sequenceOwnerColumns).To(HaveLen(1))
 		})
 	})
+	Describe("GetColumnDefaultSequences", func() {
+		It("returns sequences used in column defaults", func() {
+			testhelper.AssertQueryRuns(connectionPool, "CREATE SEQUENCE public.my_sequence;")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP SEQUENCE public.my_sequence")
+			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.my_table(a int DEFAULT nextval('public.my_sequence'::regclass), b int);")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.my_table")
+
+			defaultSequences := backup.GetColumnDefaultSequences(connectionPool)
+
+			Expect(defaultSequences).To(Equal([]backup.ColumnDefaultSequence{{TableSchema: "public", TableName: "my_table", SequenceSchema: "public", SequenceName: "my_sequence"}}))
+		})
+		It("does not return sequences that are not used in column defaults", func() {
+			testhelper.AssertQueryRuns(connectionPool, "CREATE SEQUENCE public.my_sequence;")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP SEQUENCE public.my_sequence")
+			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.my_table(a int DEFAULT 1, b int);")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.my_table")
+
+			defaultSequences := backup.GetColumnDefaultSequences(connectionPool)
+
+			Expect(defaultSequences).To(BeEmpty())
+		})
+	})
 	Describe("GetAllSequences", func() {
 		It("returns a slice of definitions for all sequences", func() {
 			testhelper.AssertQueryRuns(connectionPool, "CREATE SEQUENCE public.seq_one START 3")
@@ -428,4 +450,41 @@ PARTITION BY LIST (gender)
 			structmatcher.ExpectStructsToMatchExcluding(&view, &results[0], "Oid")
 		})
 	})
+	Describe("GetMaterializedViews", func() {
+		BeforeEach(func() {
+			testutils.SkipIfBefore6(connectionPool)
+		})
+		It("returns a slice for a basic materialized view", func() {
+			testhelper.AssertQueryRuns(connectionPool, "CREATE MATERIALIZED VIEW public.simplemview AS SELECT 1 AS a DISTRIBUTED BY (a)")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP MATERIALIZED VIEW public.simplemview")
+
+			results := backup.GetMaterializedViews(connectionPool)
+
+			mview := backup.MaterializedView{Oid: 1, Schema: "public", Name: "simplemview", Definition: " SELECT 1 AS a;", DistPolicy: "DISTRIBUTED BY (a)"}
+
+			Expect(results).To(HaveLen(1))
+			structmatcher.ExpectStructsToMatchExcluding(&mview, &results[0], "Oid")
+		})
+		It("returns a slice for a materialized view with options and a tablespace", func() {
+			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLESPACE test_tablespace LOCATION '/tmp/test_dir'")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLESPACE test_tablespace")
+			testhelper.AssertQueryRuns(connectionPool, "CREATE MATERIALIZED VIEW public.simplemview WITH (fillfactor=50) TABLESPACE test_tablespace AS SELECT 1 AS a DISTRIBUTED BY (a)")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP MATERIALIZED VIEW public.simplemview")
+
+			results := backup.GetMaterializedViews(connectionPool)
+
+			mview := backup.MaterializedView{Oid: 1, Schema: "public", Name: "simplemview", Options: " WITH (fillfactor=50)", Tablespace: "test_tablespace", Definition: " SELECT 1 AS a;", DistPolicy: "DISTRIBUTED BY (a)"}
+
+			Expect(results).To(HaveLen(1))
+			structmatcher.ExpectStructsToMatchExcluding(&mview, &results[0], "Oid")
+		})
+		It("does not return regular views", func() {
+			testhelper.AssertQueryRuns(connectionPool, "CREATE VIEW public.simpleview AS SELECT 1")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP VIEW public.simpleview")
+
+			results := backup.GetMaterializedViews(connectionPool)
+
+			Expect(results).To(BeEmpty())
+		})
+	})
 })
@@ -0,0 +1,204 @@
+package manager
+
+/*
+ * This file contains the convert-to-sql command, which converts a backup set
+ * into a single plain-SQL dump, in the style of pg_dump, that psql can load
+ * without gprestore, including the table data of every backup in the restore
+ * plan as COPY ... FROM stdin blocks.
+ */
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/iohelper"
+	"github.com/greenplum-db/gpbackup/backup_history"
+	"github.com/greenplum-db/gpbackup/restore"
+	"github.com/greenplum-db/gpbackup/storage"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+const defaultPostgresTargetVersion = 12
+
+func NewConvertToSQLCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "convert-to-sql",
+		Short: "Convert a backup set into a plain-SQL dump that can be loaded with psql",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			defer DoTeardown()
+			cmdFlags = cmd.Flags()
+			ValidateConvertToSQLFlags(cmdFlags)
+			DoConvertToSQL()
+		}}
+	setLoggingFlagDefaults(cmd)
+	cmd.Flags().String(utils.BACKUP_DIR, "", "The absolute path of the directory in which the backup is located")
+	cmd.Flags().String(utils.OUTPUT_FILE, "", "The file to which to write the dump, instead of standard output")
+	cmd.Flags().String(utils.TARGET_FLAVOR, restore.TARGET_FLAVOR_GREENPLUM, "The type of database the dump is for, either greenplum or postgres. A dump for PostgreSQL has Greenplum-specific syntax removed from its metadata.")
+	cmd.Flags().Int(utils.TARGET_VERSION, defaultPostgresTargetVersion, "The major version of PostgreSQL the dump is for, with --target-flavor postgres")
+	cmd.Flags().String(utils.TIMESTAMP, "", "The timestamp of the backup, in the format YYYYMMDDHHMMSS")
+	cmd.Flags().Bool(utils.WITH_GLOBALS, false, "Include global metadata, such as roles and tablespaces")
+	_ = cmd.MarkFlagRequired(utils.BACKUP_DIR)
+	_ = cmd.MarkFlagRequired(utils.TIMESTAMP)
+	return cmd
+}
+
+func ValidateConvertToSQLFlags(flags *pflag.FlagSet) {
+	utils.CheckExclusiveFlags(flags, utils.DEBUG, utils.QUIET, utils.VERBOSE)
+	err := utils.ValidateFullPath(MustGetFlagString(utils.BACKUP_DIR))
+	gplog.FatalOnError(err)
+	targetFlavor := MustGetFlagString(utils.TARGET_FLAVOR)
+	if targetFlavor != restore.TARGET_FLAVOR_GREENPLUM && targetFlavor != restore.TARGET_FLAVOR_POSTGRES {
+		gplog.Fatal(errors.Errorf("Invalid target flavor %s. Valid values are %s and %s.", targetFlavor, restore.TARGET_FLAVOR_GREENPLUM, restore.TARGET_FLAVOR_POSTGRES), "")
+	}
+	if flags.Changed(utils.TARGET_VERSION) && targetFlavor != restore.TARGET_FLAVOR_POSTGRES {
+		gplog.Fatal(errors.Errorf("--%s can only be used with --%s %s", utils.TARGET_VERSION, utils.TARGET_FLAVOR, restore.TARGET_FLAVOR_POSTGRES), "")
+	}
+}
+
+func DoConvertToSQL() {
+	SetLoggerVerbosity()
+	timestamp := MustGetFlagString(utils.TIMESTAMP)
+	backupDir := MustGetFlagString(utils.BACKUP_DIR)
+	fpInfo := NewMasterFilePathInfo(backupDir, timestamp)
+	backupConfig := backup_history.ReadConfigFile(fpInfo.GetConfigFilePath())
+	toc := utils.NewTOC(fpInfo.GetTOCFilePath())
+	toc.InitializeMetadataEntryMap()
+	metadataFile := iohelper.MustOpenFileForReading(fpInfo.GetMetadataFilePath())
+	defer metadataFile.Close()
+
+	var converter *restore.PostgresConverter
+	if MustGetFlagString(utils.TARGET_FLAVOR) == restore.TARGET_FLAVOR_POSTGRES {
+		converter = restore.NewPostgresConverter(MustGetFlagInt(utils.TARGET_VERSION) * 10000)
+	}
+	preDataStatements := ExtractGlobalStatements(toc, metadataFile, StatementFilters{}, MustGetFlagBool(utils.WITH_GLOBALS))
+	preDataStatements = append(preDataStatements, ExtractSectionStatements(toc, metadataFile, "predata", StatementFilters{})...)
+	postDataStatements := ExtractSectionStatements(toc, metadataFile, "postdata", StatementFilters{})
+	// Backups taken before the TOC recorded replicated tables have them only in the CREATE TABLE statements
+	replicatedTables := utils.GetReplicatedTables(preDataStatements)
+	dataTargets := make(map[string]string)
+	if converter != nil {
+		preDataStatements = converter.ConvertStatements(preDataStatements)
+		postDataStatements = converter.ConvertStatements(postDataStatements)
+		dataTargets = converter.DataTargets
+	}
+	sources := make([]TableDataSource, 0)
+	if !backupConfig.MetadataOnly {
+		sources = GetRestorePlanDataSources(backupDir, backupConfig, toc)
+	}
+	for i := range sources {
+		if replicatedTables[utils.MakeFQN(sources[i].Entry.Schema, sources[i].Entry.Name)] {
+			sources[i].Replicated = true
+		}
+	}
+
+	var writer io.Writer = os.Stdout
+	if outputFile := MustGetFlagString(utils.OUTPUT_FILE); outputFile != "" {
+		file := iohelper.MustOpenFileForWriting(outputFile)
+		defer file.Close()
+		writer = file
+	}
+	bufferedWriter := bufio.NewWriter(writer)
+	PrintSQLStatements(bufferedWriter, preDataStatements)
+	backend := &storage.FilesystemBackend{}
+	for _, source := range sources {
+		tableFQN := utils.MakeFQN(source.Entry.Schema, source.Entry.Name)
+		tableName := tableFQN
+		if dataTarget, ok := dataTargets[tableFQN]; ok {
+			tableName = dataTarget
+		}
+		err := WriteCopyFromStdin(bufferedWriter, backend, source, tableName)
+		gplog.FatalOnError(err, fmt.Sprintf("Unable to convert data for table %s", tableFQN))
+	}
+	/*
+	 * Constraints are in the post-data section, so they are added after the
+	 * data is loaded, and foreign keys come last, after the primary keys and
+	 * unique indexes that they reference.
+	 */
+	postDataStatements, foreignKeyStatements := restore.SplitForeignKeyConstraints(postDataStatements)
+	PrintSQLStatements(bufferedWriter, append(postDataStatements, foreignKeyStatements...))
+	err := bufferedWriter.Flush()
+	gplog.FatalOnError(err, "Unable to write dump")
+	gplog.Info("Converted backup %s with %d statements and data for %d tables", timestamp, len(preDataStatements)+len(postDataStatements), len(sources))
+}
+
+/*
+ * Returns the data of every table in the restore plan of a backup, from the
+ * backup that holds it, in restore plan order.
+ */
+func GetRestorePlanDataSources(backupDir string, backupConfig *backup_history.BackupConfig, toc *utils.TOC) []TableDataSource {
+	if backupConfig.RestorePlan == nil {
+		restore.SetRestorePlanForLegacyBackup(toc, backupConfig.Timestamp, backupConfig)
+	}
+	sources := make([]TableDataSource, 0)
+	for _, planEntry := range backupConfig.RestorePlan {
+		dataFPInfo, dataConfig := ReadDataBackupConfig(backupDir, planEntry.Timestamp)
+		dataTOC := utils.NewTOC(dataFPInfo.GetTOCFilePath())
+		for _, entry := range dataTOC.GetDataEntriesMatching([]string{}, []string{}, []string{}, []string{}, planEntry.TableFQNs) {
+			sources = append(sources, NewTableDataSource(dataFPInfo, dataConfig, entry))
+		}
+	}
+	return sources
+}
+
+/*
+ * The data files hold the output of COPY ... TO ... WITH CSV, in which a
+ * quoted value may span several lines.  psql ends the data of COPY ... FROM
+ * stdin at any line consisting only of \., even inside a quoted value, so
+ * the rows are rewritten in the text format of COPY, in which the newlines
+ * and backslashes of every value are escaped.
+ */
+func WriteCopyFromStdin(writer io.Writer, backend storage.StorageBackend, source TableDataSource, tableName string) error {
+	_, err := fmt.Fprintf(writer, "\n\nCOPY %s%s FROM stdin;\n", tableName, source.Entry.AttributeString)
+	if err != nil {
+		return err
+	}
+	for _, contentID := range source.ContentIDs() {
+		reader, err := source.OpenSegmentData(backend, contentID)
+		if err != nil {
+			return err
+		}
+		err = writeCopyTextRows(writer, NewCopyCSVReader(reader))
+		closeErr := reader.Close()
+		if err != nil {
+			return errors.Wrapf(err, "Unable to read data of segment %d", contentID)
+		}
+		if closeErr != nil {
+			return closeErr
+		}
+	}
+	_, err = fmt.Fprintln(writer, `\.`)
+	return err
+}
+
+var copyTextEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+func writeCopyTextRows(writer io.Writer, csvReader *CopyCSVReader) error {
+	for {
+		row, err := csvReader.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		fields := make([]string, len(row))
+		for i, field := range row {
+			if field.Valid {
+				fields[i] = copyTextEscaper.Replace(field.String)
+			} else {
+				fields[i] = `\N`
+			}
+		}
+		_, err = fmt.Fprintf(writer, "%s\n", strings.Join(fields, "\t"))
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package manager_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+
+	"github.com/greenplum-db/gpbackup/backup_filepath"
+	"github.com/greenplum-db/gpbackup/manager"
+	"github.com/greenplum-db/gpbackup/storage"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("manager/convert_sql tests", func() {
+	Describe("WriteCopyFromStdin", func() {
+		var tempDir string
+		var source manager.TableDataSource
+		var buffer *bytes.Buffer
+		BeforeEach(func() {
+			tempDir, _ = ioutil.TempDir("", "convert_sql")
+			fpInfo := backup_filepath.FilePathInfo{UserSpecifiedBackupDir: tempDir, UserSpecifiedSegPrefix: "gpseg", Timestamp: "20170101010101"}
+			source = manager.TableDataSource{FPInfo: fpInfo, Entry: utils.MasterDataEntry{Schema: "public", Name: "foo", Oid: 3, AttributeString: "(i,j)"}, SegmentCount: 2}
+			buffer = &bytes.Buffer{}
+			writeTestFile(fpInfo.GetTableBackupFilePath(0, 3, "", false), []byte("1,a\n2,\"\\.\"\n"))
+			writeTestFile(fpInfo.GetTableBackupFilePath(1, 3, "", false), []byte("3,\n"))
+		})
+		AfterEach(func() {
+			_ = os.RemoveAll(tempDir)
+		})
+		It("writes the data of every segment in a COPY block", func() {
+			err := manager.WriteCopyFromStdin(buffer, &storage.FilesystemBackend{}, source, "public.foo")
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(buffer.String()).To(Equal(`
+
+COPY public.foo(i,j) FROM stdin;
+1	a
+2	\\.
+3	\N
+\.
+`))
+		})
+		It("writes only the first segment's copy of a replicated table into the given table", func() {
+			source.Replicated = true
+
+			err := manager.WriteCopyFromStdin(buffer, &storage.FilesystemBackend{}, source, "public.parent")
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(buffer.String()).To(Equal(`
+
+COPY public.parent(i,j) FROM stdin;
+1	a
+2	\\.
+\.
+`))
+		})
+		It("escapes values so that no line of a multi-line value ends the data", func() {
+			source.SegmentCount = 1
+			writeTestFile(source.FPInfo.GetTableBackupFilePath(0, 3, "", false), []byte("1,\"first\n\\.\nlast\"\n2,\"\"\n3,\"a\tb\\c\"\n"))
+
+			err := manager.WriteCopyFromStdin(buffer, &storage.FilesystemBackend{}, source, "public.foo")
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(buffer.String()).To(Equal(`
+
+COPY public.foo(i,j) FROM stdin;
+1	first\n\\.\nlast
+2	
+3	a\tb\\c
+\.
+`))
+		})
+		It("returns an error if a segment's data file is missing", func() {
+			source.SegmentCount = 3
+
+			err := manager.WriteCopyFromStdin(buffer, &storage.FilesystemBackend{}, source, "public.foo")
+
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
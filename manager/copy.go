@@ -0,0 +1,425 @@
+package manager
+
+/*
+ * This file contains the copy-backup command, which copies a backup set from
+ * a backup directory to a plugin destination, from a plugin destination to a
+ * backup directory, or between two plugin destinations, so that an offsite
+ * copy of a backup can be made without backing up the database again.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/iohelper"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/backup_filepath"
+	"github.com/greenplum-db/gpbackup/backup_history"
+	"github.com/greenplum-db/gpbackup/storage"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	copySourcePlugin *utils.PluginConfig
+	copyDestPlugin   *utils.PluginConfig
+	sourceFPInfo     backup_filepath.FilePathInfo
+	destFPInfo       backup_filepath.FilePathInfo
+	keptConfigPath   string
+)
+
+func NewCopyBackupCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "copy-backup",
+		Short: "Copy a backup set to a backup directory or plugin destination",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			defer DoTeardown()
+			cmdFlags = cmd.Flags()
+			ValidateCopyFlags(cmdFlags)
+			DoCopyBackup()
+		}}
+	setLoggingFlagDefaults(cmd)
+	cmd.Flags().String(utils.BACKUP_DIR, "", "The absolute path of the directory in which the backup to copy is located")
+	cmd.Flags().String(utils.DBNAME, "postgres", "The database to connect to in order to find the segments of the cluster")
+	cmd.Flags().String(utils.DEST_BACKUP_DIR, "", "The absolute path of the directory to which to copy the backup")
+	cmd.Flags().String(utils.DEST_PLUGIN_CONFIG, "", "The configuration file of the plugin to which to copy the backup")
+	cmd.Flags().String(utils.PLUGIN_CONFIG, "", "The configuration file of the plugin from which to copy the backup")
+	cmd.Flags().String(utils.TIMESTAMP, "", "The timestamp of the backup to copy, in the format YYYYMMDDHHMMSS")
+	_ = cmd.MarkFlagRequired(utils.TIMESTAMP)
+	return cmd
+}
+
+func ValidateCopyFlags(flags *pflag.FlagSet) {
+	utils.CheckExclusiveFlags(flags, utils.DEBUG, utils.QUIET, utils.VERBOSE)
+	utils.CheckExclusiveFlags(flags, utils.BACKUP_DIR, utils.PLUGIN_CONFIG)
+	utils.CheckExclusiveFlags(flags, utils.DEST_BACKUP_DIR, utils.DEST_PLUGIN_CONFIG)
+	for _, flagName := range []string{utils.BACKUP_DIR, utils.DEST_BACKUP_DIR} {
+		err := utils.ValidateFullPath(MustGetFlagString(flagName))
+		gplog.FatalOnError(err)
+	}
+	if !backup_filepath.IsValidTimestamp(MustGetFlagString(utils.TIMESTAMP)) {
+		gplog.Fatal(errors.Errorf("Timestamp %s is invalid.  Timestamps must be in the format YYYYMMDDHHMMSS.", MustGetFlagString(utils.TIMESTAMP)), "")
+	}
+	if !flags.Changed(utils.DEST_BACKUP_DIR) && !flags.Changed(utils.DEST_PLUGIN_CONFIG) {
+		gplog.Fatal(errors.Errorf("Either --%s or --%s must be specified.", utils.DEST_BACKUP_DIR, utils.DEST_PLUGIN_CONFIG), "")
+	}
+	if !flags.Changed(utils.PLUGIN_CONFIG) && !flags.Changed(utils.DEST_PLUGIN_CONFIG) {
+		gplog.Fatal(errors.Errorf("A backup can only be copied to or from a plugin destination, so at least one of --%s and --%s must be specified.", utils.PLUGIN_CONFIG, utils.DEST_PLUGIN_CONFIG), "")
+	}
+	if MustGetFlagString(utils.PLUGIN_CONFIG) == MustGetFlagString(utils.DEST_PLUGIN_CONFIG) {
+		gplog.Fatal(errors.Errorf("The source and destination plugin configs must be different."), "")
+	}
+}
+
+func DoCopyBackup() {
+	SetLoggerVerbosity()
+	timestamp := MustGetFlagString(utils.TIMESTAMP)
+	gplog.Info("Copying backup %s", timestamp)
+	InitializeCluster(MustGetFlagString(utils.DBNAME))
+	segPrefix := backup_filepath.GetSegPrefix(connectionPool)
+	sourceSegPrefix := segPrefix
+	if MustGetFlagString(utils.BACKUP_DIR) != "" {
+		sourceSegPrefix = backup_filepath.ParseSegPrefix(MustGetFlagString(utils.BACKUP_DIR), timestamp)
+	}
+	sourceFPInfo = backup_filepath.NewFilePathInfo(globalCluster, MustGetFlagString(utils.BACKUP_DIR), timestamp, sourceSegPrefix)
+	destFPInfo = backup_filepath.NewFilePathInfo(globalCluster, MustGetFlagString(utils.DEST_BACKUP_DIR), timestamp, segPrefix)
+
+	copySourcePlugin = initializeCopyPlugin(MustGetFlagString(utils.PLUGIN_CONFIG), "source")
+	if copySourcePlugin != nil {
+		copySourcePlugin.SetBackupPluginVersion(timestamp, findHistoricalPluginVersion(copySourcePlugin))
+		copySourcePlugin.CopyPluginConfigToAllHosts(globalCluster)
+		copySourcePlugin.SetupPluginForRestore(globalCluster, sourceFPInfo)
+		for _, filePath := range []string{sourceFPInfo.GetConfigFilePath(), sourceFPInfo.GetTOCFilePath()} {
+			copySourcePlugin.MustRestoreFile(filePath)
+		}
+	}
+	sourceConfig := backup_history.ReadConfigFile(sourceFPInfo.GetConfigFilePath())
+	validateSourceBackup(sourceConfig)
+	toc := utils.NewTOC(sourceFPInfo.GetTOCFilePath())
+
+	copyDestPlugin = initializeCopyPlugin(MustGetFlagString(utils.DEST_PLUGIN_CONFIG), "dest")
+	destConfig := GetCopyBackupConfig(sourceConfig, MustGetFlagString(utils.DEST_BACKUP_DIR), getSourceLocation())
+	if copyDestPlugin != nil {
+		destConfig.Plugin = copyDestPlugin.ExecutablePath
+		destConfig.PluginVersion = copyDestPlugin.CheckPluginExistsOnAllHosts(globalCluster)
+		copyDestPlugin.CopyPluginConfigToAllHosts(globalCluster)
+		copyDestPlugin.SetupPluginForBackup(globalCluster, destFPInfo)
+	}
+
+	numFiles, numBytes := copyMasterFiles(sourceConfig, destConfig)
+	if !sourceConfig.MetadataOnly {
+		segmentFiles, segmentBytes := copySegmentFiles(sourceConfig, toc)
+		numFiles += segmentFiles
+		numBytes += segmentBytes
+	}
+	gplog.Info("Copied and verified %d files (%d bytes) to %s", numFiles, numBytes, getDestLocation())
+
+	historyFilePath := sourceFPInfo.GetBackupHistoryFilePath()
+	err := backup_history.AddBackupHistoryEntries(historyFilePath, sourceConfig, destConfig)
+	gplog.FatalOnError(err, "Unable to add the copy of the backup to the history file")
+	if sourceConfig.Incremental {
+		gplog.Warn("Backup %s is an incremental backup.  The backups in its restore plan must also be copied for it to be restored from %s.", timestamp, getDestLocation())
+	}
+}
+
+/*
+ * Each plugin config is copied to the segment hosts under a name unique to
+ * this copy, so that the source and destination configs do not overwrite
+ * each other even if they have the same file name.
+ */
+func initializeCopyPlugin(configFile string, role string) *utils.PluginConfig {
+	if configFile == "" {
+		return nil
+	}
+	plugin, err := utils.ReadPluginConfig(configFile)
+	gplog.FatalOnError(err)
+	configFilename := filepath.Base(plugin.ConfigPath)
+	configDirname := filepath.Dir(plugin.ConfigPath)
+	plugin.ConfigPath = filepath.Join(configDirname, fmt.Sprintf("%s_copy_%s_%s", MustGetFlagString(utils.TIMESTAMP), role, configFilename))
+	if role == "source" {
+		plugin.CheckPluginExistsOnAllHosts(globalCluster)
+	}
+	return plugin
+}
+
+func findHistoricalPluginVersion(plugin *utils.PluginConfig) string {
+	historyFilePath := sourceFPInfo.GetBackupHistoryFilePath()
+	if !iohelper.FileExistsAndIsReadable(historyFilePath) {
+		return ""
+	}
+	history, err := backup_history.NewHistory(historyFilePath)
+	gplog.FatalOnError(err)
+	backupConfig := history.FindBackupConfigAtLocation(sourceFPInfo.Timestamp, "", plugin.ExecutablePath)
+	if backupConfig == nil {
+		return ""
+	}
+	return backupConfig.PluginVersion
+}
+
+func validateSourceBackup(sourceConfig *backup_history.BackupConfig) {
+	if sourceConfig.Plugin != "" && copySourcePlugin == nil {
+		gplog.Fatal(errors.Errorf("Backup was taken with plugin %s. The --%s flag must be used to copy it.", sourceConfig.Plugin, utils.PLUGIN_CONFIG), "")
+	} else if sourceConfig.Plugin == "" && copySourcePlugin != nil {
+		gplog.Fatal(errors.Errorf("The --%s flag cannot be used to copy a backup taken without a plugin.", utils.PLUGIN_CONFIG), "")
+	}
+}
+
+/*
+ * The copy has its own config, recording where it is stored and where it was
+ * copied from, so that it can be restored from its new location as if it had
+ * been taken there.
+ */
+func GetCopyBackupConfig(sourceConfig *backup_history.BackupConfig, destBackupDir string, sourceLocation string) *backup_history.BackupConfig {
+	destConfig := *sourceConfig
+	destConfig.BackupDir = destBackupDir
+	destConfig.Plugin = ""
+	destConfig.PluginVersion = ""
+	destConfig.CopiedFrom = sourceLocation
+	return &destConfig
+}
+
+func getSourceLocation() string {
+	if copySourcePlugin != nil {
+		return copySourcePlugin.ExecutablePath
+	}
+	return sourceFPInfo.GetDirForContent(-1)
+}
+
+func getDestLocation() string {
+	if copyDestPlugin != nil {
+		return copyDestPlugin.ExecutablePath
+	}
+	return destFPInfo.GetDirForContent(-1)
+}
+
+func getStorageBackend(plugin *utils.PluginConfig) storage.StorageBackend {
+	if plugin == nil {
+		return &storage.FilesystemBackend{}
+	}
+	backend, err := plugin.GetStorageBackend()
+	gplog.FatalOnError(err)
+	return backend
+}
+
+/*
+ * The master files are copied from local disk, after retrieving them from
+ * the source plugin if there is one, as gprestore retrieves them.
+ */
+func GetMasterCopyEntries(sourceConfig *backup_history.BackupConfig, sourceFPInfo backup_filepath.FilePathInfo, destFPInfo backup_filepath.FilePathInfo) []storage.CopyEntry {
+	filetypes := []string{"metadata", "table of contents", "report"}
+	if sourceConfig.WithStatistics {
+		filetypes = append(filetypes, "statistics")
+	}
+	entries := make([]storage.CopyEntry, 0)
+	for _, filetype := range filetypes {
+		entries = append(entries, storage.CopyEntry{SourcePath: sourceFPInfo.GetBackupFilePath(filetype), DestPath: destFPInfo.GetBackupFilePath(filetype)})
+	}
+	return entries
+}
+
+func copyMasterFiles(sourceConfig *backup_history.BackupConfig, destConfig *backup_history.BackupConfig) (int, int64) {
+	source := &storage.FilesystemBackend{}
+	dest := getStorageBackend(copyDestPlugin)
+	entries := GetMasterCopyEntries(sourceConfig, sourceFPInfo, destFPInfo)
+	if copySourcePlugin != nil {
+		for _, entry := range entries {
+			if entry.SourcePath != sourceFPInfo.GetTOCFilePath() {
+				copySourcePlugin.MustRestoreFile(entry.SourcePath)
+			}
+		}
+	}
+
+	configPath := destFPInfo.GetConfigFilePath()
+	if configPath == sourceFPInfo.GetConfigFilePath() && copySourcePlugin == nil {
+		// The original backup's config is stored at the path from which the plugin reads the copy's config
+		KeepOriginalConfig(configPath)
+		defer RestoreOriginalConfig()
+	}
+	err := os.MkdirAll(destFPInfo.GetDirForContent(-1), 0755)
+	gplog.FatalOnError(err)
+	_ = operating.System.Remove(configPath)
+	backup_history.WriteConfigFile(destConfig, configPath)
+	entries = append(entries, storage.CopyEntry{SourcePath: configPath, DestPath: configPath})
+	if copyDestPlugin != nil {
+		// Incremental backups to the destination read the plugin config of the backup they are based on
+		pluginConfigPath := destFPInfo.GetPluginConfigPath()
+		err = utils.CopyFile(MustGetFlagString(utils.DEST_PLUGIN_CONFIG), pluginConfigPath)
+		gplog.FatalOnError(err)
+		entries = append(entries, storage.CopyEntry{SourcePath: pluginConfigPath, DestPath: pluginConfigPath})
+	}
+
+	numBytes := int64(0)
+	for _, entry := range entries {
+		result, err := storage.CopyBackupFile(source, dest, entry)
+		gplog.FatalOnError(err, fmt.Sprintf("Unable to copy %s to %s", entry.SourcePath, entry.DestPath))
+		gplog.Verbose("Copied %s (%d bytes, SHA-256 %s)", result.Path, result.Size, result.Checksum)
+		numBytes += result.Size
+	}
+	return len(entries), numBytes
+}
+
+/*
+ * The original config is also put back by DoCleanup, as a termination signal
+ * exits without running deferred functions.
+ */
+func KeepOriginalConfig(configPath string) {
+	keptPath := configPath + "_gpbackup_copy"
+	err := operating.System.Rename(configPath, keptPath)
+	gplog.FatalOnError(err)
+	keptConfigPath = keptPath
+}
+
+func RestoreOriginalConfig() {
+	if keptConfigPath == "" {
+		return
+	}
+	configPath := strings.TrimSuffix(keptConfigPath, "_gpbackup_copy")
+	_ = operating.System.Remove(configPath)
+	err := operating.System.Rename(keptConfigPath, configPath)
+	if err != nil {
+		gplog.Warn("Unable to restore the original backup config from %s: %v", keptConfigPath, err)
+		return
+	}
+	keptConfigPath = ""
+}
+
+func getDataFileExtension(sourceConfig *backup_history.BackupConfig) string {
+	if sourceConfig.Compressed {
+		return ".gz"
+	}
+	return ""
+}
+
+func GetSegmentCopyEntries(sourceConfig *backup_history.BackupConfig, toc *utils.TOC, sourceFPInfo backup_filepath.FilePathInfo, destFPInfo backup_filepath.FilePathInfo, contentID int) []storage.CopyEntry {
+	extension := getDataFileExtension(sourceConfig)
+	entries := make([]storage.CopyEntry, 0)
+	if sourceConfig.SingleDataFile {
+		entries = append(entries, storage.CopyEntry{
+			SourcePath: sourceFPInfo.GetTableBackupFilePath(contentID, 0, extension, true),
+			DestPath:   destFPInfo.GetTableBackupFilePath(contentID, 0, extension, true),
+			IsData:     true,
+		})
+		entries = append(entries, storage.CopyEntry{
+			SourcePath: sourceFPInfo.GetSegmentTOCFilePath(contentID),
+			DestPath:   destFPInfo.GetSegmentTOCFilePath(contentID),
+		})
+		return entries
+	}
+	for _, dataEntry := range toc.DataEntries {
+		entries = append(entries, storage.CopyEntry{
+			SourcePath: sourceFPInfo.GetTableBackupFilePath(contentID, dataEntry.Oid, extension, false),
+			DestPath:   destFPInfo.GetTableBackupFilePath(contentID, dataEntry.Oid, extension, false),
+			IsData:     true,
+		})
+	}
+	return entries
+}
+
+/*
+ * Each segment's files are copied on its own host by gpbackup_helper, which
+ * reads the list of files to copy from a file written alongside it and
+ * reports the size and checksum of every file it copied.
+ */
+func copySegmentFiles(sourceConfig *backup_history.BackupConfig, toc *utils.TOC) (int, int64) {
+	gphome := operating.System.Getenv("GPHOME")
+	pluginStr := ""
+	if copySourcePlugin != nil {
+		pluginStr += fmt.Sprintf(" --plugin-config /tmp/%s", filepath.Base(copySourcePlugin.ConfigPath))
+	}
+	if copyDestPlugin != nil {
+		pluginStr += fmt.Sprintf(" --dest-plugin-config /tmp/%s", filepath.Base(copyDestPlugin.ConfigPath))
+	}
+	writeCopyListsToSegments(sourceConfig, toc)
+	remoteOutput := globalCluster.GenerateAndExecuteCommand("Copying segment backup files", func(contentID int) string {
+		copyListFile := sourceFPInfo.GetSegmentHelperFilePath(contentID, "copy")
+		return fmt.Sprintf("source %[1]s/greenplum_path.sh && %[1]s/bin/gpbackup_helper --copy-agent --copy-list %[2]s --content %[3]d%[4]s; status=$?; rm -f %[2]s; exit $status",
+			gphome, copyListFile, contentID, pluginStr)
+	}, cluster.ON_SEGMENTS)
+	globalCluster.CheckClusterError(remoteOutput, "Unable to copy segment backup files", func(contentID int) string {
+		return fmt.Sprintf("Unable to copy backup files of segment %d. See %s on the corresponding host for details.", contentID, sourceFPInfo.GetHelperLogPath())
+	})
+
+	numFiles := 0
+	numBytes := int64(0)
+	for contentID := range remoteOutput.Stdouts {
+		results := ParseCopyResults(remoteOutput.Stdouts[contentID])
+		for _, result := range results {
+			gplog.Verbose("Copied %s on segment %d (%d bytes, SHA-256 %s)", result.Path, contentID, result.Size, result.Checksum)
+			numBytes += result.Size
+		}
+		numFiles += len(results)
+	}
+	return numFiles, numBytes
+}
+
+/*
+ * A list holds a line for every table in the backup, which can be too long
+ * to pass in a command, so as with the oid list of gpbackup_helper, it is
+ * written to a local file and copied to the segment host with scp.
+ */
+func writeCopyListsToSegments(sourceConfig *backup_history.BackupConfig, toc *utils.TOC) {
+	localFiles := make(map[int]string)
+	defer func() {
+		for _, localFile := range localFiles {
+			err := operating.System.Remove(localFile)
+			if err != nil {
+				gplog.Warn("Cannot remove temporary copy list file: %s, Err: %s", localFile, err.Error())
+			}
+		}
+	}()
+	for contentID := range globalCluster.Segments {
+		if contentID == -1 {
+			continue
+		}
+		localFile, err := operating.System.TempFile("", "gpbackup-copy")
+		gplog.FatalOnError(err, "Cannot open temporary file to write the copy list")
+		localFiles[contentID] = localFile.Name()
+		for _, entry := range GetSegmentCopyEntries(sourceConfig, toc, sourceFPInfo, destFPInfo, contentID) {
+			line, _ := json.Marshal(entry)
+			_, err = fmt.Fprintln(localFile, string(line))
+			gplog.FatalOnError(err, localFile.Name())
+		}
+		err = localFile.Close()
+		gplog.FatalOnError(err, localFile.Name())
+	}
+
+	remoteOutput := globalCluster.GenerateAndExecuteCommand("Scp copy list files to segments", func(contentID int) string {
+		return fmt.Sprintf("scp %s %s:%s", localFiles[contentID], globalCluster.GetHostForContent(contentID), sourceFPInfo.GetSegmentHelperFilePath(contentID, "copy"))
+	}, cluster.ON_MASTER_TO_SEGMENTS)
+	globalCluster.CheckClusterError(remoteOutput, "Failed to scp copy list files", func(contentID int) string {
+		return fmt.Sprintf("Failed to scp the copy list file of segment %d", contentID)
+	})
+}
+
+func ParseCopyResults(output string) []storage.CopyResult {
+	results := make([]storage.CopyResult, 0)
+	for _, line := range strings.Split(output, "\n") {
+		result := storage.CopyResult{}
+		if json.Unmarshal([]byte(line), &result) == nil && result.Path != "" {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+func cleanupCopyPlugins() {
+	if globalCluster == nil {
+		return
+	}
+	if copySourcePlugin != nil {
+		copySourcePlugin.CleanupPluginForRestore(globalCluster, sourceFPInfo)
+		copySourcePlugin.DeletePluginConfigWhenEncrypting(globalCluster)
+	}
+	if copyDestPlugin != nil {
+		copyDestPlugin.CleanupPluginForBackup(globalCluster, destFPInfo)
+		copyDestPlugin.DeletePluginConfigWhenEncrypting(globalCluster)
+	}
+}
@@ -0,0 +1,118 @@
+package manager_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gpbackup/backup_filepath"
+	"github.com/greenplum-db/gpbackup/backup_history"
+	"github.com/greenplum-db/gpbackup/manager"
+	"github.com/greenplum-db/gpbackup/storage"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("manager/copy tests", func() {
+	var sourceFPInfo, destFPInfo backup_filepath.FilePathInfo
+	var toc *utils.TOC
+	BeforeEach(func() {
+		c := &cluster.Cluster{
+			Segments: map[int]cluster.SegConfig{
+				-1: {DataDir: "/data/gpseg-1"},
+				0:  {DataDir: "/data/gpseg0"},
+			},
+		}
+		sourceFPInfo = backup_filepath.NewFilePathInfo(c, "/backups", "20170101010101", "gpseg")
+		destFPInfo = backup_filepath.NewFilePathInfo(c, "", "20170101010101", "gpseg")
+		toc = &utils.TOC{DataEntries: []utils.MasterDataEntry{{Schema: "public", Name: "foo", Oid: 1234}, {Schema: "public", Name: "bar", Oid: 2345}}}
+	})
+	Describe("GetMasterCopyEntries", func() {
+		It("copies the master files of a backup", func() {
+			entries := manager.GetMasterCopyEntries(&backup_history.BackupConfig{}, sourceFPInfo, destFPInfo)
+
+			Expect(entries).To(Equal([]storage.CopyEntry{
+				{SourcePath: "/backups/gpseg-1/backups/20170101/20170101010101/gpbackup_20170101010101_metadata.sql", DestPath: "/data/gpseg-1/backups/20170101/20170101010101/gpbackup_20170101010101_metadata.sql"},
+				{SourcePath: "/backups/gpseg-1/backups/20170101/20170101010101/gpbackup_20170101010101_toc.yaml", DestPath: "/data/gpseg-1/backups/20170101/20170101010101/gpbackup_20170101010101_toc.yaml"},
+				{SourcePath: "/backups/gpseg-1/backups/20170101/20170101010101/gpbackup_20170101010101_report", DestPath: "/data/gpseg-1/backups/20170101/20170101010101/gpbackup_20170101010101_report"},
+			}))
+		})
+		It("copies the statistics file of a backup taken with statistics", func() {
+			entries := manager.GetMasterCopyEntries(&backup_history.BackupConfig{WithStatistics: true}, sourceFPInfo, destFPInfo)
+
+			Expect(entries).To(HaveLen(4))
+			Expect(entries[3].DestPath).To(Equal("/data/gpseg-1/backups/20170101/20170101010101/gpbackup_20170101010101_statistics.sql"))
+		})
+	})
+	Describe("GetSegmentCopyEntries", func() {
+		It("copies the data file of each table", func() {
+			entries := manager.GetSegmentCopyEntries(&backup_history.BackupConfig{Compressed: true}, toc, sourceFPInfo, destFPInfo, 0)
+
+			Expect(entries).To(Equal([]storage.CopyEntry{
+				{SourcePath: "/backups/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_1234.gz", DestPath: "/data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_1234.gz", IsData: true},
+				{SourcePath: "/backups/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_2345.gz", DestPath: "/data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_2345.gz", IsData: true},
+			}))
+		})
+		It("copies the single data file and the segment table of contents", func() {
+			entries := manager.GetSegmentCopyEntries(&backup_history.BackupConfig{SingleDataFile: true}, toc, sourceFPInfo, destFPInfo, 0)
+
+			Expect(entries).To(Equal([]storage.CopyEntry{
+				{SourcePath: "/backups/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101", DestPath: "/data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101", IsData: true},
+				{SourcePath: "/backups/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_toc.yaml", DestPath: "/data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_toc.yaml"},
+			}))
+		})
+	})
+	Describe("GetCopyBackupConfig", func() {
+		It("records the location of the copy and where it was copied from", func() {
+			sourceConfig := &backup_history.BackupConfig{Timestamp: "20170101010101", BackupDir: "/backups", Compressed: true}
+
+			destConfig := manager.GetCopyBackupConfig(sourceConfig, "", "/backups/gpseg-1/backups/20170101/20170101010101")
+
+			Expect(destConfig).To(Equal(&backup_history.BackupConfig{Timestamp: "20170101010101", Compressed: true, CopiedFrom: "/backups/gpseg-1/backups/20170101/20170101010101"}))
+			Expect(sourceConfig.BackupDir).To(Equal("/backups"))
+		})
+	})
+	Describe("KeepOriginalConfig", func() {
+		var tempDir, configPath string
+		BeforeEach(func() {
+			tempDir, _ = ioutil.TempDir("", "copy")
+			configPath = filepath.Join(tempDir, "gpbackup_20170101010101_config.yaml")
+			Expect(ioutil.WriteFile(configPath, []byte("original config"), 0444)).To(Succeed())
+		})
+		AfterEach(func() {
+			_ = os.RemoveAll(tempDir)
+		})
+		It("puts the original config back in place of the copy's config", func() {
+			manager.KeepOriginalConfig(configPath)
+			Expect(ioutil.WriteFile(configPath, []byte("copy config"), 0444)).To(Succeed())
+
+			manager.RestoreOriginalConfig()
+
+			Expect(ioutil.ReadFile(configPath)).To(Equal([]byte("original config")))
+			Expect(configPath + "_gpbackup_copy").ToNot(BeAnExistingFile())
+		})
+		It("only puts the original config back once", func() {
+			manager.KeepOriginalConfig(configPath)
+			manager.RestoreOriginalConfig()
+			Expect(ioutil.WriteFile(configPath+"_gpbackup_copy", []byte("unrelated file"), 0444)).To(Succeed())
+
+			manager.RestoreOriginalConfig()
+
+			Expect(ioutil.ReadFile(configPath)).To(Equal([]byte("original config")))
+		})
+	})
+	Describe("ParseCopyResults", func() {
+		It("parses the results printed by gpbackup_helper", func() {
+			output := `{"path":"/data/gpseg0/file1","size":10,"sha256":"abc"}
+{"path":"/data/gpseg0/file2","size":20,"sha256":"def"}
+`
+			Expect(manager.ParseCopyResults(output)).To(Equal([]storage.CopyResult{
+				{Path: "/data/gpseg0/file1", Size: 10, Checksum: "abc"},
+				{Path: "/data/gpseg0/file2", Size: 20, Checksum: "def"},
+			}))
+		})
+	})
+})
@@ -0,0 +1,214 @@
+package manager
+
+/*
+ * This file contains the diff-backups command, which compares the metadata
+ * of two backups using their tables of contents and metadata files, and
+ * reports which objects were added, removed, or changed between them.
+ */
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/iohelper"
+	"github.com/greenplum-db/gpbackup/backup_filepath"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+const (
+	ObjectAdded   = "Added"
+	ObjectRemoved = "Removed"
+	ObjectChanged = "Changed"
+)
+
+// The statistics section is not compared, as statistics are not part of the schema
+var diffSections = []string{"global", "predata", "postdata"}
+
+/*
+ * A BackupObject holds all of the statements for one object, as an object's
+ * owner, privileges, and comment are stored in separate metadata entries
+ * from its CREATE statement.
+ */
+type BackupObject struct {
+	ObjectType      string
+	Schema          string
+	Name            string
+	ReferenceObject string
+	Statement       string
+}
+
+func (object BackupObject) FQN() string {
+	fqn := object.Name
+	// The entry of a schema has the schema's name in its Schema field too
+	if object.Schema != "" && object.ObjectType != "SCHEMA" {
+		fqn = utils.MakeFQN(object.Schema, object.Name)
+	}
+	if object.ReferenceObject != "" {
+		fqn += fmt.Sprintf(" ON %s", object.ReferenceObject)
+	}
+	return fqn
+}
+
+func (object BackupObject) key() string {
+	return fmt.Sprintf("%s %s", object.ObjectType, object.FQN())
+}
+
+type ObjectDiff struct {
+	Change     string
+	ObjectType string
+	FQN        string
+	Diff       string
+}
+
+func NewDiffBackupsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "diff-backups",
+		Short: "Report the schema differences between two backups",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			defer DoTeardown()
+			cmdFlags = cmd.Flags()
+			ValidateDiffFlags(cmdFlags)
+			DoDiffBackups()
+		}}
+	setLoggingFlagDefaults(cmd)
+	cmd.Flags().String(utils.BACKUP_DIR, "", "The absolute path of the directory in which both backups are located")
+	cmd.Flags().String(utils.COMPARE_TIMESTAMP, "", "The timestamp of the backup to compare to the first backup, in the format YYYYMMDDHHMMSS")
+	cmd.Flags().String(utils.TIMESTAMP, "", "The timestamp of the first backup, in the format YYYYMMDDHHMMSS")
+	_ = cmd.MarkFlagRequired(utils.COMPARE_TIMESTAMP)
+	_ = cmd.MarkFlagRequired(utils.TIMESTAMP)
+	return cmd
+}
+
+func ValidateDiffFlags(flags *pflag.FlagSet) {
+	utils.CheckExclusiveFlags(flags, utils.DEBUG, utils.QUIET, utils.VERBOSE)
+	err := utils.ValidateFullPath(MustGetFlagString(utils.BACKUP_DIR))
+	gplog.FatalOnError(err)
+}
+
+func DoDiffBackups() {
+	SetLoggerVerbosity()
+	fromTimestamp := MustGetFlagString(utils.TIMESTAMP)
+	toTimestamp := MustGetFlagString(utils.COMPARE_TIMESTAMP)
+	gplog.Info("Comparing the metadata of backup %s to backup %s", fromTimestamp, toTimestamp)
+	fromFPInfo := NewMasterFilePathInfo(MustGetFlagString(utils.BACKUP_DIR), fromTimestamp)
+	toFPInfo := NewMasterFilePathInfo(MustGetFlagString(utils.BACKUP_DIR), toTimestamp)
+	fromObjects := GroupStatementsByObject(ReadBackupStatements(fromFPInfo))
+	toObjects := GroupStatementsByObject(ReadBackupStatements(toFPInfo))
+
+	diffs := DiffBackupObjects(fromObjects, toObjects, fromTimestamp, toTimestamp)
+	PrintDiffReport(os.Stdout, diffs)
+	logDiffSummary(diffs)
+}
+
+func ReadBackupStatements(fpInfo backup_filepath.FilePathInfo) []utils.StatementWithType {
+	toc := utils.NewTOC(fpInfo.GetTOCFilePath())
+	metadataFile := iohelper.MustOpenFileForReading(fpInfo.GetMetadataFilePath())
+	defer metadataFile.Close()
+	return GetMetadataStatements(toc, metadataFile)
+}
+
+func GetMetadataStatements(toc *utils.TOC, metadataFile io.ReaderAt) []utils.StatementWithType {
+	toc.InitializeMetadataEntryMap()
+	statements := make([]utils.StatementWithType, 0)
+	for _, section := range diffSections {
+		statements = append(statements, toc.GetSQLStatementForObjectTypes(section, metadataFile, []string{}, []string{}, []string{}, []string{}, []string{}, []string{})...)
+	}
+	return statements
+}
+
+/*
+ * Objects are returned in the order in which their first statement appears,
+ * and their statements are joined in the order in which they appear.
+ */
+func GroupStatementsByObject(statements []utils.StatementWithType) []BackupObject {
+	objects := make([]BackupObject, 0)
+	objectIndexes := make(map[string]int)
+	for _, statement := range statements {
+		object := BackupObject{ObjectType: statement.ObjectType, Schema: statement.Schema, Name: statement.Name, ReferenceObject: statement.ReferenceObject}
+		text := strings.TrimSpace(statement.Statement)
+		if index, ok := objectIndexes[object.key()]; ok {
+			objects[index].Statement += "\n" + text
+			continue
+		}
+		object.Statement = text
+		objectIndexes[object.key()] = len(objects)
+		objects = append(objects, object)
+	}
+	return objects
+}
+
+/*
+ * Differences are sorted by object type and then by name, so that all of the
+ * changes to one kind of object are reported together.
+ */
+func DiffBackupObjects(fromObjects []BackupObject, toObjects []BackupObject, fromLabel string, toLabel string) []ObjectDiff {
+	fromMap := make(map[string]BackupObject, len(fromObjects))
+	for _, object := range fromObjects {
+		fromMap[object.key()] = object
+	}
+	toMap := make(map[string]BackupObject, len(toObjects))
+	for _, object := range toObjects {
+		toMap[object.key()] = object
+	}
+
+	diffs := make([]ObjectDiff, 0)
+	for _, object := range fromObjects {
+		if _, ok := toMap[object.key()]; !ok {
+			diffs = append(diffs, ObjectDiff{Change: ObjectRemoved, ObjectType: object.ObjectType, FQN: object.FQN()})
+		}
+	}
+	for _, object := range toObjects {
+		fromObject, ok := fromMap[object.key()]
+		if !ok {
+			diffs = append(diffs, ObjectDiff{Change: ObjectAdded, ObjectType: object.ObjectType, FQN: object.FQN()})
+		} else if fromObject.Statement != object.Statement {
+			diff := utils.UnifiedDiff(fmt.Sprintf("%s %s", fromLabel, object.key()), fmt.Sprintf("%s %s", toLabel, object.key()), fromObject.Statement+"\n", object.Statement+"\n")
+			diffs = append(diffs, ObjectDiff{Change: ObjectChanged, ObjectType: object.ObjectType, FQN: object.FQN(), Diff: diff})
+		}
+	}
+	sort.SliceStable(diffs, func(i int, j int) bool {
+		if diffs[i].ObjectType != diffs[j].ObjectType {
+			return diffs[i].ObjectType < diffs[j].ObjectType
+		}
+		return diffs[i].FQN < diffs[j].FQN
+	})
+	return diffs
+}
+
+/*
+ * The list of added, removed, and changed objects is printed first, followed
+ * by the differences in the DDL of each changed object.
+ */
+func PrintDiffReport(writer io.Writer, diffs []ObjectDiff) {
+	if len(diffs) == 0 {
+		fmt.Fprintln(writer, "No differences found")
+		return
+	}
+	for _, change := range []string{ObjectAdded, ObjectRemoved, ObjectChanged} {
+		for _, diff := range diffs {
+			if diff.Change == change {
+				fmt.Fprintf(writer, "%-8s %s %s\n", diff.Change, diff.ObjectType, diff.FQN)
+			}
+		}
+	}
+	for _, diff := range diffs {
+		if diff.Diff != "" {
+			fmt.Fprintf(writer, "\n%s", diff.Diff)
+		}
+	}
+}
+
+func logDiffSummary(diffs []ObjectDiff) {
+	counts := make(map[string]int)
+	for _, diff := range diffs {
+		counts[diff.Change]++
+	}
+	gplog.Info("%d objects added, %d removed, and %d changed", counts[ObjectAdded], counts[ObjectRemoved], counts[ObjectChanged])
+}
@@ -0,0 +1,95 @@
+package manager
+
+/*
+ * This file contains the diff-database command, which compares the metadata
+ * of a backup to the metadata of a database, generated in memory with the
+ * same queries that gpbackup uses, to find changes made since the backup was
+ * taken or to check that a restore reproduced the schema of a backup.
+ */
+
+import (
+	"bytes"
+	"os"
+
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gpbackup/backup"
+	"github.com/greenplum-db/gpbackup/backup_history"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+func NewDiffDatabaseCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "diff-database",
+		Short: "Report the schema differences between a backup and a database",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			defer DoTeardown()
+			cmdFlags = cmd.Flags()
+			ValidateDiffDatabaseFlags(cmdFlags)
+			DoDiffDatabase()
+		}}
+	setLoggingFlagDefaults(cmd)
+	cmd.Flags().String(utils.BACKUP_DIR, "", "The absolute path of the directory in which the backup is located")
+	cmd.Flags().String(utils.DBNAME, "", "The database to compare to the backup, if different from the database that was backed up")
+	cmd.Flags().String(utils.TIMESTAMP, "", "The timestamp of the backup, in the format YYYYMMDDHHMMSS")
+	_ = cmd.MarkFlagRequired(utils.TIMESTAMP)
+	return cmd
+}
+
+func ValidateDiffDatabaseFlags(flags *pflag.FlagSet) {
+	utils.CheckExclusiveFlags(flags, utils.DEBUG, utils.QUIET, utils.VERBOSE)
+	err := utils.ValidateFullPath(MustGetFlagString(utils.BACKUP_DIR))
+	gplog.FatalOnError(err)
+}
+
+func DoDiffDatabase() {
+	SetLoggerVerbosity()
+	timestamp := MustGetFlagString(utils.TIMESTAMP)
+	fpInfo := NewMasterFilePathInfo(MustGetFlagString(utils.BACKUP_DIR), timestamp)
+	backupConfig := backup_history.ReadConfigFile(fpInfo.GetConfigFilePath())
+	if backupConfig.DataOnly {
+		gplog.Fatal(errors.Errorf("Backup %s is a data-only backup and contains no metadata to compare.", timestamp), "")
+	}
+	unquotedDBName := utils.UnquoteIdent(backupConfig.DatabaseName)
+	if MustGetFlagString(utils.DBNAME) != "" {
+		unquotedDBName = MustGetFlagString(utils.DBNAME)
+	}
+	gplog.Info("Comparing the metadata of backup %s to database %s", timestamp, unquotedDBName)
+
+	connectionPool = dbconn.NewDBConnFromEnvironment(unquotedDBName)
+	connectionPool.MustConnect(1)
+	utils.ValidateGPDBVersionCompatibility(connectionPool)
+	quotedDBName := utils.QuoteIdent(connectionPool, unquotedDBName)
+	backupStatements := SubstituteDatabaseName(ReadBackupStatements(fpInfo), backupConfig.DatabaseName, quotedDBName)
+
+	metadata := &bytes.Buffer{}
+	toc := backup.WriteDatabaseMetadata(connectionPool, backup.NewMetadataParams(backupConfig), metadata)
+	databaseStatements := GetMetadataStatements(toc, bytes.NewReader(metadata.Bytes()))
+
+	diffs := DiffBackupObjects(GroupStatementsByObject(backupStatements), GroupStatementsByObject(databaseStatements), timestamp, quotedDBName)
+	PrintDiffReport(os.Stdout, diffs)
+	logDiffSummary(diffs)
+}
+
+/*
+ * A backup compared to a database restored under a different name would
+ * otherwise report every database-level object as changed, so the name of
+ * the backed up database is replaced as gprestore --redirect-db replaces it.
+ */
+func SubstituteDatabaseName(statements []utils.StatementWithType, oldQuotedName string, newQuotedName string) []utils.StatementWithType {
+	if oldQuotedName == newQuotedName {
+		return statements
+	}
+	databaseTypes := map[string]bool{"DATABASE": true, "DATABASE GUC": true, "DATABASE METADATA": true}
+	statements = utils.SubstituteRedirectDatabaseInStatements(statements, oldQuotedName, newQuotedName)
+	for i := range statements {
+		if databaseTypes[statements[i].ObjectType] && statements[i].Name == oldQuotedName {
+			statements[i].Name = newQuotedName
+		}
+	}
+	return statements
+}
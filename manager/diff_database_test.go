@@ -0,0 +1,27 @@
+package manager_test
+
+import (
+	"github.com/greenplum-db/gpbackup/manager"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("manager/diff_database tests", func() {
+	Describe("SubstituteDatabaseName", func() {
+		It("replaces the name of the backed up database in its statements", func() {
+			statements := []utils.StatementWithType{
+				{Name: "olddb", ObjectType: "DATABASE GUC", Statement: "ALTER DATABASE olddb SET search_path TO public;"},
+				{Name: "olddb", ObjectType: "DATABASE METADATA", Statement: "ALTER DATABASE olddb OWNER TO testrole;"},
+				{Schema: "public", Name: "olddb", ObjectType: "TABLE", Statement: "CREATE TABLE public.olddb (\n\ti integer\n);"},
+			}
+
+			Expect(manager.SubstituteDatabaseName(statements, "olddb", "newdb")).To(Equal([]utils.StatementWithType{
+				{Name: "newdb", ObjectType: "DATABASE GUC", Statement: "ALTER DATABASE newdb SET search_path TO public;"},
+				{Name: "newdb", ObjectType: "DATABASE METADATA", Statement: "ALTER DATABASE newdb OWNER TO testrole;"},
+				{Schema: "public", Name: "olddb", ObjectType: "TABLE", Statement: "CREATE TABLE public.olddb (\n\ti integer\n);"},
+			}))
+		})
+	})
+})
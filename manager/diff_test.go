@@ -0,0 +1,104 @@
+package manager_test
+
+import (
+	"bytes"
+
+	"github.com/greenplum-db/gpbackup/manager"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("manager/diff tests", func() {
+	Describe("GroupStatementsByObject", func() {
+		It("joins the statements of each object in order", func() {
+			statements := []utils.StatementWithType{
+				{Schema: "public", Name: "foo", ObjectType: "TABLE", Statement: "\n\nCREATE TABLE public.foo (\n\ti integer\n);\n"},
+				{Schema: "public", Name: "bar", ObjectType: "VIEW", Statement: "\n\nCREATE VIEW public.bar AS SELECT 1;\n"},
+				{Schema: "public", Name: "foo", ObjectType: "TABLE", Statement: "\n\nALTER TABLE public.foo OWNER TO testrole;\n"},
+			}
+
+			Expect(manager.GroupStatementsByObject(statements)).To(Equal([]manager.BackupObject{
+				{ObjectType: "TABLE", Schema: "public", Name: "foo", Statement: "CREATE TABLE public.foo (\n\ti integer\n);\nALTER TABLE public.foo OWNER TO testrole;"},
+				{ObjectType: "VIEW", Schema: "public", Name: "bar", Statement: "CREATE VIEW public.bar AS SELECT 1;"},
+			}))
+		})
+		It("distinguishes objects with the same name on different tables", func() {
+			statements := []utils.StatementWithType{
+				{Schema: "public", Name: "uniq", ObjectType: "CONSTRAINT", ReferenceObject: "public.foo", Statement: "ALTER TABLE ONLY public.foo ADD CONSTRAINT uniq UNIQUE (i);"},
+				{Schema: "public", Name: "uniq", ObjectType: "CONSTRAINT", ReferenceObject: "public.bar", Statement: "ALTER TABLE ONLY public.bar ADD CONSTRAINT uniq UNIQUE (i);"},
+			}
+
+			objects := manager.GroupStatementsByObject(statements)
+
+			Expect(objects).To(HaveLen(2))
+			Expect(objects[0].FQN()).To(Equal("public.uniq ON public.foo"))
+			Expect(objects[1].FQN()).To(Equal("public.uniq ON public.bar"))
+		})
+	})
+	Describe("DiffBackupObjects", func() {
+		It("reports added, removed, and changed objects sorted by type and name", func() {
+			fromObjects := []manager.BackupObject{
+				{ObjectType: "TABLE", Schema: "public", Name: "foo", Statement: "CREATE TABLE public.foo (\n\ti integer\n);"},
+				{ObjectType: "VIEW", Schema: "public", Name: "oldview", Statement: "CREATE VIEW public.oldview AS SELECT 1;"},
+				{ObjectType: "SCHEMA", Schema: "myschema", Name: "myschema", Statement: "CREATE SCHEMA myschema;"},
+			}
+			toObjects := []manager.BackupObject{
+				{ObjectType: "SCHEMA", Schema: "myschema", Name: "myschema", Statement: "CREATE SCHEMA myschema;"},
+				{ObjectType: "TABLE", Schema: "public", Name: "foo", Statement: "CREATE TABLE public.foo (\n\ti bigint\n);"},
+				{ObjectType: "TABLE", Schema: "public", Name: "bar", Statement: "CREATE TABLE public.bar (\n\ti integer\n);"},
+			}
+
+			diffs := manager.DiffBackupObjects(fromObjects, toObjects, "20170101010101", "20170102010101")
+
+			Expect(diffs).To(Equal([]manager.ObjectDiff{
+				{Change: manager.ObjectAdded, ObjectType: "TABLE", FQN: "public.bar"},
+				{Change: manager.ObjectChanged, ObjectType: "TABLE", FQN: "public.foo", Diff: `--- 20170101010101 TABLE public.foo
++++ 20170102010101 TABLE public.foo
+@@ -1,3 +1,3 @@
+ CREATE TABLE public.foo (
+-	i integer
++	i bigint
+ );
+`},
+				{Change: manager.ObjectRemoved, ObjectType: "VIEW", FQN: "public.oldview"},
+			}))
+		})
+		It("reports no differences between identical backups", func() {
+			objects := []manager.BackupObject{{ObjectType: "SCHEMA", Schema: "myschema", Name: "myschema", Statement: "CREATE SCHEMA myschema;"}}
+
+			Expect(manager.DiffBackupObjects(objects, objects, "20170101010101", "20170102010101")).To(BeEmpty())
+		})
+	})
+	Describe("PrintDiffReport", func() {
+		It("lists the changed objects before their differences", func() {
+			diffs := []manager.ObjectDiff{
+				{Change: manager.ObjectChanged, ObjectType: "FUNCTION", FQN: "public.add(integer, integer)", Diff: "--- a\n+++ b\n@@ -1 +1 @@\n-old\n+new\n"},
+				{Change: manager.ObjectAdded, ObjectType: "TABLE", FQN: "public.bar"},
+				{Change: manager.ObjectRemoved, ObjectType: "VIEW", FQN: "public.oldview"},
+			}
+			buffer := &bytes.Buffer{}
+
+			manager.PrintDiffReport(buffer, diffs)
+
+			Expect(buffer.String()).To(Equal(`Added    TABLE public.bar
+Removed  VIEW public.oldview
+Changed  FUNCTION public.add(integer, integer)
+
+--- a
++++ b
+@@ -1 +1 @@
+-old
++new
+`))
+		})
+		It("prints a message when there are no differences", func() {
+			buffer := &bytes.Buffer{}
+
+			manager.PrintDiffReport(buffer, []manager.ObjectDiff{})
+
+			Expect(buffer.String()).To(Equal("No differences found\n"))
+		})
+	})
+})
@@ -0,0 +1,334 @@
+package manager
+
+/*
+ * This file contains the extract-data command, which reads the data of one
+ * table out of the data files of a backup, from every segment, and writes it
+ * to a single file, without restoring it to a database.
+ */
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/iohelper"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/backup_filepath"
+	"github.com/greenplum-db/gpbackup/backup_history"
+	"github.com/greenplum-db/gpbackup/storage"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+/*
+ * A TableDataSource locates the data of one table in the backup that holds
+ * it, which for an incremental backup may be an earlier backup in its
+ * restore plan.
+ */
+type TableDataSource struct {
+	FPInfo         backup_filepath.FilePathInfo
+	Entry          utils.MasterDataEntry
+	Extension      string
+	SingleDataFile bool
+	SegmentCount   int
+	Replicated     bool
+}
+
+func NewExtractDataCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "extract-data",
+		Short: "Write the data of a table in a backup to a CSV, JSONL, or Parquet file without restoring it",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			defer DoTeardown()
+			cmdFlags = cmd.Flags()
+			ValidateExtractDataFlags(cmdFlags)
+			DoExtractData()
+		}}
+	setLoggingFlagDefaults(cmd)
+	cmd.Flags().String(utils.BACKUP_DIR, "", "The absolute path of the directory in which the backup is located")
+	cmd.Flags().String(utils.DBNAME, "postgres", "The database to connect to in order to find the segment data directories, when --backup-dir is not given")
+	cmd.Flags().String(utils.DATA_FORMAT, DataFormatCSV, fmt.Sprintf("The format in which to write the data, one of %s", strings.Join(DataFormats, ", ")))
+	cmd.Flags().String(utils.INCLUDE_RELATION, "", "The fully-qualified name of the table whose data to extract")
+	cmd.Flags().String(utils.OUTPUT_FILE, "", "The file to which to write the data, instead of standard output")
+	cmd.Flags().String(utils.TIMESTAMP, "", "The timestamp of the backup, in the format YYYYMMDDHHMMSS")
+	_ = cmd.MarkFlagRequired(utils.INCLUDE_RELATION)
+	_ = cmd.MarkFlagRequired(utils.TIMESTAMP)
+	return cmd
+}
+
+func ValidateExtractDataFlags(flags *pflag.FlagSet) {
+	utils.CheckExclusiveFlags(flags, utils.DEBUG, utils.QUIET, utils.VERBOSE)
+	err := utils.ValidateFullPath(MustGetFlagString(utils.BACKUP_DIR))
+	gplog.FatalOnError(err)
+	err = ValidateDataFormat(MustGetFlagString(utils.DATA_FORMAT))
+	gplog.FatalOnError(err)
+	utils.ValidateFQNs([]string{MustGetFlagString(utils.INCLUDE_RELATION)})
+}
+
+func DoExtractData() {
+	SetLoggerVerbosity()
+	timestamp := MustGetFlagString(utils.TIMESTAMP)
+	tableFQN := MustGetFlagString(utils.INCLUDE_RELATION)
+	format := MustGetFlagString(utils.DATA_FORMAT)
+	backupDir := MustGetFlagString(utils.BACKUP_DIR)
+	if backupDir == "" {
+		InitializeCluster(MustGetFlagString(utils.DBNAME))
+	}
+	fpInfo := NewBackupFilePathInfo(backupDir, timestamp)
+	backupConfig := backup_history.ReadConfigFile(fpInfo.GetConfigFilePath())
+	if backupConfig.MetadataOnly {
+		gplog.Fatal(errors.Errorf("Backup %s is a metadata-only backup and contains no data.", timestamp), "")
+	}
+
+	dataTimestamp := GetTableDataTimestamp(backupConfig, tableFQN)
+	if dataTimestamp == "" {
+		gplog.Fatal(errors.Errorf("Backup %s contains no data for table %s.", timestamp, tableFQN), "")
+	}
+	if dataTimestamp != timestamp {
+		gplog.Verbose("Data for table %s is in backup %s in the restore plan", tableFQN, dataTimestamp)
+	}
+	dataFPInfo, dataConfig := ReadDataBackupConfig(backupDir, dataTimestamp)
+	entry, ok := FindDataEntry(utils.NewTOC(dataFPInfo.GetTOCFilePath()), tableFQN)
+	if !ok {
+		gplog.Fatal(errors.Errorf("Backup %s contains no data for table %s.", dataTimestamp, tableFQN), "")
+	}
+	source := NewTableDataSource(dataFPInfo, dataConfig, entry)
+	if !source.Replicated && !backupConfig.DataOnly {
+		source.Replicated = IsReplicatedTable(fpInfo, tableFQN)
+	}
+
+	writer := io.Writer(os.Stdout)
+	if outputFile := MustGetFlagString(utils.OUTPUT_FILE); outputFile != "" {
+		file := iohelper.MustOpenFileForWriting(outputFile)
+		defer file.Close()
+		writer = file
+	}
+	rowWriter, err := NewRowWriter(format, writer, ParseAttributeString(source.Entry.AttributeString))
+	gplog.FatalOnError(err)
+	numRows, err := ExtractTableData(&storage.FilesystemBackend{}, source, rowWriter)
+	gplog.FatalOnError(err, fmt.Sprintf("Unable to extract data for table %s", tableFQN))
+	err = rowWriter.Close()
+	gplog.FatalOnError(err, fmt.Sprintf("Unable to write data for table %s", tableFQN))
+	gplog.Info("Extracted %d rows of table %s from backup %s", numRows, tableFQN, dataTimestamp)
+}
+
+/*
+ * Returns the timestamp of the backup in the restore plan that holds the data
+ * of the table, or an empty string if no backup does.  Backups taken before
+ * restore plans were recorded hold their own data.
+ */
+func GetTableDataTimestamp(backupConfig *backup_history.BackupConfig, tableFQN string) string {
+	if backupConfig.RestorePlan == nil {
+		return backupConfig.Timestamp
+	}
+	for _, entry := range backupConfig.RestorePlan {
+		for _, fqn := range entry.TableFQNs {
+			if fqn == tableFQN {
+				return entry.Timestamp
+			}
+		}
+	}
+	return ""
+}
+
+/*
+ * Returns the paths of the files of a backup.  A backup that is not in a
+ * backup directory is in the data directories of the segments, which are
+ * taken from the cluster configuration as gprestore takes them, so they can
+ * only be read on a host that can read those directories.
+ */
+func NewBackupFilePathInfo(backupDir string, timestamp string) backup_filepath.FilePathInfo {
+	if backupDir != "" || globalCluster == nil {
+		return NewMasterFilePathInfo(backupDir, timestamp)
+	}
+	ValidateTimestamp(timestamp)
+	return backup_filepath.NewFilePathInfo(globalCluster, "", timestamp, "")
+}
+
+/*
+ * Reads the configuration of a backup that holds table data, counting its
+ * segments if the configuration does not record them.
+ */
+func ReadDataBackupConfig(backupDir string, timestamp string) (backup_filepath.FilePathInfo, *backup_history.BackupConfig) {
+	fpInfo := NewBackupFilePathInfo(backupDir, timestamp)
+	backupConfig := backup_history.ReadConfigFile(fpInfo.GetConfigFilePath())
+	if backupConfig.SegmentCount == 0 {
+		backupConfig.SegmentCount = CountBackupSegments(fpInfo)
+	}
+	return fpInfo, backupConfig
+}
+
+func FindDataEntry(toc *utils.TOC, tableFQN string) (utils.MasterDataEntry, bool) {
+	for _, entry := range toc.DataEntries {
+		if utils.MakeFQN(entry.Schema, entry.Name) == tableFQN {
+			return entry, true
+		}
+	}
+	return utils.MasterDataEntry{}, false
+}
+
+func NewTableDataSource(fpInfo backup_filepath.FilePathInfo, backupConfig *backup_history.BackupConfig, entry utils.MasterDataEntry) TableDataSource {
+	source := TableDataSource{FPInfo: fpInfo, Entry: entry, SingleDataFile: backupConfig.SingleDataFile, SegmentCount: backupConfig.SegmentCount, Replicated: entry.IsReplicated}
+	if backupConfig.Compressed {
+		source.Extension = ".gz"
+	}
+	return source
+}
+
+/*
+ * Every segment holds a full copy of a replicated table.  Backups taken
+ * before the TOC recorded this have it only in the table's CREATE TABLE
+ * statement in the metadata file.
+ */
+func IsReplicatedTable(fpInfo backup_filepath.FilePathInfo, tableFQN string) bool {
+	toc := utils.NewTOC(fpInfo.GetTOCFilePath())
+	toc.InitializeMetadataEntryMap()
+	metadataFile := iohelper.MustOpenFileForReading(fpInfo.GetMetadataFilePath())
+	defer metadataFile.Close()
+	statements := toc.GetSQLStatementForObjectTypes("predata", metadataFile, []string{"TABLE"}, []string{}, []string{}, []string{}, []string{tableFQN}, []string{})
+	return utils.GetReplicatedTables(statements)[tableFQN]
+}
+
+/*
+ * Backups taken before the segment count was recorded in the configuration
+ * file are counted by their segment backup directories.
+ */
+func CountBackupSegments(fpInfo backup_filepath.FilePathInfo) int {
+	segmentCount := 0
+	for {
+		_, err := operating.System.Stat(fpInfo.GetDirForContent(segmentCount))
+		if err != nil {
+			break
+		}
+		segmentCount++
+	}
+	if segmentCount == 0 {
+		gplog.Fatal(errors.Errorf("No segment backup directories found for backup %s in %s", fpInfo.Timestamp, fpInfo.UserSpecifiedBackupDir), "")
+	}
+	return segmentCount
+}
+
+/*
+ * Reads the data of the table from each segment in order of content ID and
+ * writes it with the row writer, returning the number of rows written.
+ */
+func ExtractTableData(backend storage.StorageBackend, source TableDataSource, rowWriter RowWriter) (int64, error) {
+	var numRows int64
+	for _, contentID := range source.ContentIDs() {
+		reader, err := source.OpenSegmentData(backend, contentID)
+		if err != nil {
+			return numRows, err
+		}
+		csvReader := NewCopyCSVReader(reader)
+		for {
+			row, err := csvReader.Read()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				_ = reader.Close()
+				return numRows, errors.Wrapf(err, "Unable to read data of segment %d", contentID)
+			}
+			err = rowWriter.WriteRow(row)
+			if err != nil {
+				_ = reader.Close()
+				return numRows, err
+			}
+			numRows++
+		}
+		err = reader.Close()
+		if err != nil {
+			return numRows, err
+		}
+	}
+	return numRows, nil
+}
+
+// Only the first segment's copy of a replicated table is read
+func (source TableDataSource) ContentIDs() []int {
+	segmentCount := source.SegmentCount
+	if source.Replicated {
+		segmentCount = 1
+	}
+	contentIDs := make([]int, segmentCount)
+	for contentID := range contentIDs {
+		contentIDs[contentID] = contentID
+	}
+	return contentIDs
+}
+
+/*
+ * Returns the uncompressed data of the table written by one segment.  In a
+ * single data file backup, the table's data is found using the segment's
+ * table of contents, starting at the table's own gzip member if the table was
+ * compressed separately and otherwise by decompressing the data before it.
+ */
+func (source TableDataSource) OpenSegmentData(backend storage.StorageBackend, contentID int) (io.ReadCloser, error) {
+	dataFilename := source.FPInfo.GetTableBackupFilePath(contentID, source.Entry.Oid, source.Extension, source.SingleDataFile)
+	if !source.SingleDataFile {
+		file, err := backend.GetStream(dataFilename)
+		if err != nil {
+			return nil, err
+		}
+		return newDataReader(file, source.Extension, 0, -1)
+	}
+
+	tocFilename := source.FPInfo.GetSegmentTOCFilePath(contentID)
+	entry, ok := utils.NewSegmentTOC(tocFilename).DataEntries[uint(source.Entry.Oid)]
+	if !ok {
+		return nil, errors.Errorf("Segment table of contents %s has no entry for table %s", tocFilename, utils.MakeFQN(source.Entry.Schema, source.Entry.Name))
+	}
+	fileOffset, skipBytes := uint64(0), entry.StartByte
+	if source.Extension == "" {
+		fileOffset, skipBytes = entry.StartByte, 0
+	} else if entry.HasFrame() {
+		fileOffset, skipBytes = entry.FrameStartByte, 0
+	}
+	file, err := storage.GetStreamAt(backend, dataFilename, int64(fileOffset))
+	if err != nil {
+		return nil, err
+	}
+	return newDataReader(file, source.Extension, int64(skipBytes), int64(entry.EndByte-entry.StartByte))
+}
+
+type dataReader struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (reader dataReader) Close() error {
+	return reader.closer.Close()
+}
+
+/*
+ * Decompresses the data if necessary, then discards skipBytes bytes and
+ * limits the data to length bytes, unless length is negative.
+ */
+func newDataReader(file io.ReadCloser, extension string, skipBytes int64, length int64) (io.ReadCloser, error) {
+	var reader io.Reader = file
+	if extension == ".gz" {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+		reader = gzipReader
+	}
+	if skipBytes > 0 {
+		_, err := io.CopyN(ioutil.Discard, reader, skipBytes)
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+	}
+	if length >= 0 {
+		reader = io.LimitReader(reader, length)
+	}
+	return dataReader{Reader: reader, closer: file}, nil
+}
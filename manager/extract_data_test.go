@@ -0,0 +1,162 @@
+package manager_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gpbackup/backup_filepath"
+	"github.com/greenplum-db/gpbackup/backup_history"
+	"github.com/greenplum-db/gpbackup/manager"
+	"github.com/greenplum-db/gpbackup/storage"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func gzipData(data string) []byte {
+	buffer := &bytes.Buffer{}
+	writer := gzip.NewWriter(buffer)
+	_, _ = writer.Write([]byte(data))
+	_ = writer.Close()
+	return buffer.Bytes()
+}
+
+func writeTestFile(filename string, contents []byte) {
+	Expect(os.MkdirAll(filepath.Dir(filename), 0755)).To(Succeed())
+	Expect(ioutil.WriteFile(filename, contents, 0644)).To(Succeed())
+}
+
+var _ = Describe("manager/extract_data tests", func() {
+	Describe("GetTableDataTimestamp", func() {
+		backupConfig := &backup_history.BackupConfig{
+			Timestamp: "20170103010101",
+			RestorePlan: []backup_history.RestorePlanEntry{
+				{Timestamp: "20170101010101", TableFQNs: []string{"public.heap"}},
+				{Timestamp: "20170103010101", TableFQNs: []string{"public.ao"}},
+			},
+		}
+		It("returns the timestamp of the backup in the restore plan that holds the table's data", func() {
+			Expect(manager.GetTableDataTimestamp(backupConfig, "public.heap")).To(Equal("20170101010101"))
+			Expect(manager.GetTableDataTimestamp(backupConfig, "public.ao")).To(Equal("20170103010101"))
+		})
+		It("returns an empty string for a table that is not in the restore plan", func() {
+			Expect(manager.GetTableDataTimestamp(backupConfig, "public.missing")).To(Equal(""))
+		})
+		It("returns the backup's own timestamp for a backup without a restore plan", func() {
+			Expect(manager.GetTableDataTimestamp(&backup_history.BackupConfig{Timestamp: "20170101010101"}, "public.heap")).To(Equal("20170101010101"))
+		})
+	})
+	Describe("NewBackupFilePathInfo", func() {
+		AfterEach(func() {
+			manager.SetCluster(nil)
+		})
+		It("finds the segment directories under the backup directory", func() {
+			tempDir, _ := ioutil.TempDir("", "backup_file_path_info")
+			defer os.RemoveAll(tempDir)
+			Expect(os.MkdirAll(filepath.Join(tempDir, "gpseg-1", "backups", "20170101", "20170101010101"), 0755)).To(Succeed())
+			manager.SetCluster(cluster.NewCluster([]cluster.SegConfig{{ContentID: -1, DataDir: "/data/master/gpseg-1"}, {ContentID: 0, DataDir: "/data/primary/gpseg0"}}))
+
+			fpInfo := manager.NewBackupFilePathInfo(tempDir, "20170101010101")
+
+			Expect(fpInfo.GetDirForContent(0)).To(Equal(filepath.Join(tempDir, "gpseg0", "backups", "20170101", "20170101010101")))
+		})
+		It("finds the segment directories in the data directories of the cluster without a backup directory", func() {
+			manager.SetCluster(cluster.NewCluster([]cluster.SegConfig{{ContentID: -1, DataDir: "/data/master/gpseg-1"}, {ContentID: 0, DataDir: "/data/primary/gpseg0"}}))
+
+			fpInfo := manager.NewBackupFilePathInfo("", "20170101010101")
+
+			Expect(fpInfo.GetConfigFilePath()).To(Equal("/data/master/gpseg-1/backups/20170101/20170101010101/gpbackup_20170101010101_config.yaml"))
+			Expect(fpInfo.GetDirForContent(0)).To(Equal("/data/primary/gpseg0/backups/20170101/20170101010101"))
+		})
+	})
+	Describe("NewTableDataSource", func() {
+		It("takes whether the table is replicated from its TOC entry", func() {
+			fpInfo := backup_filepath.FilePathInfo{Timestamp: "20170101010101"}
+			backupConfig := &backup_history.BackupConfig{Compressed: true, SegmentCount: 2}
+
+			source := manager.NewTableDataSource(fpInfo, backupConfig, utils.MasterDataEntry{Schema: "public", Name: "codes", Oid: 1, IsReplicated: true})
+
+			Expect(source.Replicated).To(BeTrue())
+			Expect(source.Extension).To(Equal(".gz"))
+			Expect(source.SegmentCount).To(Equal(2))
+		})
+	})
+	Describe("ExtractTableData", func() {
+		var tempDir string
+		var source manager.TableDataSource
+		var buffer *bytes.Buffer
+		var rowWriter manager.RowWriter
+		BeforeEach(func() {
+			tempDir, _ = ioutil.TempDir("", "extract_data")
+			fpInfo := backup_filepath.FilePathInfo{UserSpecifiedBackupDir: tempDir, UserSpecifiedSegPrefix: "gpseg", Timestamp: "20170101010101"}
+			source = manager.TableDataSource{FPInfo: fpInfo, Entry: utils.MasterDataEntry{Schema: "public", Name: "foo", Oid: 3, AttributeString: "(i,j)"}, SegmentCount: 2}
+			buffer = &bytes.Buffer{}
+			rowWriter, _ = manager.NewRowWriter(manager.DataFormatCSV, buffer, []string{"i", "j"})
+		})
+		AfterEach(func() {
+			_ = os.RemoveAll(tempDir)
+		})
+		writeSegmentTOC := func(contentID int, entries string) {
+			writeTestFile(source.FPInfo.GetSegmentTOCFilePath(contentID), []byte(fmt.Sprintf("dataentries:\n%s", entries)))
+		}
+		extract := func() string {
+			numRows, err := manager.ExtractTableData(&storage.FilesystemBackend{}, source, rowWriter)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(numRows).To(Equal(int64(3)))
+			Expect(rowWriter.Close()).To(Succeed())
+			return buffer.String()
+		}
+		It("merges the per-table data files of every segment", func() {
+			writeTestFile(source.FPInfo.GetTableBackupFilePath(0, 3, "", false), []byte("1,a\n2,\n"))
+			writeTestFile(source.FPInfo.GetTableBackupFilePath(1, 3, "", false), []byte("3,\"\"\n"))
+
+			Expect(extract()).To(Equal("i,j\n1,a\n2,\n3,\"\"\n"))
+		})
+		It("decompresses compressed per-table data files", func() {
+			source.Extension = ".gz"
+			writeTestFile(source.FPInfo.GetTableBackupFilePath(0, 3, ".gz", false), gzipData("1,a\n2,b\n"))
+			writeTestFile(source.FPInfo.GetTableBackupFilePath(1, 3, ".gz", false), gzipData("3,c\n"))
+
+			Expect(extract()).To(Equal("i,j\n1,a\n2,b\n3,c\n"))
+		})
+		It("reads the table's data from uncompressed single data files", func() {
+			source.SingleDataFile = true
+			writeTestFile(source.FPInfo.GetTableBackupFilePath(0, 3, "", true), []byte("9,z\n1,a\n2,b\n"))
+			writeTestFile(source.FPInfo.GetTableBackupFilePath(1, 3, "", true), []byte("3,c\n8,y\n"))
+			writeSegmentTOC(0, "  2:\n    startbyte: 0\n    endbyte: 4\n  3:\n    startbyte: 4\n    endbyte: 12\n")
+			writeSegmentTOC(1, "  3:\n    startbyte: 0\n    endbyte: 4\n  4:\n    startbyte: 4\n    endbyte: 8\n")
+
+			Expect(extract()).To(Equal("i,j\n1,a\n2,b\n3,c\n"))
+		})
+		It("reads the table's data from compressed single data files with and without separately compressed tables", func() {
+			source.SingleDataFile = true
+			source.Extension = ".gz"
+			firstTable := gzipData("9,z\n")
+			writeTestFile(source.FPInfo.GetTableBackupFilePath(0, 3, ".gz", true), append(firstTable, gzipData("1,a\n2,b\n")...))
+			writeTestFile(source.FPInfo.GetTableBackupFilePath(1, 3, ".gz", true), gzipData("3,c\n8,y\n"))
+			writeSegmentTOC(0, fmt.Sprintf("  3:\n    startbyte: 4\n    endbyte: 12\n    framestartbyte: %d\n    frameendbyte: 100\n", len(firstTable)))
+			writeSegmentTOC(1, "  3:\n    startbyte: 0\n    endbyte: 4\n")
+
+			Expect(extract()).To(Equal("i,j\n1,a\n2,b\n3,c\n"))
+		})
+		It("reads only the first segment's copy of a replicated table", func() {
+			source.Replicated = true
+			writeTestFile(source.FPInfo.GetTableBackupFilePath(0, 3, "", false), []byte("1,a\n2,b\n3,c\n"))
+			writeTestFile(source.FPInfo.GetTableBackupFilePath(1, 3, "", false), []byte("1,a\n2,b\n3,c\n"))
+
+			Expect(extract()).To(Equal("i,j\n1,a\n2,b\n3,c\n"))
+		})
+		It("returns an error if a segment's data file is missing", func() {
+			writeTestFile(source.FPInfo.GetTableBackupFilePath(0, 3, "", false), []byte("1,a\n"))
+
+			_, err := manager.ExtractTableData(&storage.FilesystemBackend{}, source, rowWriter)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,146 @@
+package manager
+
+/*
+ * This file contains the extract-sql command, which prints the metadata
+ * statements of a backup using the offsets in its table of contents, with
+ * the same filters as gprestore, without connecting to a database.
+ */
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/iohelper"
+	"github.com/greenplum-db/gpbackup/backup_history"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+type StatementFilters struct {
+	IncludeObjectTypes []string
+	ExcludeObjectTypes []string
+	IncludeSchemas     []string
+	ExcludeSchemas     []string
+	IncludeRelations   []string
+	ExcludeRelations   []string
+}
+
+func NewExtractSQLCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "extract-sql",
+		Short: "Print the metadata statements of a backup without connecting to a database",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			defer DoTeardown()
+			cmdFlags = cmd.Flags()
+			ValidateExtractSQLFlags(cmdFlags)
+			DoExtractSQL()
+		}}
+	setLoggingFlagDefaults(cmd)
+	cmd.Flags().String(utils.BACKUP_DIR, "", "The absolute path of the directory in which the backup is located")
+	cmd.Flags().StringSlice(utils.EXCLUDE_OBJECT_TYPE, []string{}, "Print all metadata except objects of the specified type(s), e.g. TRIGGER. --exclude-object-type can be specified multiple times.")
+	cmd.Flags().StringSlice(utils.EXCLUDE_SCHEMA, []string{}, "Print all metadata except objects in the specified schema(s). --exclude-schema can be specified multiple times.")
+	cmd.Flags().StringSlice(utils.EXCLUDE_RELATION, []string{}, "Print all metadata except the specified relation(s). --exclude-table can be specified multiple times.")
+	cmd.Flags().StringSlice(utils.INCLUDE_OBJECT_TYPE, []string{}, "Print only metadata for objects of the specified type(s), e.g. FUNCTION. --include-object-type can be specified multiple times.")
+	cmd.Flags().StringSlice(utils.INCLUDE_SCHEMA, []string{}, "Print only the metadata of the specified schema(s). --include-schema can be specified multiple times.")
+	cmd.Flags().StringSlice(utils.INCLUDE_RELATION, []string{}, "Print only the metadata of the specified relation(s). --include-table can be specified multiple times.")
+	cmd.Flags().String(utils.OUTPUT_FILE, "", "The file to which to write the statements, instead of standard output")
+	cmd.Flags().String(utils.TIMESTAMP, "", "The timestamp of the backup, in the format YYYYMMDDHHMMSS")
+	cmd.Flags().Bool(utils.WITH_GLOBALS, false, "Print global metadata, such as roles and tablespaces")
+	_ = cmd.MarkFlagRequired(utils.TIMESTAMP)
+	return cmd
+}
+
+func ValidateExtractSQLFlags(flags *pflag.FlagSet) {
+	utils.CheckExclusiveFlags(flags, utils.DEBUG, utils.QUIET, utils.VERBOSE)
+	utils.CheckExclusiveFlags(flags, utils.INCLUDE_SCHEMA, utils.INCLUDE_RELATION)
+	utils.CheckExclusiveFlags(flags, utils.EXCLUDE_SCHEMA, utils.INCLUDE_SCHEMA)
+	utils.CheckExclusiveFlags(flags, utils.EXCLUDE_SCHEMA, utils.EXCLUDE_RELATION, utils.INCLUDE_RELATION)
+	utils.CheckExclusiveFlags(flags, utils.INCLUDE_OBJECT_TYPE, utils.EXCLUDE_OBJECT_TYPE)
+	err := utils.ValidateFullPath(MustGetFlagString(utils.BACKUP_DIR))
+	gplog.FatalOnError(err)
+	for _, objectTypeFlag := range []string{utils.INCLUDE_OBJECT_TYPE, utils.EXCLUDE_OBJECT_TYPE} {
+		err = utils.ValidateObjectTypes(MustGetFlagStringSlice(objectTypeFlag))
+		gplog.FatalOnError(err)
+	}
+	utils.ValidateFQNs(MustGetFlagStringSlice(utils.INCLUDE_RELATION))
+	utils.ValidateFQNs(MustGetFlagStringSlice(utils.EXCLUDE_RELATION))
+}
+
+func DoExtractSQL() {
+	SetLoggerVerbosity()
+	timestamp := MustGetFlagString(utils.TIMESTAMP)
+	fpInfo := NewMasterFilePathInfo(MustGetFlagString(utils.BACKUP_DIR), timestamp)
+	backupConfig := backup_history.ReadConfigFile(fpInfo.GetConfigFilePath())
+	if backupConfig.DataOnly {
+		gplog.Fatal(errors.Errorf("Backup %s is a data-only backup and contains no metadata.", timestamp), "")
+	}
+	toc := utils.NewTOC(fpInfo.GetTOCFilePath())
+	toc.InitializeMetadataEntryMap()
+	metadataFile := iohelper.MustOpenFileForReading(fpInfo.GetMetadataFilePath())
+	defer metadataFile.Close()
+
+	filters := StatementFilters{
+		IncludeObjectTypes: MustGetFlagStringSlice(utils.INCLUDE_OBJECT_TYPE),
+		ExcludeObjectTypes: MustGetFlagStringSlice(utils.EXCLUDE_OBJECT_TYPE),
+		IncludeSchemas:     MustGetFlagStringSlice(utils.INCLUDE_SCHEMA),
+		ExcludeSchemas:     MustGetFlagStringSlice(utils.EXCLUDE_SCHEMA),
+		IncludeRelations:   MustGetFlagStringSlice(utils.INCLUDE_RELATION),
+		ExcludeRelations:   MustGetFlagStringSlice(utils.EXCLUDE_RELATION),
+	}
+	statements := ExtractSQLStatements(toc, metadataFile, filters, MustGetFlagBool(utils.WITH_GLOBALS))
+
+	writer := io.Writer(os.Stdout)
+	if outputFile := MustGetFlagString(utils.OUTPUT_FILE); outputFile != "" {
+		file := iohelper.MustOpenFileForWriting(outputFile)
+		defer file.Close()
+		writer = file
+	}
+	PrintSQLStatements(writer, statements)
+	gplog.Verbose("Extracted %d statements from backup %s", len(statements), timestamp)
+}
+
+// Statements are returned in the order in which gprestore would execute them
+func ExtractSQLStatements(toc *utils.TOC, metadataFile io.ReaderAt, filters StatementFilters, withGlobals bool) []utils.StatementWithType {
+	statements := ExtractGlobalStatements(toc, metadataFile, filters, withGlobals)
+	for _, section := range []string{"predata", "postdata"} {
+		statements = append(statements, ExtractSectionStatements(toc, metadataFile, section, filters)...)
+	}
+	return statements
+}
+
+/*
+ * The session GUCs, which set the client encoding of the statements, are
+ * always included, while other global objects are only included if
+ * requested, as gprestore only restores them with --with-globals.
+ */
+func ExtractGlobalStatements(toc *utils.TOC, metadataFile io.ReaderAt, filters StatementFilters, withGlobals bool) []utils.StatementWithType {
+	globalStatements := toc.GetSQLStatementForObjectTypes("global", metadataFile, []string{}, []string{}, []string{}, []string{}, []string{}, []string{})
+	if withGlobals {
+		return utils.FilterStatementsByObjectType(globalStatements, filters.IncludeObjectTypes, filters.ExcludeObjectTypes)
+	}
+	return utils.FilterStatementsByObjectType(globalStatements, []string{"SESSION GUCS"}, []string{})
+}
+
+func ExtractSectionStatements(toc *utils.TOC, metadataFile io.ReaderAt, section string, filters StatementFilters) []utils.StatementWithType {
+	includeRelations := filters.IncludeRelations
+	if len(includeRelations) > 0 {
+		// The metadata of a leaf partition is part of the metadata of its root partition
+		includeRelations = append(includeRelations, utils.GetIncludedPartitionRoots(toc.DataEntries, includeRelations)...)
+	}
+	return toc.GetSQLStatementForObjectTypes(section, metadataFile, filters.IncludeObjectTypes, filters.ExcludeObjectTypes,
+		filters.IncludeSchemas, filters.ExcludeSchemas, includeRelations, filters.ExcludeRelations)
+}
+
+func PrintSQLStatements(writer io.Writer, statements []utils.StatementWithType) {
+	for _, statement := range statements {
+		_, err := fmt.Fprint(writer, statement.Statement)
+		gplog.FatalOnError(err, "Unable to write statements")
+	}
+	_, err := fmt.Fprintln(writer)
+	gplog.FatalOnError(err, "Unable to write statements")
+}
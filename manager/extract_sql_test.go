@@ -0,0 +1,78 @@
+package manager_test
+
+import (
+	"bytes"
+
+	"github.com/greenplum-db/gpbackup/manager"
+	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("manager/extract_sql tests", func() {
+	var toc *utils.TOC
+	var metadataFile *bytes.Reader
+	gucs := utils.StatementWithType{Name: "", ObjectType: "SESSION GUCS", Statement: "\nSET client_encoding = 'UTF8';\n"}
+	role := utils.StatementWithType{Name: "testrole", ObjectType: "ROLE", Statement: "\n\nCREATE ROLE testrole;\n"}
+	schema := utils.StatementWithType{Schema: "schema1", Name: "schema1", ObjectType: "SCHEMA", Statement: "\n\nCREATE SCHEMA schema1;\n"}
+	table1 := utils.StatementWithType{Schema: "schema1", Name: "table1", ObjectType: "TABLE", Statement: "\n\nCREATE TABLE schema1.table1 (\n\ti integer\n);\n"}
+	table2 := utils.StatementWithType{Schema: "schema2", Name: "table2", ObjectType: "TABLE", Statement: "\n\nCREATE TABLE schema2.table2 (\n\ti integer\n);\n"}
+	function := utils.StatementWithType{Schema: "schema1", Name: "func1", ObjectType: "FUNCTION", Statement: "\n\nCREATE FUNCTION schema1.func1() RETURNS integer AS $$SELECT 1$$ LANGUAGE sql;\n"}
+	index := utils.StatementWithType{Schema: "schema1", Name: "index1", ObjectType: "INDEX", ReferenceObject: "schema1.table1", Statement: "\n\nCREATE INDEX index1 ON schema1.table1 USING btree (i);\n"}
+	BeforeEach(func() {
+		buffer := &bytes.Buffer{}
+		var backupfile *utils.FileWithByteCount
+		toc, backupfile = testutils.InitializeTestTOC(buffer, "metadata")
+		addStatement := func(section string, statement utils.StatementWithType) {
+			start := backupfile.ByteCount
+			backupfile.MustPrint(statement.Statement)
+			toc.AddMetadataEntry(section, utils.MetadataEntry{Schema: statement.Schema, Name: statement.Name, ObjectType: statement.ObjectType, ReferenceObject: statement.ReferenceObject}, start, backupfile.ByteCount)
+		}
+		addStatement("global", gucs)
+		addStatement("global", role)
+		addStatement("predata", schema)
+		addStatement("predata", table1)
+		addStatement("predata", table2)
+		addStatement("predata", function)
+		addStatement("postdata", index)
+		metadataFile = bytes.NewReader(buffer.Bytes())
+	})
+	Describe("ExtractSQLStatements", func() {
+		It("returns all statements in restore order", func() {
+			statements := manager.ExtractSQLStatements(toc, metadataFile, manager.StatementFilters{}, true)
+
+			Expect(statements).To(Equal([]utils.StatementWithType{gucs, role, schema, table1, table2, function, index}))
+		})
+		It("returns only the session GUCs of the global statements without globals", func() {
+			statements := manager.ExtractSQLStatements(toc, metadataFile, manager.StatementFilters{}, false)
+
+			Expect(statements).To(Equal([]utils.StatementWithType{gucs, schema, table1, table2, function, index}))
+		})
+		It("returns the statements of the included object types", func() {
+			statements := manager.ExtractSQLStatements(toc, metadataFile, manager.StatementFilters{IncludeObjectTypes: []string{"FUNCTION"}}, false)
+
+			Expect(statements).To(Equal([]utils.StatementWithType{gucs, function}))
+		})
+		It("returns the statements of an included table", func() {
+			statements := manager.ExtractSQLStatements(toc, metadataFile, manager.StatementFilters{IncludeRelations: []string{"schema1.table1"}}, false)
+
+			Expect(statements).To(Equal([]utils.StatementWithType{gucs, table1, index}))
+		})
+		It("does not return the statements of an excluded schema", func() {
+			statements := manager.ExtractSQLStatements(toc, metadataFile, manager.StatementFilters{ExcludeSchemas: []string{"schema1"}}, false)
+
+			Expect(statements).To(Equal([]utils.StatementWithType{gucs, table2}))
+		})
+	})
+	Describe("PrintSQLStatements", func() {
+		It("prints the statements as they are stored in the metadata file", func() {
+			buffer := &bytes.Buffer{}
+
+			manager.PrintSQLStatements(buffer, []utils.StatementWithType{gucs, schema})
+
+			Expect(buffer.String()).To(Equal("\nSET client_encoding = 'UTF8';\n\n\nCREATE SCHEMA schema1;\n\n"))
+		})
+	})
+})
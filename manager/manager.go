@@ -0,0 +1,130 @@
+package manager
+
+/*
+ * This file contains the setup and teardown shared by the gpbackup_manager
+ * commands, which operate on backups that gpbackup has already taken.
+ */
+
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+	"sync"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/backup_filepath"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// This function handles setup that can be done before parsing flags.
+func DoInit(cmd *cobra.Command) {
+	CleanupGroup = &sync.WaitGroup{}
+	CleanupGroup.Add(1)
+	gplog.InitializeLogging("gpbackup_manager", "")
+	utils.InitializeSignalHandler(DoCleanup, "gpbackup_manager process", &wasTerminated)
+}
+
+func SetLoggerVerbosity() {
+	if MustGetFlagBool(utils.QUIET) {
+		gplog.SetVerbosity(gplog.LOGERROR)
+	} else if MustGetFlagBool(utils.DEBUG) {
+		gplog.SetVerbosity(gplog.LOGDEBUG)
+	} else if MustGetFlagBool(utils.VERBOSE) {
+		gplog.SetVerbosity(gplog.LOGVERBOSE)
+	}
+}
+
+func setLoggingFlagDefaults(cmd *cobra.Command) {
+	cmd.Flags().Bool(utils.DEBUG, false, "Print verbose and debug log messages")
+	cmd.Flags().Bool(utils.QUIET, false, "Suppress non-warning, non-error log messages")
+	cmd.Flags().Bool(utils.VERBOSE, false, "Print verbose log messages")
+}
+
+func InitializeCluster(unquotedDBName string) {
+	connectionPool = dbconn.NewDBConnFromEnvironment(unquotedDBName)
+	connectionPool.MustConnect(1)
+	utils.ValidateGPDBVersionCompatibility(connectionPool)
+	segConfig := cluster.MustGetSegmentConfiguration(connectionPool)
+	globalCluster = cluster.NewCluster(segConfig)
+}
+
+/*
+ * Commands that only read the master files of a backup do not connect to the
+ * database, so the master data directory is taken from the environment when
+ * the backup is not in a backup directory.
+ */
+func NewMasterFilePathInfo(backupDir string, timestamp string) backup_filepath.FilePathInfo {
+	ValidateTimestamp(timestamp)
+	segPrefix := ""
+	masterDataDir := ""
+	if backupDir != "" {
+		segPrefix = backup_filepath.ParseSegPrefix(backupDir, timestamp)
+	} else {
+		masterDataDir = operating.System.Getenv("MASTER_DATA_DIRECTORY")
+		if masterDataDir == "" {
+			gplog.Fatal(errors.Errorf("MASTER_DATA_DIRECTORY must be set to find a backup that is not in a backup directory. Set it or use the --%s flag.", utils.BACKUP_DIR), "")
+		}
+	}
+	masterOnly := cluster.NewCluster([]cluster.SegConfig{{ContentID: -1, Hostname: "localhost", DataDir: masterDataDir}})
+	return backup_filepath.NewFilePathInfo(masterOnly, backupDir, timestamp, segPrefix)
+}
+
+func ValidateTimestamp(timestamp string) {
+	if !backup_filepath.IsValidTimestamp(timestamp) {
+		gplog.Fatal(errors.Errorf("Timestamp %s is invalid.  Timestamps must be in the format YYYYMMDDHHMMSS.", timestamp), "")
+	}
+}
+
+func DoTeardown() {
+	failed := false
+	defer func() {
+		DoCleanup(failed)
+
+		errorCode := gplog.GetErrorCode()
+		if errorCode == 0 {
+			gplog.Info("gpbackup_manager completed successfully")
+		}
+		os.Exit(errorCode)
+	}()
+
+	if err := recover(); err != nil {
+		// Check if gplog.Fatal did not cause the panic
+		if gplog.GetErrorCode() != 2 {
+			gplog.Error(fmt.Sprintf("%v: %s", err, debug.Stack()))
+			gplog.SetErrorCode(2)
+		} else {
+			fmt.Println(err)
+		}
+		failed = true
+	}
+	if wasTerminated {
+		/*
+		 * Don't print an error if the command was canceled, as the signal
+		 * handler will take care of cleanup and return codes.
+		 */
+		CleanupGroup.Wait()
+		failed = true
+	}
+}
+
+func DoCleanup(failed bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			gplog.Warn("Encountered error during cleanup: %v", err)
+		}
+		gplog.Verbose("Cleanup complete")
+		CleanupGroup.Done()
+	}()
+
+	gplog.Verbose("Beginning cleanup")
+	RestoreOriginalConfig()
+	cleanupCopyPlugins()
+	if connectionPool != nil {
+		connectionPool.Close()
+	}
+}
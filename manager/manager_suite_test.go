@@ -0,0 +1,25 @@
+package manager_test
+
+import (
+	"testing"
+
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var (
+	stdout  *gbytes.Buffer
+	stderr  *gbytes.Buffer
+	logfile *gbytes.Buffer
+)
+
+func TestManager(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "manager tests")
+}
+
+var _ = BeforeSuite(func() {
+	stdout, stderr, logfile = testhelper.SetupTestLogger()
+})
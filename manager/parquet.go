@@ -0,0 +1,342 @@
+package manager
+
+/*
+ * This file contains a minimal Parquet writer for table data extracted from a
+ * backup.  Every column is written as an optional UTF8 string column, as the
+ * data files do not record column types, using PLAIN encoding and no
+ * compression so that the file can be read by any Parquet implementation.
+ *
+ * The file format is described at https://github.com/apache/parquet-format
+ * and its metadata is serialized with the Thrift compact protocol.
+ */
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	parquetMagic = "PAR1"
+	// Rows are buffered in memory and written out in row groups of this size
+	parquetRowGroupSize = 100000
+
+	parquetTypeByteArray        = 6
+	parquetRepetitionOptional   = 1
+	parquetConvertedTypeUTF8    = 0
+	parquetEncodingPlain        = 0
+	parquetEncodingRLE          = 3
+	parquetCodecUncompressed    = 0
+	parquetPageTypeDataPage     = 0
+	parquetFormatVersion        = 1
+	parquetCreatedBy            = "gpbackup_manager"
+	thriftTypeI32               = 5
+	thriftTypeI64               = 6
+	thriftTypeBinary            = 8
+	thriftTypeList              = 9
+	thriftTypeStruct            = 12
+	thriftFieldDeltaLimit       = 15
+	thriftShortListLengthLimit  = 15
+	thriftLongListLengthMarker  = 0xF0
+	parquetDefinitionLevelNull  = 0
+	parquetDefinitionLevelValue = 1
+)
+
+type parquetColumnChunk struct {
+	offset           int64
+	size             int64
+	numValues        int64
+	uncompressedSize int64
+}
+
+type parquetRowGroup struct {
+	columns  []parquetColumnChunk
+	numRows  int64
+	byteSize int64
+}
+
+type ParquetRowWriter struct {
+	writer    io.Writer
+	columns   []string
+	rows      [][]sql.NullString
+	offset    int64
+	rowGroups []parquetRowGroup
+	started   bool
+}
+
+func NewParquetRowWriter(writer io.Writer, columns []string) *ParquetRowWriter {
+	return &ParquetRowWriter{writer: writer, columns: columns, rows: make([][]sql.NullString, 0)}
+}
+
+func (w *ParquetRowWriter) WriteRow(row []sql.NullString) error {
+	if len(row) != len(w.columns) {
+		return errors.Errorf("Row has %d fields but the table has %d columns", len(row), len(w.columns))
+	}
+	w.rows = append(w.rows, row)
+	if len(w.rows) >= parquetRowGroupSize {
+		return w.writeRowGroup()
+	}
+	return nil
+}
+
+func (w *ParquetRowWriter) Close() error {
+	if err := w.start(); err != nil {
+		return err
+	}
+	if len(w.rows) > 0 {
+		if err := w.writeRowGroup(); err != nil {
+			return err
+		}
+	}
+	footer := w.fileMetadata()
+	if err := w.write(footer); err != nil {
+		return err
+	}
+	footerLength := make([]byte, 4)
+	binary.LittleEndian.PutUint32(footerLength, uint32(len(footer)))
+	if err := w.write(footerLength); err != nil {
+		return err
+	}
+	return w.write([]byte(parquetMagic))
+}
+
+func (w *ParquetRowWriter) start() error {
+	if w.started {
+		return nil
+	}
+	w.started = true
+	return w.write([]byte(parquetMagic))
+}
+
+func (w *ParquetRowWriter) write(data []byte) error {
+	n, err := w.writer.Write(data)
+	w.offset += int64(n)
+	return err
+}
+
+/*
+ * Each column chunk of a row group is written as a single data page.
+ */
+func (w *ParquetRowWriter) writeRowGroup() error {
+	if err := w.start(); err != nil {
+		return err
+	}
+	rowGroup := parquetRowGroup{columns: make([]parquetColumnChunk, len(w.columns)), numRows: int64(len(w.rows))}
+	for i := range w.columns {
+		values := make([]sql.NullString, len(w.rows))
+		for j, row := range w.rows {
+			values[j] = row[i]
+		}
+		page := encodeParquetDataPage(values)
+		rowGroup.columns[i] = parquetColumnChunk{offset: w.offset, size: int64(len(page)), numValues: int64(len(values)), uncompressedSize: int64(len(page))}
+		rowGroup.byteSize += int64(len(page))
+		if err := w.write(page); err != nil {
+			return err
+		}
+	}
+	w.rowGroups = append(w.rowGroups, rowGroup)
+	w.rows = w.rows[:0]
+	return nil
+}
+
+/*
+ * A version 1 data page holds the definition levels of the column, which
+ * mark which values are NULL, followed by the lengths and bytes of the values
+ * that are not NULL.  There are no repetition levels, as no column is nested.
+ */
+func encodeParquetDataPage(values []sql.NullString) []byte {
+	levels := make([]int, len(values))
+	data := &bytes.Buffer{}
+	for i, value := range values {
+		levels[i] = parquetDefinitionLevelNull
+		if value.Valid {
+			levels[i] = parquetDefinitionLevelValue
+		}
+	}
+	encodedLevels := EncodeParquetLevels(levels)
+	_ = binary.Write(data, binary.LittleEndian, uint32(len(encodedLevels)))
+	data.Write(encodedLevels)
+	for _, value := range values {
+		if value.Valid {
+			_ = binary.Write(data, binary.LittleEndian, uint32(len(value.String)))
+			data.WriteString(value.String)
+		}
+	}
+
+	header := &thriftWriter{}
+	header.writeI32Field(1, parquetPageTypeDataPage)
+	header.writeI32Field(2, int32(data.Len()))
+	header.writeI32Field(3, int32(data.Len()))
+	header.beginStructField(5)
+	header.writeI32Field(1, int32(len(values)))
+	header.writeI32Field(2, parquetEncodingPlain)
+	header.writeI32Field(3, parquetEncodingRLE)
+	header.writeI32Field(4, parquetEncodingRLE)
+	header.endStruct()
+	header.endStruct()
+	return append(header.Bytes(), data.Bytes()...)
+}
+
+/*
+ * Levels are encoded with the RLE half of the RLE/bit-packing hybrid
+ * encoding, as a run length header followed by the repeated level in one
+ * byte, which is enough for the bit width of 1 used by optional columns.
+ */
+func EncodeParquetLevels(levels []int) []byte {
+	encoded := &bytes.Buffer{}
+	for i := 0; i < len(levels); {
+		runLength := 1
+		for i+runLength < len(levels) && levels[i+runLength] == levels[i] {
+			runLength++
+		}
+		encoded.Write(encodeVarint(uint64(runLength) << 1))
+		encoded.WriteByte(byte(levels[i]))
+		i += runLength
+	}
+	return encoded.Bytes()
+}
+
+func (w *ParquetRowWriter) fileMetadata() []byte {
+	var numRows int64
+	for _, rowGroup := range w.rowGroups {
+		numRows += rowGroup.numRows
+	}
+	metadata := &thriftWriter{}
+	metadata.writeI32Field(1, parquetFormatVersion)
+
+	// The root of the schema is a group holding every column
+	metadata.writeListHeader(2, thriftTypeStruct, len(w.columns)+1)
+	metadata.beginStruct()
+	metadata.writeStringField(4, "schema")
+	metadata.writeI32Field(5, int32(len(w.columns)))
+	metadata.endStruct()
+	for _, column := range w.columns {
+		metadata.beginStruct()
+		metadata.writeI32Field(1, parquetTypeByteArray)
+		metadata.writeI32Field(3, parquetRepetitionOptional)
+		metadata.writeStringField(4, column)
+		metadata.writeI32Field(6, parquetConvertedTypeUTF8)
+		metadata.endStruct()
+	}
+
+	metadata.writeI64Field(3, numRows)
+
+	metadata.writeListHeader(4, thriftTypeStruct, len(w.rowGroups))
+	for _, rowGroup := range w.rowGroups {
+		metadata.beginStruct()
+		metadata.writeListHeader(1, thriftTypeStruct, len(rowGroup.columns))
+		for i, chunk := range rowGroup.columns {
+			metadata.beginStruct()
+			metadata.writeI64Field(2, chunk.offset)
+			metadata.beginStructField(3)
+			metadata.writeI32Field(1, parquetTypeByteArray)
+			metadata.writeListHeader(2, thriftTypeI32, 2)
+			metadata.writeZigzag(parquetEncodingPlain)
+			metadata.writeZigzag(parquetEncodingRLE)
+			metadata.writeListHeader(3, thriftTypeBinary, 1)
+			metadata.writeBinary(w.columns[i])
+			metadata.writeI32Field(4, parquetCodecUncompressed)
+			metadata.writeI64Field(5, chunk.numValues)
+			metadata.writeI64Field(6, chunk.uncompressedSize)
+			metadata.writeI64Field(7, chunk.size)
+			metadata.writeI64Field(9, chunk.offset)
+			metadata.endStruct()
+			metadata.endStruct()
+		}
+		metadata.writeI64Field(2, rowGroup.byteSize)
+		metadata.writeI64Field(3, rowGroup.numRows)
+		metadata.endStruct()
+	}
+
+	metadata.writeStringField(6, parquetCreatedBy)
+	metadata.endStruct()
+	return metadata.Bytes()
+}
+
+/*
+ * thriftWriter serializes structs with the Thrift compact protocol, in which
+ * each field header holds the difference between its field ID and the ID of
+ * the previous field in the same struct.
+ */
+type thriftWriter struct {
+	bytes.Buffer
+	lastFieldID  int16
+	parentFields []int16
+}
+
+func encodeVarint(value uint64) []byte {
+	encoded := make([]byte, binary.MaxVarintLen64)
+	return encoded[:binary.PutUvarint(encoded, value)]
+}
+
+func (w *thriftWriter) writeZigzag(value int64) {
+	w.Write(encodeVarint(uint64((value << 1) ^ (value >> 63))))
+}
+
+func (w *thriftWriter) writeBinary(value string) {
+	w.Write(encodeVarint(uint64(len(value))))
+	w.WriteString(value)
+}
+
+func (w *thriftWriter) writeFieldHeader(fieldID int16, fieldType byte) {
+	delta := fieldID - w.lastFieldID
+	if delta > 0 && delta <= thriftFieldDeltaLimit {
+		w.WriteByte(byte(delta)<<4 | fieldType)
+	} else {
+		w.WriteByte(fieldType)
+		w.writeZigzag(int64(fieldID))
+	}
+	w.lastFieldID = fieldID
+}
+
+func (w *thriftWriter) writeI32Field(fieldID int16, value int32) {
+	w.writeFieldHeader(fieldID, thriftTypeI32)
+	w.writeZigzag(int64(value))
+}
+
+func (w *thriftWriter) writeI64Field(fieldID int16, value int64) {
+	w.writeFieldHeader(fieldID, thriftTypeI64)
+	w.writeZigzag(value)
+}
+
+func (w *thriftWriter) writeStringField(fieldID int16, value string) {
+	w.writeFieldHeader(fieldID, thriftTypeBinary)
+	w.writeBinary(value)
+}
+
+// The elements of the list must be written immediately after its header
+func (w *thriftWriter) writeListHeader(fieldID int16, elementType byte, size int) {
+	w.writeFieldHeader(fieldID, thriftTypeList)
+	if size < thriftShortListLengthLimit {
+		w.WriteByte(byte(size)<<4 | elementType)
+	} else {
+		w.WriteByte(thriftLongListLengthMarker | elementType)
+		w.Write(encodeVarint(uint64(size)))
+	}
+}
+
+// Starts a struct that is an element of a list, which has no field header
+func (w *thriftWriter) beginStruct() {
+	w.parentFields = append(w.parentFields, w.lastFieldID)
+	w.lastFieldID = 0
+}
+
+func (w *thriftWriter) beginStructField(fieldID int16) {
+	w.writeFieldHeader(fieldID, thriftTypeStruct)
+	w.beginStruct()
+}
+
+/*
+ * Ends the current struct.  The top-level struct is ended without having
+ * been begun, so there is no parent field ID to restore.
+ */
+func (w *thriftWriter) endStruct() {
+	w.WriteByte(0)
+	if len(w.parentFields) > 0 {
+		w.lastFieldID = w.parentFields[len(w.parentFields)-1]
+		w.parentFields = w.parentFields[:len(w.parentFields)-1]
+	}
+}
@@ -0,0 +1,223 @@
+package manager_test
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/greenplum-db/gpbackup/manager"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/*
+ * thriftReader decodes the subset of the Thrift compact protocol that the
+ * Parquet writer uses, returning each struct as a map from field ID to value
+ * so that the tests can read the file independently of the writer.
+ */
+type thriftReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *thriftReader) readVarint() uint64 {
+	value, length := binary.Uvarint(r.data[r.pos:])
+	Expect(length).To(BeNumerically(">", 0))
+	r.pos += length
+	return value
+}
+
+func (r *thriftReader) readZigzag() int64 {
+	value := r.readVarint()
+	return int64(value>>1) ^ -int64(value&1)
+}
+
+func (r *thriftReader) readStruct() map[int16]interface{} {
+	fields := make(map[int16]interface{})
+	var fieldID int16
+	for {
+		header := r.data[r.pos]
+		r.pos++
+		if header == 0 {
+			return fields
+		}
+		if delta := int16(header >> 4); delta > 0 {
+			fieldID += delta
+		} else {
+			fieldID = int16(r.readZigzag())
+		}
+		fields[fieldID] = r.readValue(header & 0x0F)
+	}
+}
+
+func (r *thriftReader) readValue(valueType byte) interface{} {
+	switch valueType {
+	case 5, 6:
+		return r.readZigzag()
+	case 8:
+		length := int(r.readVarint())
+		value := string(r.data[r.pos : r.pos+length])
+		r.pos += length
+		return value
+	case 9:
+		header := r.data[r.pos]
+		r.pos++
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(r.readVarint())
+		}
+		list := make([]interface{}, size)
+		for i := range list {
+			list[i] = r.readValue(header & 0x0F)
+		}
+		return list
+	case 12:
+		return r.readStruct()
+	}
+	Fail(fmt.Sprintf("Unexpected Thrift type %d", valueType))
+	return nil
+}
+
+/*
+ * Decodes the RLE runs of definition levels, and then the values that are
+ * not NULL, of a version 1 data page.
+ */
+func readParquetDataPage(data []byte, numValues int) []sql.NullString {
+	levelsLength := int(binary.LittleEndian.Uint32(data[:4]))
+	levels := &thriftReader{data: data[4 : 4+levelsLength]}
+	values := make([]sql.NullString, 0, numValues)
+	for levels.pos < len(levels.data) {
+		runHeader := levels.readVarint()
+		Expect(runHeader & 1).To(Equal(uint64(0)))
+		level := levels.data[levels.pos]
+		levels.pos++
+		for i := uint64(0); i < runHeader>>1; i++ {
+			values = append(values, sql.NullString{Valid: level == 1})
+		}
+	}
+	Expect(values).To(HaveLen(numValues))
+	pos := 4 + levelsLength
+	for i := range values {
+		if values[i].Valid {
+			length := int(binary.LittleEndian.Uint32(data[pos : pos+4]))
+			values[i].String = string(data[pos+4 : pos+4+length])
+			pos += 4 + length
+		}
+	}
+	Expect(pos).To(Equal(len(data)))
+	return values
+}
+
+/*
+ * Returns the column names, the number of rows in each row group, and the
+ * rows of a Parquet file, checking that the row counts recorded in the footer
+ * match the data.
+ */
+func readParquetFile(contents []byte) ([]string, []int64, [][]sql.NullString) {
+	Expect(string(contents[:4])).To(Equal("PAR1"))
+	Expect(string(contents[len(contents)-4:])).To(Equal("PAR1"))
+	footerLength := int(binary.LittleEndian.Uint32(contents[len(contents)-8 : len(contents)-4]))
+	metadata := (&thriftReader{data: contents[len(contents)-8-footerLength : len(contents)-8]}).readStruct()
+
+	schema := metadata[2].([]interface{})
+	Expect(schema[0].(map[int16]interface{})[5]).To(Equal(int64(len(schema) - 1)))
+	columns := make([]string, 0)
+	for _, element := range schema[1:] {
+		Expect(element.(map[int16]interface{})[3]).To(Equal(int64(1)))
+		columns = append(columns, element.(map[int16]interface{})[4].(string))
+	}
+
+	rowGroupSizes := make([]int64, 0)
+	rows := make([][]sql.NullString, 0)
+	for _, rowGroup := range metadata[4].([]interface{}) {
+		numRows := rowGroup.(map[int16]interface{})[3].(int64)
+		rowGroupSizes = append(rowGroupSizes, numRows)
+		firstRow := len(rows)
+		for i := int64(0); i < numRows; i++ {
+			rows = append(rows, make([]sql.NullString, len(columns)))
+		}
+		chunks := rowGroup.(map[int16]interface{})[1].([]interface{})
+		Expect(chunks).To(HaveLen(len(columns)))
+		for i, chunk := range chunks {
+			chunkMetadata := chunk.(map[int16]interface{})[3].(map[int16]interface{})
+			Expect(chunkMetadata[5]).To(Equal(numRows))
+			page := &thriftReader{data: contents, pos: int(chunkMetadata[9].(int64))}
+			pageHeader := page.readStruct()
+			pageSize := int(pageHeader[3].(int64))
+			Expect(pageHeader[5].(map[int16]interface{})[1]).To(Equal(numRows))
+			values := readParquetDataPage(contents[page.pos:page.pos+pageSize], int(numRows))
+			for j, value := range values {
+				rows[firstRow+j][i] = value
+			}
+		}
+	}
+	Expect(metadata[3]).To(Equal(int64(len(rows))))
+	return columns, rowGroupSizes, rows
+}
+
+var _ = Describe("manager/parquet tests", func() {
+	Describe("EncodeParquetLevels", func() {
+		It("encodes each run of levels as a run length and a level", func() {
+			Expect(manager.EncodeParquetLevels([]int{1, 1, 1, 0, 1})).To(Equal([]byte{0x06, 0x01, 0x02, 0x00, 0x02, 0x01}))
+		})
+		It("encodes long runs with a multi-byte run length", func() {
+			levels := make([]int, 100)
+			Expect(manager.EncodeParquetLevels(levels)).To(Equal([]byte{0xC8, 0x01, 0x00}))
+		})
+	})
+	Describe("ParquetRowWriter", func() {
+		It("writes a file that begins and ends with the Parquet magic number", func() {
+			buffer := &bytes.Buffer{}
+			writer := manager.NewParquetRowWriter(buffer, []string{"id", "name"})
+			Expect(writer.WriteRow([]sql.NullString{{String: "1", Valid: true}, {String: "abc", Valid: true}})).To(Succeed())
+			Expect(writer.WriteRow([]sql.NullString{{String: "2", Valid: true}, {}})).To(Succeed())
+			Expect(writer.Close()).To(Succeed())
+
+			contents := buffer.Bytes()
+			Expect(string(contents[:4])).To(Equal("PAR1"))
+			Expect(string(contents[len(contents)-4:])).To(Equal("PAR1"))
+			footerLength := int(binary.LittleEndian.Uint32(contents[len(contents)-8 : len(contents)-4]))
+			footer := contents[len(contents)-8-footerLength : len(contents)-8]
+			Expect(footer).To(ContainSubstring("name"))
+			Expect(footer).To(ContainSubstring("gpbackup_manager"))
+			Expect(contents[:len(contents)-8-footerLength]).To(ContainSubstring("abc"))
+		})
+		It("writes rows that can be read back, with NULLs, in several row groups", func() {
+			buffer := &bytes.Buffer{}
+			writer := manager.NewParquetRowWriter(buffer, []string{"id", "name"})
+			expectedRows := make([][]sql.NullString, 200003)
+			for i := range expectedRows {
+				name := sql.NullString{}
+				if i%3 != 0 {
+					name = sql.NullString{String: fmt.Sprintf("name%d", i), Valid: true}
+				}
+				expectedRows[i] = []sql.NullString{{String: fmt.Sprintf("%d", i), Valid: true}, name}
+				Expect(writer.WriteRow(expectedRows[i])).To(Succeed())
+			}
+			Expect(writer.Close()).To(Succeed())
+
+			columns, rowGroupSizes, rows := readParquetFile(buffer.Bytes())
+
+			Expect(columns).To(Equal([]string{"id", "name"}))
+			Expect(rowGroupSizes).To(Equal([]int64{100000, 100000, 3}))
+			Expect(rows).To(Equal(expectedRows))
+		})
+		It("writes a file with no rows", func() {
+			buffer := &bytes.Buffer{}
+			writer := manager.NewParquetRowWriter(buffer, []string{"id"})
+			Expect(writer.Close()).To(Succeed())
+
+			columns, rowGroupSizes, rows := readParquetFile(buffer.Bytes())
+
+			Expect(columns).To(Equal([]string{"id"}))
+			Expect(rowGroupSizes).To(BeEmpty())
+			Expect(rows).To(BeEmpty())
+		})
+		It("returns an error for a row with the wrong number of fields", func() {
+			writer := manager.NewParquetRowWriter(&bytes.Buffer{}, []string{"id", "name"})
+			Expect(writer.WriteRow([]sql.NullString{{String: "1", Valid: true}})).To(MatchError("Row has 1 fields but the table has 2 columns"))
+		})
+	})
+})
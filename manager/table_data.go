@@ -0,0 +1,241 @@
+package manager
+
+/*
+ * This file contains functions for reading the table data files written by
+ * gpbackup, which hold the output of COPY ... TO ... WITH CSV, and for
+ * writing that data in formats that do not require a database to load.
+ */
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	DataFormatCSV     = "csv"
+	DataFormatJSONL   = "jsonl"
+	DataFormatParquet = "parquet"
+)
+
+var DataFormats = []string{DataFormatCSV, DataFormatJSONL, DataFormatParquet}
+
+/*
+ * Returns the unquoted names of the columns in an attribute string such as
+ * (a,"B","c,d"), which lists the quoted names of the columns of a table in
+ * the order in which their data was backed up.
+ */
+func ParseAttributeString(attributeString string) []string {
+	attributeString = strings.TrimSuffix(strings.TrimPrefix(attributeString, "("), ")")
+	columns := make([]string, 0)
+	if attributeString == "" {
+		return columns
+	}
+	inQuotes := false
+	start := 0
+	for i := 0; i < len(attributeString); i++ {
+		switch attributeString[i] {
+		case '"':
+			inQuotes = !inQuotes
+		case ',':
+			if !inQuotes {
+				columns = append(columns, utils.UnquoteIdent(attributeString[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(columns, utils.UnquoteIdent(attributeString[start:]))
+}
+
+/*
+ * CopyCSVReader reads rows in the CSV format of COPY, in which a NULL is an
+ * unquoted empty field and an empty string is a quoted empty field, a
+ * distinction that encoding/csv does not make.
+ */
+type CopyCSVReader struct {
+	reader *bufio.Reader
+}
+
+func NewCopyCSVReader(reader io.Reader) *CopyCSVReader {
+	return &CopyCSVReader{reader: bufio.NewReader(reader)}
+}
+
+/*
+ * Returns the fields of the next row, or io.EOF if there are no more rows.
+ */
+func (r *CopyCSVReader) Read() ([]sql.NullString, error) {
+	row := make([]sql.NullString, 0)
+	field := &strings.Builder{}
+	inQuotes := false
+	wasQuoted := false
+	readAny := false
+	endField := func() {
+		row = append(row, sql.NullString{String: field.String(), Valid: wasQuoted || field.Len() > 0})
+		field.Reset()
+		wasQuoted = false
+	}
+	for {
+		char, err := r.reader.ReadByte()
+		if err == io.EOF {
+			if inQuotes {
+				return nil, errors.New("Unexpected end of data in quoted field")
+			}
+			if !readAny {
+				return nil, io.EOF
+			}
+			endField()
+			return row, nil
+		} else if err != nil {
+			return nil, err
+		}
+		readAny = true
+		if inQuotes {
+			if char != '"' {
+				field.WriteByte(char)
+				continue
+			}
+			// A doubled quote inside a quoted field is a literal quote
+			next, err := r.reader.Peek(1)
+			if err == nil && next[0] == '"' {
+				_, _ = r.reader.ReadByte()
+				field.WriteByte('"')
+			} else {
+				inQuotes = false
+			}
+			continue
+		}
+		switch char {
+		case '"':
+			inQuotes = true
+			wasQuoted = true
+		case ',':
+			endField()
+		case '\n':
+			endField()
+			return row, nil
+		case '\r':
+			if next, err := r.reader.Peek(1); err == nil && next[0] == '\n' {
+				continue
+			}
+			field.WriteByte(char)
+		default:
+			field.WriteByte(char)
+		}
+	}
+}
+
+/*
+ * A RowWriter writes rows of table data to a file in one of the supported
+ * formats.  Close must be called to finish the file, but does not close the
+ * underlying writer.
+ */
+type RowWriter interface {
+	WriteRow(row []sql.NullString) error
+	Close() error
+}
+
+func NewRowWriter(format string, writer io.Writer, columns []string) (RowWriter, error) {
+	switch format {
+	case DataFormatCSV:
+		return newCSVRowWriter(writer, columns)
+	case DataFormatJSONL:
+		return &jsonlRowWriter{writer: bufio.NewWriter(writer), columns: columns}, nil
+	case DataFormatParquet:
+		return NewParquetRowWriter(writer, columns), nil
+	}
+	return nil, errors.Errorf("Unrecognized data format %s.  Valid formats are %s.", format, strings.Join(DataFormats, ", "))
+}
+
+func ValidateDataFormat(format string) error {
+	for _, validFormat := range DataFormats {
+		if format == validFormat {
+			return nil
+		}
+	}
+	return errors.Errorf("Unrecognized data format %s.  Valid formats are %s.", format, strings.Join(DataFormats, ", "))
+}
+
+/*
+ * Rows are written in the same CSV format that COPY uses, with a header, so
+ * that NULLs can be distinguished from empty strings.
+ */
+type csvRowWriter struct {
+	writer *bufio.Writer
+}
+
+func newCSVRowWriter(writer io.Writer, columns []string) (*csvRowWriter, error) {
+	csvWriter := &csvRowWriter{writer: bufio.NewWriter(writer)}
+	header := make([]sql.NullString, len(columns))
+	for i, column := range columns {
+		header[i] = sql.NullString{String: column, Valid: true}
+	}
+	return csvWriter, csvWriter.WriteRow(header)
+}
+
+func (w *csvRowWriter) WriteRow(row []sql.NullString) error {
+	fields := make([]string, len(row))
+	for i, field := range row {
+		fields[i] = quoteCSVField(field)
+	}
+	_, err := fmt.Fprintf(w.writer, "%s\n", strings.Join(fields, ","))
+	return err
+}
+
+func (w *csvRowWriter) Close() error {
+	return w.writer.Flush()
+}
+
+func quoteCSVField(field sql.NullString) string {
+	if !field.Valid {
+		return ""
+	}
+	if field.String == "" || field.String == `\.` || strings.ContainsAny(field.String, ",\"\r\n") {
+		return fmt.Sprintf(`"%s"`, strings.Replace(field.String, `"`, `""`, -1))
+	}
+	return field.String
+}
+
+/*
+ * Each row is written as a JSON object on its own line, with its keys in the
+ * order of the table's columns.  All values are written as strings, as they
+ * are stored in the data files, or as null.
+ */
+type jsonlRowWriter struct {
+	writer  *bufio.Writer
+	columns []string
+}
+
+func (w *jsonlRowWriter) WriteRow(row []sql.NullString) error {
+	if len(row) != len(w.columns) {
+		return errors.Errorf("Row has %d fields but the table has %d columns", len(row), len(w.columns))
+	}
+	line := &strings.Builder{}
+	line.WriteString("{")
+	for i, field := range row {
+		if i > 0 {
+			line.WriteString(",")
+		}
+		key, _ := json.Marshal(w.columns[i])
+		line.Write(key)
+		line.WriteString(":")
+		if !field.Valid {
+			line.WriteString("null")
+			continue
+		}
+		value, _ := json.Marshal(field.String)
+		line.Write(value)
+	}
+	line.WriteString("}\n")
+	_, err := w.writer.WriteString(line.String())
+	return err
+}
+
+func (w *jsonlRowWriter) Close() error {
+	return w.writer.Flush()
+}
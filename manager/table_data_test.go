@@ -0,0 +1,108 @@
+package manager_test
+
+import (
+	"bytes"
+	"database/sql"
+	"io"
+	"strings"
+
+	"github.com/greenplum-db/gpbackup/manager"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func field(str string) sql.NullString {
+	return sql.NullString{String: str, Valid: true}
+}
+
+var nullField = sql.NullString{}
+
+var _ = Describe("manager/table_data tests", func() {
+	Describe("ParseAttributeString", func() {
+		It("returns the unquoted column names", func() {
+			Expect(manager.ParseAttributeString(`(a,"B","c,d","e""f")`)).To(Equal([]string{"a", "B", "c,d", `e"f`}))
+		})
+		It("returns no columns for a table without columns", func() {
+			Expect(manager.ParseAttributeString("")).To(BeEmpty())
+		})
+	})
+	Describe("CopyCSVReader", func() {
+		readAll := func(data string) [][]sql.NullString {
+			reader := manager.NewCopyCSVReader(strings.NewReader(data))
+			rows := make([][]sql.NullString, 0)
+			for {
+				row, err := reader.Read()
+				if err == io.EOF {
+					return rows
+				}
+				Expect(err).ToNot(HaveOccurred())
+				rows = append(rows, row)
+			}
+		}
+		It("reads unquoted and quoted fields", func() {
+			Expect(readAll("1,abc\n2,\"d,e\"\n")).To(Equal([][]sql.NullString{
+				{field("1"), field("abc")},
+				{field("2"), field("d,e")},
+			}))
+		})
+		It("distinguishes NULLs from empty strings", func() {
+			Expect(readAll("1,,\"\"\n,\n")).To(Equal([][]sql.NullString{
+				{field("1"), nullField, field("")},
+				{nullField, nullField},
+			}))
+		})
+		It("reads escaped quotes and newlines in quoted fields", func() {
+			Expect(readAll("\"say \"\"hi\"\"\",\"two\nlines\"\n")).To(Equal([][]sql.NullString{
+				{field(`say "hi"`), field("two\nlines")},
+			}))
+		})
+		It("reads a last row without a newline", func() {
+			Expect(readAll("1,2\n3,4")).To(Equal([][]sql.NullString{
+				{field("1"), field("2")},
+				{field("3"), field("4")},
+			}))
+		})
+		It("returns an error for an unterminated quoted field", func() {
+			_, err := manager.NewCopyCSVReader(strings.NewReader("1,\"abc\n")).Read()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Describe("NewRowWriter", func() {
+		columns := []string{"id", "name"}
+		rows := [][]sql.NullString{{field("1"), field("a,b")}, {field("2"), nullField}, {field("3"), field("")}}
+		writeRows := func(format string) string {
+			buffer := &bytes.Buffer{}
+			writer, err := manager.NewRowWriter(format, buffer, columns)
+			Expect(err).ToNot(HaveOccurred())
+			for _, row := range rows {
+				Expect(writer.WriteRow(row)).To(Succeed())
+			}
+			Expect(writer.Close()).To(Succeed())
+			return buffer.String()
+		}
+		It("writes CSV with a header, quoting empty strings but not NULLs", func() {
+			Expect(writeRows(manager.DataFormatCSV)).To(Equal("id,name\n1,\"a,b\"\n2,\n3,\"\"\n"))
+		})
+		It("writes one JSON object per row with keys in column order", func() {
+			Expect(writeRows(manager.DataFormatJSONL)).To(Equal(`{"id":"1","name":"a,b"}
+{"id":"2","name":null}
+{"id":"3","name":""}
+`))
+		})
+		It("writes CSV that reads back as the same rows", func() {
+			reader := manager.NewCopyCSVReader(strings.NewReader(writeRows(manager.DataFormatCSV)))
+			header, err := reader.Read()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(header).To(Equal([]sql.NullString{field("id"), field("name")}))
+			for _, row := range rows {
+				Expect(reader.Read()).To(Equal(row))
+			}
+		})
+		It("returns an error for an unrecognized format", func() {
+			_, err := manager.NewRowWriter("xml", &bytes.Buffer{}, columns)
+			Expect(err).To(MatchError("Unrecognized data format xml.  Valid formats are csv, jsonl, parquet."))
+		})
+	})
+})
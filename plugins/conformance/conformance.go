@@ -0,0 +1,270 @@
+package conformance
+
+/*
+ * This file contains a conformance suite for plugin executables, which runs
+ * every command of the plugin API against a plugin and reports which of its
+ * checks passed, so that plugin authors can verify a plugin without running
+ * gpbackup and gprestore against a live cluster.
+ */
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var version string
+
+const DefaultDataSize = 64 * 1024 * 1024
+
+type Suite struct {
+	Plugin              string
+	ConfigPath          string
+	SecondaryConfigPath string
+	// Local backup directories are created under TestDir, as under a segment's data directory
+	TestDir     string
+	DataSize    int64
+	Concurrency int
+	Out         io.Writer
+
+	apiVersion   string
+	capabilities []string
+	backups      []*testBackup
+}
+
+type TestResult struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	Message  string  `json:"message,omitempty"`
+	Duration float64 `json:"duration_seconds"`
+}
+
+const (
+	StatusPassed  = "passed"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped"
+)
+
+type Report struct {
+	Plugin       string       `json:"plugin"`
+	APIVersion   string       `json:"api_version"`
+	Capabilities []string     `json:"capabilities"`
+	NumPassed    int          `json:"passed"`
+	NumFailed    int          `json:"failed"`
+	NumSkipped   int          `json:"skipped"`
+	Results      []TestResult `json:"results"`
+}
+
+// A check returns a skipError if it does not apply to the plugin
+type skipError struct {
+	reason string
+}
+
+func (err skipError) Error() string {
+	return err.reason
+}
+
+func skip(format string, args ...interface{}) error {
+	return skipError{reason: fmt.Sprintf(format, args...)}
+}
+
+func (suite *Suite) Run() *Report {
+	report := &Report{Plugin: suite.Plugin, Results: make([]TestResult, 0)}
+	for _, test := range conformanceTests {
+		start := time.Now()
+		err := test.run(suite)
+		result := TestResult{Name: test.name, Status: StatusPassed, Duration: time.Since(start).Seconds()}
+		if _, ok := err.(skipError); ok {
+			result.Status = StatusSkipped
+			result.Message = err.Error()
+			report.NumSkipped++
+			fmt.Fprintf(suite.Out, "[SKIPPED] %s: %s\n", test.name, err.Error())
+		} else if err != nil {
+			result.Status = StatusFailed
+			result.Message = err.Error()
+			report.NumFailed++
+			fmt.Fprintf(suite.Out, "[FAILED] %s: %s\n", test.name, err.Error())
+		} else {
+			report.NumPassed++
+			fmt.Fprintf(suite.Out, "[PASSED] %s\n", test.name)
+		}
+		report.Results = append(report.Results, result)
+	}
+	suite.cleanup()
+	report.APIVersion = suite.apiVersion
+	report.Capabilities = suite.capabilities
+	return report
+}
+
+func (suite *Suite) hasCapability(capability string) bool {
+	for _, pluginCapability := range suite.capabilities {
+		if pluginCapability == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Deletes the backups created by the suite, both locally and from the plugin destination
+func (suite *Suite) cleanup() {
+	for _, backup := range suite.backups {
+		if !backup.deleted {
+			_, _ = suite.runCommand("delete_backup", suite.ConfigPath, backup.timestamp)
+		}
+		for _, scope := range []string{"master", "segment_host", "segment"} {
+			_, _ = suite.runCommand("cleanup_plugin_for_backup", suite.ConfigPath, backup.dir, scope, scopeContentID(scope))
+		}
+	}
+	_ = os.RemoveAll(suite.TestDir)
+}
+
+/*
+ * The plugin is invoked through bash, as gpbackup and gprestore invoke it,
+ * so the executable path may include arguments.
+ */
+func (suite *Suite) invoke(stdin io.Reader, stdout io.Writer, command string, args ...string) (string, error) {
+	cmd := exec.Command("bash", "-c", strings.Join(append([]string{suite.Plugin, command}, args...), " "))
+	stderr := &bytes.Buffer{}
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	return stderr.String(), err
+}
+
+// Runs a command that is expected to succeed, returning its stdout
+func (suite *Suite) runCommand(command string, args ...string) (string, error) {
+	stdout := &bytes.Buffer{}
+	stderr, err := suite.invoke(nil, stdout, command, args...)
+	if err != nil {
+		return "", errors.Errorf("%s failed with %s: %s", command, err.Error(), strings.TrimSpace(stderr))
+	}
+	return stdout.String(), nil
+}
+
+/*
+ * Runs a command that is expected to fail because the file or backup it
+ * refers to does not exist.  The plugin must exit with a non-zero code and
+ * explain the failure on stderr, with a structured error if it has the
+ * json_errors capability.
+ */
+func (suite *Suite) expectNotFound(command string, args ...string) error {
+	stderr, err := suite.invoke(nil, ioutil.Discard, command, args...)
+	if err == nil {
+		return errors.Errorf("%s exited with code 0 for a nonexistent file", command)
+	}
+	if _, ok := err.(*exec.ExitError); !ok {
+		return errors.Errorf("%s could not be run: %s", command, err.Error())
+	}
+	if strings.TrimSpace(stderr) == "" {
+		return errors.Errorf("%s failed without writing an error message to stderr", command)
+	}
+	if suite.hasCapability("json_errors") {
+		return checkJSONError(command, stderr, "not_found")
+	}
+	return nil
+}
+
+func scopeContentID(scope string) string {
+	switch scope {
+	case "master":
+		return `\"-1\"`
+	case "segment":
+		return `\"0\"`
+	}
+	return ""
+}
+
+/*
+ * Each backup has a unique timestamp, so that concurrent runs of the suite
+ * against the same destination do not interfere with each other.
+ */
+type testBackup struct {
+	timestamp string
+	dir       string
+	deleted   bool
+}
+
+func (suite *Suite) newBackup() (*testBackup, error) {
+	now := time.Now()
+	timestamp := fmt.Sprintf("%s%06d", now.Format("20060102"), rand.Intn(1000000))
+	backup := &testBackup{
+		timestamp: timestamp,
+		dir:       filepath.Join(suite.TestDir, "backups", timestamp[0:8], timestamp),
+	}
+	suite.backups = append(suite.backups, backup)
+	err := os.MkdirAll(backup.dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	for _, scope := range []string{"master", "segment_host", "segment"} {
+		_, err = suite.runCommand("setup_plugin_for_backup", suite.ConfigPath, backup.dir, scope, scopeContentID(scope))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return backup, nil
+}
+
+func (backup *testBackup) path(filename string) string {
+	return filepath.Join(backup.dir, fmt.Sprintf("gpbackup_%s_%s", backup.timestamp, filename))
+}
+
+func DoConformance() {
+	plugin := flag.String("plugin", "", "The plugin executable to test")
+	configPath := flag.String("plugin-config", "", "Absolute path to the plugin config file")
+	secondaryConfigPath := flag.String("secondary-plugin-config", "", "Absolute path to a plugin config file for a secondary destination, if the plugin replicates backups")
+	testDir := flag.String("test-dir", "/tmp/gpbackup_plugin_conformance", "The directory in which to create local backup files")
+	dataSize := flag.Int64("data-size", DefaultDataSize, "The number of bytes to stream in the large data test")
+	concurrency := flag.Int("concurrency", 4, "The number of plugin processes to run at once in the concurrency test")
+	reportFile := flag.String("report", "", "The file to which to write a JSON report of the results")
+	printVersion := flag.Bool("version", false, "Print version number and exit")
+	flag.Parse()
+	if *printVersion {
+		fmt.Printf("gpbackup_plugin_conformance version %s\n", version)
+		os.Exit(0)
+	}
+	if *plugin == "" || !filepath.IsAbs(*configPath) {
+		fmt.Fprintln(os.Stderr, "--plugin and an absolute path for --plugin-config must be specified")
+		os.Exit(2)
+	}
+	if *concurrency < 1 || *dataSize < 0 {
+		fmt.Fprintln(os.Stderr, "--concurrency must be at least 1 and --data-size must not be negative")
+		os.Exit(2)
+	}
+
+	rand.Seed(time.Now().UnixNano())
+	suite := &Suite{
+		Plugin:              *plugin,
+		ConfigPath:          *configPath,
+		SecondaryConfigPath: *secondaryConfigPath,
+		TestDir:             *testDir,
+		DataSize:            *dataSize,
+		Concurrency:         *concurrency,
+		Out:                 os.Stdout,
+	}
+	report := suite.Run()
+	fmt.Printf("%d passed, %d failed, %d skipped\n", report.NumPassed, report.NumFailed, report.NumSkipped)
+	if *reportFile != "" {
+		contents, _ := json.MarshalIndent(report, "", "  ")
+		err := ioutil.WriteFile(*reportFile, contents, 0644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write report: %s\n", err.Error())
+			os.Exit(2)
+		}
+	}
+	if report.NumFailed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,119 @@
+package conformance_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/greenplum-db/gpbackup/plugins/conformance"
+	"github.com/onsi/gomega/gbytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/*
+ * Stores files under the destination directory by timestamp, reporting
+ * errors for missing files as a plugin with the json_errors capability would
+ */
+const testPluginScript = `#!/bin/bash
+dest=%s
+file=$dest/$(basename $(dirname "$3"))/$(basename "$3")${4:+_part$4}
+not_found() { echo "{\"error\": \"$1 does not exist\", \"code\": \"not_found\"}" >&2; exit 1; }
+succeed() { exit 0; }
+case $1 in
+	plugin_api_version) echo "%s" ;;
+	plugin_capabilities) echo '["list_backups", "stat_file", "parallel_data", "json_errors", "range_data"]' ;;
+	--version) echo "test_plugin version 1.0.0" ;;
+	setup_plugin_for_backup|setup_plugin_for_restore|cleanup_plugin_for_backup|cleanup_plugin_for_restore) ;;
+	backup_file) mkdir -p $(dirname $file) && cp "$3" $file ;;
+	restore_file) [[ -f $file ]] || not_found "$3"; cp $file "$3" ;;
+	backup_data) mkdir -p $(dirname $file) && cat > $file ;;
+	restore_data) [[ -f $file ]] || %s "$3"; cat $file ;;
+	restore_data_range)
+		file=$dest/$(basename $(dirname "$3"))/$(basename "$3")
+		if [[ -f $file ]]; then tail -c +$(($4 + 1)) $file; else cat ${file}_part* | tail -c +$(($4 + 1)); fi ;;
+	stat_file)
+		if [[ -f $file ]]; then echo "{\"size\": $(cat $file | wc -c), \"parts\": 0}"
+		elif ls ${file}_part* > /dev/null 2>&1; then echo "{\"size\": $(cat ${file}_part* | wc -c), \"parts\": $(ls ${file}_part* | wc -l)}"
+		else not_found "$3"; fi ;;
+	list_backups) ls $dest ;;
+	delete_backup) rm -rf $dest/$3 ;;
+	*) echo "Unknown command $1" >&2; exit 1 ;;
+esac
+`
+
+var _ = Describe("plugins/conformance tests", func() {
+	var tempDir string
+	var suite *conformance.Suite
+	writePlugin := func(apiVersion string, restoreDataNotFound string) {
+		script := fmt.Sprintf(testPluginScript, filepath.Join(tempDir, "dest"), apiVersion, restoreDataNotFound)
+		Expect(ioutil.WriteFile(suite.Plugin, []byte(script), 0755)).To(Succeed())
+	}
+	resultsByName := func(report *conformance.Report) map[string]conformance.TestResult {
+		results := make(map[string]conformance.TestResult)
+		for _, result := range report.Results {
+			results[result.Name] = result
+		}
+		return results
+	}
+	BeforeEach(func() {
+		tempDir, _ = ioutil.TempDir("", "conformance")
+		suite = &conformance.Suite{
+			Plugin:      filepath.Join(tempDir, "test_plugin"),
+			ConfigPath:  filepath.Join(tempDir, "test_plugin_config.yaml"),
+			TestDir:     filepath.Join(tempDir, "local"),
+			DataSize:    1024 * 1024,
+			Concurrency: 4,
+			Out:         gbytes.NewBuffer(),
+		}
+	})
+	AfterEach(func() {
+		_ = os.RemoveAll(tempDir)
+	})
+	It("passes every check for a conforming plugin implementing version 2 of the API", func() {
+		writePlugin("2.0.0", "not_found")
+
+		report := suite.Run()
+
+		Expect(report.NumFailed).To(Equal(0), fmt.Sprintf("%v", report.Results))
+		Expect(report.NumSkipped).To(Equal(1))
+		Expect(resultsByName(report)["restore from secondary destination"].Status).To(Equal(conformance.StatusSkipped))
+		Expect(report.APIVersion).To(Equal("2.0.0"))
+		Expect(report.Capabilities).To(Equal([]string{"list_backups", "stat_file", "parallel_data", "json_errors", "range_data"}))
+		Expect(suite.Out).To(gbytes.Say(`\[PASSED\] plugin_api_version`))
+	})
+	It("skips the checks of optional commands for a plugin implementing version 1 of the API", func() {
+		writePlugin("0.4.0", "not_found")
+
+		report := suite.Run()
+
+		Expect(report.NumFailed).To(Equal(0), fmt.Sprintf("%v", report.Results))
+		results := resultsByName(report)
+		for _, name := range []string{"plugin_capabilities", "list_backups", "stat_file", "backup_data and restore_data in parts", "restore_data_range"} {
+			Expect(results[name].Status).To(Equal(conformance.StatusSkipped))
+		}
+		Expect(report.Capabilities).To(BeNil())
+	})
+	It("reports a failure for a plugin that does not report errors", func() {
+		writePlugin("0.4.0", "succeed")
+
+		report := suite.Run()
+
+		results := resultsByName(report)
+		Expect(report.NumFailed).To(Equal(2))
+		Expect(results["restore_data of a nonexistent file fails"].Status).To(Equal(conformance.StatusFailed))
+		Expect(results["restore_data of a nonexistent file fails"].Message).To(Equal("restore_data exited with code 0 for a nonexistent file"))
+		Expect(results["delete_backup deletes only the given backup"].Status).To(Equal(conformance.StatusFailed))
+		Expect(suite.Out).To(gbytes.Say(`\[FAILED\] restore_data of a nonexistent file fails`))
+	})
+	It("removes the backups it created", func() {
+		writePlugin("2.0.0", "not_found")
+
+		_ = suite.Run()
+
+		Expect(ioutil.ReadDir(filepath.Join(tempDir, "dest"))).To(BeEmpty())
+		Expect(suite.TestDir).ToNot(BeADirectory())
+	})
+})
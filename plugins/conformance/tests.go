@@ -0,0 +1,592 @@
+package conformance
+
+/*
+ * This file contains the checks run by the conformance suite, in the order
+ * in which they are run.
+ */
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/blang/semver"
+	"github.com/greenplum-db/gpbackup/storage"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+var conformanceTests = []struct {
+	name string
+	run  func(suite *Suite) error
+}{
+	{"plugin_api_version", testAPIVersion},
+	{"--version", testNativeVersion},
+	{"plugin_capabilities", testCapabilities},
+	{"setup and cleanup hooks", testHooks},
+	{"backup_file and restore_file", testFiles},
+	{"restore_file of a nonexistent file fails", testMissingFile},
+	{"backup_data and restore_data", testData},
+	{"backup_data and restore_data with no data", testEmptyData},
+	{"backup_data and restore_data with a large binary stream", testLargeData},
+	{"concurrent backup_data and restore_data", testConcurrentData},
+	{"restore_data of a nonexistent file fails", testMissingData},
+	{"delete_backup deletes only the given backup", testDeleteBackup},
+	{"delete_backup is idempotent", testDeleteBackupIdempotent},
+	{"list_backups", testListBackups},
+	{"stat_file", testStatFile},
+	{"backup_data and restore_data in parts", testDataInParts},
+	{"restore_data_range", testDataRange},
+	{"restore from secondary destination", testSecondaryDestination},
+	{"unknown command fails", testUnknownCommand},
+}
+
+func testAPIVersion(suite *Suite) error {
+	output, err := suite.runCommand("plugin_api_version")
+	if err != nil {
+		return err
+	}
+	apiVersion := strings.TrimSpace(output)
+	version, err := semver.Make(apiVersion)
+	if err != nil {
+		return errors.Errorf("Unable to parse plugin API version %s: %s", apiVersion, err.Error())
+	}
+	requiredVersion, _ := semver.Make(utils.RequiredPluginVersion)
+	if !version.GE(requiredVersion) {
+		return errors.Errorf("Plugin API version %s is less than the minimum supported version %s", apiVersion, utils.RequiredPluginVersion)
+	}
+	suite.apiVersion = apiVersion
+	return nil
+}
+
+func testNativeVersion(suite *Suite) error {
+	output, err := suite.runCommand("--version")
+	if err != nil {
+		return err
+	}
+	fields := strings.Split(strings.TrimSpace(output), " ")
+	if len(fields) != 3 || fields[1] != "version" {
+		return errors.Errorf(`Output "%s" is not in the format "<plugin name> version <version>"`, strings.TrimSpace(output))
+	}
+	return nil
+}
+
+func testCapabilities(suite *Suite) error {
+	version, err := semver.Make(suite.apiVersion)
+	if err != nil || version.LT(semver.MustParse(utils.PluginAPIVersion2)) {
+		return skip("Plugin API version %s does not support capabilities", suite.apiVersion)
+	}
+	output, err := suite.runCommand("plugin_capabilities")
+	if err != nil {
+		return err
+	}
+	capabilities := make([]string, 0)
+	err = json.Unmarshal([]byte(strings.TrimSpace(output)), &capabilities)
+	if err != nil {
+		return errors.Errorf("Unable to parse capabilities %s: %s", strings.TrimSpace(output), err.Error())
+	}
+	suite.capabilities = capabilities
+	for _, capability := range capabilities {
+		known := false
+		for _, knownCapability := range storage.BuiltinCapabilities {
+			known = known || capability == knownCapability
+		}
+		if !known {
+			return errors.Errorf("Unknown capability %s", capability)
+		}
+	}
+	if suite.hasCapability(storage.CapabilityParallelData) && !suite.hasCapability(storage.CapabilityStatFile) {
+		return errors.Errorf("The %s capability requires the %s capability", storage.CapabilityParallelData, storage.CapabilityStatFile)
+	}
+	return nil
+}
+
+func testHooks(suite *Suite) error {
+	backup, err := suite.newBackup()
+	if err != nil {
+		return err
+	}
+	for _, command := range []string{"setup_plugin_for_restore", "cleanup_plugin_for_restore", "cleanup_plugin_for_backup"} {
+		for _, scope := range []string{"master", "segment_host", "segment"} {
+			_, err = suite.runCommand(command, suite.ConfigPath, backup.dir, scope, scopeContentID(scope))
+			if err != nil {
+				return errors.Wrapf(err, "At scope %s", scope)
+			}
+		}
+	}
+	return nil
+}
+
+func (suite *Suite) backupFile(backup *testBackup, filename string, contents string) (string, error) {
+	filePath := backup.path(filename)
+	err := ioutil.WriteFile(filePath, []byte(contents), 0644)
+	if err != nil {
+		return "", err
+	}
+	_, err = suite.runCommand("backup_file", suite.ConfigPath, filePath)
+	if err != nil {
+		return "", err
+	}
+	if _, err = os.Stat(filePath); err != nil {
+		return "", errors.Errorf("backup_file did not leave the local copy of %s", filePath)
+	}
+	return filePath, nil
+}
+
+func (suite *Suite) checkRestoredFile(configPath string, filePath string, contents string) error {
+	_ = os.Remove(filePath)
+	_, err := suite.runCommand("restore_file", configPath, filePath)
+	if err != nil {
+		return err
+	}
+	restored, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return errors.Errorf("restore_file did not restore %s", filePath)
+	}
+	if string(restored) != contents {
+		return errors.Errorf("restore_file restored different contents than were backed up for %s", filePath)
+	}
+	return nil
+}
+
+func testFiles(suite *Suite) error {
+	backup, err := suite.newBackup()
+	if err != nil {
+		return err
+	}
+	filePath, err := suite.backupFile(backup, "config.yaml", "this is some text\n")
+	if err != nil {
+		return err
+	}
+	return suite.checkRestoredFile(suite.ConfigPath, filePath, "this is some text\n")
+}
+
+func testMissingFile(suite *Suite) error {
+	backup, err := suite.newBackup()
+	if err != nil {
+		return err
+	}
+	return suite.expectNotFound("restore_file", suite.ConfigPath, backup.path("nonexistent.yaml"))
+}
+
+type countingWriter struct {
+	hash.Hash
+	size int64
+}
+
+func (writer *countingWriter) Write(p []byte) (int, error) {
+	writer.size += int64(len(p))
+	return writer.Hash.Write(p)
+}
+
+// Identifies data generated by the suite by its size and checksum
+type dataChecksum struct {
+	size int64
+	sum  string
+}
+
+/*
+ * Streams size bytes of random binary data to backup_data, so that plugins
+ * that treat the data as text are detected.  Any extra arguments, such as a
+ * part number, are passed after the data file key.
+ */
+func (suite *Suite) backupData(filePath string, size int64, extraArgs ...string) (dataChecksum, error) {
+	writer := &countingWriter{Hash: sha256.New()}
+	data := io.TeeReader(io.LimitReader(rand.New(rand.NewSource(rand.Int63())), size), writer)
+	stderr, err := suite.invoke(data, ioutil.Discard, "backup_data", append([]string{suite.ConfigPath, filePath}, extraArgs...)...)
+	if err != nil {
+		return dataChecksum{}, errors.Errorf("backup_data failed with %s: %s", err.Error(), strings.TrimSpace(stderr))
+	}
+	if writer.size != size {
+		return dataChecksum{}, errors.Errorf("backup_data read %d bytes of %d", writer.size, size)
+	}
+	return dataChecksum{size: size, sum: hex.EncodeToString(writer.Sum(nil))}, nil
+}
+
+func (suite *Suite) checkRestoredData(configPath string, filePath string, expected dataChecksum, extraArgs ...string) error {
+	writer := &countingWriter{Hash: sha256.New()}
+	stderr, err := suite.invoke(nil, writer, "restore_data", append([]string{configPath, filePath}, extraArgs...)...)
+	if err != nil {
+		return errors.Errorf("restore_data failed with %s: %s", err.Error(), strings.TrimSpace(stderr))
+	}
+	if writer.size != expected.size {
+		return errors.Errorf("restore_data returned %d bytes for %s, expected %d", writer.size, filePath, expected.size)
+	}
+	if hex.EncodeToString(writer.Sum(nil)) != expected.sum {
+		return errors.Errorf("restore_data returned different data than was backed up for %s", filePath)
+	}
+	return nil
+}
+
+func (suite *Suite) testDataRoundTrip(size int64) error {
+	backup, err := suite.newBackup()
+	if err != nil {
+		return err
+	}
+	dataFile := backup.path("pipe_0")
+	checksum, err := suite.backupData(dataFile, size)
+	if err != nil {
+		return err
+	}
+	return suite.checkRestoredData(suite.ConfigPath, dataFile, checksum)
+}
+
+func testData(suite *Suite) error {
+	return suite.testDataRoundTrip(1000)
+}
+
+func testEmptyData(suite *Suite) error {
+	return suite.testDataRoundTrip(0)
+}
+
+func testLargeData(suite *Suite) error {
+	return suite.testDataRoundTrip(suite.DataSize)
+}
+
+/*
+ * gpbackup_helper runs one plugin process per segment, so a plugin must
+ * support several processes streaming different files on the same host.
+ */
+func testConcurrentData(suite *Suite) error {
+	backup, err := suite.newBackup()
+	if err != nil {
+		return err
+	}
+	size := suite.DataSize / int64(suite.Concurrency)
+	checksums := make([]dataChecksum, suite.Concurrency)
+	errs := make([]error, suite.Concurrency)
+	runConcurrently := func(run func(contentID int) error) error {
+		var wg sync.WaitGroup
+		for i := 0; i < suite.Concurrency; i++ {
+			wg.Add(1)
+			go func(contentID int) {
+				defer wg.Done()
+				errs[contentID] = run(contentID)
+			}(i)
+		}
+		wg.Wait()
+		for _, err := range errs {
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err = runConcurrently(func(contentID int) (err error) {
+		checksums[contentID], err = suite.backupData(backup.path(fmt.Sprintf("pipe_%d", contentID)), size)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return runConcurrently(func(contentID int) error {
+		return suite.checkRestoredData(suite.ConfigPath, backup.path(fmt.Sprintf("pipe_%d", contentID)), checksums[contentID])
+	})
+}
+
+func testMissingData(suite *Suite) error {
+	backup, err := suite.newBackup()
+	if err != nil {
+		return err
+	}
+	return suite.expectNotFound("restore_data", suite.ConfigPath, backup.path("nonexistent_pipe"))
+}
+
+func (suite *Suite) deleteBackup(backup *testBackup) error {
+	_, err := suite.runCommand("delete_backup", suite.ConfigPath, backup.timestamp)
+	if err != nil {
+		return err
+	}
+	backup.deleted = true
+	return nil
+}
+
+func testDeleteBackup(suite *Suite) error {
+	backups := make([]*testBackup, 2)
+	filePaths := make([]string, 2)
+	dataFiles := make([]string, 2)
+	checksums := make([]dataChecksum, 2)
+	for i := range backups {
+		var err error
+		backups[i], err = suite.newBackup()
+		if err != nil {
+			return err
+		}
+		filePaths[i], err = suite.backupFile(backups[i], "config.yaml", "this is some text\n")
+		if err != nil {
+			return err
+		}
+		dataFiles[i] = backups[i].path("pipe_0")
+		checksums[i], err = suite.backupData(dataFiles[i], 1000)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := suite.deleteBackup(backups[0])
+	if err != nil {
+		return err
+	}
+	err = suite.expectNotFound("restore_file", suite.ConfigPath, filePaths[0])
+	if err != nil {
+		return errors.Wrap(err, "After deleting the backup")
+	}
+	err = suite.expectNotFound("restore_data", suite.ConfigPath, dataFiles[0])
+	if err != nil {
+		return errors.Wrap(err, "After deleting the backup")
+	}
+	err = suite.checkRestoredFile(suite.ConfigPath, filePaths[1], "this is some text\n")
+	if err != nil {
+		return errors.Wrap(err, "After deleting another backup")
+	}
+	err = suite.checkRestoredData(suite.ConfigPath, dataFiles[1], checksums[1])
+	if err != nil {
+		return errors.Wrap(err, "After deleting another backup")
+	}
+	return nil
+}
+
+// An interrupted deletion may be retried, so deleting a deleted backup must succeed
+func testDeleteBackupIdempotent(suite *Suite) error {
+	backup, err := suite.newBackup()
+	if err != nil {
+		return err
+	}
+	_, err = suite.backupData(backup.path("pipe_0"), 1000)
+	if err != nil {
+		return err
+	}
+	err = suite.deleteBackup(backup)
+	if err != nil {
+		return err
+	}
+	err = suite.deleteBackup(backup)
+	if err != nil {
+		return errors.Wrap(err, "Deleting the backup a second time")
+	}
+	return nil
+}
+
+func (suite *Suite) listBackups() ([]string, error) {
+	output, err := suite.runCommand("list_backups", suite.ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(output), nil
+}
+
+func containsTimestamp(timestamps []string, timestamp string) bool {
+	for _, listed := range timestamps {
+		if listed == timestamp {
+			return true
+		}
+	}
+	return false
+}
+
+func testListBackups(suite *Suite) error {
+	if !suite.hasCapability(storage.CapabilityListBackups) {
+		return skip("Plugin does not have the %s capability", storage.CapabilityListBackups)
+	}
+	backup, err := suite.newBackup()
+	if err != nil {
+		return err
+	}
+	_, err = suite.backupData(backup.path("pipe_0"), 1000)
+	if err != nil {
+		return err
+	}
+	timestamps, err := suite.listBackups()
+	if err != nil {
+		return err
+	}
+	if !containsTimestamp(timestamps, backup.timestamp) {
+		return errors.Errorf("list_backups did not list backup %s", backup.timestamp)
+	}
+	err = suite.deleteBackup(backup)
+	if err != nil {
+		return err
+	}
+	timestamps, err = suite.listBackups()
+	if err != nil {
+		return err
+	}
+	if containsTimestamp(timestamps, backup.timestamp) {
+		return errors.Errorf("list_backups listed deleted backup %s", backup.timestamp)
+	}
+	return nil
+}
+
+func (suite *Suite) statFile(filePath string) (storage.FileStat, error) {
+	output, err := suite.runCommand("stat_file", suite.ConfigPath, filePath)
+	if err != nil {
+		return storage.FileStat{}, err
+	}
+	stat := storage.FileStat{}
+	err = json.Unmarshal([]byte(strings.TrimSpace(output)), &stat)
+	if err != nil {
+		return storage.FileStat{}, errors.Errorf("Unable to parse output %s of stat_file: %s", strings.TrimSpace(output), err.Error())
+	}
+	return stat, nil
+}
+
+func testStatFile(suite *Suite) error {
+	if !suite.hasCapability(storage.CapabilityStatFile) {
+		return skip("Plugin does not have the %s capability", storage.CapabilityStatFile)
+	}
+	backup, err := suite.newBackup()
+	if err != nil {
+		return err
+	}
+	dataFile := backup.path("pipe_0")
+	_, err = suite.backupData(dataFile, 1000)
+	if err != nil {
+		return err
+	}
+	stat, err := suite.statFile(dataFile)
+	if err != nil {
+		return err
+	}
+	if stat != (storage.FileStat{Size: 1000, Parts: 0}) {
+		return errors.Errorf("stat_file reported %d bytes in %d parts, expected 1000 bytes in 0 parts", stat.Size, stat.Parts)
+	}
+	return suite.expectNotFound("stat_file", suite.ConfigPath, backup.path("nonexistent_pipe"))
+}
+
+func testDataInParts(suite *Suite) error {
+	if !suite.hasCapability(storage.CapabilityParallelData) {
+		return skip("Plugin does not have the %s capability", storage.CapabilityParallelData)
+	}
+	backup, err := suite.newBackup()
+	if err != nil {
+		return err
+	}
+	dataFile := backup.path("pipe_0")
+	sizes := []int64{1000, 1000, 10}
+	checksums := make([]dataChecksum, len(sizes))
+	// Parts are backed up concurrently by gpbackup_helper, and may complete in any order
+	for i := len(sizes) - 1; i >= 0; i-- {
+		checksums[i], err = suite.backupData(dataFile, sizes[i], fmt.Sprintf("%d", i+1))
+		if err != nil {
+			return err
+		}
+	}
+	stat, err := suite.statFile(dataFile)
+	if err != nil {
+		return err
+	}
+	if stat != (storage.FileStat{Size: 2010, Parts: 3}) {
+		return errors.Errorf("stat_file reported %d bytes in %d parts, expected 2010 bytes in 3 parts", stat.Size, stat.Parts)
+	}
+	for i := range sizes {
+		err = suite.checkRestoredData(suite.ConfigPath, dataFile, checksums[i], fmt.Sprintf("%d", i+1))
+		if err != nil {
+			return errors.Wrapf(err, "Part %d", i+1)
+		}
+	}
+	return nil
+}
+
+func (suite *Suite) backupDataString(filePath string, contents string, extraArgs ...string) error {
+	stderr, err := suite.invoke(strings.NewReader(contents), ioutil.Discard, "backup_data", append([]string{suite.ConfigPath, filePath}, extraArgs...)...)
+	if err != nil {
+		return errors.Errorf("backup_data failed with %s: %s", err.Error(), strings.TrimSpace(stderr))
+	}
+	return nil
+}
+
+func (suite *Suite) checkRestoredRange(filePath string, offset int, expected string) error {
+	output, err := suite.runCommand("restore_data_range", suite.ConfigPath, filePath, strconv.Itoa(offset))
+	if err != nil {
+		return err
+	}
+	if output != expected {
+		return errors.Errorf("restore_data_range returned %q from offset %d of %s, expected %q", output, offset, filePath, expected)
+	}
+	return nil
+}
+
+// A plugin that stores data in parts must find the part containing the offset
+func testDataRange(suite *Suite) error {
+	if !suite.hasCapability(storage.CapabilityRangeData) {
+		return skip("Plugin does not have the %s capability", storage.CapabilityRangeData)
+	}
+	backup, err := suite.newBackup()
+	if err != nil {
+		return err
+	}
+	dataFile := backup.path("pipe_0")
+	err = suite.backupDataString(dataFile, "0123456789")
+	if err != nil {
+		return err
+	}
+	for offset, expected := range map[int]string{0: "0123456789", 4: "456789", 10: ""} {
+		err = suite.checkRestoredRange(dataFile, offset, expected)
+		if err != nil {
+			return err
+		}
+	}
+	if !suite.hasCapability(storage.CapabilityParallelData) {
+		return nil
+	}
+	partsFile := backup.path("pipe_1")
+	for i, contents := range []string{"01234", "56789"} {
+		err = suite.backupDataString(partsFile, contents, fmt.Sprintf("%d", i+1))
+		if err != nil {
+			return err
+		}
+	}
+	return suite.checkRestoredRange(partsFile, 7, "789")
+}
+
+func testSecondaryDestination(suite *Suite) error {
+	if suite.SecondaryConfigPath == "" {
+		return skip("No secondary plugin config was specified")
+	}
+	backup, err := suite.newBackup()
+	if err != nil {
+		return err
+	}
+	filePath, err := suite.backupFile(backup, "config.yaml", "this is some text\n")
+	if err != nil {
+		return err
+	}
+	dataFile := backup.path("pipe_0")
+	checksum, err := suite.backupData(dataFile, 1000)
+	if err != nil {
+		return err
+	}
+	err = suite.checkRestoredFile(suite.SecondaryConfigPath, filePath, "this is some text\n")
+	if err != nil {
+		return err
+	}
+	return suite.checkRestoredData(suite.SecondaryConfigPath, dataFile, checksum)
+}
+
+func testUnknownCommand(suite *Suite) error {
+	_, err := suite.invoke(nil, ioutil.Discard, "unknown_command", suite.ConfigPath)
+	if err == nil {
+		return errors.New("Plugin exited with code 0 for an unknown command")
+	}
+	return nil
+}
+
+func checkJSONError(command string, stderr string, code string) error {
+	pluginErr, ok := storage.ParsePluginError(nil, stderr).(*storage.PluginError)
+	if !ok {
+		return errors.Errorf("%s did not write a JSON error to stderr: %s", command, strings.TrimSpace(stderr))
+	}
+	if pluginErr.Code != code {
+		return errors.Errorf(`%s reported error code "%s", expected "%s"`, command, pluginErr.Code, code)
+	}
+	return nil
+}
@@ -6,6 +6,8 @@ package restore
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 
@@ -61,16 +63,34 @@ func restoreSingleTableData(fpInfo *backup_filepath.FilePathInfo, entry utils.Ma
 	} else {
 		gplog.Verbose("Reading data for table %s from file", name)
 	}
+	if isPostgresTarget() {
+		return restoreSingleTableDataToPostgres(fpInfo, entry, name, whichConn)
+	}
 	destinationToRead := ""
+	isReplicatedOnRestore := isReplicatedAfterRestore(entry)
 	if backupConfig.SingleDataFile {
 		destinationToRead = fmt.Sprintf("%s_%d", fpInfo.GetSegmentPipePathForCopyCommand(), entry.Oid)
 	} else {
 		destinationToRead = fpInfo.GetTableBackupFilePathForCopyCommand(entry.Oid, utils.GetPipeThroughProgram().Extension, backupConfig.SingleDataFile)
+		if entry.IsReplicated && !isReplicatedOnRestore {
+			// Every segment of the backup cluster holds a full copy of a replicated table, so only one copy is loaded
+			destinationToRead = GetFirstSegmentFilePathForCopyCommand(destinationToRead)
+		} else if isResizeRestore() {
+			if entry.IsReplicated {
+				destinationToRead = strings.Replace(destinationToRead, "<SEGID>", "0", -1)
+			} else {
+				destinationToRead = GetResizeFilePathsForCopyCommand(destinationToRead, backupConfig.SegmentCount, getDestinationSegmentCount())
+			}
+		}
 	}
 	numRowsRestored, err := CopyTableIn(connectionPool, name, entry.AttributeString, destinationToRead, backupConfig.SingleDataFile, whichConn)
 	if err != nil {
 		return err
 	}
+	if entry.IsReplicated && (isResizeRestore() || !isReplicatedOnRestore) {
+		// The backed up row count is multiplied by the number of segments in the backup cluster
+		return nil
+	}
 	numRowsBackedUp := entry.RowsCopied
 	err = CheckRowsRestored(numRowsRestored, numRowsBackedUp, name)
 	if err != nil {
@@ -79,6 +99,80 @@ func restoreSingleTableData(fpInfo *backup_filepath.FilePathInfo, entry utils.Ma
 	return nil
 }
 
+/*
+ * When restoring to a cluster with fewer segments than the backup cluster,
+ * each destination segment reads the files of every original segment whose
+ * content ID is congruent to its own modulo the destination segment count;
+ * e.g. restoring a 16-segment backup to 4 segments, segment 1 reads the files
+ * of original segments 1, 5, 9, and 13.  The data is redistributed once all
+ * tables have been loaded.
+ *
+ * /dev/null is always passed to cat so that a destination segment with no
+ * corresponding original segments reads nothing instead of waiting on stdin.
+ */
+func GetResizeFilePathsForCopyCommand(templateFilePath string, origSegCount int, destSegCount int) string {
+	origFilePath := strings.Replace(templateFilePath, "<SEGID>", "${ORIG_SEGID}", -1)
+	return fmt.Sprintf("/dev/null $(for ORIG_SEGID in $(seq <SEGID> %d %d); do echo %s; done)", destSegCount, origSegCount-1, origFilePath)
+}
+
+/*
+ * Segment 0 loads the file it backed up of a replicated table, and every
+ * other segment loads nothing, as with GetResizeFilePathsForCopyCommand.
+ */
+func GetFirstSegmentFilePathForCopyCommand(templateFilePath string) string {
+	firstSegmentFilePath := strings.Replace(templateFilePath, "<SEGID>", "0", -1)
+	return fmt.Sprintf("/dev/null $(if [ <SEGID> -eq 0 ]; then echo %s; fi)", firstSegmentFilePath)
+}
+
+func getDestinationSegmentCount() int {
+	// The cluster map includes the master, which does not hold any table data
+	return len(globalCluster.Segments) - 1
+}
+
+func isResizeRestore() bool {
+	return MustGetFlagBool(utils.RESIZE_CLUSTER) && backupConfig.SegmentCount != getDestinationSegmentCount()
+}
+
+/*
+ * Rows restored with --resize-cluster are left on whichever segment read
+ * them, so each restored table is rewritten to move its rows to the segments
+ * dictated by its distribution policy.  Partitioned tables are reorganized
+ * through their root partition.
+ */
+func GetRedistributeStatements(connectionPool *dbconn.DBConn, dataEntries []utils.MasterDataEntry) []utils.StatementWithType {
+	query := `
+SELECT
+	quote_ident(partitionschemaname) || '.' || quote_ident(partitiontablename) AS leaf,
+	quote_ident(schemaname) || '.' || quote_ident(tablename) AS root
+FROM pg_partitions`
+	results := make([]struct {
+		Leaf string
+		Root string
+	}, 0)
+	err := connectionPool.Select(&results, query)
+	gplog.FatalOnError(err)
+	rootPartitions := make(map[string]string, len(results))
+	for _, result := range results {
+		rootPartitions[result.Leaf] = result.Root
+	}
+
+	tablesReorganized := make(map[string]bool, len(dataEntries))
+	statements := make([]utils.StatementWithType, 0)
+	for _, entry := range dataEntries {
+		table := utils.MakeFQN(entry.Schema, entry.Name)
+		if root, ok := rootPartitions[table]; ok {
+			table = root
+		}
+		if tablesReorganized[table] || isReplicatedAfterRestore(entry) {
+			continue
+		}
+		tablesReorganized[table] = true
+		statements = append(statements, utils.StatementWithType{Name: table, ObjectType: "TABLE",
+			Statement: fmt.Sprintf("ALTER TABLE %s SET WITH (REORGANIZE=true);", table)})
+	}
+	return statements
+}
+
 func CheckRowsRestored(rowsRestored int64, rowsBackedUp int64, tableName string) error {
 	if rowsRestored != rowsBackedUp {
 		rowsErrMsg := fmt.Sprintf("Expected to restore %d rows to table %s, but restored %d instead", rowsBackedUp, tableName, rowsRestored)
@@ -87,6 +181,46 @@ func CheckRowsRestored(rowsRestored int64, rowsBackedUp int64, tableName string)
 	return nil
 }
 
+/*
+ * Each agent restoring a single data file reads the tables in its oid list in
+ * order of oid, which is the order in which they were written to the file.
+ * With more than one job, the tables are split into runs of consecutive oids
+ * with roughly the same number of rows, so that each agent seeks to the start
+ * of its run and reads only that part of the file.
+ */
+func PartitionDataEntries(dataEntries []utils.MasterDataEntry, numPartitions int) [][]utils.MasterDataEntry {
+	sortedEntries := make([]utils.MasterDataEntry, len(dataEntries))
+	copy(sortedEntries, dataEntries)
+	sort.SliceStable(sortedEntries, func(i, j int) bool {
+		return sortedEntries[i].Oid < sortedEntries[j].Oid
+	})
+	if numPartitions > len(sortedEntries) {
+		numPartitions = len(sortedEntries)
+	}
+
+	// Empty tables are counted as one row, as restoring them is not free either
+	remainingWeight := int64(0)
+	for _, entry := range sortedEntries {
+		remainingWeight += entry.RowsCopied + 1
+	}
+	partitions := make([][]utils.MasterDataEntry, 0, numPartitions)
+	start := 0
+	for numRemaining := numPartitions; numRemaining > 0; numRemaining-- {
+		target := remainingWeight / int64(numRemaining)
+		end := start
+		weight := int64(0)
+		// Every remaining partition gets at least one table
+		for end < len(sortedEntries)-(numRemaining-1) && (end == start || weight+sortedEntries[end].RowsCopied+1 <= target) {
+			weight += sortedEntries[end].RowsCopied + 1
+			end++
+		}
+		partitions = append(partitions, sortedEntries[start:end])
+		remainingWeight -= weight
+		start = end
+	}
+	return partitions
+}
+
 func restoreDataFromTimestamp(fpInfo backup_filepath.FilePathInfo, dataEntries []utils.MasterDataEntry,
 	gucStatements []utils.StatementWithType, dataProgressBar utils.ProgressBar) {
 	if len(dataEntries) == 0 {
@@ -94,20 +228,62 @@ func restoreDataFromTimestamp(fpInfo backup_filepath.FilePathInfo, dataEntries [
 		return
 	}
 
+	/*
+	 * Without a single data file, every connection takes the next table from
+	 * a shared queue.  With one, each connection restores the tables of its
+	 * own agent, as an agent can only provide its tables in order.
+	 */
+	taskQueues := make([]chan utils.MasterDataEntry, connectionPool.NumConns)
 	if backupConfig.SingleDataFile {
+		for _, entry := range dataEntries {
+			if !entry.IsReplicated {
+				continue
+			}
+			if isResizeRestore() {
+				gplog.Fatal(errors.Errorf("Cannot restore replicated table %s from a single data file backup with --resize-cluster", utils.MakeFQN(entry.Schema, entry.Name)), "")
+			} else if !isReplicatedAfterRestore(entry) {
+				gplog.Fatal(errors.Errorf("Cannot restore replicated table %s from a single data file backup with a different distribution policy", utils.MakeFQN(entry.Schema, entry.Name)), "")
+			}
+		}
 		gplog.Verbose("Initializing pipes and gpbackup_helper on segments for single data file restore")
 		utils.VerifyHelperVersionOnSegments(version, globalCluster)
-		filteredOids := make([]string, len(dataEntries))
-		for i, entry := range dataEntries {
-			filteredOids[i] = fmt.Sprintf("%d", entry.Oid)
+		partitions := PartitionDataEntries(dataEntries, connectionPool.NumConns)
+		for i, partition := range partitions {
+			helperFPInfo := fpInfo.ForHelper(i)
+			filteredOids := make([]string, len(partition))
+			for j, entry := range partition {
+				filteredOids[j] = fmt.Sprintf("%d", entry.Oid)
+			}
+			utils.WriteOidListToSegments(filteredOids, globalCluster, helperFPInfo)
+			utils.CreateFirstSegmentPipeOnAllHosts(filteredOids[0], globalCluster, helperFPInfo)
 		}
-		utils.WriteOidListToSegments(filteredOids, globalCluster, fpInfo)
-		firstOid := fmt.Sprintf("%d", dataEntries[0].Oid)
-		utils.CreateFirstSegmentPipeOnAllHosts(firstOid, globalCluster, fpInfo)
 		if wasTerminated {
 			return
 		}
-		utils.StartGpbackupHelpers(globalCluster, fpInfo, "--restore-agent", MustGetFlagString(utils.PLUGIN_CONFIG), "", MustGetFlagBool(utils.ON_ERROR_CONTINUE))
+		compressStr := fmt.Sprintf(" --decompression-blocks %d", MustGetFlagInt(utils.DECOMPRESSION_BLOCKS))
+		for i := range partitions {
+			utils.StartGpbackupHelpers(globalCluster, fpInfo.ForHelper(i), "--restore-agent", MustGetFlagString(utils.PLUGIN_CONFIG), compressStr, MustGetFlagBool(utils.ON_ERROR_CONTINUE),
+				isResizeRestore(), backupConfig.SegmentCount, getDestinationSegmentCount())
+		}
+		helperAgents = utils.ConnectToHelperAgents(globalCluster, fpInfo, len(partitions))
+		for i := range taskQueues {
+			taskQueues[i] = make(chan utils.MasterDataEntry, len(dataEntries))
+			if i < len(partitions) {
+				for _, entry := range partitions[i] {
+					taskQueues[i] <- entry
+				}
+			}
+			close(taskQueues[i])
+		}
+	} else {
+		tasks := make(chan utils.MasterDataEntry, len(dataEntries))
+		for _, entry := range dataEntries {
+			tasks <- entry
+		}
+		close(tasks)
+		for i := range taskQueues {
+			taskQueues[i] = tasks
+		}
 	}
 	/*
 	 * We break when an interrupt is received and rely on
@@ -115,7 +291,6 @@ func restoreDataFromTimestamp(fpInfo backup_filepath.FilePathInfo, dataEntries [
 	 * statements in progress if they don't finish on their own.
 	 */
 	var tableNum uint32 = 1
-	tasks := make(chan utils.MasterDataEntry, len(dataEntries))
 	var workerPool sync.WaitGroup
 	var numErrors int32
 
@@ -124,12 +299,13 @@ func restoreDataFromTimestamp(fpInfo backup_filepath.FilePathInfo, dataEntries [
 		go func(whichConn int) {
 			defer workerPool.Done()
 			setGUCsForConnection(gucStatements, whichConn)
-			for entry := range tasks {
+			helperFPInfo := fpInfo.ForHelper(whichConn)
+			for entry := range taskQueues[whichConn] {
 				if wasTerminated {
 					dataProgressBar.(*pb.ProgressBar).NotPrint = true
 					return
 				}
-				err := restoreSingleTableData(&fpInfo, entry, tableNum, len(dataEntries), whichConn)
+				err := restoreSingleTableData(&helperFPInfo, entry, tableNum, len(dataEntries), whichConn)
 				if err != nil {
 					gplog.Error(err.Error())
 					atomic.AddInt32(&numErrors, 1)
@@ -140,7 +316,7 @@ func restoreDataFromTimestamp(fpInfo backup_filepath.FilePathInfo, dataEntries [
 				}
 
 				if backupConfig.SingleDataFile {
-					agentErr := utils.CheckAgentErrorsOnSegments(globalCluster, globalFPInfo)
+					agentErr := helperAgents.CheckErrors()
 					if agentErr != nil {
 						gplog.Error(agentErr.Error())
 						return
@@ -152,11 +328,11 @@ func restoreDataFromTimestamp(fpInfo backup_filepath.FilePathInfo, dataEntries [
 			}
 		}(i)
 	}
-	for _, entry := range dataEntries {
-		tasks <- entry
-	}
-	close(tasks)
 	workerPool.Wait()
+	if helperAgents != nil && !wasTerminated {
+		helperAgents.Stop()
+		helperAgents = nil
+	}
 
 	if numErrors > 0 {
 		fmt.Println("")
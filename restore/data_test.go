@@ -1,6 +1,7 @@
 package restore_test
 
 import (
+	"fmt"
 	"regexp"
 
 	"github.com/greenplum-db/gpbackup/backup"
@@ -87,6 +88,89 @@ var _ = Describe("restore/data tests", func() {
 				"ERROR: value of distribution key doesn't belong to segment with ID 0, it belongs to segment with ID 1 (SQLSTATE 22P04)"))
 		})
 	})
+	Describe("GetResizeFilePathsForCopyCommand", func() {
+		It("reads the files of every original segment assigned to the destination segment", func() {
+			filename := "/backups/gpseg<SEGID>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz"
+
+			filePaths := restore.GetResizeFilePathsForCopyCommand(filename, 16, 4)
+
+			Expect(filePaths).To(Equal("/dev/null $(for ORIG_SEGID in $(seq <SEGID> 4 15); do echo /backups/gpseg${ORIG_SEGID}/backups/20170101/20170101010101/gpbackup_${ORIG_SEGID}_20170101010101_3456.gz; done)"))
+		})
+	})
+	Describe("GetFirstSegmentFilePathForCopyCommand", func() {
+		It("reads the file of the first original segment on the first destination segment only", func() {
+			filename := "/backups/gpseg<SEGID>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz"
+
+			filePaths := restore.GetFirstSegmentFilePathForCopyCommand(filename)
+
+			Expect(filePaths).To(Equal("/dev/null $(if [ <SEGID> -eq 0 ]; then echo /backups/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_3456.gz; fi)"))
+		})
+	})
+	Describe("GetRedistributeStatements", func() {
+		entries := []utils.MasterDataEntry{
+			{Schema: "public", Name: "sales", Oid: 1},
+			{Schema: "public", Name: "codes", Oid: 2, IsReplicated: true},
+		}
+		BeforeEach(func() {
+			mock.ExpectQuery("SELECT (.*) FROM pg_partitions").WillReturnRows(sqlmock.NewRows([]string{"leaf", "root"}))
+		})
+		It("does not reorganize a table that was replicated in the backup and is restored as replicated", func() {
+			statements := restore.GetRedistributeStatements(connectionPool, entries)
+
+			Expect(statements).To(Equal([]utils.StatementWithType{
+				{Name: "public.sales", ObjectType: "TABLE", Statement: "ALTER TABLE public.sales SET WITH (REORGANIZE=true);"},
+			}))
+		})
+		It("reorganizes a table that was replicated in the backup and is restored with another distribution policy", func() {
+			_ = cmdFlags.Set(utils.DISTRIBUTED_RANDOMLY, "true")
+
+			statements := restore.GetRedistributeStatements(connectionPool, entries)
+
+			Expect(statements).To(Equal([]utils.StatementWithType{
+				{Name: "public.sales", ObjectType: "TABLE", Statement: "ALTER TABLE public.sales SET WITH (REORGANIZE=true);"},
+				{Name: "public.codes", ObjectType: "TABLE", Statement: "ALTER TABLE public.codes SET WITH (REORGANIZE=true);"},
+			}))
+		})
+	})
+	Describe("PartitionDataEntries", func() {
+		entry := func(oid uint32, rows int64) utils.MasterDataEntry {
+			return utils.MasterDataEntry{Schema: "public", Name: fmt.Sprintf("t%d", oid), Oid: oid, RowsCopied: rows}
+		}
+		getOids := func(partitions [][]utils.MasterDataEntry) [][]uint32 {
+			oids := make([][]uint32, len(partitions))
+			for i, partition := range partitions {
+				oids[i] = make([]uint32, 0)
+				for _, entry := range partition {
+					oids[i] = append(oids[i], entry.Oid)
+				}
+			}
+			return oids
+		}
+		It("returns all tables in order of oid in one partition", func() {
+			partitions := restore.PartitionDataEntries([]utils.MasterDataEntry{entry(3, 10), entry(1, 10), entry(2, 10)}, 1)
+
+			Expect(getOids(partitions)).To(Equal([][]uint32{{1, 2, 3}}))
+		})
+		It("splits tables into runs of consecutive oids with similar numbers of rows", func() {
+			entries := []utils.MasterDataEntry{entry(1, 99), entry(2, 99), entry(3, 199), entry(4, 49), entry(5, 49), entry(6, 99)}
+
+			partitions := restore.PartitionDataEntries(entries, 3)
+
+			Expect(getOids(partitions)).To(Equal([][]uint32{{1, 2}, {3}, {4, 5, 6}}))
+		})
+		It("gives every partition at least one table", func() {
+			entries := []utils.MasterDataEntry{entry(1, 1000000), entry(2, 0), entry(3, 0)}
+
+			partitions := restore.PartitionDataEntries(entries, 3)
+
+			Expect(getOids(partitions)).To(Equal([][]uint32{{1}, {2}, {3}}))
+		})
+		It("returns one partition per table when there are fewer tables than partitions", func() {
+			partitions := restore.PartitionDataEntries([]utils.MasterDataEntry{entry(1, 10), entry(2, 10)}, 4)
+
+			Expect(getOids(partitions)).To(Equal([][]uint32{{1}, {2}}))
+		})
+	})
 	Describe("CheckRowsRestored", func() {
 		var (
 			expectedRows int64 = 10
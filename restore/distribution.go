@@ -0,0 +1,168 @@
+package restore
+
+/*
+ * This file contains functions related to overriding the distribution
+ * policies of restored tables.
+ */
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/iohelper"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+var distributionPolicyMapRegex = regexp.MustCompile(`(?i)^(.+?)\s+(DISTRIBUTED\s+(BY\s*\(.*\)|RANDOMLY|REPLICATED))$`)
+
+/*
+ * Each line of a distribution policy map contains a fully-qualified table
+ * name followed by the distribution clause to use for that table, e.g.
+ *   public.sales DISTRIBUTED BY (region)
+ * Blank lines and lines beginning with # are ignored.
+ */
+func ParseDistributionPolicyMap(lines []string) (map[string]string, error) {
+	policies := make(map[string]string, len(lines))
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		match := distributionPolicyMapRegex.FindStringSubmatch(line)
+		if match == nil {
+			return nil, errors.Errorf("Invalid distribution policy map entry on line %d: %s", i+1, line)
+		}
+		fqn := strings.TrimSpace(match[1])
+		if _, ok := policies[fqn]; ok {
+			return nil, errors.Errorf("Table %s is listed more than once in the distribution policy map", fqn)
+		}
+		policies[fqn] = match[2]
+	}
+	return policies, nil
+}
+
+func InitializeDistributionPolicies() {
+	distributionPolicies = make(map[string]string)
+	if MustGetFlagString(utils.DISTRIBUTION_POLICY_MAP) == "" {
+		return
+	}
+	lines := iohelper.MustReadLinesFromFile(MustGetFlagString(utils.DISTRIBUTION_POLICY_MAP))
+	policies, err := ParseDistributionPolicyMap(lines)
+	gplog.FatalOnError(err)
+	tables := make([]string, 0, len(policies))
+	for fqn := range policies {
+		tables = append(tables, fqn)
+	}
+	utils.ValidateFQNs(tables)
+	if missingTables := getFilterRelationsInBackupSet(tables); len(missingTables) != 0 {
+		gplog.Warn("Could not find the following relation(s) from the distribution policy map in the backup set: %s", strings.Join(missingTables, ", "))
+	}
+	distributionPolicies = policies
+}
+
+func getDistributionPolicyOverride(fqn string) string {
+	if policy, ok := distributionPolicies[fqn]; ok {
+		return policy
+	}
+	if MustGetFlagBool(utils.DISTRIBUTED_RANDOMLY) {
+		return "DISTRIBUTED RANDOMLY"
+	}
+	return ""
+}
+
+func isReplicatedPolicy(policy string) bool {
+	return strings.HasSuffix(strings.ToUpper(policy), "REPLICATED")
+}
+
+/*
+ * Backups taken before the TOC recorded whether a table is replicated have
+ * IsReplicated unset for every table, so the distribution policy in the
+ * backed-up CREATE TABLE statement of each table is checked as well.
+ */
+func MarkReplicatedTables(dataEntries [][]utils.MasterDataEntry, tableStatements []utils.StatementWithType) {
+	replicatedTables := utils.GetReplicatedTables(tableStatements)
+	for _, entries := range dataEntries {
+		for i := range entries {
+			if replicatedTables[utils.MakeFQN(entries[i].Schema, entries[i].Name)] {
+				entries[i].IsReplicated = true
+			}
+		}
+	}
+}
+
+func getTableStatements(metadataFilename string) []utils.StatementWithType {
+	metadataFile := iohelper.MustOpenFileForReading(metadataFilename)
+	defer metadataFile.Close()
+	return globalTOC.GetSQLStatementForObjectTypes("predata", metadataFile, []string{"TABLE"}, []string{}, []string{}, []string{}, []string{}, []string{})
+}
+
+/*
+ * Whether a table was replicated is recorded in the backup, as the restore
+ * database only holds the policy the table is restored with.
+ */
+func isReplicatedAfterRestore(entry utils.MasterDataEntry) bool {
+	if !entry.IsReplicated {
+		return false
+	}
+	policy := getDistributionPolicyOverride(utils.MakeFQN(entry.Schema, entry.Name))
+	return policy == "" || isReplicatedPolicy(policy)
+}
+
+func hasDistributionPolicyOverrides() bool {
+	return len(distributionPolicies) > 0 || MustGetFlagBool(utils.DISTRIBUTED_RANDOMLY)
+}
+
+/*
+ * Rows loaded from a backup are placed on the segment from which they were
+ * backed up, which is still valid for a randomly distributed table but must
+ * be corrected once the data is loaded for any other distribution policy.
+ */
+func isRedistributionRequired(fqn string) bool {
+	policy := getDistributionPolicyOverride(fqn)
+	return policy != "" && !strings.HasSuffix(strings.ToUpper(policy), "RANDOMLY") && !isReplicatedPolicy(policy)
+}
+
+func overrideDistributionPolicies(statements []utils.StatementWithType) []utils.StatementWithType {
+	for i, statement := range statements {
+		if statement.ObjectType != "TABLE" || !isCreateTableStatement(statement.Statement) {
+			continue
+		}
+		fqn := utils.MakeFQN(statement.Schema, statement.Name)
+		if policy := getDistributionPolicyOverride(fqn); policy != "" {
+			newStatement, err := ReplaceDistributionPolicy(statement.Statement, policy)
+			gplog.FatalOnError(err, fmt.Sprintf("Cannot restore table %s", fqn))
+			statements[i].Statement = newStatement
+		}
+	}
+	return statements
+}
+
+/*
+ * The distribution clause is only searched for after the column list, so
+ * that column defaults containing the same text are not modified.
+ *
+ * Each segment backs up only its own rows of a distributed table, so no
+ * segment has all of the data to load into a replicated table.
+ */
+func ReplaceDistributionPolicy(statement string, policy string) (string, error) {
+	columnsStart := indexTopLevel(statement, "(")
+	if columnsStart == -1 {
+		return statement, nil
+	}
+	columnsEnd := matchingParen(statement, columnsStart)
+	if columnsEnd == -1 {
+		return statement, nil
+	}
+	tableOptions := statement[columnsEnd+1:]
+	distribution := distributedByRegex.FindString(tableOptions)
+	if distribution == "" {
+		return statement, nil
+	}
+	if isReplicatedPolicy(policy) && !isReplicatedPolicy(distribution) {
+		return "", errors.Errorf("A table backed up with %s cannot be restored with DISTRIBUTED REPLICATED", distribution)
+	}
+	return statement[:columnsEnd+1] + strings.Replace(tableOptions, distribution, policy, 1), nil
+}
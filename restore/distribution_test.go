@@ -0,0 +1,84 @@
+package restore_test
+
+import (
+	"github.com/greenplum-db/gpbackup/restore"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("restore/distribution tests", func() {
+	Describe("ParseDistributionPolicyMap", func() {
+		It("parses table names and distribution clauses", func() {
+			lines := []string{"# a comment", "", "public.sales DISTRIBUTED BY (region, id)", "  public.events   distributed randomly", "public.codes DISTRIBUTED REPLICATED"}
+
+			policies, err := restore.ParseDistributionPolicyMap(lines)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(policies).To(Equal(map[string]string{
+				"public.sales":  "DISTRIBUTED BY (region, id)",
+				"public.events": "distributed randomly",
+				"public.codes":  "DISTRIBUTED REPLICATED",
+			}))
+		})
+		It("returns an error for an entry without a distribution clause", func() {
+			_, err := restore.ParseDistributionPolicyMap([]string{"public.sales DISTRIBUTED BY (id)", "public.events"})
+
+			Expect(err).To(MatchError("Invalid distribution policy map entry on line 2: public.events"))
+		})
+		It("returns an error for a table listed more than once", func() {
+			_, err := restore.ParseDistributionPolicyMap([]string{"public.sales DISTRIBUTED BY (id)", "public.sales DISTRIBUTED RANDOMLY"})
+
+			Expect(err).To(MatchError("Table public.sales is listed more than once in the distribution policy map"))
+		})
+	})
+	Describe("ReplaceDistributionPolicy", func() {
+		It("replaces the distribution clause of a table", func() {
+			statement := "\n\nCREATE TABLE public.sales (\n\tid integer,\n\tregion text\n) WITH (appendonly=true) DISTRIBUTED BY (id);"
+
+			Expect(restore.ReplaceDistributionPolicy(statement, "DISTRIBUTED BY (region)")).To(Equal("\n\nCREATE TABLE public.sales (\n\tid integer,\n\tregion text\n) WITH (appendonly=true) DISTRIBUTED BY (region);"))
+		})
+		It("replaces the distribution clause of a partitioned table", func() {
+			statement := "CREATE TABLE public.sales (\n\tid integer,\n\tyear integer\n) DISTRIBUTED RANDOMLY PARTITION BY RANGE(year)\n(\nSTART (2017) END (2018) WITH (tablename='sales_1_prt_1', appendonly=false )\n);"
+
+			Expect(restore.ReplaceDistributionPolicy(statement, "DISTRIBUTED BY (id)")).To(Equal("CREATE TABLE public.sales (\n\tid integer,\n\tyear integer\n) DISTRIBUTED BY (id) PARTITION BY RANGE(year)\n(\nSTART (2017) END (2018) WITH (tablename='sales_1_prt_1', appendonly=false )\n);"))
+		})
+		It("does not modify column defaults", func() {
+			statement := "CREATE TABLE public.notes (\n\tnote text DEFAULT 'DISTRIBUTED RANDOMLY'\n) DISTRIBUTED BY (note);"
+
+			Expect(restore.ReplaceDistributionPolicy(statement, "DISTRIBUTED RANDOMLY")).To(Equal("CREATE TABLE public.notes (\n\tnote text DEFAULT 'DISTRIBUTED RANDOMLY'\n) DISTRIBUTED RANDOMLY;"))
+		})
+		It("replaces the distribution clause of a replicated table", func() {
+			statement := "CREATE TABLE public.codes (\n\tcode text\n) DISTRIBUTED REPLICATED;"
+
+			Expect(restore.ReplaceDistributionPolicy(statement, "DISTRIBUTED BY (code)")).To(Equal("CREATE TABLE public.codes (\n\tcode text\n) DISTRIBUTED BY (code);"))
+		})
+		It("returns an error for a distributed table restored as replicated", func() {
+			statement := "CREATE TABLE public.sales (\n\tid integer\n) DISTRIBUTED BY (id);"
+
+			_, err := restore.ReplaceDistributionPolicy(statement, "DISTRIBUTED REPLICATED")
+
+			Expect(err).To(MatchError("A table backed up with DISTRIBUTED BY (id) cannot be restored with DISTRIBUTED REPLICATED"))
+		})
+	})
+	Describe("MarkReplicatedTables", func() {
+		It("marks tables that are replicated in the backed-up table definitions of a backup that does not record it", func() {
+			dataEntries := [][]utils.MasterDataEntry{{
+				{Schema: "public", Name: "codes", Oid: 1},
+				{Schema: "public", Name: "sales", Oid: 2},
+				{Schema: "public", Name: "regions", Oid: 3, IsReplicated: true},
+			}}
+			tableStatements := []utils.StatementWithType{
+				{Schema: "public", Name: "codes", ObjectType: "TABLE", Statement: "\n\nCREATE TABLE public.codes (\n\tcode text\n) DISTRIBUTED REPLICATED;"},
+				{Schema: "public", Name: "sales", ObjectType: "TABLE", Statement: "\n\nCREATE TABLE public.sales (\n\tnote text DEFAULT 'DISTRIBUTED REPLICATED'::text\n) DISTRIBUTED RANDOMLY;"},
+			}
+
+			restore.MarkReplicatedTables(dataEntries, tableStatements)
+
+			Expect(dataEntries[0][0].IsReplicated).To(BeTrue())
+			Expect(dataEntries[0][1].IsReplicated).To(BeFalse())
+			Expect(dataEntries[0][2].IsReplicated).To(BeTrue())
+		})
+	})
+})
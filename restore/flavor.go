@@ -0,0 +1,595 @@
+package restore
+
+/*
+ * This file contains structs and functions related to restoring a Greenplum
+ * backup into a PostgreSQL database, which does not accept Greenplum-specific
+ * syntax such as distribution policies, append-optimized storage options, or
+ * Greenplum partition definitions.
+ */
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gpbackup/backup_filepath"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+const (
+	TARGET_FLAVOR_GREENPLUM = "greenplum"
+	TARGET_FLAVOR_POSTGRES  = "postgres"
+
+	// The oldest server version whose syntax the restore code can produce
+	POSTGRES_TARGET_MINIMUM_VERSION = 90400
+)
+
+var (
+	externalTableRegex    = regexp.MustCompile(`^\s*CREATE (READABLE|WRITABLE) EXTERNAL `)
+	columnEncodingRegex   = regexp.MustCompile(`(?i)\s+ENCODING \([^()]*\)`)
+	distributedByRegex    = regexp.MustCompile(`DISTRIBUTED (BY \(.*?\)|RANDOMLY|REPLICATED)`)
+	partitionTableRegex   = regexp.MustCompile(`tablename='((?:[^']|'')*)'`)
+	partitionTypeRegex    = regexp.MustCompile(`(?s)^PARTITION BY (RANGE|LIST)\s*\(`)
+	roleResourceRegex     = regexp.MustCompile(` RESOURCE (QUEUE|GROUP) ("(?:[^"]|"")*"|[^\s;]+)`)
+	roleExtTableRegex     = regexp.MustCompile(` (NO)?CREATEEXTTABLE( \([^()]*\))?`)
+	roleDenyRegex         = regexp.MustCompile(`^\s*ALTER ROLE .* DENY `)
+	databaseGUCRegex      = regexp.MustCompile(` SET (gp_|optimizer)`)
+	functionModifierRegex = regexp.MustCompile(` (CONTAINS SQL|MODIFIES SQL DATA|NO SQL|READS SQL DATA|EXECUTE ON (ANY|MASTER|ALL SEGMENTS|INITPLAN))`)
+	simpleIdentRegex      = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+	foreignKeyRegex       = regexp.MustCompile(`ADD CONSTRAINT ("(?:[^"]|"")*"|[^\s"]+) FOREIGN KEY \(`)
+
+	gpdbStorageOptions = []string{"appendonly", "appendoptimized", "blocksize", "checksum", "compresslevel", "compresstype", "oids", "orientation"}
+
+	postgresSkippedObjectTypes = []string{"EXCHANGE PARTITION", "PROTOCOL", "RESOURCE GROUP", "RESOURCE QUEUE"}
+)
+
+var postgresConverter *PostgresConverter
+
+func isPostgresTarget() bool {
+	return MustGetFlagString(utils.TARGET_FLAVOR) == TARGET_FLAVOR_POSTGRES
+}
+
+/*
+ * Functions for connecting to a PostgreSQL target
+ */
+
+/*
+ * dbconn.DBConn.Connect determines the database version by parsing the
+ * Greenplum version string, which a PostgreSQL server does not have, so the
+ * connections are opened here instead.
+ */
+func connectToPostgres(conn *dbconn.DBConn, numConns int) {
+	connURL := url.URL{
+		Scheme:   "postgres",
+		User:     url.User(conn.User),
+		Host:     fmt.Sprintf("%s:%d", conn.Host, conn.Port),
+		Path:     conn.DBName,
+		RawQuery: "sslmode=disable&statement_cache_capacity=0",
+	}
+	conn.ConnPool = make([]*sqlx.DB, numConns)
+	for i := 0; i < numConns; i++ {
+		db, err := conn.Driver.Connect("pgx", connURL.String())
+		if err != nil {
+			gplog.Fatal(err, fmt.Sprintf(`Could not connect to PostgreSQL database "%s"`, conn.DBName))
+		}
+		db.SetMaxOpenConns(1)
+		db.SetMaxIdleConns(1)
+		conn.ConnPool[i] = db
+	}
+	conn.Tx = make([]*sqlx.Tx, numConns)
+	conn.NumConns = numConns
+
+	serverVersion, err := strconv.Atoi(dbconn.MustSelectString(conn, "SELECT current_setting('server_version_num') AS string"))
+	gplog.FatalOnError(err)
+	versionString := dbconn.MustSelectString(conn, "SELECT version() AS string")
+	if serverVersion < POSTGRES_TARGET_MINIMUM_VERSION {
+		gplog.Fatal(errors.Errorf("PostgreSQL server version %s is not supported. Please restore to PostgreSQL 9.4 or later.", versionString), "")
+	}
+	conn.Version = dbconn.GPDBVersion{
+		VersionString: versionString,
+		SemVer:        ParsePostgresServerVersion(serverVersion),
+	}
+	if postgresConverter == nil || postgresConverter.ServerVersion != serverVersion {
+		postgresConverter = NewPostgresConverter(serverVersion)
+	}
+}
+
+/*
+ * server_version_num is MMmmpp before PostgreSQL 10 and MM00pp from 10 on,
+ * when the minor version was dropped.  The Greenplum version checks in the
+ * restore code all treat a supported PostgreSQL version as at least GPDB 6.
+ */
+func ParsePostgresServerVersion(serverVersion int) semver.Version {
+	major := serverVersion / 10000
+	if major >= 10 {
+		return semver.Version{Major: uint64(major), Patch: uint64(serverVersion % 10000)}
+	}
+	return semver.Version{Major: uint64(major), Minor: uint64(serverVersion / 100 % 100), Patch: uint64(serverVersion % 100)}
+}
+
+/*
+ * Table data is loaded with COPY ... FROM PROGRAM, which runs on the
+ * PostgreSQL server, so the backup directory must be readable at the same
+ * path on the server host.  This is checked in the same way before anything
+ * is restored, rather than failing on the first table.
+ */
+func ValidateBackupDirectoryOnPostgresServer(backupDir string) {
+	connectionPool.MustExec("CREATE TEMPORARY TABLE gprestore_backup_dir_check (result text)")
+	defer connectionPool.MustExec("DROP TABLE gprestore_backup_dir_check")
+	_, err := connectionPool.Exec(fmt.Sprintf("COPY gprestore_backup_dir_check FROM PROGRAM 'test -r %s && test -x %s && echo ok || echo missing'", backupDir, backupDir))
+	gplog.FatalOnError(err, fmt.Sprintf("Unable to check backup directory %s on the PostgreSQL server host. Restoring to PostgreSQL requires permission to run COPY ... FROM PROGRAM.", backupDir))
+	result := dbconn.MustSelectString(connectionPool, "SELECT result AS string FROM gprestore_backup_dir_check")
+	if result != "ok" {
+		gplog.Fatal(errors.Errorf("Backup directory %s is not readable on the PostgreSQL server host %s. The backup files must be accessible at the same path on the server host to restore data to PostgreSQL.", backupDir, connectionPool.Host), "")
+	}
+}
+
+/*
+ * When only data is restored, the CREATE TABLE statements are still converted
+ * so that the converter knows which tables are replicated and which partitions
+ * are loaded into another table.
+ */
+func InitializePostgresConverterTables(metadataFilename string) {
+	postgresConverter.ConvertStatements(getTableStatements(metadataFilename))
+}
+
+func initializePostgresConnectionPool() {
+	setupQuery := `
+SET application_name TO 'gprestore';
+SET search_path TO pg_catalog;
+SET statement_timeout = 0;
+SET lock_timeout = 0;
+SET check_function_bodies = false;
+SET client_min_messages = error;
+SET standard_conforming_strings = on;
+SET default_transaction_read_only = off;
+`
+	for i := 0; i < connectionPool.NumConns; i++ {
+		connectionPool.MustExec(setupQuery, i)
+	}
+}
+
+/*
+ * A PostgreSQL server has no segments, so the cluster consists only of the
+ * host to which gprestore connects.  Backup files are read from the
+ * user-specified backup directory, so no data directory is needed.
+ */
+func getPostgresTargetCluster() *cluster.Cluster {
+	return cluster.NewCluster([]cluster.SegConfig{{ContentID: -1, Hostname: connectionPool.Host}})
+}
+
+/*
+ * Functions for loading data into a PostgreSQL target
+ */
+
+/*
+ * All segment files for a table are concatenated and loaded with a single
+ * COPY on the PostgreSQL server, so the backup directory must be readable
+ * from the server host.  Each segment of the backup cluster holds a full
+ * copy of a replicated table, so only the first segment's file is read.
+ */
+func GetPostgresFilePathsForCopyCommand(fpInfo *backup_filepath.FilePathInfo, tableOid uint32, extension string, segmentCount int, isReplicated bool) string {
+	if isReplicated {
+		segmentCount = 1
+	}
+	filePaths := make([]string, segmentCount)
+	for contentID := 0; contentID < segmentCount; contentID++ {
+		filePaths[contentID] = fpInfo.GetTableBackupFilePath(contentID, tableOid, extension, false)
+	}
+	return strings.Join(filePaths, " ")
+}
+
+func CopyTableInOnCoordinator(connectionPool *dbconn.DBConn, tableName string, tableAttributes string, filePaths string, whichConn int) (int64, error) {
+	whichConn = connectionPool.ValidateConnNum(whichConn)
+	customPipeThroughCommand := utils.GetPipeThroughProgram().InputCommand
+	query := fmt.Sprintf("COPY %s%s FROM PROGRAM 'cat %s | %s' WITH CSV DELIMITER '%s';", tableName, tableAttributes, filePaths, customPipeThroughCommand, tableDelim)
+	result, err := connectionPool.Exec(query, whichConn)
+	if err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("Error loading data into table %s", tableName))
+	}
+	numRows, _ := result.RowsAffected()
+	return numRows, err
+}
+
+func restoreSingleTableDataToPostgres(fpInfo *backup_filepath.FilePathInfo, entry utils.MasterDataEntry, name string, whichConn int) error {
+	isReplicated := postgresConverter.ReplicatedTables[name]
+	filePaths := GetPostgresFilePathsForCopyCommand(fpInfo, entry.Oid, utils.GetPipeThroughProgram().Extension, backupConfig.SegmentCount, isReplicated)
+	tableName := name
+	if dataTarget, ok := postgresConverter.DataTargets[name]; ok {
+		tableName = dataTarget
+	}
+	numRowsRestored, err := CopyTableInOnCoordinator(connectionPool, tableName, entry.AttributeString, filePaths, whichConn)
+	if err != nil {
+		return err
+	}
+	if isReplicated {
+		// The backed up row count is multiplied by the number of segments in the backup cluster
+		return nil
+	}
+	return CheckRowsRestored(numRowsRestored, entry.RowsCopied, name)
+}
+
+/*
+ * Functions for converting metadata for a PostgreSQL target
+ */
+
+type PostgresConverter struct {
+	ServerVersion    int
+	ReplicatedTables map[string]bool
+	// Maps partitions that are not restored as separate tables to the table that receives their data
+	DataTargets   map[string]string
+	skippedTables map[string]bool
+}
+
+func NewPostgresConverter(serverVersion int) *PostgresConverter {
+	return &PostgresConverter{
+		ServerVersion:    serverVersion,
+		ReplicatedTables: make(map[string]bool),
+		DataTargets:      make(map[string]string),
+		skippedTables:    make(map[string]bool),
+	}
+}
+
+func (converter *PostgresConverter) ConvertStatements(statements []utils.StatementWithType) []utils.StatementWithType {
+	skippedTypes := utils.NewSet(postgresSkippedObjectTypes)
+	convertedStatements := make([]utils.StatementWithType, 0)
+	for _, statement := range statements {
+		if skippedTypes.MatchesFilter(statement.ObjectType) {
+			gplog.Verbose("Skipping %s %s, which is not supported by PostgreSQL", statement.ObjectType, statement.Name)
+			continue
+		}
+		switch statement.ObjectType {
+		case "TABLE":
+			fqn := utils.MakeFQN(statement.Schema, statement.Name)
+			if externalTableRegex.MatchString(statement.Statement) {
+				gplog.Warn("Skipping external table %s, which is not supported by PostgreSQL", fqn)
+				converter.skippedTables[fqn] = true
+			}
+			if converter.skippedTables[fqn] {
+				continue
+			}
+			if isCreateTableStatement(statement.Statement) {
+				statement.Statement = converter.ConvertCreateTableStatement(statement.Schema, statement.Name, statement.Statement)
+			}
+		case "ROLE":
+			if roleDenyRegex.MatchString(statement.Statement) {
+				continue
+			}
+			statement.Statement = roleResourceRegex.ReplaceAllString(statement.Statement, "")
+			statement.Statement = roleExtTableRegex.ReplaceAllString(statement.Statement, "")
+		case "DATABASE GUC":
+			if databaseGUCRegex.MatchString(statement.Statement) {
+				continue
+			}
+		case "FUNCTION":
+			statement.Statement = removeFunctionModifiers(statement.Statement)
+		case "INDEX":
+			statement.Statement = strings.Replace(statement.Statement, " USING bitmap ", " USING btree ", 1)
+		case "MATERIALIZED VIEW":
+			statement.Statement = removeMaterializedViewDistribution(statement.Statement)
+		}
+		convertedStatements = append(convertedStatements, statement)
+	}
+	return convertedStatements
+}
+
+/*
+ * PostgreSQL checks a FOREIGN KEY constraint against the data already in the
+ * table and requires a unique index on the referenced columns, so as in
+ * pg_dump, foreign keys are added after the data is loaded and after every
+ * PRIMARY KEY and UNIQUE constraint and index is created.  Other statements
+ * about a foreign key, such as its comment, are moved with it.
+ */
+func SplitForeignKeyConstraints(statements []utils.StatementWithType) ([]utils.StatementWithType, []utils.StatementWithType) {
+	foreignKeys := make(map[string]bool)
+	for _, statement := range statements {
+		if statement.ObjectType == "CONSTRAINT" && foreignKeyRegex.MatchString(statement.Statement) {
+			foreignKeys[constraintKey(statement)] = true
+		}
+	}
+	otherStatements := make([]utils.StatementWithType, 0)
+	foreignKeyStatements := make([]utils.StatementWithType, 0)
+	for _, statement := range statements {
+		if statement.ObjectType == "CONSTRAINT" && foreignKeys[constraintKey(statement)] {
+			foreignKeyStatements = append(foreignKeyStatements, statement)
+		} else {
+			otherStatements = append(otherStatements, statement)
+		}
+	}
+	return otherStatements, foreignKeyStatements
+}
+
+// Constraint names are unique only within the table to which they belong
+func constraintKey(statement utils.StatementWithType) string {
+	return fmt.Sprintf("%s %s", statement.ReferenceObject, statement.Name)
+}
+
+// Only the clause after the query is removed, as the query may contain the same text
+func removeMaterializedViewDistribution(statement string) string {
+	withNoDataIndex := strings.LastIndex(statement, "WITH NO DATA")
+	if withNoDataIndex == -1 {
+		return statement
+	}
+	clause := statement[withNoDataIndex:]
+	if distribution := distributedByRegex.FindString(clause); distribution != "" {
+		clause = strings.Replace(clause, " "+distribution, "", 1)
+	}
+	return statement[:withNoDataIndex] + clause
+}
+
+func isCreateTableStatement(statement string) bool {
+	statement = strings.TrimSpace(statement)
+	return strings.HasPrefix(statement, "CREATE TABLE ") || strings.HasPrefix(statement, "CREATE UNLOGGED TABLE ")
+}
+
+/*
+ * Greenplum-specific function attributes are printed after the LANGUAGE
+ * clause, so only that part of the statement is modified to avoid changing
+ * the function body.
+ */
+func removeFunctionModifiers(statement string) string {
+	languageIndex := strings.LastIndex(statement, "LANGUAGE ")
+	if languageIndex == -1 {
+		return statement
+	}
+	return statement[:languageIndex] + functionModifierRegex.ReplaceAllString(statement[languageIndex:], "")
+}
+
+/*
+ * A CREATE TABLE entry in the TOC consists of the CREATE TABLE statement
+ * itself, optionally followed by a SUBPARTITION TEMPLATE statement and ALTER
+ * COLUMN statements.
+ */
+func (converter *PostgresConverter) ConvertCreateTableStatement(schema string, name string, statement string) string {
+	fqn := utils.MakeFQN(schema, name)
+	createEnd := indexTopLevel(statement, ";")
+	if createEnd == -1 {
+		createEnd = len(statement)
+	}
+	createStatement := statement[:createEnd]
+	otherStatements := make([]string, 0)
+	if createEnd < len(statement) {
+		for _, otherStatement := range splitTopLevel(statement[createEnd+1:], ';') {
+			if strings.TrimSpace(otherStatement) == "" || strings.Contains(otherStatement, "SUBPARTITION TEMPLATE") {
+				continue
+			}
+			otherStatements = append(otherStatements, otherStatement+";")
+		}
+	}
+
+	columnsStart := indexTopLevel(createStatement, "(")
+	if columnsStart == -1 {
+		return statement
+	}
+	columnsEnd := matchingParen(createStatement, columnsStart)
+	if columnsEnd == -1 {
+		return statement
+	}
+	columns := columnEncodingRegex.ReplaceAllString(createStatement[columnsStart+1:columnsEnd], "")
+	tableOptions := createStatement[columnsEnd+1:]
+
+	partitionDef := ""
+	if partitionStart := indexTopLevel(tableOptions, "PARTITION BY "); partitionStart != -1 {
+		partitionDef = strings.TrimSpace(tableOptions[partitionStart:])
+		tableOptions = tableOptions[:partitionStart]
+	}
+	if distribution := distributedByRegex.FindString(tableOptions); distribution != "" {
+		if distribution == "DISTRIBUTED REPLICATED" {
+			converter.ReplicatedTables[fqn] = true
+		}
+		tableOptions = strings.Replace(tableOptions, distribution, "", 1)
+	}
+	tableOptions = removeGPDBStorageOptions(tableOptions)
+
+	partitionStatements := make([]string, 0)
+	if partitionDef != "" {
+		partitionKey, leafStatements, ok := converter.convertPartitionDefinition(schema, fqn, partitionDef)
+		if ok {
+			tableOptions += " " + partitionKey
+			partitionStatements = leafStatements
+		} else {
+			gplog.Warn("The partition definition of table %s cannot be converted for PostgreSQL %d; restoring it as a regular table", fqn, converter.ServerVersion)
+			for _, match := range partitionTableRegex.FindAllStringSubmatch(partitionDef, -1) {
+				partitionName := strings.Replace(match[1], "''", "'", -1)
+				converter.DataTargets[utils.MakeFQN(schema, quoteIdentifierForPostgres(partitionName))] = fqn
+			}
+		}
+	}
+
+	tableOptions = strings.TrimSpace(tableOptions)
+	if tableOptions != "" {
+		tableOptions = " " + tableOptions
+	}
+	convertedStatement := fmt.Sprintf("%s%s)%s;", createStatement[:columnsStart+1], columns, tableOptions)
+	for _, partitionStatement := range partitionStatements {
+		convertedStatement += "\n" + partitionStatement
+	}
+	return convertedStatement + strings.Join(otherStatements, "")
+}
+
+func removeGPDBStorageOptions(tableOptions string) string {
+	withStart := indexTopLevel(tableOptions, "WITH (")
+	if withStart == -1 {
+		return tableOptions
+	}
+	optionsStart := withStart + len("WITH ")
+	optionsEnd := matchingParen(tableOptions, optionsStart)
+	if optionsEnd == -1 {
+		return tableOptions
+	}
+	gpdbOptions := utils.NewSet(gpdbStorageOptions)
+	keptOptions := make([]string, 0)
+	for _, option := range splitTopLevel(tableOptions[optionsStart+1:optionsEnd], ',') {
+		key := strings.ToLower(strings.TrimSpace(strings.SplitN(option, "=", 2)[0]))
+		if !gpdbOptions.MatchesFilter(key) {
+			keptOptions = append(keptOptions, strings.TrimSpace(option))
+		}
+	}
+	withClause := ""
+	if len(keptOptions) > 0 {
+		withClause = fmt.Sprintf("WITH (%s)", strings.Join(keptOptions, ", "))
+	}
+	return tableOptions[:withStart] + withClause + tableOptions[optionsEnd+1:]
+}
+
+/*
+ * Single-level RANGE and LIST partitions with explicitly bounded partitions
+ * are converted to declarative partitions, with each partition keeping the
+ * table name it had in Greenplum.  Multi-level partitions and partitions
+ * defined with EVERY or with an inclusive end or exclusive start cannot be
+ * expressed in PostgreSQL and are not converted.
+ */
+func (converter *PostgresConverter) convertPartitionDefinition(schema string, fqn string, partitionDef string) (string, []string, bool) {
+	if converter.ServerVersion < 100000 {
+		return "", nil, false
+	}
+	typeMatch := partitionTypeRegex.FindStringSubmatch(partitionDef)
+	if typeMatch == nil {
+		return "", nil, false
+	}
+	partitionType := typeMatch[1]
+	keyStart := len(typeMatch[0]) - 1
+	keyEnd := matchingParen(partitionDef, keyStart)
+	if keyEnd == -1 {
+		return "", nil, false
+	}
+	partitionKey := fmt.Sprintf("PARTITION BY %s (%s)", partitionType, strings.TrimSpace(partitionDef[keyStart+1:keyEnd]))
+
+	partitionList := strings.TrimSpace(partitionDef[keyEnd+1:])
+	if !strings.HasPrefix(partitionList, "(") || matchingParen(partitionList, 0) != len(partitionList)-1 {
+		return "", nil, false
+	}
+	leafStatements := make([]string, 0)
+	for _, partition := range splitTopLevel(partitionList[1:len(partitionList)-1], ',') {
+		partition = strings.TrimSpace(partition)
+		if strings.Contains(partition, "SUBPARTITION") || indexTopLevel(partition, "EVERY") != -1 {
+			return "", nil, false
+		}
+		nameMatch := partitionTableRegex.FindStringSubmatch(partition)
+		if nameMatch == nil {
+			return "", nil, false
+		}
+		leafFQN := utils.MakeFQN(schema, quoteIdentifierForPostgres(strings.Replace(nameMatch[1], "''", "'", -1)))
+
+		bound := ""
+		if strings.HasPrefix(partition, "DEFAULT PARTITION") {
+			if converter.ServerVersion < 110000 {
+				return "", nil, false
+			}
+			bound = "DEFAULT"
+		} else if partitionType == "RANGE" {
+			start, startModifier := getPartitionBound(partition, "START")
+			end, endModifier := getPartitionBound(partition, "END")
+			if startModifier == "EXCLUSIVE" || endModifier == "INCLUSIVE" {
+				return "", nil, false
+			}
+			if start == "" {
+				start = "MINVALUE"
+			}
+			if end == "" {
+				end = "MAXVALUE"
+			}
+			bound = fmt.Sprintf("FOR VALUES FROM (%s) TO (%s)", start, end)
+		} else {
+			values, _ := getPartitionBound(partition, "VALUES")
+			if values == "" {
+				return "", nil, false
+			}
+			bound = fmt.Sprintf("FOR VALUES IN (%s)", values)
+		}
+		leafStatements = append(leafStatements, fmt.Sprintf("CREATE TABLE %s PARTITION OF %s %s;", leafFQN, fqn, bound))
+	}
+	return partitionKey, leafStatements, true
+}
+
+/*
+ * Returns the parenthesized expression following the given keyword in a
+ * partition definition, along with an INCLUSIVE or EXCLUSIVE modifier if
+ * one follows the expression.
+ */
+func getPartitionBound(partition string, keyword string) (string, string) {
+	keywordIndex := indexTopLevel(partition, keyword)
+	if keywordIndex == -1 {
+		return "", ""
+	}
+	boundStart := indexTopLevel(partition[keywordIndex:], "(")
+	if boundStart == -1 {
+		return "", ""
+	}
+	boundStart += keywordIndex
+	boundEnd := matchingParen(partition, boundStart)
+	if boundEnd == -1 {
+		return "", ""
+	}
+	modifier := ""
+	remainder := strings.Fields(partition[boundEnd+1:])
+	if len(remainder) > 0 && (remainder[0] == "INCLUSIVE" || remainder[0] == "EXCLUSIVE") {
+		modifier = remainder[0]
+	}
+	return strings.TrimSpace(partition[boundStart+1 : boundEnd]), modifier
+}
+
+func quoteIdentifierForPostgres(name string) string {
+	if simpleIdentRegex.MatchString(name) {
+		return name
+	}
+	return fmt.Sprintf(`"%s"`, strings.Replace(name, `"`, `""`, -1))
+}
+
+/*
+ * Returns the index of the first occurrence of substr in s that is not
+ * enclosed in parentheses or quotes, or -1 if there is none.
+ */
+func indexTopLevel(s string, substr string) int {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		if quote != 0 {
+			if s[i] == quote {
+				quote = 0
+			}
+			continue
+		}
+		if depth == 0 && strings.HasPrefix(s[i:], substr) {
+			return i
+		}
+		switch s[i] {
+		case '\'', '"':
+			quote = s[i]
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+	}
+	return -1
+}
+
+func splitTopLevel(s string, separator byte) []string {
+	parts := make([]string, 0)
+	for {
+		index := indexTopLevel(s, string(separator))
+		if index == -1 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:index])
+		s = s[index+1:]
+	}
+}
+
+// Returns the index of the parenthesis closing the one at index open, or -1 if it is not closed
+func matchingParen(s string, open int) int {
+	closeIndex := indexTopLevel(s[open+1:], ")")
+	if closeIndex == -1 {
+		return -1
+	}
+	return open + 1 + closeIndex
+}
@@ -0,0 +1,240 @@
+package restore_test
+
+import (
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/backup_filepath"
+	"github.com/greenplum-db/gpbackup/restore"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var _ = Describe("restore/flavor tests", func() {
+	var converter *restore.PostgresConverter
+	BeforeEach(func() {
+		converter = restore.NewPostgresConverter(110000)
+	})
+	Describe("ConvertCreateTableStatement", func() {
+		It("removes the distribution policy", func() {
+			statement := `
+
+CREATE TABLE public.foo (
+	i integer,
+	j text
+) DISTRIBUTED BY (i);`
+
+			Expect(converter.ConvertCreateTableStatement("public", "foo", statement)).To(Equal(`
+
+CREATE TABLE public.foo (
+	i integer,
+	j text
+);`))
+			Expect(converter.ReplicatedTables).To(BeEmpty())
+		})
+		It("records replicated tables", func() {
+			statement := "CREATE TABLE public.foo (\n\ti integer\n) DISTRIBUTED REPLICATED;"
+
+			Expect(converter.ConvertCreateTableStatement("public", "foo", statement)).To(Equal("CREATE TABLE public.foo (\n\ti integer\n);"))
+			Expect(converter.ReplicatedTables).To(Equal(map[string]bool{"public.foo": true}))
+		})
+		It("removes append-optimized storage options and column encodings", func() {
+			statement := `CREATE TABLE public.foo (
+	i integer ENCODING (compresstype=zlib,blocksize=32768,compresslevel=1),
+	j text DEFAULT 'a,b' NOT NULL
+) WITH (appendonly=true, orientation=column, fillfactor=50) TABLESPACE test_tablespace DISTRIBUTED RANDOMLY;
+ALTER TABLE ONLY public.foo ALTER COLUMN i SET STATISTICS 10;`
+
+			Expect(converter.ConvertCreateTableStatement("public", "foo", statement)).To(Equal(`CREATE TABLE public.foo (
+	i integer,
+	j text DEFAULT 'a,b' NOT NULL
+) WITH (fillfactor=50) TABLESPACE test_tablespace;
+ALTER TABLE ONLY public.foo ALTER COLUMN i SET STATISTICS 10;`))
+		})
+		It("removes the WITH clause if only Greenplum storage options are set", func() {
+			statement := "CREATE TABLE public.foo (\n\ti integer\n) WITH (appendonly=true, compresstype=zstd) DISTRIBUTED BY (i);"
+
+			Expect(converter.ConvertCreateTableStatement("public", "foo", statement)).To(Equal("CREATE TABLE public.foo (\n\ti integer\n);"))
+		})
+		It("converts a range partitioned table to declarative partitions", func() {
+			statement := `CREATE TABLE public.sales (
+	id integer,
+	year integer
+) DISTRIBUTED BY (id) PARTITION BY RANGE(year)
+          (
+          PARTITION y2017 START (2017) END (2018) WITH (tablename='sales_1_prt_y2017', appendonly=false ),
+          START (2018) END (2019) WITH (tablename='sales_1_prt_2', appendonly=false ),
+          DEFAULT PARTITION other  WITH (tablename='sales_1_prt_other', appendonly=false )
+          );`
+
+			Expect(converter.ConvertCreateTableStatement("public", "sales", statement)).To(Equal(`CREATE TABLE public.sales (
+	id integer,
+	year integer
+) PARTITION BY RANGE (year);
+CREATE TABLE public.sales_1_prt_y2017 PARTITION OF public.sales FOR VALUES FROM (2017) TO (2018);
+CREATE TABLE public.sales_1_prt_2 PARTITION OF public.sales FOR VALUES FROM (2018) TO (2019);
+CREATE TABLE public.sales_1_prt_other PARTITION OF public.sales DEFAULT;`))
+			Expect(converter.DataTargets).To(BeEmpty())
+		})
+		It("converts a list partitioned table to declarative partitions", func() {
+			statement := `CREATE TABLE public.rank (
+	id integer,
+	gender character(1)
+) DISTRIBUTED BY (id) PARTITION BY LIST(gender)
+          (
+          PARTITION girls VALUES('F') WITH (tablename='rank_1_prt_girls', appendonly=false ),
+          PARTITION boys VALUES('M') WITH (tablename='rank_1_prt_boys', appendonly=false )
+          );`
+
+			Expect(converter.ConvertCreateTableStatement("public", "rank", statement)).To(Equal(`CREATE TABLE public.rank (
+	id integer,
+	gender character(1)
+) PARTITION BY LIST (gender);
+CREATE TABLE public.rank_1_prt_girls PARTITION OF public.rank FOR VALUES IN ('F');
+CREATE TABLE public.rank_1_prt_boys PARTITION OF public.rank FOR VALUES IN ('M');`))
+		})
+		It("restores a multi-level partitioned table as a regular table and redirects partition data to it", func() {
+			statement := `CREATE TABLE public.sales (
+	id integer,
+	year integer,
+	region text
+) DISTRIBUTED BY (id) PARTITION BY RANGE(year) SUBPARTITION BY LIST(region)
+          (
+          START (2017) END (2018) WITH (tablename='sales_1_prt_1', appendonly=false )
+                  (
+                  SUBPARTITION usa VALUES('usa') WITH (tablename='sales_1_prt_1_2_prt_usa', appendonly=false )
+                  )
+          );
+ALTER TABLE public.sales SET SUBPARTITION TEMPLATE ( SUBPARTITION usa VALUES('usa') WITH (tablename='sales'));`
+
+			Expect(converter.ConvertCreateTableStatement("public", "sales", statement)).To(Equal(`CREATE TABLE public.sales (
+	id integer,
+	year integer,
+	region text
+);`))
+			Expect(converter.DataTargets).To(Equal(map[string]string{
+				"public.sales_1_prt_1":           "public.sales",
+				"public.sales_1_prt_1_2_prt_usa": "public.sales",
+			}))
+		})
+		It("restores a partitioned table as a regular table for PostgreSQL versions without declarative partitioning", func() {
+			converter = restore.NewPostgresConverter(90600)
+			statement := `CREATE TABLE public.rank (
+	id integer,
+	gender character(1)
+) DISTRIBUTED BY (id) PARTITION BY LIST(gender)
+          (
+          PARTITION girls VALUES('F') WITH (tablename='rank_1_prt_girls', appendonly=false )
+          );`
+
+			Expect(converter.ConvertCreateTableStatement("public", "rank", statement)).To(Equal("CREATE TABLE public.rank (\n\tid integer,\n\tgender character(1)\n);"))
+			Expect(converter.DataTargets).To(Equal(map[string]string{"public.rank_1_prt_girls": "public.rank"}))
+		})
+	})
+	Describe("ConvertStatements", func() {
+		It("skips objects that PostgreSQL does not support", func() {
+			statements := []utils.StatementWithType{
+				{Name: "myqueue", ObjectType: "RESOURCE QUEUE", Statement: "CREATE RESOURCE QUEUE myqueue WITH (ACTIVE_STATEMENTS=5);"},
+				{Name: "mygroup", ObjectType: "RESOURCE GROUP", Statement: "CREATE RESOURCE GROUP mygroup WITH (CPU_RATE_LIMIT=10);"},
+				{Name: "myprotocol", ObjectType: "PROTOCOL", Statement: "CREATE PROTOCOL myprotocol (readfunc = public.read_from_s3);"},
+				{Schema: "public", Name: "ext", ObjectType: "TABLE", Statement: "\n\nCREATE READABLE EXTERNAL TABLE public.ext (\n\ti integer\n) LOCATION (\n\t'file://host/tmp/file'\n) FORMAT 'text';"},
+				{Schema: "public", Name: "ext", ObjectType: "TABLE", Statement: "\n\nALTER TABLE public.ext OWNER TO testrole;"},
+				{Schema: "public", Name: "foo", ObjectType: "TABLE", Statement: "\n\nALTER TABLE public.foo OWNER TO testrole;"},
+			}
+
+			Expect(converter.ConvertStatements(statements)).To(Equal([]utils.StatementWithType{statements[5]}))
+		})
+		It("removes Greenplum-specific role attributes", func() {
+			statements := []utils.StatementWithType{
+				{Name: "testrole", ObjectType: "ROLE", Statement: "\n\nCREATE ROLE testrole;\nALTER ROLE testrole WITH NOSUPERUSER LOGIN RESOURCE QUEUE pg_default RESOURCE GROUP default_group CREATEEXTTABLE (protocol='gpfdist', type='readable');"},
+				{Name: "testrole", ObjectType: "ROLE", Statement: "\nALTER ROLE testrole DENY BETWEEN DAY 0 TIME '00:00:00' AND DAY 1 TIME '00:00:00';"},
+			}
+
+			Expect(converter.ConvertStatements(statements)).To(Equal([]utils.StatementWithType{
+				{Name: "testrole", ObjectType: "ROLE", Statement: "\n\nCREATE ROLE testrole;\nALTER ROLE testrole WITH NOSUPERUSER LOGIN;"},
+			}))
+		})
+		It("removes Greenplum-specific function attributes", func() {
+			statements := []utils.StatementWithType{
+				{Schema: "public", Name: "add", ObjectType: "FUNCTION", Statement: "\n\nCREATE FUNCTION public.add(integer, integer) RETURNS integer AS \n$$SELECT $1 + $2$$\nLANGUAGE sql CONTAINS SQL IMMUTABLE EXECUTE ON MASTER STRICT;"},
+			}
+
+			Expect(converter.ConvertStatements(statements)[0].Statement).To(Equal("\n\nCREATE FUNCTION public.add(integer, integer) RETURNS integer AS \n$$SELECT $1 + $2$$\nLANGUAGE sql IMMUTABLE STRICT;"))
+		})
+		It("skips Greenplum-specific database GUCs", func() {
+			statements := []utils.StatementWithType{
+				{Name: "testdb", ObjectType: "DATABASE GUC", Statement: "\nALTER DATABASE testdb SET gp_default_storage_options TO 'appendonly=true';"},
+				{Name: "testdb", ObjectType: "DATABASE GUC", Statement: "\nALTER DATABASE testdb SET search_path TO public;"},
+			}
+
+			Expect(converter.ConvertStatements(statements)).To(Equal([]utils.StatementWithType{statements[1]}))
+		})
+		It("removes the distribution policy of materialized views", func() {
+			statements := []utils.StatementWithType{
+				{Schema: "public", Name: "mview", ObjectType: "MATERIALIZED VIEW", Statement: "\n\nCREATE MATERIALIZED VIEW public.mview AS SELECT 1 AS a\nWITH NO DATA DISTRIBUTED BY (a);\n"},
+			}
+
+			Expect(converter.ConvertStatements(statements)[0].Statement).To(Equal("\n\nCREATE MATERIALIZED VIEW public.mview AS SELECT 1 AS a\nWITH NO DATA;\n"))
+		})
+	})
+	Describe("SplitForeignKeyConstraints", func() {
+		It("moves foreign keys and their comments after the constraints they reference", func() {
+			statements := []utils.StatementWithType{
+				{Schema: "public", Name: "child_parent_id_fkey", ObjectType: "CONSTRAINT", ReferenceObject: "public.child", Statement: "\n\nALTER TABLE ONLY public.child ADD CONSTRAINT child_parent_id_fkey FOREIGN KEY (parent_id) REFERENCES public.parent(id);\n"},
+				{Schema: "public", Name: "child_parent_id_fkey", ObjectType: "CONSTRAINT", ReferenceObject: "public.child", Statement: "\n\nCOMMENT ON CONSTRAINT child_parent_id_fkey ON public.child IS 'references parent';"},
+				{Schema: "public", Name: "parent_pkey", ObjectType: "CONSTRAINT", ReferenceObject: "public.parent", Statement: "\n\nALTER TABLE ONLY public.parent ADD CONSTRAINT parent_pkey PRIMARY KEY (id);\n"},
+				{Schema: "public", Name: "\"Child Check\"", ObjectType: "CONSTRAINT", ReferenceObject: "public.child", Statement: "\n\nALTER TABLE ONLY public.child ADD CONSTRAINT \"Child Check\" CHECK ((note <> 'FOREIGN KEY ('::text));\n"},
+				{Schema: "public", Name: "child_idx", ObjectType: "INDEX", ReferenceObject: "public.child", Statement: "\n\nCREATE INDEX child_idx ON public.child USING btree (parent_id);"},
+			}
+
+			otherStatements, foreignKeyStatements := restore.SplitForeignKeyConstraints(statements)
+
+			Expect(otherStatements).To(Equal([]utils.StatementWithType{statements[2], statements[3], statements[4]}))
+			Expect(foreignKeyStatements).To(Equal([]utils.StatementWithType{statements[0], statements[1]}))
+		})
+	})
+	Describe("GetPostgresFilePathsForCopyCommand", func() {
+		var fpInfo backup_filepath.FilePathInfo
+		BeforeEach(func() {
+			testCluster := cluster.NewCluster([]cluster.SegConfig{{ContentID: -1, Hostname: "localhost"}})
+			fpInfo = backup_filepath.NewFilePathInfo(testCluster, "/backups", "20170101010101", "gpseg")
+		})
+		It("returns the file of every segment of the backup cluster", func() {
+			Expect(restore.GetPostgresFilePathsForCopyCommand(&fpInfo, 3456, ".gz", 2, false)).To(Equal(
+				"/backups/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_3456.gz " +
+					"/backups/gpseg1/backups/20170101/20170101010101/gpbackup_1_20170101010101_3456.gz"))
+		})
+		It("returns only the first segment's file for a replicated table", func() {
+			Expect(restore.GetPostgresFilePathsForCopyCommand(&fpInfo, 3456, "", 2, true)).To(Equal(
+				"/backups/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_3456"))
+		})
+	})
+	Describe("ParsePostgresServerVersion", func() {
+		It("parses a version before PostgreSQL 10", func() {
+			Expect(restore.ParsePostgresServerVersion(90624).String()).To(Equal("9.6.24"))
+		})
+		It("parses a version from PostgreSQL 10 on", func() {
+			Expect(restore.ParsePostgresServerVersion(120015).String()).To(Equal("12.0.15"))
+		})
+	})
+	Describe("ValidateBackupDirectoryOnPostgresServer", func() {
+		BeforeEach(func() {
+			mock.ExpectExec("CREATE TEMPORARY TABLE gprestore_backup_dir_check").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("COPY gprestore_backup_dir_check FROM PROGRAM 'test -r /backups/gpseg0 && test -x /backups/gpseg0 && echo ok \\|\\| echo missing'").WillReturnResult(sqlmock.NewResult(0, 1))
+		})
+		It("does nothing if the backup directory is readable on the server host", func() {
+			mock.ExpectQuery("SELECT result AS string FROM gprestore_backup_dir_check").WillReturnRows(sqlmock.NewRows([]string{"string"}).AddRow("ok"))
+			mock.ExpectExec("DROP TABLE gprestore_backup_dir_check").WillReturnResult(sqlmock.NewResult(0, 0))
+			restore.ValidateBackupDirectoryOnPostgresServer("/backups/gpseg0")
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("panics if the backup directory is not readable on the server host", func() {
+			mock.ExpectQuery("SELECT result AS string FROM gprestore_backup_dir_check").WillReturnRows(sqlmock.NewRows([]string{"string"}).AddRow("missing"))
+			mock.ExpectExec("DROP TABLE gprestore_backup_dir_check").WillReturnResult(sqlmock.NewResult(0, 0))
+			defer testhelper.ShouldPanicWithMessage("Backup directory /backups/gpseg0 is not readable on the PostgreSQL server host")
+			restore.ValidateBackupDirectoryOnPostgresServer("/backups/gpseg0")
+		})
+	})
+})
@@ -21,15 +21,19 @@ import (
  */
 
 var (
-	backupConfig     *backup_history.BackupConfig
-	connectionPool   *dbconn.DBConn
-	globalCluster    *cluster.Cluster
-	globalFPInfo     backup_filepath.FilePathInfo
-	globalTOC        *utils.TOC
-	pluginConfig     *utils.PluginConfig
-	restoreStartTime string
-	version          string
-	wasTerminated    bool
+	backupConfig         *backup_history.BackupConfig
+	connectionPool       *dbconn.DBConn
+	distributionPolicies map[string]string
+	globalCluster        *cluster.Cluster
+	globalFPInfo         backup_filepath.FilePathInfo
+	globalTOC            *utils.TOC
+	helperAgents         *utils.HelperAgents
+	pluginConfig         *utils.PluginConfig
+	restoreList          []utils.RestoreListEntry
+	restoreStartTime     string
+	storageOptionsMap    map[string][]StorageOption
+	version              string
+	wasTerminated        bool
 
 	/*
 	 * Used for synchronizing DoCleanup.  In DoInit() we increment the group
@@ -76,6 +80,10 @@ func SetTOC(toc *utils.TOC) {
 	globalTOC = toc
 }
 
+func SetRestoreList(list []utils.RestoreListEntry) {
+	restoreList = list
+}
+
 // Util functions to enable ease of access to global flag values
 
 func MustGetFlagString(flagName string) string {
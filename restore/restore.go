@@ -8,6 +8,7 @@ import (
 
 	"github.com/greenplum-db/gp-common-go-libs/cluster"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
 	"github.com/greenplum-db/gpbackup/backup_filepath"
 	"github.com/greenplum-db/gpbackup/backup_history"
 	"github.com/greenplum-db/gpbackup/utils"
@@ -33,10 +34,16 @@ func SetFlagDefaults(flagSet *pflag.FlagSet) {
 	flagSet.Bool(utils.CREATE_DB, false, "Create the database before metadata restore")
 	flagSet.Bool(utils.DATA_ONLY, false, "Only restore data, do not restore metadata")
 	flagSet.Bool(utils.DEBUG, false, "Print verbose and debug log messages")
+	flagSet.Int(utils.DECOMPRESSION_BLOCKS, 1, "The number of blocks of data each gpbackup_helper reads ahead of the data being restored, for backups with a single data file. Each gpbackup_helper still decompresses on one core.")
+	flagSet.String(utils.DISTRIBUTION_POLICY_MAP, "", "A file containing fully-qualified table names and the distribution clause with which to restore each table, e.g. \"public.sales DISTRIBUTED BY (region)\". Only tables that were replicated in the backup can be restored with DISTRIBUTED REPLICATED.")
+	flagSet.Bool(utils.DISTRIBUTED_RANDOMLY, false, "Restore all tables with random distribution, except those listed in --distribution-policy-map")
+	flagSet.StringSlice(utils.EXCLUDE_OBJECT_TYPE, []string{}, "Restore all metadata except objects of the specified type(s), e.g. TRIGGER. --exclude-object-type can be specified multiple times.")
 	flagSet.StringSlice(utils.EXCLUDE_SCHEMA, []string{}, "Restore all metadata except objects in the specified schema(s). --exclude-schema can be specified multiple times.")
 	flagSet.StringSlice(utils.EXCLUDE_RELATION, []string{}, "Restore all metadata except the specified relation(s). --exclude-table can be specified multiple times.")
 	flagSet.String(utils.EXCLUDE_RELATION_FILE, "", "A file containing a list of fully-qualified relation(s) that will not be restored")
 	flagSet.Bool("help", false, "Help for gprestore")
+	flagSet.Bool(utils.INCLUDE_DEPENDENCIES, false, "Also restore the objects that the relations specified with --include-table or --include-table-file depend on")
+	flagSet.StringSlice(utils.INCLUDE_OBJECT_TYPE, []string{}, "Restore only metadata for objects of the specified type(s), e.g. FUNCTION. --include-object-type can be specified multiple times.")
 	flagSet.StringSlice(utils.INCLUDE_SCHEMA, []string{}, "Restore only the specified schema(s). --include-schema can be specified multiple times.")
 	flagSet.StringSlice(utils.INCLUDE_RELATION, []string{}, "Restore only the specified relation(s). --include-table can be specified multiple times.")
 	flagSet.String(utils.INCLUDE_RELATION_FILE, "", "A file containing a list of fully-qualified relation(s) that will be restored")
@@ -47,10 +54,16 @@ func SetFlagDefaults(flagSet *pflag.FlagSet) {
 	flagSet.Bool("version", false, "Print version number and exit")
 	flagSet.Bool(utils.QUIET, false, "Suppress non-warning, non-error log messages")
 	flagSet.String(utils.REDIRECT_DB, "", "Restore to the specified database instead of the database that was backed up")
+	flagSet.Bool(utils.REFRESH_MATVIEWS, false, "Refresh materialized views after restoring table data, as materialized views are restored without data")
+	flagSet.Bool(utils.RESIZE_CLUSTER, false, "Restore a backup taken on a cluster with a different number of segments. Requires --backup-dir on a filesystem shared by all segment hosts, as each segment reads the backup files of other original segments.")
+	flagSet.String(utils.STORAGE_OPTIONS_MAP, "", "A file containing fully-qualified table names or schema names and the storage options with which to restore each table, e.g. \"public.sales WITH (appendonly=true, orientation=column, compresstype=zstd)\"")
 	flagSet.Bool(utils.WITH_GLOBALS, false, "Restore global metadata")
+	flagSet.String(utils.TARGET_FLAVOR, TARGET_FLAVOR_GREENPLUM, "The type of database to restore to, either greenplum or postgres. Restoring to PostgreSQL removes Greenplum-specific syntax from the restored metadata.")
 	flagSet.String(utils.TIMESTAMP, "", "The timestamp to be restored, in the format YYYYMMDDHHMMSS")
+	flagSet.String(utils.USE_LIST, "", "A restore list file, as written by --write-list, specifying which backup entries to restore and in what order")
 	flagSet.Bool(utils.VERBOSE, false, "Print verbose log messages")
 	flagSet.Bool(utils.WITH_STATS, false, "Restore query plan statistics")
+	flagSet.String(utils.WRITE_LIST, "", "Write an editable list of the contents of the backup to the specified file and exit without restoring")
 }
 
 // This function handles setup that can be done before parsing flags.
@@ -75,18 +88,32 @@ func DoValidation(cmd *cobra.Command) {
 	if !backup_filepath.IsValidTimestamp(MustGetFlagString(utils.TIMESTAMP)) {
 		gplog.Fatal(errors.Errorf("Timestamp %s is invalid.  Timestamps must be in the format YYYYMMDDHHMMSS.", MustGetFlagString(utils.TIMESTAMP)), "")
 	}
+	err = utils.ValidateObjectTypes(MustGetFlagStringSlice(utils.INCLUDE_OBJECT_TYPE))
+	gplog.FatalOnError(err)
+	err = utils.ValidateObjectTypes(MustGetFlagStringSlice(utils.EXCLUDE_OBJECT_TYPE))
+	gplog.FatalOnError(err)
 }
 
 // This function handles setup that must be done after parsing flags.
 func DoSetup() {
 	SetLoggerVerbosity()
-	utils.CheckGpexpandRunning(utils.RestorePreventedByGpexpandMessage)
 	restoreStartTime = backup_history.CurrentTimestamp()
 	gplog.Info("Restore Key = %s", MustGetFlagString(utils.TIMESTAMP))
+	if MustGetFlagString(utils.WRITE_LIST) != "" && MustGetFlagString(utils.PLUGIN_CONFIG) == "" {
+		setupForRestoreListWithoutDatabase()
+		return
+	}
+	if !isPostgresTarget() && MustGetFlagString(utils.WRITE_LIST) == "" {
+		utils.CheckGpexpandRunning(utils.RestorePreventedByGpexpandMessage)
+	}
 
 	CreateConnectionPool("postgres")
-	segConfig := cluster.MustGetSegmentConfiguration(connectionPool)
-	globalCluster = cluster.NewCluster(segConfig)
+	if isPostgresTarget() {
+		globalCluster = getPostgresTargetCluster()
+	} else {
+		segConfig := cluster.MustGetSegmentConfiguration(connectionPool)
+		globalCluster = cluster.NewCluster(segConfig)
+	}
 	segPrefix := backup_filepath.ParseSegPrefix(MustGetFlagString(utils.BACKUP_DIR), MustGetFlagString(utils.TIMESTAMP))
 	globalFPInfo = backup_filepath.NewFilePathInfo(globalCluster, MustGetFlagString(utils.BACKUP_DIR), MustGetFlagString(utils.TIMESTAMP), segPrefix)
 
@@ -98,6 +125,12 @@ func DoSetup() {
 	}
 
 	BackupConfigurationValidation()
+	InitializeDistributionPolicies()
+	InitializeStorageOptionsMap()
+	if MustGetFlagString(utils.WRITE_LIST) != "" {
+		WriteRestoreListFile(MustGetFlagString(utils.WRITE_LIST))
+		return
+	}
 	metadataFilename := globalFPInfo.GetMetadataFilePath()
 	if !backupConfig.DataOnly {
 		gplog.Verbose("Metadata will be restored from %s", metadataFilename)
@@ -116,6 +149,11 @@ func DoSetup() {
 		connectionPool.Close()
 	}
 	InitializeConnectionPool(unquotedRestoreDatabase)
+	if isPostgresTarget() && !backupConfig.MetadataOnly && !MustGetFlagBool(utils.METADATA_ONLY) {
+		for _, fpInfo := range GetBackupFPInfoListFromRestorePlan() {
+			ValidateBackupDirectoryOnPostgresServer(fpInfo.GetDirForContent(0))
+		}
+	}
 
 	/*
 	 * We don't need to validate anything if we're creating the database; we
@@ -129,17 +167,52 @@ func DoSetup() {
 	}
 }
 
+/*
+ * Writing a restore list only reads the metadata files on the master, so no
+ * database connection is made and the cluster holds only the master.  A
+ * plugin still needs the segment configuration to recover those files, so
+ * --write-list with --plugin-config goes through the usual setup.
+ */
+func setupForRestoreListWithoutDatabase() {
+	backupDir := MustGetFlagString(utils.BACKUP_DIR)
+	timestamp := MustGetFlagString(utils.TIMESTAMP)
+	masterDataDir := ""
+	if backupDir == "" {
+		masterDataDir = operating.System.Getenv("MASTER_DATA_DIRECTORY")
+		if masterDataDir == "" {
+			gplog.Fatal(errors.Errorf("MASTER_DATA_DIRECTORY must be set to write a restore list for a backup that is not in a backup directory. Set it or use the --%s flag.", utils.BACKUP_DIR), "")
+		}
+	}
+	globalCluster = cluster.NewCluster([]cluster.SegConfig{{ContentID: -1, Hostname: "localhost", DataDir: masterDataDir}})
+	segPrefix := backup_filepath.ParseSegPrefix(backupDir, timestamp)
+	globalFPInfo = backup_filepath.NewFilePathInfo(globalCluster, backupDir, timestamp, segPrefix)
+	InitializeBackupConfig()
+	BackupConfigurationValidation()
+	InitializeDistributionPolicies()
+	InitializeStorageOptionsMap()
+	WriteRestoreListFile(MustGetFlagString(utils.WRITE_LIST))
+}
+
 func DoRestore() {
+	if MustGetFlagString(utils.WRITE_LIST) != "" {
+		return
+	}
 	gucStatements := setGUCsForConnection(nil, 0)
 	metadataFilename := globalFPInfo.GetMetadataFilePath()
 	isDataOnly := backupConfig.DataOnly || MustGetFlagBool(utils.DATA_ONLY)
 	isMetadataOnly := backupConfig.MetadataOnly || MustGetFlagBool(utils.METADATA_ONLY)
+	if !isMetadataOnly && !shouldRestoreObjectType("TABLE") {
+		gplog.Info("Skipping data restore because TABLE objects are excluded by the object type filters of the backup or restore")
+		isMetadataOnly = true
+	}
 	if !isDataOnly {
 		restorePredata(metadataFilename)
+	} else if isPostgresTarget() && !backupConfig.DataOnly {
+		InitializePostgresConverterTables(metadataFilename)
 	}
 
 	if !isMetadataOnly {
-		if MustGetFlagString(utils.PLUGIN_CONFIG) == "" {
+		if MustGetFlagString(utils.PLUGIN_CONFIG) == "" && !isResizeRestore() && !isPostgresTarget() {
 			backupFileCount := 2 // 1 for the actual data file, 1 for the segment TOC file
 			if !backupConfig.SingleDataFile {
 				backupFileCount = len(globalTOC.DataEntries)
@@ -153,6 +226,10 @@ func DoRestore() {
 		restorePostdata(metadataFilename)
 	}
 
+	if MustGetFlagBool(utils.REFRESH_MATVIEWS) && !isMetadataOnly {
+		refreshMaterializedViews(metadataFilename)
+	}
+
 	if MustGetFlagBool(utils.WITH_STATS) && backupConfig.WithStatistics {
 		restoreStatistics()
 	}
@@ -178,12 +255,18 @@ func restoreGlobal(metadataFilename string) {
 		objectTypes = append(objectTypes, "DATABASE")
 	}
 	gplog.Info("Restoring global metadata")
-	statements := GetRestoreMetadataStatements("global", metadataFilename, objectTypes, []string{}, false, false)
+	var statements []utils.StatementWithType
+	if len(restoreList) > 0 {
+		statements = utils.FilterStatementsByObjectType(GetRestoreListStatements("global", metadataFilename), objectTypes, []string{})
+	} else {
+		statements = GetRestoreMetadataStatements("global", metadataFilename, objectTypes, []string{}, false, false)
+	}
 	if MustGetFlagString(utils.REDIRECT_DB) != "" {
 		quotedDBName := utils.QuoteIdent(connectionPool, MustGetFlagString(utils.REDIRECT_DB))
 		statements = utils.SubstituteRedirectDatabaseInStatements(statements, backupConfig.DatabaseName, quotedDBName)
 	}
 	statements = utils.RemoveActiveRole(connectionPool.User, statements)
+	statements = filterStatementsByObjectType(statements)
 	ExecuteRestoreMetadataStatements(statements, "Global objects", nil, utils.PB_VERBOSE, false)
 	gplog.Info("Global database metadata restore complete")
 }
@@ -194,8 +277,15 @@ func restorePredata(metadataFilename string) {
 	}
 	gplog.Info("Restoring pre-data metadata")
 
-	schemaStatements := GetRestoreMetadataStatements("predata", metadataFilename, []string{"SCHEMA"}, []string{}, true, false)
-	statements := GetRestoreMetadataStatements("predata", metadataFilename, []string{}, []string{"SCHEMA"}, true, true)
+	var schemaStatements, statements []utils.StatementWithType
+	if len(restoreList) > 0 {
+		schemaStatements, statements = splitSchemaStatements(GetRestoreListStatements("predata", metadataFilename))
+	} else {
+		schemaStatements = GetRestoreMetadataStatements("predata", metadataFilename, []string{"SCHEMA"}, []string{}, true, false)
+		statements = GetRestoreMetadataStatements("predata", metadataFilename, []string{}, []string{"SCHEMA"}, true, true)
+		schemaStatements = filterStatementsByObjectType(schemaStatements)
+		statements = filterStatementsByObjectType(statements)
+	}
 
 	progressBar := utils.NewProgressBar(len(schemaStatements)+len(statements), "Pre-data objects restored: ", utils.PB_VERBOSE)
 	progressBar.Start()
@@ -226,10 +316,19 @@ func restoreData(fpInfoList []backup_filepath.FilePathInfo, gucStatements []util
 		filteredDataEntriesForTimestamp := toc.GetDataEntriesMatching(MustGetFlagStringSlice(utils.INCLUDE_SCHEMA),
 			MustGetFlagStringSlice(utils.EXCLUDE_SCHEMA), MustGetFlagStringSlice(utils.INCLUDE_RELATION),
 			MustGetFlagStringSlice(utils.EXCLUDE_RELATION), restorePlanTableFQNs)
+		if len(restoreList) > 0 {
+			filteredDataEntriesForTimestamp = utils.FilterDataEntriesByRestoreList(filteredDataEntriesForTimestamp, restoreList)
+		}
 		filteredDataEntries = append(filteredDataEntries, filteredDataEntriesForTimestamp)
 
 		totalTables += len(filteredDataEntriesForTimestamp)
 	}
+	if isResizeRestore() || hasDistributionPolicyOverrides() {
+		MarkReplicatedTables(filteredDataEntries, getTableStatements(globalFPInfo.GetMetadataFilePath()))
+	}
+	if isResizeRestore() {
+		gplog.Info("Restoring data from a %d-segment backup to a %d-segment cluster", backupConfig.SegmentCount, getDestinationSegmentCount())
+	}
 	dataProgressBar := utils.NewProgressBar(totalTables, "Tables restored: ", utils.PB_INFO)
 	dataProgressBar.Start()
 
@@ -241,8 +340,45 @@ func restoreData(fpInfoList []backup_filepath.FilePathInfo, gucStatements []util
 	dataProgressBar.Finish()
 	if wasTerminated {
 		gplog.Info("Data restore incomplete")
+		return
+	}
+	gplog.Info("Data restore complete")
+	if isResizeRestore() || hasDistributionPolicyOverrides() {
+		redistributeData(filteredDataEntries)
+	}
+}
+
+func redistributeData(filteredDataEntries [][]utils.MasterDataEntry) {
+	dataEntries := make([]utils.MasterDataEntry, 0)
+	for _, entries := range filteredDataEntries {
+		dataEntries = append(dataEntries, entries...)
+	}
+	gplog.Info("Redistributing restored table data across segments")
+	statements := GetRedistributeStatements(connectionPool, dataEntries)
+	if !isResizeRestore() {
+		// The data of a table that was replicated is all loaded onto one segment
+		replicatedInBackup := make(map[string]bool)
+		for _, entry := range dataEntries {
+			if entry.IsReplicated {
+				replicatedInBackup[utils.MakeFQN(entry.Schema, entry.Name)] = true
+			}
+		}
+		tablesToRedistribute := make([]utils.StatementWithType, 0)
+		for _, statement := range statements {
+			if isRedistributionRequired(statement.Name) || replicatedInBackup[statement.Name] {
+				tablesToRedistribute = append(tablesToRedistribute, statement)
+			}
+		}
+		statements = tablesToRedistribute
+	}
+	if len(statements) == 0 {
+		return
+	}
+	ExecuteStatementsAndCreateProgressBar(statements, "Tables redistributed", utils.PB_INFO, connectionPool.NumConns > 1)
+	if wasTerminated {
+		gplog.Info("Data redistribution incomplete")
 	} else {
-		gplog.Info("Data restore complete")
+		gplog.Info("Data redistribution complete")
 	}
 }
 
@@ -251,12 +387,24 @@ func restorePostdata(metadataFilename string) {
 		return
 	}
 	gplog.Info("Restoring post-data metadata")
-	statements := GetRestoreMetadataStatements("postdata", metadataFilename, []string{}, []string{}, true, true)
-	firstBatch, secondBatch := BatchPostdataStatements(statements)
+	var statements []utils.StatementWithType
+	if len(restoreList) > 0 {
+		statements = GetRestoreListStatements("postdata", metadataFilename)
+	} else {
+		statements = GetRestoreMetadataStatements("postdata", metadataFilename, []string{}, []string{}, true, true)
+		statements = filterStatementsByObjectType(statements)
+	}
 	progressBar := utils.NewProgressBar(len(statements), "Post-data objects restored: ", utils.PB_VERBOSE)
+	foreignKeyStatements := make([]utils.StatementWithType, 0)
+	if isPostgresTarget() {
+		statements, foreignKeyStatements = SplitForeignKeyConstraints(statements)
+	}
+	firstBatch, secondBatch := BatchPostdataStatements(statements)
 	progressBar.Start()
 	ExecuteRestoreMetadataStatements(firstBatch, "", progressBar, utils.PB_VERBOSE, connectionPool.NumConns > 1)
 	ExecuteRestoreMetadataStatements(secondBatch, "", progressBar, utils.PB_VERBOSE, connectionPool.NumConns > 1)
+	// Foreign keys are added one at a time, as two of them may lock the same tables in a different order
+	ExecuteRestoreMetadataStatements(foreignKeyStatements, "", progressBar, utils.PB_VERBOSE, false)
 	progressBar.Finish()
 	if wasTerminated {
 		gplog.Info("Post-data metadata restore incomplete")
@@ -265,13 +413,45 @@ func restorePostdata(metadataFilename string) {
 	}
 }
 
+/*
+ * Materialized views are refreshed one at a time in the order in which they
+ * were created, as a materialized view may be based on another one.
+ */
+func refreshMaterializedViews(metadataFilename string) {
+	if wasTerminated {
+		return
+	}
+	var statements []utils.StatementWithType
+	if len(restoreList) > 0 {
+		statements = GetRestoreListStatements("predata", metadataFilename)
+	} else {
+		statements = GetRestoreMetadataStatements("predata", metadataFilename, []string{"MATERIALIZED VIEW"}, []string{}, true, true)
+	}
+	statements = GetRefreshMaterializedViewStatements(filterStatementsByObjectType(statements))
+	if len(statements) == 0 {
+		return
+	}
+	gplog.Info("Refreshing materialized views")
+	ExecuteRestoreMetadataStatements(statements, "Materialized views", nil, utils.PB_VERBOSE, false)
+	if wasTerminated {
+		gplog.Info("Materialized view refresh incomplete")
+	} else {
+		gplog.Info("Materialized view refresh complete")
+	}
+}
+
 func restoreStatistics() {
 	if wasTerminated {
 		return
 	}
 	statisticsFilename := globalFPInfo.GetStatisticsFilePath()
 	gplog.Info("Restoring query planner statistics from %s", statisticsFilename)
-	statements := GetRestoreMetadataStatements("statistics", statisticsFilename, []string{}, []string{}, true, false)
+	var statements []utils.StatementWithType
+	if len(restoreList) > 0 {
+		statements = GetRestoreListStatements("statistics", statisticsFilename)
+	} else {
+		statements = GetRestoreMetadataStatements("statistics", statisticsFilename, []string{}, []string{}, true, false)
+	}
 	ExecuteRestoreMetadataStatements(statements, "Table statistics", nil, utils.PB_VERBOSE, false)
 	gplog.Info("Query planner statistics restore complete")
 }
@@ -322,9 +502,12 @@ func DoTeardown() {
 			return
 		}
 
-		reportFilename := globalFPInfo.GetRestoreReportFilePath(restoreStartTime)
-		utils.WriteRestoreReportFile(reportFilename, globalFPInfo.Timestamp, restoreStartTime, connectionPool, version, errMsg)
-		utils.EmailReport(globalCluster, globalFPInfo.Timestamp, reportFilename, "gprestore")
+		// Writing a restore list does not restore anything, so there is nothing to report
+		if MustGetFlagString(utils.WRITE_LIST) == "" {
+			reportFilename := globalFPInfo.GetRestoreReportFilePath(restoreStartTime)
+			utils.WriteRestoreReportFile(reportFilename, globalFPInfo.Timestamp, restoreStartTime, connectionPool, version, errMsg)
+			utils.EmailReport(globalCluster, globalFPInfo.Timestamp, reportFilename, "gprestore")
+		}
 		if pluginConfig != nil {
 			pluginConfig.CleanupPluginForRestore(globalCluster, globalFPInfo)
 			pluginConfig.DeletePluginConfigWhenEncrypting(globalCluster)
@@ -342,13 +525,17 @@ func DoCleanup(restoreFailed bool) {
 	}()
 
 	gplog.Verbose("Beginning cleanup")
+	if helperAgents != nil {
+		helperAgents.Stop()
+	}
 	if backupConfig != nil && backupConfig.SingleDataFile {
 		fpInfoList := GetBackupFPInfoListFromRestorePlan()
 		for _, fpInfo := range fpInfoList {
 			if restoreFailed {
 				utils.CleanUpSegmentHelperProcesses(globalCluster, fpInfo, "restore")
 			}
-			utils.CleanUpHelperFilesOnAllHosts(globalCluster, fpInfo)
+			// One agent is started for each job
+			utils.CleanUpHelperFilesOnAllHosts(globalCluster, fpInfo, MustGetFlagInt(utils.JOBS))
 			if wasTerminated { // These should all end on their own in a successful restore
 				utils.TerminateHangingCopySessions(connectionPool, fpInfo, "gprestore")
 			}
@@ -0,0 +1,297 @@
+package restore
+
+/*
+ * This file contains functions related to changing the storage options of
+ * restored tables, e.g. restoring a heap table as an append-optimized table
+ * or changing the compression of an append-optimized table.
+ */
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/iohelper"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+var (
+	storageOptionsMapRegex = regexp.MustCompile(`(?i)^(.+?)\s+WITH\s*\((.*)\)$`)
+	withClauseRegex        = regexp.MustCompile(`WITH \(`)
+
+	appendOnlyStorageOptions = []string{"blocksize", "checksum", "compresslevel", "compresstype", "orientation"}
+)
+
+type StorageOption struct {
+	Key   string
+	Value string
+}
+
+/*
+ * Each line of a storage options map contains either a fully-qualified table
+ * name or a schema name, followed by the storage options to use for that
+ * table or for every table in that schema, e.g.
+ *   public.sales WITH (appendonly=true, orientation=column, compresstype=zstd)
+ *   staging WITH (appendonly=false)
+ * Blank lines and lines beginning with # are ignored.
+ */
+func ParseStorageOptionsMap(lines []string) (map[string][]StorageOption, error) {
+	mappings := make(map[string][]StorageOption, len(lines))
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		match := storageOptionsMapRegex.FindStringSubmatch(line)
+		if match == nil {
+			return nil, errors.Errorf("Invalid storage options map entry on line %d: %s", i+1, line)
+		}
+		target := strings.TrimSpace(match[1])
+		if _, ok := mappings[target]; ok {
+			return nil, errors.Errorf("%s is listed more than once in the storage options map", target)
+		}
+		options, err := parseStorageOptions(match[2])
+		if err != nil {
+			return nil, errors.Errorf("Invalid storage options map entry on line %d: %s", i+1, err.Error())
+		}
+		mappings[target] = options
+	}
+	return mappings, nil
+}
+
+func parseStorageOptions(optionString string) ([]StorageOption, error) {
+	options := make([]StorageOption, 0)
+	for _, option := range splitTopLevel(optionString, ',') {
+		if strings.TrimSpace(option) == "" {
+			continue
+		}
+		keyValue := strings.SplitN(option, "=", 2)
+		if len(keyValue) != 2 || strings.TrimSpace(keyValue[0]) == "" {
+			return nil, errors.Errorf("%s is not of the form option=value", strings.TrimSpace(option))
+		}
+		options = append(options, StorageOption{Key: strings.ToLower(strings.TrimSpace(keyValue[0])), Value: strings.TrimSpace(keyValue[1])})
+	}
+	return options, nil
+}
+
+func printStorageOptions(options []StorageOption) string {
+	optionStrings := make([]string, len(options))
+	for i, option := range options {
+		optionStrings[i] = fmt.Sprintf("%s=%s", option.Key, option.Value)
+	}
+	return strings.Join(optionStrings, ", ")
+}
+
+func InitializeStorageOptionsMap() {
+	storageOptionsMap = make(map[string][]StorageOption)
+	if MustGetFlagString(utils.STORAGE_OPTIONS_MAP) == "" {
+		return
+	}
+	lines := iohelper.MustReadLinesFromFile(MustGetFlagString(utils.STORAGE_OPTIONS_MAP))
+	mappings, err := ParseStorageOptionsMap(lines)
+	gplog.FatalOnError(err)
+	targets := make([]string, 0, len(mappings))
+	for target := range mappings {
+		targets = append(targets, target)
+	}
+	// Each entry names either a table or a schema, so it is only missing if it is neither
+	missingSchemas := utils.NewSet(getFilterSchemasInBackupSet(targets))
+	missingTargets := make([]string, 0)
+	for _, target := range getFilterRelationsInBackupSet(targets) {
+		if missingSchemas.MatchesFilter(target) {
+			missingTargets = append(missingTargets, target)
+		}
+	}
+	if len(missingTargets) != 0 {
+		sort.Strings(missingTargets)
+		gplog.Warn("Could not find the following table(s) or schema(s) from the storage options map in the backup set: %s", strings.Join(missingTargets, ", "))
+	}
+	storageOptionsMap = mappings
+}
+
+// A mapping for a table takes precedence over a mapping for its schema
+func getStorageOptionsOverride(schema string, name string) []StorageOption {
+	if options, ok := storageOptionsMap[utils.MakeFQN(schema, name)]; ok {
+		return options
+	}
+	return storageOptionsMap[schema]
+}
+
+func overrideStorageOptions(statements []utils.StatementWithType) []utils.StatementWithType {
+	for i, statement := range statements {
+		if statement.ObjectType != "TABLE" || !isCreateTableStatement(statement.Statement) {
+			continue
+		}
+		if options := getStorageOptionsOverride(statement.Schema, statement.Name); options != nil {
+			statements[i].Statement = ReplaceStorageOptions(statement.Statement, options)
+		}
+	}
+	return statements
+}
+
+/*
+ * Applies the given options on top of the existing options of the table and
+ * of each of its partitions.  If the options change the orientation or the
+ * compression of the table, column encodings are removed, as they are not
+ * valid for row-oriented tables and would otherwise take precedence over the
+ * new table-level compression setting for column-oriented tables.
+ *
+ * A statement whose clauses cannot be parsed is returned unchanged.
+ */
+func ReplaceStorageOptions(statement string, options []StorageOption) string {
+	columnsStart := indexTopLevel(statement, "(")
+	if columnsStart == -1 {
+		return statement
+	}
+	columnsEnd := matchingParen(statement, columnsStart)
+	if columnsEnd == -1 {
+		return statement
+	}
+	createEnd := indexTopLevel(statement[columnsEnd:], ";")
+	if createEnd == -1 {
+		createEnd = len(statement)
+	} else {
+		createEnd += columnsEnd
+	}
+	columns := statement[columnsStart+1 : columnsEnd]
+	tableOptions := statement[columnsEnd+1 : createEnd]
+
+	partitionDef := ""
+	if partitionStart := indexTopLevel(tableOptions, "PARTITION BY "); partitionStart != -1 {
+		partitionDef = replacePartitionStorageOptions(tableOptions[partitionStart:], options)
+		tableOptions = tableOptions[:partitionStart]
+	}
+
+	existingOptionString := ""
+	if withStart := indexTopLevel(tableOptions, "WITH ("); withStart != -1 {
+		optionsStart := withStart + len("WITH ")
+		optionsEnd := matchingParen(tableOptions, optionsStart)
+		if optionsEnd == -1 {
+			return statement
+		}
+		existingOptionString = tableOptions[optionsStart+1 : optionsEnd]
+		tableOptions = tableOptions[:optionsStart+1] + mergeStorageOptions(existingOptionString, options) + tableOptions[optionsEnd:]
+	} else {
+		insertIndex := 0
+		if inheritsStart := indexTopLevel(tableOptions, "INHERITS ("); inheritsStart != -1 {
+			inheritsEnd := matchingParen(tableOptions, inheritsStart+len("INHERITS "))
+			if inheritsEnd == -1 {
+				return statement
+			}
+			insertIndex = inheritsEnd + 1
+		}
+		withClause := fmt.Sprintf(" WITH (%s)", mergeStorageOptions("", options))
+		tableOptions = tableOptions[:insertIndex] + withClause + tableOptions[insertIndex:]
+	}
+	if changesColumnStorage(existingOptionString, options) {
+		columns = columnEncodingRegex.ReplaceAllString(columns, "")
+	}
+
+	return statement[:columnsStart+1] + columns + ")" + tableOptions + partitionDef + statement[createEnd:]
+}
+
+/*
+ * Column encodings only hold compression settings, and are only valid for
+ * column-oriented tables, so they are kept unless the options change either.
+ */
+func changesColumnStorage(existingOptionString string, overrides []StorageOption) bool {
+	existingOptions, err := parseStorageOptions(existingOptionString)
+	if err != nil {
+		return true
+	}
+	existingValues := make(map[string]string, len(existingOptions))
+	for _, option := range existingOptions {
+		existingValues[option.Key] = strings.ToLower(option.Value)
+	}
+	for _, override := range overrides {
+		value := strings.ToLower(override.Value)
+		switch override.Key {
+		case "appendonly", "appendoptimized":
+			if value == "false" {
+				return true
+			}
+		case "compresstype", "compresslevel", "orientation":
+			if existingValues[override.Key] != value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/*
+ * Each partition in a partition definition has a WITH clause containing its
+ * table name and its own storage options, which take precedence over those
+ * of the parent table.
+ */
+func replacePartitionStorageOptions(partitionDef string, options []StorageOption) string {
+	result := ""
+	for {
+		location := withClauseRegex.FindStringIndex(partitionDef)
+		if location == nil {
+			return result + partitionDef
+		}
+		optionsStart := location[1] - 1
+		optionsEnd := matchingParen(partitionDef, optionsStart)
+		if optionsEnd == -1 {
+			return result + partitionDef
+		}
+		result += partitionDef[:optionsStart+1] + mergeStorageOptions(partitionDef[optionsStart+1:optionsEnd], options) + ")"
+		partitionDef = partitionDef[optionsEnd+1:]
+	}
+}
+
+/*
+ * If the overrides set appendonly=false, any options only valid for
+ * append-optimized tables are removed; if they set such options without
+ * setting appendonly, appendonly=true is implied.
+ */
+func mergeStorageOptions(existingOptionString string, overrides []StorageOption) string {
+	options, err := parseStorageOptions(existingOptionString)
+	if err != nil {
+		// The options were generated by gpbackup, so this should not happen, but keep them unchanged if it does
+		return existingOptionString
+	}
+	appendOnlyOptionSet := utils.NewSet(appendOnlyStorageOptions)
+	appendOnlyOverride := ""
+	hasAppendOnlyOptions := false
+	for _, override := range overrides {
+		if isAppendOnlyKey(override.Key) {
+			appendOnlyOverride = strings.ToLower(override.Value)
+		} else if appendOnlyOptionSet.MatchesFilter(override.Key) {
+			hasAppendOnlyOptions = true
+		}
+	}
+	if appendOnlyOverride == "" && hasAppendOnlyOptions {
+		overrides = append([]StorageOption{{Key: "appendonly", Value: "true"}}, overrides...)
+	}
+
+	for _, override := range overrides {
+		found := false
+		for i := range options {
+			if options[i].Key == override.Key || (isAppendOnlyKey(options[i].Key) && isAppendOnlyKey(override.Key)) {
+				options[i] = override
+				found = true
+			}
+		}
+		if !found {
+			options = append(options, override)
+		}
+	}
+
+	mergedOptions := make([]StorageOption, 0, len(options))
+	for _, option := range options {
+		if appendOnlyOverride == "false" && appendOnlyOptionSet.MatchesFilter(option.Key) {
+			continue
+		}
+		mergedOptions = append(mergedOptions, option)
+	}
+	return printStorageOptions(mergedOptions)
+}
+
+func isAppendOnlyKey(key string) bool {
+	return key == "appendonly" || key == "appendoptimized"
+}
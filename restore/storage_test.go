@@ -0,0 +1,92 @@
+package restore_test
+
+import (
+	"github.com/greenplum-db/gpbackup/restore"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("restore/storage tests", func() {
+	Describe("ParseStorageOptionsMap", func() {
+		It("parses table and schema names and storage options", func() {
+			lines := []string{"# a comment", "", "public.sales WITH (appendonly=true, orientation=column, compresstype=zstd)", "  staging   with (APPENDONLY=false)"}
+
+			mappings, err := restore.ParseStorageOptionsMap(lines)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mappings).To(Equal(map[string][]restore.StorageOption{
+				"public.sales": {{Key: "appendonly", Value: "true"}, {Key: "orientation", Value: "column"}, {Key: "compresstype", Value: "zstd"}},
+				"staging":      {{Key: "appendonly", Value: "false"}},
+			}))
+		})
+		It("returns an error for an entry without storage options", func() {
+			_, err := restore.ParseStorageOptionsMap([]string{"public.sales WITH (appendonly=true)", "public.events"})
+
+			Expect(err).To(MatchError("Invalid storage options map entry on line 2: public.events"))
+		})
+		It("returns an error for an invalid storage option", func() {
+			_, err := restore.ParseStorageOptionsMap([]string{"public.sales WITH (appendonly)"})
+
+			Expect(err).To(MatchError("Invalid storage options map entry on line 1: appendonly is not of the form option=value"))
+		})
+		It("returns an error for a table listed more than once", func() {
+			_, err := restore.ParseStorageOptionsMap([]string{"public.sales WITH (appendonly=true)", "public.sales WITH (appendonly=false)"})
+
+			Expect(err).To(MatchError("public.sales is listed more than once in the storage options map"))
+		})
+	})
+	Describe("ReplaceStorageOptions", func() {
+		columnar := []restore.StorageOption{{Key: "appendonly", Value: "true"}, {Key: "orientation", Value: "column"}, {Key: "compresstype", Value: "zstd"}}
+		It("adds a WITH clause to a heap table", func() {
+			statement := "\n\nCREATE TABLE public.sales (\n\tid integer\n) DISTRIBUTED BY (id);"
+
+			Expect(restore.ReplaceStorageOptions(statement, columnar)).To(Equal("\n\nCREATE TABLE public.sales (\n\tid integer\n) WITH (appendonly=true, orientation=column, compresstype=zstd) DISTRIBUTED BY (id);"))
+		})
+		It("adds a WITH clause after the INHERITS clause", func() {
+			statement := "CREATE TABLE public.sales (\n\tid integer\n) INHERITS (public.parent) DISTRIBUTED BY (id);"
+
+			Expect(restore.ReplaceStorageOptions(statement, columnar)).To(Equal("CREATE TABLE public.sales (\n\tid integer\n) INHERITS (public.parent) WITH (appendonly=true, orientation=column, compresstype=zstd) DISTRIBUTED BY (id);"))
+		})
+		It("merges the options into an existing WITH clause and removes column encodings", func() {
+			statement := `CREATE TABLE public.sales (
+	id integer ENCODING (compresstype=zlib,blocksize=32768,compresslevel=1),
+	note text DEFAULT 'WITH (x)'
+) WITH (appendonly=true, orientation=row, fillfactor=50) TABLESPACE test_tablespace DISTRIBUTED BY (id);
+ALTER TABLE ONLY public.sales ALTER COLUMN id SET STATISTICS 10;`
+
+			Expect(restore.ReplaceStorageOptions(statement, columnar)).To(Equal(`CREATE TABLE public.sales (
+	id integer,
+	note text DEFAULT 'WITH (x)'
+) WITH (appendonly=true, orientation=column, fillfactor=50, compresstype=zstd) TABLESPACE test_tablespace DISTRIBUTED BY (id);
+ALTER TABLE ONLY public.sales ALTER COLUMN id SET STATISTICS 10;`))
+		})
+		It("removes append-optimized options when converting to a heap table", func() {
+			statement := "CREATE TABLE public.sales (\n\tid integer ENCODING (compresstype=zlib)\n) WITH (appendonly=true, orientation=column, compresstype=zlib, compresslevel=1) DISTRIBUTED BY (id);"
+
+			Expect(restore.ReplaceStorageOptions(statement, []restore.StorageOption{{Key: "appendonly", Value: "false"}})).To(Equal("CREATE TABLE public.sales (\n\tid integer\n) WITH (appendonly=false) DISTRIBUTED BY (id);"))
+		})
+		It("keeps column encodings if the orientation and compression are unchanged", func() {
+			statement := "CREATE TABLE public.sales (\n\tid integer ENCODING (compresstype=zlib)\n) WITH (appendonly=true, orientation=column, compresstype=zstd) DISTRIBUTED BY (id);"
+			blockSize := []restore.StorageOption{{Key: "orientation", Value: "column"}, {Key: "blocksize", Value: "65536"}}
+
+			Expect(restore.ReplaceStorageOptions(statement, blockSize)).To(Equal("CREATE TABLE public.sales (\n\tid integer ENCODING (compresstype=zlib)\n) WITH (appendonly=true, orientation=column, compresstype=zstd, blocksize=65536) DISTRIBUTED BY (id);"))
+		})
+		It("leaves the statement unchanged if the WITH clause is not closed", func() {
+			statement := "CREATE TABLE public.sales (\n\tid integer\n) WITH (appendonly=true DISTRIBUTED BY id;"
+
+			Expect(restore.ReplaceStorageOptions(statement, columnar)).To(Equal(statement))
+		})
+		It("leaves the statement unchanged if the INHERITS clause is not closed", func() {
+			statement := "CREATE TABLE public.sales (\n\tid integer\n) INHERITS (public.parent DISTRIBUTED BY id;"
+
+			Expect(restore.ReplaceStorageOptions(statement, columnar)).To(Equal(statement))
+		})
+		It("replaces the options of each partition and implies appendonly=true for compression options", func() {
+			statement := "CREATE TABLE public.sales (\n\tid integer,\n\tyear integer\n) DISTRIBUTED BY (id) PARTITION BY RANGE(year)\n(\nSTART (2017) END (2018) WITH (tablename='sales_1_prt_1', appendonly=false ),\nSTART (2018) END (2019) WITH (tablename='sales_1_prt_2', appendonly=true, compresstype=zlib )\n);"
+			compression := []restore.StorageOption{{Key: "compresstype", Value: "zstd"}, {Key: "compresslevel", Value: "5"}}
+
+			Expect(restore.ReplaceStorageOptions(statement, compression)).To(Equal("CREATE TABLE public.sales (\n\tid integer,\n\tyear integer\n) WITH (appendonly=true, compresstype=zstd, compresslevel=5) DISTRIBUTED BY (id) PARTITION BY RANGE(year)\n(\nSTART (2017) END (2018) WITH (tablename='sales_1_prt_1', appendonly=true, compresstype=zstd, compresslevel=5),\nSTART (2018) END (2019) WITH (tablename='sales_1_prt_2', appendonly=true, compresstype=zstd, compresslevel=5)\n);"))
+		})
+	})
+})
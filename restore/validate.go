@@ -77,6 +77,18 @@ func getFilterSchemasInBackupSet(schemaList []string) []string {
 }
 
 func GenerateRestoreRelationList() []string {
+	if len(restoreList) > 0 {
+		relationList := make([]string, 0)
+		for _, entry := range restoreList {
+			if entry.Section == "data" {
+				relationList = append(relationList, utils.MakeFQN(entry.Schema, entry.Name))
+			}
+		}
+		return relationList
+	}
+	if !shouldRestoreObjectType("TABLE") {
+		return []string{}
+	}
 	includeRelations := MustGetFlagStringSlice(utils.INCLUDE_RELATION)
 	if len(includeRelations) > 0 {
 		return includeRelations
@@ -162,7 +174,7 @@ func getFilterRelationsInBackupSet(relationList []string) []string {
 		relationMap[relation] = true
 	}
 	for _, entry := range globalTOC.PredataEntries {
-		if entry.ObjectType != "TABLE" && entry.ObjectType != "SEQUENCE" && entry.ObjectType != "VIEW" {
+		if entry.ObjectType != "TABLE" && entry.ObjectType != "SEQUENCE" && entry.ObjectType != "VIEW" && entry.ObjectType != "MATERIALIZED VIEW" {
 			continue
 		}
 		fqn := utils.MakeFQN(entry.Schema, entry.Name)
@@ -216,9 +228,6 @@ END AS string;`, utils.EscapeSingleQuotes(unquotedDBName))
 }
 
 func ValidateBackupFlagCombinations() {
-	if backupConfig.SingleDataFile && MustGetFlagInt(utils.JOBS) != 1 {
-		gplog.Fatal(errors.Errorf("Cannot use jobs flag when restoring backups with a single data file per segment."), "")
-	}
 	if (backupConfig.IncludeTableFiltered || backupConfig.DataOnly) && MustGetFlagBool(utils.WITH_GLOBALS) {
 		gplog.Fatal(errors.Errorf("Global metadata is not backed up in table-filtered or data-only backups."), "")
 	}
@@ -229,6 +238,32 @@ func ValidateBackupFlagCombinations() {
 		gplog.Fatal(errors.Errorf("Cannot use metadata-only flag when restoring data-only backup"), "")
 	}
 	validateBackupFlagPluginCombinations()
+	validateBackupSegmentCount()
+}
+
+func validateBackupSegmentCount() {
+	if backupConfig.MetadataOnly || MustGetFlagBool(utils.METADATA_ONLY) {
+		return
+	}
+	if isPostgresTarget() {
+		if backupConfig.SingleDataFile {
+			gplog.Fatal(errors.Errorf("Cannot restore a backup with a single data file per segment to a PostgreSQL database."), "")
+		}
+		if backupConfig.SegmentCount == 0 {
+			gplog.Fatal(errors.Errorf("Cannot restore data to a PostgreSQL database from a backup that does not record its segment count. Backups taken with an earlier version of gpbackup are not supported."), "")
+		}
+		return
+	}
+	destSegCount := getDestinationSegmentCount()
+	if backupConfig.SegmentCount == 0 {
+		if MustGetFlagBool(utils.RESIZE_CLUSTER) {
+			gplog.Fatal(errors.Errorf("The --resize-cluster flag cannot be used to restore a backup that does not record its segment count. Backups taken with an earlier version of gpbackup are not supported."), "")
+		}
+		return
+	}
+	if backupConfig.SegmentCount != destSegCount && !MustGetFlagBool(utils.RESIZE_CLUSTER) {
+		gplog.Fatal(errors.Errorf("Backup was taken on a cluster with %d segments, but the restore cluster has %d segments. Use the --resize-cluster flag to restore to a cluster with a different number of segments.", backupConfig.SegmentCount, destSegCount), "")
+	}
 }
 
 func validateBackupFlagPluginCombinations() {
@@ -247,5 +282,38 @@ func ValidateFlagCombinations(flags *pflag.FlagSet) {
 	utils.CheckExclusiveFlags(flags, utils.EXCLUDE_SCHEMA, utils.INCLUDE_SCHEMA)
 	utils.CheckExclusiveFlags(flags, utils.EXCLUDE_SCHEMA, utils.EXCLUDE_RELATION, utils.INCLUDE_RELATION, utils.EXCLUDE_RELATION_FILE, utils.INCLUDE_RELATION_FILE)
 	utils.CheckExclusiveFlags(flags, utils.METADATA_ONLY, utils.DATA_ONLY)
+	utils.CheckExclusiveFlags(flags, utils.DATA_ONLY, utils.INCLUDE_OBJECT_TYPE, utils.EXCLUDE_OBJECT_TYPE)
 	utils.CheckExclusiveFlags(flags, utils.PLUGIN_CONFIG, utils.BACKUP_DIR)
+	utils.CheckExclusiveFlags(flags, utils.USE_LIST, utils.WRITE_LIST)
+	utils.CheckExclusiveFlags(flags, utils.METADATA_ONLY, utils.REFRESH_MATVIEWS)
+	if flags.Changed(utils.RESIZE_CLUSTER) && !flags.Changed(utils.BACKUP_DIR) {
+		gplog.Fatal(errors.Errorf("--resize-cluster must be specified with --backup-dir"), "")
+	}
+	targetFlavor, _ := flags.GetString(utils.TARGET_FLAVOR)
+	if targetFlavor != TARGET_FLAVOR_GREENPLUM && targetFlavor != TARGET_FLAVOR_POSTGRES {
+		gplog.Fatal(errors.Errorf("Invalid target flavor %s. Valid values are %s and %s.", targetFlavor, TARGET_FLAVOR_GREENPLUM, TARGET_FLAVOR_POSTGRES), "")
+	}
+	if targetFlavor == TARGET_FLAVOR_POSTGRES {
+		if !flags.Changed(utils.BACKUP_DIR) {
+			gplog.Fatal(errors.Errorf("--target-flavor postgres must be specified with --backup-dir"), "")
+		}
+		for _, unsupportedFlag := range []string{utils.RESIZE_CLUSTER, utils.WITH_STATS, utils.DISTRIBUTION_POLICY_MAP, utils.DISTRIBUTED_RANDOMLY, utils.STORAGE_OPTIONS_MAP} {
+			if flags.Changed(unsupportedFlag) {
+				gplog.Fatal(errors.Errorf("--%s cannot be used with --target-flavor postgres", unsupportedFlag), "")
+			}
+		}
+	}
+	utils.CheckExclusiveFlags(flags, utils.DATA_ONLY, utils.INCLUDE_DEPENDENCIES)
+	utils.CheckExclusiveFlags(flags, utils.DATA_ONLY, utils.DISTRIBUTION_POLICY_MAP, utils.DISTRIBUTED_RANDOMLY)
+	utils.CheckExclusiveFlags(flags, utils.DATA_ONLY, utils.STORAGE_OPTIONS_MAP)
+	if flags.Changed(utils.INCLUDE_DEPENDENCIES) && !flags.Changed(utils.INCLUDE_RELATION) && !flags.Changed(utils.INCLUDE_RELATION_FILE) {
+		gplog.Fatal(errors.Errorf("--include-dependencies must be specified with --include-table or --include-table-file"), "")
+	}
+	if decompressionBlocks, _ := flags.GetInt(utils.DECOMPRESSION_BLOCKS); decompressionBlocks < 1 {
+		gplog.Fatal(errors.Errorf("Decompression blocks must be at least 1"), "")
+	}
+	for _, filterFlag := range []string{utils.INCLUDE_SCHEMA, utils.EXCLUDE_SCHEMA, utils.INCLUDE_RELATION, utils.EXCLUDE_RELATION,
+		utils.INCLUDE_RELATION_FILE, utils.EXCLUDE_RELATION_FILE, utils.INCLUDE_OBJECT_TYPE, utils.EXCLUDE_OBJECT_TYPE} {
+		utils.CheckExclusiveFlags(flags, utils.USE_LIST, filterFlag)
+	}
 }
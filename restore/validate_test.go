@@ -1,6 +1,7 @@
 package restore_test
 
 import (
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
 	"github.com/greenplum-db/gp-common-go-libs/testhelper"
 	"github.com/greenplum-db/gpbackup/backup_history"
 	"github.com/greenplum-db/gpbackup/restore"
@@ -30,10 +31,10 @@ var _ = Describe("restore/validate tests", func() {
 			toc, backupfile = testutils.InitializeTestTOC(buffer, "predata")
 			backupfile.ByteCount = table1Len
 			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: "schema1", Name: "table1", ObjectType: "TABLE"}, 0, backupfile.ByteCount)
-			toc.AddMasterDataEntry("schema1", "table1", 1, "(i)", 0, "")
+			toc.AddMasterDataEntry("schema1", "table1", 1, "(i)", 0, "", false)
 			backupfile.ByteCount += table2Len
 			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: "schema2", Name: "table2", ObjectType: "TABLE"}, table1Len, backupfile.ByteCount)
-			toc.AddMasterDataEntry("schema2", "table2", 2, "(j)", 0, "")
+			toc.AddMasterDataEntry("schema2", "table2", 2, "(j)", 0, "", false)
 			backupfile.ByteCount += sequenceLen
 			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: "schema", Name: "somesequence", ObjectType: "SEQUENCE"}, table1Len+table2Len, backupfile.ByteCount)
 			restore.SetTOC(toc)
@@ -71,10 +72,10 @@ var _ = Describe("restore/validate tests", func() {
 	Describe("GenerateRestoreRelationList", func() {
 		BeforeEach(func() {
 			toc, _ = testutils.InitializeTestTOC(buffer, "metadata")
-			toc.AddMasterDataEntry("s1", "table1", 1, "(j)", 0, "")
-			toc.AddMasterDataEntry("s1", "table2", 2, "(j)", 0, "")
-			toc.AddMasterDataEntry("s2", "table1", 3, "(j)", 0, "")
-			toc.AddMasterDataEntry("s2", "table2", 4, "(j)", 0, "")
+			toc.AddMasterDataEntry("s1", "table1", 1, "(j)", 0, "", false)
+			toc.AddMasterDataEntry("s1", "table2", 2, "(j)", 0, "", false)
+			toc.AddMasterDataEntry("s2", "table1", 3, "(j)", 0, "", false)
+			toc.AddMasterDataEntry("s2", "table2", 4, "(j)", 0, "", false)
 			restore.SetTOC(toc)
 			cmdFlags.Set(utils.INCLUDE_RELATION, "")
 			cmdFlags.Set(utils.EXCLUDE_RELATION, "")
@@ -129,6 +130,48 @@ var _ = Describe("restore/validate tests", func() {
 
 			Expect(resultRelations).To(ConsistOf(expectedRelations))
 		})
+		It("returns only tables with data entries in the restore list", func() {
+			restore.SetRestoreList([]utils.RestoreListEntry{
+				{Section: "data", Index: 3, ObjectType: "TABLE DATA", Schema: "s2", Name: "table2"},
+				{Section: "predata", Index: 0, ObjectType: "TABLE", Schema: "s1", Name: "table1"},
+				{Section: "data", Index: 0, ObjectType: "TABLE DATA", Schema: "s1", Name: "table1"},
+			})
+			defer restore.SetRestoreList(nil)
+
+			resultRelations := restore.GenerateRestoreRelationList()
+
+			Expect(resultRelations).To(Equal([]string{"s2.table2", "s1.table1"}))
+		})
+		It("returns no tables if tables are excluded by object type", func() {
+			cmdFlags.Set(utils.EXCLUDE_OBJECT_TYPE, "TABLE")
+
+			resultRelations := restore.GenerateRestoreRelationList()
+
+			Expect(resultRelations).To(BeEmpty())
+		})
+		It("returns no tables if tables are not among the included object types", func() {
+			cmdFlags.Set(utils.INCLUDE_OBJECT_TYPE, "FUNCTION,VIEW")
+
+			resultRelations := restore.GenerateRestoreRelationList()
+
+			Expect(resultRelations).To(BeEmpty())
+		})
+		It("returns no tables if tables were excluded by object type from the backup", func() {
+			restore.SetBackupConfig(&backup_history.BackupConfig{ExcludeObjectTypes: []string{"TABLE"}})
+			defer restore.SetBackupConfig(&backup_history.BackupConfig{})
+
+			resultRelations := restore.GenerateRestoreRelationList()
+
+			Expect(resultRelations).To(BeEmpty())
+		})
+		It("returns no tables if tables were not among the object types included in the backup", func() {
+			restore.SetBackupConfig(&backup_history.BackupConfig{IncludeObjectTypes: []string{"FUNCTION"}})
+			defer restore.SetBackupConfig(&backup_history.BackupConfig{})
+
+			resultRelations := restore.GenerateRestoreRelationList()
+
+			Expect(resultRelations).To(BeEmpty())
+		})
 	})
 	Describe("ValidateRelationsInRestoreDatabase", func() {
 		BeforeEach(func() {
@@ -193,10 +236,10 @@ var _ = Describe("restore/validate tests", func() {
 		BeforeEach(func() {
 			toc, backupfile = testutils.InitializeTestTOC(buffer, "predata")
 			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: "schema1", Name: "table1", ObjectType: "TABLE"}, 0, backupfile.ByteCount)
-			toc.AddMasterDataEntry("schema1", "table1", 1, "(i)", 0, "")
+			toc.AddMasterDataEntry("schema1", "table1", 1, "(i)", 0, "", false)
 
 			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: "schema2", Name: "table2", ObjectType: "TABLE"}, 0, backupfile.ByteCount)
-			toc.AddMasterDataEntry("schema2", "table2", 2, "(j)", 0, "")
+			toc.AddMasterDataEntry("schema2", "table2", 2, "(j)", 0, "", false)
 
 			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: "schema1", Name: "somesequence", ObjectType: "SEQUENCE"}, 0, backupfile.ByteCount)
 			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: "schema1", Name: "someview", ObjectType: "VIEW"}, 0, backupfile.ByteCount)
@@ -260,6 +303,40 @@ var _ = Describe("restore/validate tests", func() {
 			restore.ValidateIncludeRelationsInBackupSet(filterList)
 		})
 	})
+	Describe("ValidateBackupFlagCombinations", func() {
+		BeforeEach(func() {
+			restore.SetCluster(cluster.NewCluster([]cluster.SegConfig{{ContentID: -1}, {ContentID: 0}, {ContentID: 1}}))
+		})
+		It("passes when the backup and restore clusters have the same number of segments", func() {
+			restore.SetBackupConfig(&backup_history.BackupConfig{SegmentCount: 2})
+			restore.ValidateBackupFlagCombinations()
+		})
+		It("passes when the backup does not record its segment count", func() {
+			restore.SetBackupConfig(&backup_history.BackupConfig{})
+			restore.ValidateBackupFlagCombinations()
+		})
+		It("panics when the segment counts differ and --resize-cluster is not passed", func() {
+			restore.SetBackupConfig(&backup_history.BackupConfig{SegmentCount: 16})
+			defer testhelper.ShouldPanicWithMessage("Backup was taken on a cluster with 16 segments, but the restore cluster has 2 segments. Use the --resize-cluster flag")
+			restore.ValidateBackupFlagCombinations()
+		})
+		It("passes when the segment counts differ and --resize-cluster is passed", func() {
+			cmdFlags.Set(utils.RESIZE_CLUSTER, "true")
+			restore.SetBackupConfig(&backup_history.BackupConfig{SegmentCount: 16})
+			restore.ValidateBackupFlagCombinations()
+		})
+		It("passes when the segment counts differ for a metadata-only restore", func() {
+			cmdFlags.Set(utils.METADATA_ONLY, "true")
+			restore.SetBackupConfig(&backup_history.BackupConfig{SegmentCount: 16})
+			restore.ValidateBackupFlagCombinations()
+		})
+		It("panics when --resize-cluster is passed for a backup that does not record its segment count", func() {
+			cmdFlags.Set(utils.RESIZE_CLUSTER, "true")
+			restore.SetBackupConfig(&backup_history.BackupConfig{})
+			defer testhelper.ShouldPanicWithMessage("The --resize-cluster flag cannot be used to restore a backup that does not record its segment count.")
+			restore.ValidateBackupFlagCombinations()
+		})
+	})
 	Describe("ValidateDatabaseExistence", func() {
 		It("panics if createdb passed when db exists", func() {
 			db_exists := sqlmock.NewRows([]string{"string"}).
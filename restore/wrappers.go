@@ -3,8 +3,8 @@ package restore
 import (
 	"fmt"
 	"path/filepath"
-	"strings"
 	"strconv"
+	"strings"
 
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
@@ -12,6 +12,7 @@ import (
 	"github.com/greenplum-db/gpbackup/backup_filepath"
 	"github.com/greenplum-db/gpbackup/backup_history"
 	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
 )
 
 /*
@@ -37,12 +38,20 @@ func SetLoggerVerbosity() {
 
 func CreateConnectionPool(unquotedDBName string) {
 	connectionPool = dbconn.NewDBConnFromEnvironment(unquotedDBName)
+	if isPostgresTarget() {
+		connectToPostgres(connectionPool, MustGetFlagInt(utils.JOBS))
+		return
+	}
 	connectionPool.MustConnect(MustGetFlagInt(utils.JOBS))
 	utils.ValidateGPDBVersionCompatibility(connectionPool)
 }
 
 func InitializeConnectionPool(unquotedDBName string) {
 	CreateConnectionPool(unquotedDBName)
+	if isPostgresTarget() {
+		initializePostgresConnectionPool()
+		return
+	}
 	setupQuery := `
 SET application_name TO 'gprestore';
 SET search_path TO pg_catalog;
@@ -58,6 +67,10 @@ SET default_with_oids = off;
 	}
 	if connectionPool.Version.AtLeast("5") {
 		setupQuery += "SET gp_ignore_error_table = on;\n"
+		if isResizeRestore() || hasDistributionPolicyOverrides() {
+			// Rows are loaded onto segments that do not match their distribution key until the tables are reorganized
+			setupQuery += "SET gp_enable_segment_copy_checking = off;\n"
+		}
 	}
 	if connectionPool.Version.Before("6") {
 		setupQuery += "SET allow_system_table_mods = 'DML';\n"
@@ -106,7 +119,10 @@ func InitializeBackupConfig() {
 	backupConfig = backup_history.ReadConfigFile(globalFPInfo.GetConfigFilePath())
 	utils.InitializePipeThroughParameters(backupConfig.Compressed, 0)
 	utils.EnsureBackupVersionCompatibility(backupConfig.BackupVersion, version)
-	utils.EnsureDatabaseVersionCompatibility(backupConfig.DatabaseVersion, connectionPool.Version)
+	// There is no connection when only writing a restore list
+	if connectionPool != nil {
+		utils.EnsureDatabaseVersionCompatibility(backupConfig.DatabaseVersion, connectionPool.Version)
+	}
 }
 
 func InitializeFilterLists() {
@@ -141,11 +157,52 @@ func BackupConfigurationValidation() {
 		SetRestorePlanForLegacyBackup(globalTOC, globalFPInfo.Timestamp, backupConfig)
 	}
 
+	if MustGetFlagString(utils.USE_LIST) != "" {
+		InitializeRestoreList(MustGetFlagString(utils.USE_LIST))
+	}
+	if MustGetFlagBool(utils.INCLUDE_DEPENDENCIES) {
+		IncludeRelationDependencies()
+	}
+
 	ValidateBackupFlagCombinations()
 
 	validateFilterListsInBackupSet()
 }
 
+func InitializeRestoreList(listFilename string) {
+	listEntries, err := utils.ParseRestoreList(iohelper.MustReadLinesFromFile(listFilename))
+	gplog.FatalOnError(err)
+	restoreList, err = globalTOC.ResolveRestoreList(listEntries)
+	gplog.FatalOnError(err)
+	if len(restoreList) == 0 {
+		gplog.Fatal(errors.Errorf("Restore list %s does not contain any entries", listFilename), "")
+	}
+	gplog.Verbose("Restoring %d entries from restore list %s", len(restoreList), listFilename)
+}
+
+func IncludeRelationDependencies() {
+	if len(globalTOC.Dependencies) == 0 {
+		gplog.Warn("Backup %s does not contain dependency information; only the specified relations will be restored", globalFPInfo.Timestamp)
+		return
+	}
+	dependencies := globalTOC.GetDependencyClosure(MustGetFlagStringSlice(utils.INCLUDE_RELATION))
+	dependencyKeys := make([]string, 0, len(dependencies))
+	for _, dependency := range dependencies {
+		gplog.Verbose("Including dependency %s", dependency.DependencyKey())
+		dependencyKeys = append(dependencyKeys, dependency.DependencyKey())
+	}
+	globalTOC.IncludeDependencies(dependencyKeys)
+	gplog.Info("Including %d object(s) that the specified relations depend on", len(dependencyKeys))
+}
+
+func WriteRestoreListFile(listFilename string) {
+	listFile := iohelper.MustOpenFileForWriting(listFilename)
+	globalTOC.WriteRestoreList(listFile, globalFPInfo.Timestamp)
+	err := listFile.Close()
+	gplog.FatalOnError(err)
+	gplog.Info("Restore list written to %s", listFilename)
+}
+
 func SetRestorePlanForLegacyBackup(toc *utils.TOC, backupTimestamp string, backupConfig *backup_history.BackupConfig) {
 	tableFQNs := make([]string, 0, len(toc.DataEntries))
 	for _, entry := range toc.DataEntries {
@@ -226,6 +283,7 @@ func FindHistoricalPluginVersion(timestamp string) string {
 
 func GetRestoreMetadataStatements(section string, filename string, includeObjectTypes []string, excludeObjectTypes []string, filterSchemas bool, filterRelations bool) []utils.StatementWithType {
 	metadataFile := iohelper.MustOpenFileForReading(filename)
+	defer metadataFile.Close()
 	var statements []utils.StatementWithType
 	var inSchemas, exSchemas, inRelations, exRelations []string
 	if len(includeObjectTypes) > 0 || len(excludeObjectTypes) > 0 || filterSchemas || filterRelations {
@@ -245,9 +303,96 @@ func GetRestoreMetadataStatements(section string, filename string, includeObject
 		}
 	}
 	statements = globalTOC.GetSQLStatementForObjectTypes(section, metadataFile, includeObjectTypes, excludeObjectTypes, inSchemas, exSchemas, inRelations, exRelations)
+	if isPostgresTarget() {
+		statements = postgresConverter.ConvertStatements(statements)
+	} else {
+		if hasDistributionPolicyOverrides() {
+			statements = overrideDistributionPolicies(statements)
+		}
+		if len(storageOptionsMap) > 0 {
+			statements = overrideStorageOptions(statements)
+		}
+	}
 	return statements
 }
 
+func GetRestoreListStatements(section string, filename string) []utils.StatementWithType {
+	metadataFile := iohelper.MustOpenFileForReading(filename)
+	defer metadataFile.Close()
+	statements := globalTOC.GetSQLStatementForRestoreList(section, metadataFile, restoreList)
+	if isPostgresTarget() {
+		statements = postgresConverter.ConvertStatements(statements)
+	} else {
+		if hasDistributionPolicyOverrides() {
+			statements = overrideDistributionPolicies(statements)
+		}
+		if len(storageOptionsMap) > 0 {
+			statements = overrideStorageOptions(statements)
+		}
+	}
+	return statements
+}
+
+/*
+ * Schemas are restored separately from other pre-data objects so that an
+ * already-existing schema does not cause the restore to fail.
+ */
+func splitSchemaStatements(statements []utils.StatementWithType) ([]utils.StatementWithType, []utils.StatementWithType) {
+	schemaStatements := make([]utils.StatementWithType, 0)
+	otherStatements := make([]utils.StatementWithType, 0)
+	for _, statement := range statements {
+		if statement.ObjectType == "SCHEMA" {
+			schemaStatements = append(schemaStatements, statement)
+		} else {
+			otherStatements = append(otherStatements, statement)
+		}
+	}
+	return schemaStatements, otherStatements
+}
+
+func getObjectTypeFilterSet() *utils.FilterSet {
+	return utils.NewObjectTypeFilterSet(MustGetFlagStringSlice(utils.INCLUDE_OBJECT_TYPE), MustGetFlagStringSlice(utils.EXCLUDE_OBJECT_TYPE))
+}
+
+/*
+ * An object type left out of the backup by its object type filters is not
+ * restored either, which matters for table data, as backups taken before
+ * TABLE was checked for data still hold the data of every table.
+ */
+func shouldRestoreObjectType(objectType string) bool {
+	if backupConfig != nil && !utils.NewObjectTypeFilterSet(backupConfig.IncludeObjectTypes, backupConfig.ExcludeObjectTypes).MatchesFilter(objectType) {
+		return false
+	}
+	return getObjectTypeFilterSet().MatchesFilter(objectType)
+}
+
+func filterStatementsByObjectType(statements []utils.StatementWithType) []utils.StatementWithType {
+	return utils.FilterStatementsByObjectType(statements, MustGetFlagStringSlice(utils.INCLUDE_OBJECT_TYPE), MustGetFlagStringSlice(utils.EXCLUDE_OBJECT_TYPE))
+}
+
+/*
+ * The metadata statements of a materialized view, e.g. its comment and owner,
+ * have the same object type as its CREATE statement, so only the first
+ * statement for each materialized view is used.
+ */
+func GetRefreshMaterializedViewStatements(statements []utils.StatementWithType) []utils.StatementWithType {
+	refreshStatements := make([]utils.StatementWithType, 0)
+	refreshed := make(map[string]bool)
+	for _, statement := range statements {
+		if statement.ObjectType != "MATERIALIZED VIEW" {
+			continue
+		}
+		fqn := utils.MakeFQN(statement.Schema, statement.Name)
+		if refreshed[fqn] {
+			continue
+		}
+		refreshed[fqn] = true
+		refreshStatements = append(refreshStatements, utils.StatementWithType{Schema: statement.Schema, Name: statement.Name,
+			ObjectType: "MATERIALIZED VIEW", Statement: fmt.Sprintf("REFRESH MATERIALIZED VIEW %s;", fqn)})
+	}
+	return refreshStatements
+}
+
 func ExecuteRestoreMetadataStatements(statements []utils.StatementWithType, objectsTitle string, progressBar utils.ProgressBar, showProgressBar int, executeInParallel bool) {
 	if progressBar == nil {
 		ExecuteStatementsAndCreateProgressBar(statements, objectsTitle, showProgressBar, executeInParallel)
@@ -97,6 +97,21 @@ var _ = Describe("wrapper tests", func() {
 			restore.RestoreSchemas(schemaArray, ignoredProgressBar)
 		})
 	})
+	Describe("GetRefreshMaterializedViewStatements", func() {
+		It("returns one refresh statement for each materialized view", func() {
+			statements := []utils.StatementWithType{
+				{Schema: "public", Name: "mview1", ObjectType: "MATERIALIZED VIEW", Statement: "\n\nCREATE MATERIALIZED VIEW public.mview1 AS SELECT 1\nWITH NO DATA;\n"},
+				{Schema: "public", Name: "view1", ObjectType: "VIEW", Statement: "\n\nCREATE VIEW public.view1 AS SELECT 1;\n"},
+				{Schema: "public", Name: "mview1", ObjectType: "MATERIALIZED VIEW", Statement: "\n\nALTER MATERIALIZED VIEW public.mview1 OWNER TO testrole;\n"},
+				{Schema: "schema2", Name: "mview2", ObjectType: "MATERIALIZED VIEW", Statement: "\n\nCREATE MATERIALIZED VIEW schema2.mview2 AS SELECT 2\nWITH NO DATA;\n"},
+			}
+
+			Expect(restore.GetRefreshMaterializedViewStatements(statements)).To(Equal([]utils.StatementWithType{
+				{Schema: "public", Name: "mview1", ObjectType: "MATERIALIZED VIEW", Statement: "REFRESH MATERIALIZED VIEW public.mview1;"},
+				{Schema: "schema2", Name: "mview2", ObjectType: "MATERIALIZED VIEW", Statement: "REFRESH MATERIALIZED VIEW schema2.mview2;"},
+			}))
+		})
+	})
 	Describe("SetRestorePlanForLegacyBackup", func() {
 		legacyBackupConfig := backup_history.BackupConfig{}
 		legacyBackupConfig.RestorePlan = nil
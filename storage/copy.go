@@ -0,0 +1,238 @@
+package storage
+
+/*
+ * This file contains functions for copying the files of a backup set from
+ * one backend to another, e.g. from a backup directory to a plugin
+ * destination, verifying each copy against a checksum of the original.
+ */
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+/*
+ * A file may be stored at a different path at the destination, as backups in
+ * a backup directory are stored under that directory while plugins receive
+ * the paths under the segment data directories.  Data files are streamed
+ * with the backup_data and restore_data plugin commands, and all other files
+ * are transferred whole with backup_file and restore_file.
+ */
+type CopyEntry struct {
+	SourcePath string `json:"source"`
+	DestPath   string `json:"dest"`
+	IsData     bool   `json:"data,omitempty"`
+}
+
+type CopyResult struct {
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"sha256"`
+}
+
+func CopyBackupFile(source StorageBackend, dest StorageBackend, entry CopyEntry) (CopyResult, error) {
+	if entry.IsData {
+		return copyData(source, dest, entry)
+	}
+	return copyFile(source, dest, entry)
+}
+
+func checksum(reader io.Reader) (int64, string, error) {
+	hash := sha256.New()
+	size, err := io.Copy(hash, reader)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func checksumStream(backend StorageBackend, filePath string) (int64, string, error) {
+	reader, err := backend.GetStream(filePath)
+	if err != nil {
+		return 0, "", err
+	}
+	defer reader.Close()
+	return checksum(reader)
+}
+
+func checksumLocalFile(filePath string) (int64, string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+	return checksum(file)
+}
+
+func verifyChecksum(result CopyResult, size int64, sum string) error {
+	if size != result.Size || sum != result.Checksum {
+		return errors.Errorf("Copy of %s does not match the original: expected %d bytes with checksum %s, found %d bytes with checksum %s",
+			result.Path, result.Size, result.Checksum, size, sum)
+	}
+	return nil
+}
+
+func copyData(source StorageBackend, dest StorageBackend, entry CopyEntry) (CopyResult, error) {
+	result := CopyResult{Path: entry.DestPath}
+	reader, err := source.GetStream(entry.SourcePath)
+	if err != nil {
+		return result, err
+	}
+	defer reader.Close()
+	writer, err := dest.PutStream(entry.DestPath)
+	if err != nil {
+		return result, err
+	}
+	hash := sha256.New()
+	result.Size, err = io.Copy(writer, io.TeeReader(reader, hash))
+	closeErr := writer.Close()
+	if err != nil {
+		return result, err
+	} else if closeErr != nil {
+		return result, closeErr
+	}
+	result.Checksum = hex.EncodeToString(hash.Sum(nil))
+	return result, verifyData(dest, result)
+}
+
+/*
+ * A data file is checked by the size the destination reports for it where
+ * the destination can report sizes, rather than by reading back all of its
+ * data, and otherwise by its size and checksum.
+ */
+func verifyData(dest StorageBackend, result CopyResult) error {
+	if canStatFiles(dest) {
+		stat, err := StatFile(dest, result.Path)
+		if err != nil {
+			return errors.Wrapf(err, "Unable to verify copy of %s", result.Path)
+		}
+		if stat.Size != result.Size {
+			return errors.Errorf("Copy of %s does not match the original: expected %d bytes, found %d bytes", result.Path, result.Size, stat.Size)
+		}
+		return nil
+	}
+	size, sum, err := checksumStream(dest, result.Path)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to verify copy of %s", result.Path)
+	}
+	return verifyChecksum(result, size, sum)
+}
+
+// The built-in backends can all report the sizes of files, but plugins need the stat_file command
+func canStatFiles(backend StorageBackend) bool {
+	if plugin, ok := backend.(*PluginBackend); ok {
+		return plugin.HasCapability(CapabilityStatFile)
+	}
+	return true
+}
+
+/*
+ * Whole files are transferred through local disk, as plugins read and write
+ * them at their local paths.  A file retrieved from the source only to be
+ * copied elsewhere is removed once it has been copied.
+ */
+func copyFile(source StorageBackend, dest StorageBackend, entry CopyEntry) (CopyResult, error) {
+	result := CopyResult{Path: entry.DestPath}
+	_, sourceIsLocal := source.(*FilesystemBackend)
+	_, destIsLocal := dest.(*FilesystemBackend)
+	if !sourceIsLocal {
+		err := os.MkdirAll(filepath.Dir(entry.SourcePath), 0755)
+		if err != nil {
+			return result, err
+		}
+		err = GetFile(source, entry.SourcePath)
+		if err != nil {
+			return result, err
+		}
+		if entry.SourcePath != entry.DestPath {
+			defer os.Remove(entry.SourcePath)
+		}
+	}
+	var err error
+	result.Size, result.Checksum, err = checksumLocalFile(entry.SourcePath)
+	if err != nil {
+		return result, err
+	}
+	if entry.SourcePath != entry.DestPath {
+		err = copyLocalFile(entry.SourcePath, entry.DestPath)
+		if err != nil {
+			return result, err
+		}
+	}
+	if !destIsLocal {
+		err = PutFile(dest, entry.DestPath)
+		if err != nil {
+			return result, err
+		}
+		return result, verifyFile(dest, result)
+	}
+	size, sum, err := checksumLocalFile(entry.DestPath)
+	if err != nil {
+		return result, err
+	}
+	return result, verifyChecksum(result, size, sum)
+}
+
+/*
+ * Retrieves a file just stored in a backend to check it, keeping the local
+ * copy aside so that it is not lost if the file cannot be retrieved.
+ */
+func verifyFile(backend StorageBackend, result CopyResult) error {
+	keptPath := result.Path + "_gpbackup_verify"
+	err := os.Rename(result.Path, keptPath)
+	if err != nil {
+		return err
+	}
+	err = GetFile(backend, result.Path)
+	if err != nil {
+		_ = os.Rename(keptPath, result.Path)
+		return errors.Wrapf(err, "Unable to verify copy of %s", result.Path)
+	}
+	size, sum, err := checksumLocalFile(result.Path)
+	if err == nil {
+		err = verifyChecksum(result, size, sum)
+	}
+	if err != nil {
+		_ = os.Rename(keptPath, result.Path)
+		return err
+	}
+	return os.Remove(keptPath)
+}
+
+func copyLocalFile(sourcePath string, destPath string) error {
+	sourceFile, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+	info, err := sourceFile.Stat()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(destPath), 0755)
+	if err != nil {
+		return err
+	}
+	// Backup metadata files are read-only, so an existing copy is replaced rather than overwritten
+	err = os.Remove(destPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	destFile, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode()|0200)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(destFile, sourceFile)
+	closeErr := destFile.Close()
+	if err != nil {
+		return err
+	} else if closeErr != nil {
+		return closeErr
+	}
+	return os.Chmod(destPath, info.Mode())
+}
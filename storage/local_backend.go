@@ -0,0 +1,138 @@
+package storage
+
+/*
+ * This file contains the StorageBackend implementation for a local or
+ * mounted filesystem directory, e.g. an NFS share accessible from every host.
+ */
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type LocalBackend struct {
+	Directory string
+}
+
+func NewLocalBackend(options map[string]string) (*LocalBackend, error) {
+	directory := options["directory"]
+	if directory == "" || !filepath.IsAbs(directory) {
+		return nil, errors.New("The directory option for the local backend must be an absolute path")
+	}
+	return &LocalBackend{Directory: directory}, nil
+}
+
+func (backend *LocalBackend) getLocation(filePath string) string {
+	return filepath.Join(backend.Directory, GetRelativeKey(filePath))
+}
+
+func (backend *LocalBackend) PutStream(filePath string) (io.WriteCloser, error) {
+	location := backend.getLocation(filePath)
+	err := os.MkdirAll(filepath.Dir(location), 0755)
+	if err != nil {
+		return nil, err
+	}
+	return os.Create(location)
+}
+
+func (backend *LocalBackend) GetStream(filePath string) (io.ReadCloser, error) {
+	return os.Open(backend.getLocation(filePath))
+}
+
+func (backend *LocalBackend) GetStreamAt(filePath string, offset int64) (io.ReadCloser, error) {
+	return openFileAt(backend.getLocation(filePath), offset)
+}
+
+func (backend *LocalBackend) List(prefix string) ([]ObjectInfo, error) {
+	locationPrefix := backend.getLocation(prefix)
+	if strings.HasSuffix(prefix, "/") {
+		locationPrefix += "/"
+	}
+	searchDir := filepath.Dir(locationPrefix)
+	objects := make([]ObjectInfo, 0)
+	if _, err := os.Stat(searchDir); os.IsNotExist(err) {
+		return objects, nil
+	}
+	err := filepath.Walk(searchDir, func(location string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasPrefix(location, locationPrefix) {
+			objects = append(objects, ObjectInfo{Key: getPathForKey(prefix, locationPrefix, location), Size: info.Size()})
+		}
+		return nil
+	})
+	return objects, err
+}
+
+func (backend *LocalBackend) Delete(filePath string) error {
+	return os.Remove(backend.getLocation(filePath))
+}
+
+func (backend *LocalBackend) Stat(filePath string) (ObjectInfo, error) {
+	info, err := os.Stat(backend.getLocation(filePath))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Key: filePath, Size: info.Size()}, nil
+}
+
+/*
+ * FilesystemBackend stores each file at its own path, as gpbackup does when
+ * it is not using a plugin, so that backup directories on local disk can be
+ * read and written through the same interface as plugin destinations.
+ */
+type FilesystemBackend struct{}
+
+func (backend *FilesystemBackend) PutStream(filePath string) (io.WriteCloser, error) {
+	err := os.MkdirAll(filepath.Dir(filePath), 0755)
+	if err != nil {
+		return nil, err
+	}
+	return os.Create(filePath)
+}
+
+func (backend *FilesystemBackend) GetStream(filePath string) (io.ReadCloser, error) {
+	return os.Open(filePath)
+}
+
+func (backend *FilesystemBackend) GetStreamAt(filePath string, offset int64) (io.ReadCloser, error) {
+	return openFileAt(filePath, offset)
+}
+
+func (backend *FilesystemBackend) List(prefix string) ([]ObjectInfo, error) {
+	searchDir := filepath.Dir(prefix)
+	if strings.HasSuffix(prefix, "/") {
+		searchDir = filepath.Clean(prefix)
+	}
+	objects := make([]ObjectInfo, 0)
+	if _, err := os.Stat(searchDir); os.IsNotExist(err) {
+		return objects, nil
+	}
+	err := filepath.Walk(searchDir, func(location string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasPrefix(location, prefix) {
+			objects = append(objects, ObjectInfo{Key: location, Size: info.Size()})
+		}
+		return nil
+	})
+	return objects, err
+}
+
+func (backend *FilesystemBackend) Delete(filePath string) error {
+	return os.Remove(filePath)
+}
+
+func (backend *FilesystemBackend) Stat(filePath string) (ObjectInfo, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Key: filePath, Size: info.Size()}, nil
+}
@@ -0,0 +1,178 @@
+package storage
+
+/*
+ * This file contains the parts of version 2 of the plugin API shared by
+ * gpbackup, which calls plugins, and the built-in backends, which implement
+ * the API themselves.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+/*
+ * Plugins implementing version 2 of the API report the optional commands and
+ * behaviors they support in response to the plugin_capabilities command, and
+ * gpbackup only uses those a plugin has reported.
+ */
+const (
+	CapabilityListBackups  = "list_backups"
+	CapabilityStatFile     = "stat_file"
+	CapabilityParallelData = "parallel_data"
+	CapabilityJSONErrors   = "json_errors"
+	CapabilityRangeData    = "range_data"
+)
+
+var BuiltinCapabilities = []string{CapabilityListBackups, CapabilityStatFile, CapabilityParallelData, CapabilityJSONErrors, CapabilityRangeData}
+
+var PluginCommands = []string{
+	"setup_plugin_for_backup", "setup_plugin_for_restore", "cleanup_plugin_for_backup", "cleanup_plugin_for_restore",
+	"backup_file", "restore_file", "backup_data", "restore_data", "delete_backup", "list_backups", "stat_file",
+	"restore_data_range",
+}
+
+const (
+	ErrorCodeNotFound = "not_found"
+	ErrorCodeTimeout  = "timeout"
+	ErrorCodeUnknown  = "unknown"
+)
+
+// The output of the stat_file command
+type FileStat struct {
+	Size  int64 `json:"size"`
+	Parts int   `json:"parts"`
+}
+
+/*
+ * Plugins with the json_errors capability write a single JSON object to
+ * stderr when a command fails, e.g.
+ * {"error": "Unable to find file", "code": "not_found", "retryable": false}
+ */
+type PluginError struct {
+	Message   string `json:"error"`
+	Code      string `json:"code,omitempty"`
+	Retryable bool   `json:"retryable,omitempty"`
+}
+
+func (err *PluginError) Error() string {
+	return err.Message
+}
+
+/*
+ * Returns the structured error written by the plugin if there is one, and
+ * otherwise wraps the error from running the plugin with its stderr, as
+ * plugins implementing only version 1 of the API report errors.
+ */
+func ParsePluginError(err error, stderr string) error {
+	stderr = strings.TrimSpace(strings.Trim(stderr, "\x00"))
+	pluginErr := &PluginError{}
+	if json.Unmarshal([]byte(stderr), pluginErr) == nil && pluginErr.Message != "" {
+		return pluginErr
+	}
+	return errors.Wrap(err, stderr)
+}
+
+// Formats an error from a built-in backend as a plugin with the json_errors capability would
+func FormatPluginError(err error) string {
+	pluginErr, ok := errors.Cause(err).(*PluginError)
+	if !ok {
+		pluginErr = &PluginError{Message: err.Error(), Code: ErrorCodeUnknown}
+		if IsNotFound(err) {
+			pluginErr.Code = ErrorCodeNotFound
+		}
+	}
+	output, _ := json.Marshal(pluginErr)
+	return string(output)
+}
+
+// Errors are only retryable if the plugin reported them as such or the command timed out
+func IsRetryable(err error) bool {
+	pluginErr, ok := errors.Cause(err).(*PluginError)
+	return ok && pluginErr.Retryable
+}
+
+func IsNotFound(err error) bool {
+	switch cause := errors.Cause(err).(type) {
+	case *PluginError:
+		return cause.Code == ErrorCodeNotFound
+	case *S3Error:
+		return cause.StatusCode == http.StatusNotFound
+	}
+	return os.IsNotExist(errors.Cause(err))
+}
+
+/*
+ * Data streamed to a plugin in several parts is stored by the built-in
+ * backends as one file per part, numbered from 1.
+ */
+func getPartPath(filePath string, part int) string {
+	return fmt.Sprintf("%s_part%d", filePath, part)
+}
+
+// Backends that report how a file is stored themselves implement FileStatter
+type FileStatter interface {
+	StatFile(filePath string) (FileStat, error)
+}
+
+/*
+ * A file stored in parts does not exist itself, so its size is the total size
+ * of its parts.  A file stored as a single stream has no parts.
+ */
+func StatFile(backend StorageBackend, filePath string) (FileStat, error) {
+	if statter, ok := backend.(FileStatter); ok {
+		return statter.StatFile(filePath)
+	}
+	info, err := backend.Stat(filePath)
+	if err == nil {
+		return FileStat{Size: info.Size}, nil
+	} else if !IsNotFound(err) {
+		return FileStat{}, err
+	}
+	objects, listErr := backend.List(filePath + "_part")
+	if listErr != nil || len(objects) == 0 {
+		return FileStat{}, err
+	}
+	stat := FileStat{}
+	for _, object := range objects {
+		stat.Size += object.Size
+		stat.Parts++
+	}
+	return stat, nil
+}
+
+// Backends that can list backups more efficiently than by listing every file implement BackupLister
+type BackupLister interface {
+	ListBackups() ([]string, error)
+}
+
+// Returns the timestamps of the backups stored in the backend, in ascending order
+func ListBackups(backend StorageBackend) ([]string, error) {
+	if lister, ok := backend.(BackupLister); ok {
+		return lister.ListBackups()
+	}
+	objects, err := backend.List("/backups/")
+	if err != nil {
+		return nil, err
+	}
+	timestampSet := make(map[string]bool)
+	for _, object := range objects {
+		// Keys are of the form /backups/YYYYMMDD/YYYYMMDDHHMMSS/<file>
+		dirs := strings.Split(strings.TrimPrefix(object.Key, "/backups/"), "/")
+		if len(dirs) == 3 && len(dirs[1]) == 14 && strings.HasPrefix(dirs[1], dirs[0]) {
+			timestampSet[dirs[1]] = true
+		}
+	}
+	timestamps := make([]string, 0, len(timestampSet))
+	for timestamp := range timestampSet {
+		timestamps = append(timestamps, timestamp)
+	}
+	sort.Strings(timestamps)
+	return timestamps, nil
+}
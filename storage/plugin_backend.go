@@ -0,0 +1,471 @@
+package storage
+
+/*
+ * This file contains the StorageBackend implementation for plugin
+ * executables implementing the gpbackup plugin API.
+ */
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultPluginPartSize       = 256 * 1024 * 1024
+	DefaultPluginMaxRetries     = 3
+	DefaultPluginRetryBaseDelay = time.Second
+
+	// Plugins exit with this code, EX_TEMPFAIL in sysexits.h, for failures that may succeed if retried
+	RetryableExitCode = 75
+
+	maxPluginRetryDelay = time.Minute
+)
+
+/*
+ * Capabilities are those reported by a plugin implementing version 2 of the
+ * API, and are empty for other plugins.  Data is streamed to a plugin with
+ * the parallel_data capability in parts of PartSize bytes over up to Streams
+ * plugin processes at once.
+ *
+ * A command with a timeout in Timeouts is killed if it runs for longer, and
+ * idempotent commands that fail with a retryable error are retried up to
+ * MaxRetries times.
+ */
+type PluginBackend struct {
+	ExecutablePath string
+	ConfigPath     string
+	Capabilities   []string
+	Streams        int
+	PartSize       int64
+	Timeouts       map[string]time.Duration
+	MaxRetries     int
+	RetryBaseDelay time.Duration
+}
+
+func (backend *PluginBackend) HasCapability(capability string) bool {
+	for _, pluginCapability := range backend.Capabilities {
+		if pluginCapability == capability {
+			return true
+		}
+	}
+	return false
+}
+
+type pluginCommand struct {
+	*exec.Cmd
+	name     string
+	timeout  time.Duration
+	timer    *time.Timer
+	timedOut int32
+}
+
+func (backend *PluginBackend) command(name string, arguments ...interface{}) *pluginCommand {
+	commandStr := fmt.Sprintf("%s %s %s", backend.ExecutablePath, name, backend.ConfigPath)
+	for _, argument := range arguments {
+		commandStr += fmt.Sprintf(" %v", argument)
+	}
+	cmd := &pluginCommand{Cmd: exec.Command("bash", "-c", commandStr), name: name, timeout: backend.Timeouts[name]}
+	cmd.Stderr = &bytes.Buffer{}
+	if cmd.timeout > 0 {
+		// The plugin runs in its own process group, so that any processes it starts are killed with it on a timeout
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
+	return cmd
+}
+
+func (cmd *pluginCommand) Start() error {
+	err := cmd.Cmd.Start()
+	if err != nil || cmd.timeout <= 0 {
+		return err
+	}
+	cmd.timer = time.AfterFunc(cmd.timeout, func() {
+		atomic.StoreInt32(&cmd.timedOut, 1)
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	})
+	return nil
+}
+
+func (cmd *pluginCommand) Wait() error {
+	err := cmd.Cmd.Wait()
+	if cmd.timer != nil {
+		cmd.timer.Stop()
+	}
+	return cmd.checkError(err)
+}
+
+/*
+ * A command that timed out or exited with RetryableExitCode is reported as a
+ * retryable PluginError, unless the plugin reported a structured error itself.
+ */
+func (cmd *pluginCommand) checkError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if atomic.LoadInt32(&cmd.timedOut) == 1 {
+		return &PluginError{Message: fmt.Sprintf("Plugin command %s timed out after %s", cmd.name, cmd.timeout), Code: ErrorCodeTimeout, Retryable: true}
+	}
+	err = ParsePluginError(err, cmd.Stderr.(*bytes.Buffer).String())
+	if _, ok := err.(*PluginError); !ok && exitCode(errors.Cause(err)) == RetryableExitCode {
+		return &PluginError{Message: err.Error(), Code: ErrorCodeUnknown, Retryable: true}
+	}
+	return err
+}
+
+func exitCode(err error) int {
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus()
+		}
+	}
+	return -1
+}
+
+// Runs a command that does not read from stdin, returning its stdout
+func (backend *PluginBackend) run(name string, arguments ...interface{}) ([]byte, error) {
+	cmd := backend.command(name, arguments...)
+	stdout := &bytes.Buffer{}
+	cmd.Stdout = stdout
+	err := cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Wait()
+	if err != nil {
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+/*
+ * Runs an idempotent command, retrying it with exponential backoff and
+ * jitter for as long as it fails with a retryable error.
+ */
+func (backend *PluginBackend) runWithRetries(name string, arguments ...interface{}) ([]byte, error) {
+	delay := backend.RetryBaseDelay
+	for attempt := 1; ; attempt++ {
+		output, err := backend.run(name, arguments...)
+		if err == nil || !IsRetryable(err) {
+			return output, err
+		}
+		if attempt > backend.MaxRetries {
+			if backend.MaxRetries > 0 {
+				err = errors.Wrapf(err, "Plugin command %s failed after %d attempts", name, attempt)
+			}
+			return nil, err
+		}
+		time.Sleep(delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1)))
+		delay *= 2
+		if delay > maxPluginRetryDelay {
+			delay = maxPluginRetryDelay
+		}
+	}
+}
+
+type pluginWriter struct {
+	io.WriteCloser
+	cmd *pluginCommand
+}
+
+// Close waits for the plugin to finish processing the data written to it
+func (writer *pluginWriter) Close() error {
+	_ = writer.WriteCloser.Close()
+	return writer.cmd.Wait()
+}
+
+func (backend *PluginBackend) startWriter(arguments ...interface{}) (*pluginWriter, error) {
+	cmd := backend.command("backup_data", arguments...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	return &pluginWriter{WriteCloser: stdin, cmd: cmd}, nil
+}
+
+func (backend *PluginBackend) PutStream(filePath string) (io.WriteCloser, error) {
+	if backend.HasCapability(CapabilityParallelData) && backend.Streams > 1 {
+		return &pluginPartWriter{backend: backend, filePath: filePath}, nil
+	}
+	return backend.startWriter(filePath)
+}
+
+/*
+ * Each part is written to its own plugin process, which is left to finish
+ * processing the part in the background once all of its data is written, so
+ * that up to Streams parts are processed at once.
+ */
+type pluginPartWriter struct {
+	backend  *PluginBackend
+	filePath string
+	numParts int
+	written  int64
+	current  *pluginWriter
+	active   []*pluginWriter
+}
+
+func (writer *pluginPartWriter) startPart() error {
+	for len(writer.active) >= writer.backend.Streams {
+		err := writer.waitOldest()
+		if err != nil {
+			return err
+		}
+	}
+	writer.numParts++
+	current, err := writer.backend.startWriter(writer.filePath, writer.numParts)
+	if err != nil {
+		return err
+	}
+	writer.current = current
+	writer.written = 0
+	return nil
+}
+
+func (writer *pluginPartWriter) finishPart() {
+	_ = writer.current.WriteCloser.Close()
+	writer.active = append(writer.active, writer.current)
+	writer.current = nil
+}
+
+func (writer *pluginPartWriter) waitOldest() error {
+	oldest := writer.active[0]
+	writer.active = writer.active[1:]
+	return oldest.cmd.Wait()
+}
+
+func (writer *pluginPartWriter) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		if writer.current == nil {
+			err := writer.startPart()
+			if err != nil {
+				return total, err
+			}
+		}
+		toWrite := p
+		if remaining := writer.backend.PartSize - writer.written; int64(len(toWrite)) > remaining {
+			toWrite = p[:remaining]
+		}
+		numBytes, err := writer.current.Write(toWrite)
+		total += numBytes
+		writer.written += int64(numBytes)
+		if err != nil {
+			return total, err
+		}
+		if writer.written == writer.backend.PartSize {
+			writer.finishPart()
+		}
+		p = p[numBytes:]
+	}
+	return total, nil
+}
+
+// Close waits for the plugin to finish processing every part
+func (writer *pluginPartWriter) Close() error {
+	// An empty stream is stored as a single empty part
+	if writer.current == nil && writer.numParts == 0 {
+		err := writer.startPart()
+		if err != nil {
+			return err
+		}
+	}
+	if writer.current != nil {
+		writer.finishPart()
+	}
+	var firstErr error
+	for len(writer.active) > 0 {
+		err := writer.waitOldest()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+type pluginReader struct {
+	io.ReadCloser
+	cmd      *pluginCommand
+	finished bool
+}
+
+// A plugin that exits with an error is reported by the read that reaches the end of its output
+func (reader *pluginReader) Read(p []byte) (int, error) {
+	numBytes, err := reader.ReadCloser.Read(p)
+	if err == io.EOF && !reader.finished {
+		reader.finished = true
+		waitErr := reader.cmd.Wait()
+		if waitErr != nil {
+			return numBytes, waitErr
+		}
+	}
+	return numBytes, err
+}
+
+func (reader *pluginReader) Close() error {
+	if reader.finished {
+		return nil
+	}
+	reader.finished = true
+	_ = reader.ReadCloser.Close()
+	_ = reader.cmd.Process.Kill()
+	_ = reader.cmd.Wait()
+	return nil
+}
+
+func (backend *PluginBackend) startReader(name string, arguments ...interface{}) (*pluginReader, error) {
+	cmd := backend.command(name, arguments...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	return &pluginReader{ReadCloser: stdout, cmd: cmd}, nil
+}
+
+/*
+ * Data may have been backed up in parts even if it is not being restored
+ * with several streams, so a plugin that supports parts is asked how the data
+ * was stored.
+ */
+func (backend *PluginBackend) GetStream(filePath string) (io.ReadCloser, error) {
+	if backend.HasCapability(CapabilityParallelData) {
+		stat, err := backend.StatFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+		if stat.Parts > 0 {
+			return &pluginPartReader{backend: backend, filePath: filePath, numParts: stat.Parts}, nil
+		}
+	}
+	return backend.startReader("restore_data", filePath)
+}
+
+// A plugin without the range_data capability has to stream the data before the offset too
+func (backend *PluginBackend) GetStreamAt(filePath string, offset int64) (io.ReadCloser, error) {
+	if !backend.HasCapability(CapabilityRangeData) {
+		reader, err := backend.GetStream(filePath)
+		if err != nil {
+			return nil, err
+		}
+		return skipTo(reader, offset)
+	}
+	return backend.startReader("restore_data_range", filePath, offset)
+}
+
+// Parts are read in order, each from its own plugin process
+type pluginPartReader struct {
+	backend  *PluginBackend
+	filePath string
+	numParts int
+	part     int
+	current  *pluginReader
+}
+
+func (reader *pluginPartReader) Read(p []byte) (int, error) {
+	for {
+		if reader.current == nil {
+			if reader.part == reader.numParts {
+				return 0, io.EOF
+			}
+			reader.part++
+			current, err := reader.backend.startReader("restore_data", reader.filePath, reader.part)
+			if err != nil {
+				return 0, err
+			}
+			reader.current = current
+		}
+		numBytes, err := reader.current.Read(p)
+		if err == io.EOF {
+			reader.current = nil
+			if numBytes == 0 {
+				continue
+			}
+			err = nil
+		}
+		return numBytes, err
+	}
+}
+
+func (reader *pluginPartReader) Close() error {
+	if reader.current != nil {
+		return reader.current.Close()
+	}
+	return nil
+}
+
+func (backend *PluginBackend) PutFile(filePath string) error {
+	_, err := backend.runWithRetries("backup_file", filePath)
+	if err != nil {
+		return errors.Wrapf(err, "Plugin failed to process %s", filePath)
+	}
+	return nil
+}
+
+func (backend *PluginBackend) GetFile(filePath string) error {
+	_, err := backend.runWithRetries("restore_file", filePath)
+	return err
+}
+
+func (backend *PluginBackend) DeleteBackup(timestamp string) error {
+	_, err := backend.runWithRetries("delete_backup", timestamp)
+	return err
+}
+
+func (backend *PluginBackend) ListBackups() ([]string, error) {
+	if !backend.HasCapability(CapabilityListBackups) {
+		return nil, errors.Errorf("Plugin %s does not support listing backups", backend.ExecutablePath)
+	}
+	output, err := backend.runWithRetries("list_backups")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(output)), nil
+}
+
+func (backend *PluginBackend) StatFile(filePath string) (FileStat, error) {
+	if !backend.HasCapability(CapabilityStatFile) {
+		return FileStat{}, errors.Errorf("Plugin %s does not support inspecting files", backend.ExecutablePath)
+	}
+	output, err := backend.runWithRetries("stat_file", filePath)
+	if err != nil {
+		return FileStat{}, err
+	}
+	stat := FileStat{}
+	err = json.Unmarshal(output, &stat)
+	if err != nil {
+		return FileStat{}, errors.Wrapf(err, "Unable to parse output of stat_file for %s", filePath)
+	}
+	return stat, nil
+}
+
+// The plugin API has no commands for listing or deleting individual files
+func (backend *PluginBackend) List(prefix string) ([]ObjectInfo, error) {
+	return nil, errors.Errorf("Plugin %s does not support listing files", backend.ExecutablePath)
+}
+
+func (backend *PluginBackend) Delete(filePath string) error {
+	return errors.Errorf("Plugin %s does not support deleting individual files", backend.ExecutablePath)
+}
+
+func (backend *PluginBackend) Stat(filePath string) (ObjectInfo, error) {
+	stat, err := backend.StatFile(filePath)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Key: filePath, Size: stat.Size}, nil
+}
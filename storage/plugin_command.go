@@ -0,0 +1,146 @@
+package storage
+
+/*
+ * This file contains the implementation of the plugin API commands for the
+ * built-in backends, which gpbackup_helper runs when invoked with
+ * --builtin-plugin in place of a plugin executable.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+/*
+ * The arguments are those passed to a plugin executable, starting with the
+ * command and the config path.  The plugin_api_version, plugin_capabilities
+ * and --version commands do not use a backend and are handled by
+ * gpbackup_helper.
+ */
+func RunPluginCommand(backend StorageBackend, args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 2 && args[0] == "list_backups" {
+		timestamps, err := ListBackups(backend)
+		if err != nil {
+			return err
+		}
+		for _, timestamp := range timestamps {
+			_, _ = fmt.Fprintln(stdout, timestamp)
+		}
+		return nil
+	}
+	if len(args) < 3 {
+		return errors.New("Not enough arguments for plugin command")
+	}
+	command := args[0]
+	argument := args[2]
+	// backup_data and restore_data take an optional part number
+	if len(args) > 3 && (command == "backup_data" || command == "restore_data") {
+		part, err := strconv.Atoi(args[3])
+		if err != nil || part < 1 {
+			return errors.Errorf("Invalid part number %s", args[3])
+		}
+		argument = getPartPath(argument, part)
+	}
+
+	switch command {
+	case "setup_plugin_for_backup", "setup_plugin_for_restore":
+		// gprestore reads restored files from the local backup directory, so it must exist
+		err := os.MkdirAll(argument, 0755)
+		if err != nil {
+			return err
+		}
+		// Checks that the destination is accessible once per backup or restore
+		if len(args) > 3 && args[3] == "master" {
+			_, err = backend.List(argument + "/")
+		}
+		return err
+	case "cleanup_plugin_for_backup", "cleanup_plugin_for_restore":
+		return nil
+	case "backup_file":
+		return PutFile(backend, argument)
+	case "restore_file":
+		return GetFile(backend, argument)
+	case "backup_data":
+		writer, err := backend.PutStream(argument)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(writer, stdin)
+		closeErr := writer.Close()
+		if err != nil {
+			return err
+		}
+		return closeErr
+	case "restore_data":
+		reader, err := backend.GetStream(argument)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		_, err = io.Copy(stdout, reader)
+		return err
+	case "restore_data_range":
+		if len(args) < 4 {
+			return errors.New("No offset specified for restore_data_range")
+		}
+		offset, err := strconv.ParseInt(args[3], 10, 64)
+		if err != nil || offset < 0 {
+			return errors.Errorf("Invalid offset %s", args[3])
+		}
+		return restoreDataRange(backend, argument, offset, stdout)
+	case "stat_file":
+		stat, err := StatFile(backend, argument)
+		if err != nil {
+			return err
+		}
+		output, _ := json.Marshal(stat)
+		_, err = fmt.Fprintln(stdout, string(output))
+		return err
+	case "delete_backup":
+		return DeleteBackup(backend, argument)
+	}
+	return errors.Errorf("Unknown plugin command %s", command)
+}
+
+// Data stored in parts is read from the part containing the offset onwards
+func restoreDataRange(backend StorageBackend, filePath string, offset int64, stdout io.Writer) error {
+	stat, err := StatFile(backend, filePath)
+	if err != nil {
+		return err
+	}
+	if stat.Parts == 0 {
+		return copyStreamAt(backend, filePath, offset, stdout)
+	}
+	for part := 1; part <= stat.Parts; part++ {
+		partPath := getPartPath(filePath, part)
+		info, err := backend.Stat(partPath)
+		if err != nil {
+			return err
+		}
+		if offset >= info.Size {
+			offset -= info.Size
+			continue
+		}
+		err = copyStreamAt(backend, partPath, offset, stdout)
+		if err != nil {
+			return err
+		}
+		offset = 0
+	}
+	return nil
+}
+
+func copyStreamAt(backend StorageBackend, filePath string, offset int64, stdout io.Writer) error {
+	reader, err := GetStreamAt(backend, filePath, offset)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	_, err = io.Copy(stdout, reader)
+	return err
+}
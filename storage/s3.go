@@ -0,0 +1,704 @@
+package storage
+
+/*
+ * This file contains a client for S3-compatible object storage, used by the
+ * built-in S3 backend so that backups can be stored in S3 without installing
+ * a separate plugin executable.
+ */
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultPartSize    = 100 * 1024 * 1024
+	DefaultConcurrency = 6
+	DefaultMaxRetries  = 5
+	MinimumPartSize    = 5 * 1024 * 1024
+
+	DefaultConnectTimeout        = 30 * time.Second
+	DefaultResponseHeaderTimeout = 60 * time.Second
+	DefaultIdleConnTimeout       = 90 * time.Second
+
+	partSizeMetadataHeader = "X-Amz-Meta-Gpbackup-Part-Size"
+	defaultRetryBaseDelay  = 500 * time.Millisecond
+	maxRetryDelay          = 30 * time.Second
+)
+
+type S3Config struct {
+	Endpoint           string
+	Region             string
+	AccessKeyID        string
+	SecretAccessKey    string
+	Bucket             string
+	Folder             string
+	UsePathStyle       bool
+	BackupPartSize     int64
+	RestorePartSize    int64
+	BackupConcurrency  int
+	RestoreConcurrency int
+	MaxRetries         int
+	RetryBaseDelay     time.Duration
+	VerifyChecksums    bool
+	// Limits on establishing a connection, including the TLS handshake, and on waiting for a response; 0 means no limit
+	ConnectTimeout        time.Duration
+	ResponseHeaderTimeout time.Duration
+	IdleConnTimeout       time.Duration
+}
+
+type S3Client struct {
+	Config     S3Config
+	HTTPClient *http.Client
+	endpoint   *url.URL
+}
+
+/*
+ * The option names match those of the gpbackup_s3_plugin, so that an existing
+ * plugin configuration only needs "backend: s3" added to it to use the
+ * built-in backend.
+ */
+func NewS3ConfigFromOptions(options map[string]string) (S3Config, error) {
+	config := S3Config{
+		Endpoint:           options["endpoint"],
+		Region:             options["region"],
+		AccessKeyID:        options["aws_access_key_id"],
+		SecretAccessKey:    options["aws_secret_access_key"],
+		Bucket:             options["bucket"],
+		Folder:             strings.Trim(options["folder"], "/"),
+		UsePathStyle:       options["endpoint"] != "" || options["force_path_style"] == "true",
+		BackupPartSize:     DefaultPartSize,
+		RestorePartSize:    DefaultPartSize,
+		BackupConcurrency:  DefaultConcurrency,
+		RestoreConcurrency: DefaultConcurrency,
+		MaxRetries:         DefaultMaxRetries,
+		RetryBaseDelay:     defaultRetryBaseDelay,
+		VerifyChecksums:    options["checksum_verification"] != "off",
+	}
+	if config.Bucket == "" {
+		return S3Config{}, errors.New("The bucket option is required for the s3 backend")
+	}
+	if config.Region == "" {
+		if config.Endpoint == "" {
+			return S3Config{}, errors.New("The region option is required for the s3 backend if no endpoint is specified")
+		}
+		config.Region = "us-east-1"
+	}
+	if config.AccessKeyID == "" && config.SecretAccessKey == "" {
+		config.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		config.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	if config.AccessKeyID == "" || config.SecretAccessKey == "" {
+		return S3Config{}, errors.New("The aws_access_key_id and aws_secret_access_key options are required for the s3 backend")
+	}
+	scheme := "https"
+	if options["encryption"] == "off" {
+		scheme = "http"
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = fmt.Sprintf("%s://s3.%s.amazonaws.com", scheme, config.Region)
+	} else if !strings.Contains(config.Endpoint, "://") {
+		config.Endpoint = fmt.Sprintf("%s://%s", scheme, config.Endpoint)
+	}
+
+	var err error
+	if config.BackupPartSize, err = parseSizeOption(options, "backup_multipart_chunksize", DefaultPartSize); err != nil {
+		return S3Config{}, err
+	}
+	if config.RestorePartSize, err = parseSizeOption(options, "restore_multipart_chunksize", DefaultPartSize); err != nil {
+		return S3Config{}, err
+	}
+	if config.BackupConcurrency, err = parseIntOption(options, "backup_max_concurrent_requests", DefaultConcurrency); err != nil {
+		return S3Config{}, err
+	}
+	if config.RestoreConcurrency, err = parseIntOption(options, "restore_max_concurrent_requests", DefaultConcurrency); err != nil {
+		return S3Config{}, err
+	}
+	if config.MaxRetries, err = parseIntOption(options, "max_retries", DefaultMaxRetries); err != nil {
+		return S3Config{}, err
+	}
+	if config.ConnectTimeout, err = parseSecondsOption(options, "connect_timeout", DefaultConnectTimeout); err != nil {
+		return S3Config{}, err
+	}
+	if config.ResponseHeaderTimeout, err = parseSecondsOption(options, "response_header_timeout", DefaultResponseHeaderTimeout); err != nil {
+		return S3Config{}, err
+	}
+	if config.IdleConnTimeout, err = parseSecondsOption(options, "idle_connection_timeout", DefaultIdleConnTimeout); err != nil {
+		return S3Config{}, err
+	}
+	return config, nil
+}
+
+func parseIntOption(options map[string]string, name string, defaultValue int) (int, error) {
+	if options[name] == "" {
+		return defaultValue, nil
+	}
+	value, err := strconv.Atoi(options[name])
+	if err != nil || value < 0 {
+		return 0, errors.Errorf("Invalid value for %s: %s", name, options[name])
+	}
+	return value, nil
+}
+
+func parseSecondsOption(options map[string]string, name string, defaultValue time.Duration) (time.Duration, error) {
+	seconds, err := parseIntOption(options, name, int(defaultValue/time.Second))
+	return time.Duration(seconds) * time.Second, err
+}
+
+// Sizes may be given in bytes or with a KB, MB or GB suffix, e.g. "500MB"
+func parseSizeOption(options map[string]string, name string, defaultValue int64) (int64, error) {
+	valueStr := strings.ToUpper(strings.TrimSpace(options[name]))
+	if valueStr == "" {
+		return defaultValue, nil
+	}
+	multiplier := int64(1)
+	for suffix, suffixMultiplier := range map[string]int64{"KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30} {
+		if strings.HasSuffix(valueStr, suffix) {
+			multiplier = suffixMultiplier
+			valueStr = strings.TrimSuffix(valueStr, suffix)
+			break
+		}
+	}
+	value, err := strconv.ParseInt(valueStr, 10, 64)
+	if err != nil || value*multiplier < MinimumPartSize {
+		return 0, errors.Errorf("Invalid value for %s: %s. The minimum size is 5MB.", name, options[name])
+	}
+	return value * multiplier, nil
+}
+
+func NewS3Client(config S3Config) (*S3Client, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, errors.Errorf("Invalid s3 endpoint %s: %s", config.Endpoint, err.Error())
+	}
+	if config.BackupConcurrency < 1 {
+		config.BackupConcurrency = 1
+	}
+	if config.RestoreConcurrency < 1 {
+		config.RestoreConcurrency = 1
+	}
+	/*
+	 * Without these timeouts, a request to an endpoint that stops responding
+	 * would hang the backup instead of failing and being retried.
+	 */
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: config.ConnectTimeout, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   config.ConnectTimeout,
+		ResponseHeaderTimeout: config.ResponseHeaderTimeout,
+		IdleConnTimeout:       config.IdleConnTimeout,
+		MaxIdleConnsPerHost:   config.BackupConcurrency + config.RestoreConcurrency,
+	}
+	return &S3Client{Config: config, HTTPClient: &http.Client{Transport: transport}, endpoint: endpoint}, nil
+}
+
+// Files are stored under the folder, e.g. <folder>/backups/20170101/20170101010101/<file>
+func (client *S3Client) GetKeyForPath(filePath string) string {
+	key := GetRelativeKey(filePath)
+	if client.Config.Folder == "" {
+		return key
+	}
+	return client.Config.Folder + "/" + key
+}
+
+/*
+ * Streams smaller than a single part are uploaded with one request;
+ * larger streams are uploaded as a multipart upload with up to
+ * BackupConcurrency parts in flight at once.
+ */
+func (client *S3Client) UploadStream(key string, reader io.Reader) error {
+	firstPart, err := readPart(reader, client.Config.BackupPartSize)
+	if err != nil {
+		return err
+	}
+	if int64(len(firstPart)) < client.Config.BackupPartSize {
+		return client.putObject(key, firstPart)
+	}
+	return client.uploadMultipart(key, firstPart, reader)
+}
+
+func readPart(reader io.Reader, partSize int64) ([]byte, error) {
+	buffer := make([]byte, partSize)
+	numBytes, err := io.ReadFull(reader, buffer)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return buffer[:numBytes], err
+}
+
+func (client *S3Client) putObject(key string, data []byte) error {
+	response, err := client.doRequest("PUT", key, nil, nil, data)
+	if err != nil {
+		return errors.Errorf("Unable to upload %s: %s", key, err.Error())
+	}
+	response.Body.Close()
+	return nil
+}
+
+type initiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+type partResult struct {
+	partNumber int
+	etag       string
+	err        error
+}
+
+func (client *S3Client) uploadMultipart(key string, firstPart []byte, reader io.Reader) error {
+	headers := map[string]string{partSizeMetadataHeader: strconv.FormatInt(client.Config.BackupPartSize, 10)}
+	response, err := client.doRequest("POST", key, url.Values{"uploads": {""}}, headers, nil)
+	if err != nil {
+		return errors.Errorf("Unable to start upload of %s: %s", key, err.Error())
+	}
+	result := initiateMultipartUploadResult{}
+	err = decodeXMLResponse(response, &result)
+	if err != nil {
+		return errors.Errorf("Unable to start upload of %s: %s", key, err.Error())
+	}
+	uploadID := result.UploadID
+
+	err = client.uploadParts(key, uploadID, firstPart, reader)
+	if err != nil {
+		client.abortMultipartUpload(key, uploadID)
+		return errors.Errorf("Unable to upload %s: %s", key, err.Error())
+	}
+	return nil
+}
+
+func (client *S3Client) uploadParts(key string, uploadID string, firstPart []byte, reader io.Reader) error {
+	results := make(chan partResult)
+	parts := make([]completedPart, 0)
+	inFlight := 0
+	var uploadErr error
+
+	collect := func() {
+		result := <-results
+		inFlight--
+		if result.err != nil && uploadErr == nil {
+			uploadErr = result.err
+		}
+		parts = append(parts, completedPart{PartNumber: result.partNumber, ETag: result.etag})
+	}
+
+	part := firstPart
+	for partNumber := 1; len(part) > 0 && uploadErr == nil; partNumber++ {
+		// Limits the number of parts held in memory and in flight at once
+		for inFlight >= client.Config.BackupConcurrency {
+			collect()
+		}
+		inFlight++
+		go func(partNumber int, data []byte) {
+			etag, err := client.uploadPart(key, uploadID, partNumber, data)
+			results <- partResult{partNumber: partNumber, etag: etag, err: err}
+		}(partNumber, part)
+		if int64(len(part)) < client.Config.BackupPartSize {
+			break
+		}
+		var err error
+		part, err = readPart(reader, client.Config.BackupPartSize)
+		if err != nil && uploadErr == nil {
+			uploadErr = err
+		}
+	}
+	for inFlight > 0 {
+		collect()
+	}
+	if uploadErr != nil {
+		return uploadErr
+	}
+	return client.completeMultipartUpload(key, uploadID, parts)
+}
+
+func (client *S3Client) uploadPart(key string, uploadID string, partNumber int, data []byte) (string, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {uploadID}}
+	response, err := client.doRequest("PUT", key, query, nil, data)
+	if err != nil {
+		return "", err
+	}
+	response.Body.Close()
+	return response.Header.Get("ETag"), nil
+}
+
+func (client *S3Client) completeMultipartUpload(key string, uploadID string, parts []completedPart) error {
+	sortCompletedParts(parts)
+	body, err := xml.Marshal(completeMultipartUpload{Parts: parts})
+	if err != nil {
+		return err
+	}
+	response, err := client.doRequest("POST", key, url.Values{"uploadId": {uploadID}}, nil, body)
+	if err != nil {
+		return err
+	}
+	// S3 can report an error in the body of a successful response to this request
+	responseBody, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return err
+	}
+	if s3Err := parseS3Error(responseBody); s3Err != nil {
+		return s3Err
+	}
+	return nil
+}
+
+func sortCompletedParts(parts []completedPart) {
+	for i := 1; i < len(parts); i++ {
+		for j := i; j > 0 && parts[j-1].PartNumber > parts[j].PartNumber; j-- {
+			parts[j-1], parts[j] = parts[j], parts[j-1]
+		}
+	}
+}
+
+func (client *S3Client) abortMultipartUpload(key string, uploadID string) {
+	response, err := client.doRequest("DELETE", key, url.Values{"uploadId": {uploadID}}, nil, nil)
+	if err == nil {
+		response.Body.Close()
+	}
+}
+
+func (client *S3Client) StatObject(key string) (ObjectInfo, error) {
+	response, err := client.doRequest("HEAD", key, nil, nil, nil)
+	if err != nil {
+		return ObjectInfo{}, errors.Wrapf(err, "Unable to find %s", key)
+	}
+	response.Body.Close()
+	info := ObjectInfo{Key: key, Size: response.ContentLength, ETag: strings.Trim(response.Header.Get("ETag"), `"`)}
+	if info.Size < 0 {
+		info.Size, _ = strconv.ParseInt(response.Header.Get("Content-Length"), 10, 64)
+	}
+	if partSize := response.Header.Get(partSizeMetadataHeader); partSize != "" {
+		info.PartSize, _ = strconv.ParseInt(partSize, 10, 64)
+	}
+	return info, nil
+}
+
+type rangeResult struct {
+	data []byte
+	err  error
+}
+
+/*
+ * Objects are downloaded as byte ranges with up to RestoreConcurrency
+ * requests in flight at once, and written to the writer in order.  Objects
+ * uploaded by this client are downloaded in ranges matching their upload
+ * parts, so that their multipart ETag can be verified.
+ */
+func (client *S3Client) DownloadStream(key string, writer io.Writer) error {
+	return client.DownloadStreamAt(key, 0, writer)
+}
+
+// Only a download of a whole object can be verified against its ETag
+func (client *S3Client) DownloadStreamAt(key string, offset int64, writer io.Writer) error {
+	info, err := client.StatObject(key)
+	if err != nil {
+		return err
+	}
+	if offset < 0 || offset > info.Size {
+		return errors.Errorf("Offset %d is outside of %s, which is %d bytes", offset, key, info.Size)
+	}
+	rangeSize := client.Config.RestorePartSize
+	if info.PartSize > 0 {
+		rangeSize = info.PartSize
+	}
+	numRanges := int((info.Size - offset + rangeSize - 1) / rangeSize)
+
+	done := make(chan bool)
+	defer close(done)
+	ranges := make(chan chan rangeResult, client.Config.RestoreConcurrency)
+	go func() {
+		defer close(ranges)
+		for i := 0; i < numRanges; i++ {
+			resultChan := make(chan rangeResult, 1)
+			select {
+			case ranges <- resultChan:
+			case <-done:
+				return
+			}
+			start := offset + int64(i)*rangeSize
+			end := start + rangeSize - 1
+			if end >= info.Size {
+				end = info.Size - 1
+			}
+			go func(start int64, end int64) {
+				data, err := client.getRange(key, start, end)
+				resultChan <- rangeResult{data: data, err: err}
+			}(start, end)
+		}
+	}()
+
+	checksum := newETagChecksum()
+	for resultChan := range ranges {
+		result := <-resultChan
+		if result.err != nil {
+			return errors.Errorf("Unable to download %s: %s", key, result.err.Error())
+		}
+		checksum.addPart(result.data)
+		_, err = writer.Write(result.data)
+		if err != nil {
+			return err
+		}
+	}
+	if client.Config.VerifyChecksums && offset == 0 && !checksum.matches(info.ETag, info.PartSize > 0) {
+		return errors.Errorf("Checksum mismatch downloading %s: expected ETag %s, computed %s", key, info.ETag, checksum.etag(strings.Contains(info.ETag, "-")))
+	}
+	return nil
+}
+
+func (client *S3Client) getRange(key string, start int64, end int64) ([]byte, error) {
+	headers := map[string]string{"Range": fmt.Sprintf("bytes=%d-%d", start, end)}
+	var data []byte
+	// A connection dropped while reading the body is retried like a failed request
+	err := client.withRetries(func() (bool, error) {
+		response, err := client.doRequestOnce("GET", key, nil, headers, nil)
+		if err != nil {
+			return isRetryable(err), err
+		}
+		defer response.Body.Close()
+		data, err = ioutil.ReadAll(response.Body)
+		if err == nil && int64(len(data)) != end-start+1 {
+			err = errors.Errorf("Expected %d bytes for range %d-%d, received %d", end-start+1, start, end, len(data))
+		}
+		return err != nil, err
+	})
+	return data, err
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key  string
+		Size int64
+		ETag string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (client *S3Client) ListObjects(prefix string) ([]ObjectInfo, error) {
+	objects := make([]ObjectInfo, 0)
+	continuationToken := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+		response, err := client.doRequest("GET", "", query, nil, nil)
+		if err != nil {
+			return nil, errors.Errorf("Unable to list objects with prefix %s: %s", prefix, err.Error())
+		}
+		result := listBucketResult{}
+		err = decodeXMLResponse(response, &result)
+		if err != nil {
+			return nil, errors.Errorf("Unable to list objects with prefix %s: %s", prefix, err.Error())
+		}
+		for _, object := range result.Contents {
+			objects = append(objects, ObjectInfo{Key: object.Key, Size: object.Size, ETag: strings.Trim(object.ETag, `"`)})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		continuationToken = result.NextContinuationToken
+	}
+}
+
+func (client *S3Client) DeleteObject(key string) error {
+	response, err := client.doRequest("DELETE", key, nil, nil, nil)
+	if err != nil {
+		return errors.Errorf("Unable to delete %s: %s", key, err.Error())
+	}
+	response.Body.Close()
+	return nil
+}
+
+/*
+ * Request handling
+ */
+
+type S3Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (err *S3Error) Error() string {
+	if err.Code == "" {
+		return fmt.Sprintf("HTTP status %d", err.StatusCode)
+	}
+	return fmt.Sprintf("%s: %s (HTTP status %d)", err.Code, err.Message, err.StatusCode)
+}
+
+func parseS3Error(body []byte) *S3Error {
+	s3Err := struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}{}
+	if xml.Unmarshal(body, &s3Err) != nil {
+		return nil
+	}
+	return &S3Error{StatusCode: http.StatusOK, Code: s3Err.Code, Message: s3Err.Message}
+}
+
+func decodeXMLResponse(response *http.Response, result interface{}) error {
+	defer response.Body.Close()
+	return xml.NewDecoder(response.Body).Decode(result)
+}
+
+func isRetryable(err error) bool {
+	s3Err, ok := err.(*S3Error)
+	if !ok {
+		// Errors not returned by S3 itself are network errors
+		return true
+	}
+	return s3Err.StatusCode >= 500 || s3Err.StatusCode == http.StatusTooManyRequests ||
+		s3Err.Code == "RequestTimeout" || s3Err.Code == "SlowDown"
+}
+
+func (client *S3Client) withRetries(attempt func() (bool, error)) error {
+	var err error
+	for retry := 0; ; retry++ {
+		var retryable bool
+		retryable, err = attempt()
+		if err == nil || !retryable || retry >= client.Config.MaxRetries {
+			return err
+		}
+		delay := client.Config.RetryBaseDelay << uint(retry)
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+		// Jitter keeps the parallel requests of a file from all retrying at once
+		time.Sleep(delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1)))
+	}
+}
+
+func (client *S3Client) doRequest(method string, key string, query url.Values, headers map[string]string, body []byte) (*http.Response, error) {
+	var response *http.Response
+	err := client.withRetries(func() (bool, error) {
+		var err error
+		response, err = client.doRequestOnce(method, key, query, headers, body)
+		return err != nil && isRetryable(err), err
+	})
+	return response, err
+}
+
+func (client *S3Client) doRequestOnce(method string, key string, query url.Values, headers map[string]string, body []byte) (*http.Response, error) {
+	request, err := client.newRequest(method, key, query, headers, body)
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.HTTPClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= 300 {
+		responseBody, _ := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		s3Err := parseS3Error(responseBody)
+		if s3Err == nil {
+			s3Err = &S3Error{}
+		}
+		s3Err.StatusCode = response.StatusCode
+		return nil, s3Err
+	}
+	return response, nil
+}
+
+func (client *S3Client) newRequest(method string, key string, query url.Values, headers map[string]string, body []byte) (*http.Request, error) {
+	requestURL := *client.endpoint
+	objectPath := "/" + key
+	if client.Config.UsePathStyle {
+		objectPath = "/" + client.Config.Bucket + objectPath
+	} else {
+		requestURL.Host = client.Config.Bucket + "." + requestURL.Host
+	}
+	requestURL.Path = path.Join(requestURL.Path, objectPath)
+	requestURL.RawPath = uriEncode(requestURL.Path, false)
+	requestURL.RawQuery = canonicalQueryString(query)
+
+	request, err := http.NewRequest(method, requestURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.ContentLength = int64(len(body))
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	if body != nil {
+		md5Sum := md5.Sum(body)
+		request.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(md5Sum[:]))
+	}
+	SignRequestV4(request, body, client.Config.AccessKeyID, client.Config.SecretAccessKey, client.Config.Region, time.Now().UTC())
+	return request, nil
+}
+
+/*
+ * S3 computes the ETag of an object uploaded in a single request as the MD5
+ * of its contents, and of an object uploaded in parts as the MD5 of the
+ * concatenated MD5s of its parts followed by the number of parts.
+ */
+type etagChecksum struct {
+	whole    hash.Hash
+	partMD5s []byte
+	numParts int
+}
+
+func newETagChecksum() *etagChecksum {
+	return &etagChecksum{whole: md5.New()}
+}
+
+func (checksum *etagChecksum) addPart(data []byte) {
+	checksum.whole.Write(data)
+	partMD5 := md5.Sum(data)
+	checksum.partMD5s = append(checksum.partMD5s, partMD5[:]...)
+	checksum.numParts++
+}
+
+func (checksum *etagChecksum) etag(multipart bool) string {
+	if !multipart {
+		return hex.EncodeToString(checksum.whole.Sum(nil))
+	}
+	combined := md5.Sum(checksum.partMD5s)
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(combined[:]), checksum.numParts)
+}
+
+/*
+ * A multipart ETag can only be verified if the ranges that were downloaded
+ * match the parts that were uploaded.  ETags of objects encrypted with
+ * SSE-KMS are not MD5-based, so checksum_verification must be turned off
+ * for those objects.
+ */
+func (checksum *etagChecksum) matches(etag string, rangesMatchParts bool) bool {
+	isMultipartETag := strings.Contains(etag, "-")
+	if isMultipartETag && !rangesMatchParts {
+		return true
+	}
+	return etag == checksum.etag(isMultipartETag)
+}
@@ -0,0 +1,97 @@
+package storage
+
+/*
+ * This file contains the StorageBackend implementation for S3-compatible
+ * object storage.
+ */
+
+import (
+	"io"
+	"strings"
+)
+
+type S3Backend struct {
+	Client *S3Client
+}
+
+func NewS3Backend(options map[string]string) (*S3Backend, error) {
+	config, err := NewS3ConfigFromOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	client, err := NewS3Client(config)
+	if err != nil {
+		return nil, err
+	}
+	return &S3Backend{Client: client}, nil
+}
+
+type s3Writer struct {
+	*io.PipeWriter
+	done chan error
+}
+
+// Close reports the result of the upload once it has finished
+func (writer *s3Writer) Close() error {
+	err := writer.PipeWriter.Close()
+	uploadErr := <-writer.done
+	if uploadErr != nil {
+		return uploadErr
+	}
+	return err
+}
+
+func (backend *S3Backend) PutStream(filePath string) (io.WriteCloser, error) {
+	reader, writer := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := backend.Client.UploadStream(backend.Client.GetKeyForPath(filePath), reader)
+		// Unblocks the writer if the upload fails before all data is written
+		reader.CloseWithError(err)
+		done <- err
+	}()
+	return &s3Writer{PipeWriter: writer, done: done}, nil
+}
+
+func (backend *S3Backend) GetStream(filePath string) (io.ReadCloser, error) {
+	return backend.GetStreamAt(filePath, 0)
+}
+
+func (backend *S3Backend) GetStreamAt(filePath string, offset int64) (io.ReadCloser, error) {
+	key := backend.Client.GetKeyForPath(filePath)
+	// Check that the object exists before starting the download, so that a missing file is reported immediately
+	_, err := backend.Client.StatObject(key)
+	if err != nil {
+		return nil, err
+	}
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(backend.Client.DownloadStreamAt(key, offset, writer))
+	}()
+	return reader, nil
+}
+
+func (backend *S3Backend) List(prefix string) ([]ObjectInfo, error) {
+	keyPrefix := backend.Client.GetKeyForPath(prefix)
+	if strings.HasSuffix(prefix, "/") && !strings.HasSuffix(keyPrefix, "/") {
+		keyPrefix += "/"
+	}
+	objects, err := backend.Client.ListObjects(keyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for i := range objects {
+		objects[i].Key = getPathForKey(prefix, keyPrefix, objects[i].Key)
+	}
+	return objects, nil
+}
+
+func (backend *S3Backend) Delete(filePath string) error {
+	return backend.Client.DeleteObject(backend.Client.GetKeyForPath(filePath))
+}
+
+func (backend *S3Backend) Stat(filePath string) (ObjectInfo, error) {
+	info, err := backend.Client.StatObject(backend.Client.GetKeyForPath(filePath))
+	info.Key = filePath
+	return info, err
+}
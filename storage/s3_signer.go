@@ -0,0 +1,109 @@
+package storage
+
+/*
+ * This file contains functions for signing requests to S3-compatible object
+ * storage with AWS Signature Version 4.
+ */
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const signingAlgorithm = "AWS4-HMAC-SHA256"
+
+func SignRequestV4(request *http.Request, body []byte, accessKeyID string, secretAccessKey string, region string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256.Sum256(body)
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	signedHeaders, canonicalHeaders := getCanonicalHeaders(request)
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, region)
+	stringToSign := strings.Join([]string{signingAlgorithm, amzDate, scope, hex.EncodeToString(canonicalRequestHash[:])}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	signingKey = hmacSHA256(signingKey, region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		signingAlgorithm, accessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// The Host header and all X-Amz-* and Content-* headers are signed
+func getCanonicalHeaders(request *http.Request) (string, string) {
+	headers := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		lowerName := strings.ToLower(name)
+		if strings.HasPrefix(lowerName, "x-amz-") || strings.HasPrefix(lowerName, "content-") || lowerName == "range" {
+			headers[lowerName] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += fmt.Sprintf("%s:%s\n", name, headers[name])
+	}
+	return strings.Join(names, ";"), canonicalHeaders
+}
+
+func canonicalQueryString(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(query))
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, fmt.Sprintf("%s=%s", uriEncode(key, true), uriEncode(value, true)))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// Encodes every byte except unreserved characters and, in paths, slashes
+func uriEncode(value string, encodeSlash bool) string {
+	var encoded strings.Builder
+	for i := 0; i < len(value); i++ {
+		char := value[i]
+		if (char >= 'A' && char <= 'Z') || (char >= 'a' && char <= 'z') || (char >= '0' && char <= '9') ||
+			char == '-' || char == '_' || char == '.' || char == '~' || (char == '/' && !encodeSlash) {
+			encoded.WriteByte(char)
+		} else {
+			encoded.WriteString(fmt.Sprintf("%%%02X", char))
+		}
+	}
+	return encoded.String()
+}
@@ -0,0 +1,184 @@
+package storage
+
+/*
+ * This file contains the interface through which backup files are stored in
+ * and retrieved from a plugin destination, and functions shared by its
+ * implementations.
+ */
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+/*
+ * Files are identified by the path at which gpbackup would write them to
+ * local disk if it were not using a plugin, as they are in the plugin API.
+ * Each backend maps these paths to its own storage locations, and paths
+ * returned by List can be passed back to the other functions.
+ */
+type StorageBackend interface {
+	// The upload is complete once Close returns without an error
+	PutStream(filePath string) (io.WriteCloser, error)
+	GetStream(filePath string) (io.ReadCloser, error)
+	List(prefix string) ([]ObjectInfo, error)
+	Delete(filePath string) error
+	Stat(filePath string) (ObjectInfo, error)
+}
+
+// Backends that store whole files differently from streamed data implement FileBackend
+type FileBackend interface {
+	PutFile(filePath string) error
+	GetFile(filePath string) error
+}
+
+// Backends that can start reading a file partway through without reading what comes before implement OffsetReader
+type OffsetReader interface {
+	GetStreamAt(filePath string, offset int64) (io.ReadCloser, error)
+}
+
+// Backends that can delete a whole backup more efficiently than file by file implement BackupDeleter
+type BackupDeleter interface {
+	DeleteBackup(timestamp string) error
+}
+
+type ObjectInfo struct {
+	Key      string
+	Size     int64
+	ETag     string
+	PartSize int64
+}
+
+func PutFile(backend StorageBackend, filePath string) error {
+	if fileBackend, ok := backend.(FileBackend); ok {
+		return fileBackend.PutFile(filePath)
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer, err := backend.PutStream(filePath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, file)
+	closeErr := writer.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+func GetFile(backend StorageBackend, filePath string) error {
+	if fileBackend, ok := backend.(FileBackend); ok {
+		return fileBackend.GetFile(filePath)
+	}
+	reader, err := backend.GetStream(filePath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, reader)
+	closeErr := file.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// Returns the contents of a file from the given offset to its end
+func GetStreamAt(backend StorageBackend, filePath string, offset int64) (io.ReadCloser, error) {
+	if offsetReader, ok := backend.(OffsetReader); ok {
+		return offsetReader.GetStreamAt(filePath, offset)
+	}
+	reader, err := backend.GetStream(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return skipTo(reader, offset)
+}
+
+// Reports whether GetStreamAt can start reading at an offset without reading the data before it
+func SupportsOffsets(backend StorageBackend) bool {
+	if pluginBackend, ok := backend.(*PluginBackend); ok {
+		return pluginBackend.HasCapability(CapabilityRangeData)
+	}
+	_, ok := backend.(OffsetReader)
+	return ok
+}
+
+func skipTo(reader io.ReadCloser, offset int64) (io.ReadCloser, error) {
+	_, err := io.CopyN(ioutil.Discard, reader, offset)
+	if err != nil {
+		_ = reader.Close()
+		return nil, err
+	}
+	return reader, nil
+}
+
+func openFileAt(filePath string, offset int64) (io.ReadCloser, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	_, err = file.Seek(offset, io.SeekStart)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func DeleteBackup(backend StorageBackend, timestamp string) error {
+	if deleter, ok := backend.(BackupDeleter); ok {
+		return deleter.DeleteBackup(timestamp)
+	}
+	if len(timestamp) != 14 {
+		return errors.Errorf("Invalid timestamp %s", timestamp)
+	}
+	objects, err := backend.List(fmt.Sprintf("/backups/%s/%s/", timestamp[0:8], timestamp))
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		err = backend.Delete(object.Key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+ * Built-in backends store files relative to their backups directory, e.g.
+ * backups/20170101/20170101010101/<file>, the same layout used by the
+ * gpbackup_s3_plugin, so that a file's location does not depend on the data
+ * directory of the segment that wrote it.
+ */
+func GetRelativeKey(filePath string) string {
+	if index := strings.LastIndex(filePath, "/backups/"); index != -1 {
+		key := path.Clean(filePath[index+1:])
+		if strings.HasSuffix(filePath, "/") {
+			key += "/"
+		}
+		return key
+	}
+	return filepath.Base(filePath)
+}
+
+// Maps a key under the key prefix for the given path prefix back to a path
+func getPathForKey(prefix string, keyPrefix string, key string) string {
+	return prefix + strings.TrimPrefix(key, keyPrefix)
+}
@@ -0,0 +1,547 @@
+package storage_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/greenplum-db/gpbackup/storage"
+	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func putString(backend storage.StorageBackend, filePath string, contents string) {
+	writer, err := backend.PutStream(filePath)
+	Expect(err).ToNot(HaveOccurred())
+	_, err = io.WriteString(writer, contents)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(writer.Close()).To(Succeed())
+}
+
+func getString(backend storage.StorageBackend, filePath string) (string, error) {
+	reader, err := backend.GetStream(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	contents, err := ioutil.ReadAll(reader)
+	return string(contents), err
+}
+
+func getStringAt(backend storage.StorageBackend, filePath string, offset int64) (string, error) {
+	reader, err := storage.GetStreamAt(backend, filePath, offset)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	contents, err := ioutil.ReadAll(reader)
+	return string(contents), err
+}
+
+var _ = Describe("storage tests", func() {
+	var tempDir string
+	dataFile := "/data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_pipe_1234"
+	BeforeEach(func() {
+		tempDir, _ = ioutil.TempDir("", "storage")
+	})
+	AfterEach(func() {
+		_ = os.RemoveAll(tempDir)
+	})
+	Describe("GetRelativeKey", func() {
+		It("returns the path relative to the backups directory", func() {
+			Expect(storage.GetRelativeKey(dataFile)).To(Equal("backups/20170101/20170101010101/gpbackup_0_20170101010101_pipe_1234"))
+			Expect(storage.GetRelativeKey("/data/gpseg0/backups/20170101/20170101010101/")).To(Equal("backups/20170101/20170101010101/"))
+			Expect(storage.GetRelativeKey("/tmp/file")).To(Equal("file"))
+		})
+	})
+	Describe("LocalBackend", func() {
+		var backend *storage.LocalBackend
+		BeforeEach(func() {
+			backend, _ = storage.NewLocalBackend(map[string]string{"directory": tempDir})
+		})
+		It("returns an error for a relative directory", func() {
+			_, err := storage.NewLocalBackend(map[string]string{"directory": "relative/dir"})
+
+			Expect(err).To(MatchError("The directory option for the local backend must be an absolute path"))
+		})
+		It("stores and retrieves data under the directory", func() {
+			putString(backend, dataFile, "table data")
+
+			Expect(ioutil.ReadFile(filepath.Join(tempDir, "backups/20170101/20170101010101/gpbackup_0_20170101010101_pipe_1234"))).To(Equal([]byte("table data")))
+			Expect(getString(backend, dataFile)).To(Equal("table data"))
+			info, err := backend.Stat(dataFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info).To(Equal(storage.ObjectInfo{Key: dataFile, Size: 10}))
+		})
+		It("reads a file from an offset", func() {
+			putString(backend, dataFile, "table data")
+
+			Expect(getStringAt(backend, dataFile, 6)).To(Equal("data"))
+		})
+		It("lists and deletes the files of a backup", func() {
+			putString(backend, "/data/gpseg0/backups/20170101/20170101010101/file1", "data")
+			putString(backend, "/data/gpseg1/backups/20170101/20170101010101/file2", "data")
+			putString(backend, "/data/gpseg0/backups/20170101/20170101020202/file1", "data")
+
+			objects, err := backend.List("/data/gpseg0/backups/20170101/20170101010101/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(objects).To(Equal([]storage.ObjectInfo{
+				{Key: "/data/gpseg0/backups/20170101/20170101010101/file1", Size: 4},
+				{Key: "/data/gpseg0/backups/20170101/20170101010101/file2", Size: 4},
+			}))
+
+			Expect(storage.DeleteBackup(backend, "20170101010101")).To(Succeed())
+			objects, err = backend.List("/backups/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(objects).To(Equal([]storage.ObjectInfo{{Key: "/backups/20170101/20170101020202/file1", Size: 4}}))
+		})
+	})
+	Describe("PluginBackend", func() {
+		var backend *storage.PluginBackend
+		var storeDir string
+		BeforeEach(func() {
+			storeDir = filepath.Join(tempDir, "store")
+			_ = os.MkdirAll(storeDir, 0755)
+			script := fmt.Sprintf(`#!/bin/bash
+set -e
+case $1 in
+	backup_data) cat > %[1]s/$(basename $3) ;;
+	restore_data) cat %[1]s/$(basename $3) ;;
+	backup_file) cp $3 %[1]s/ ;;
+	restore_file) cp %[1]s/$(basename $3) $3 ;;
+	delete_backup) echo "cannot delete backup $3" >&2; exit 1 ;;
+esac
+`, storeDir)
+			executablePath := filepath.Join(tempDir, "test_plugin")
+			Expect(ioutil.WriteFile(executablePath, []byte(script), 0755)).To(Succeed())
+			backend = &storage.PluginBackend{ExecutablePath: executablePath, ConfigPath: "/tmp/test_plugin_config.yaml"}
+		})
+		It("streams data to and from the plugin", func() {
+			putString(backend, dataFile, "table data")
+
+			Expect(ioutil.ReadFile(filepath.Join(storeDir, "gpbackup_0_20170101010101_pipe_1234"))).To(Equal([]byte("table data")))
+			Expect(getString(backend, dataFile)).To(Equal("table data"))
+		})
+		It("reads data from an offset by skipping the data before it", func() {
+			putString(backend, dataFile, "table data")
+
+			Expect(getStringAt(backend, dataFile, 6)).To(Equal("data"))
+			Expect(storage.SupportsOffsets(backend)).To(BeFalse())
+		})
+		It("reports an error from the plugin when reading data", func() {
+			_, err := getString(backend, "/data/gpseg0/backups/20170101/20170101010101/missing")
+
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("No such file or directory"))
+		})
+		It("uses the file commands of the plugin for files", func() {
+			filename := filepath.Join(tempDir, "gpbackup_20170101010101_config.yaml")
+			Expect(ioutil.WriteFile(filename, []byte("config contents"), 0644)).To(Succeed())
+
+			Expect(storage.PutFile(backend, filename)).To(Succeed())
+			Expect(os.Remove(filename)).To(Succeed())
+			Expect(storage.GetFile(backend, filename)).To(Succeed())
+
+			Expect(ioutil.ReadFile(filepath.Join(storeDir, "gpbackup_20170101010101_config.yaml"))).To(Equal([]byte("config contents")))
+			Expect(ioutil.ReadFile(filename)).To(Equal([]byte("config contents")))
+		})
+		It("uses the delete_backup command of the plugin to delete a backup", func() {
+			err := storage.DeleteBackup(backend, "20170101010101")
+
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("cannot delete backup 20170101010101"))
+		})
+		It("does not support listing files", func() {
+			_, err := backend.List("/backups/")
+
+			Expect(err).To(MatchError(fmt.Sprintf("Plugin %s does not support listing files", backend.ExecutablePath)))
+		})
+	})
+	Describe("PluginBackend with version 2 of the plugin API", func() {
+		var backend *storage.PluginBackend
+		var storeDir string
+		BeforeEach(func() {
+			storeDir = filepath.Join(tempDir, "store")
+			_ = os.MkdirAll(storeDir, 0755)
+			script := fmt.Sprintf(`#!/bin/bash
+file=%[1]s/$(basename $3)${4:+_part$4}
+case $1 in
+	backup_data) cat > $file ;;
+	restore_data)
+		if [[ ! -f $file ]]; then echo "{\"error\": \"Unable to find $3\", \"code\": \"not_found\"}" >&2; exit 1; fi
+		cat $file ;;
+	restore_data_range) tail -c +$(($4 + 1)) %[1]s/$(basename $3) ;;
+	stat_file)
+		parts=$(ls ${file}_part* 2>/dev/null | wc -l)
+		size=$(cat $file ${file}_part* 2>/dev/null | wc -c)
+		echo "{\"size\": $size, \"parts\": $parts}" ;;
+	list_backups) echo 20170101010101; echo 20170102010101 ;;
+esac
+`, storeDir)
+			executablePath := filepath.Join(tempDir, "test_plugin")
+			Expect(ioutil.WriteFile(executablePath, []byte(script), 0755)).To(Succeed())
+			backend = &storage.PluginBackend{
+				ExecutablePath: executablePath,
+				ConfigPath:     "/tmp/test_plugin_config.yaml",
+				Capabilities:   storage.BuiltinCapabilities,
+				Streams:        2,
+				PartSize:       4,
+			}
+		})
+		It("streams data to and from the plugin in parts", func() {
+			putString(backend, dataFile, "0123456789")
+
+			Expect(ioutil.ReadFile(filepath.Join(storeDir, "gpbackup_0_20170101010101_pipe_1234_part1"))).To(Equal([]byte("0123")))
+			Expect(ioutil.ReadFile(filepath.Join(storeDir, "gpbackup_0_20170101010101_pipe_1234_part2"))).To(Equal([]byte("4567")))
+			Expect(ioutil.ReadFile(filepath.Join(storeDir, "gpbackup_0_20170101010101_pipe_1234_part3"))).To(Equal([]byte("89")))
+			Expect(storage.StatFile(backend, dataFile)).To(Equal(storage.FileStat{Size: 10, Parts: 3}))
+			Expect(getString(backend, dataFile)).To(Equal("0123456789"))
+		})
+		It("stores an empty stream as a single part", func() {
+			putString(backend, dataFile, "")
+
+			Expect(storage.StatFile(backend, dataFile)).To(Equal(storage.FileStat{Size: 0, Parts: 1}))
+			Expect(getString(backend, dataFile)).To(Equal(""))
+		})
+		It("reads data from an offset with restore_data_range", func() {
+			Expect(ioutil.WriteFile(filepath.Join(storeDir, "gpbackup_0_20170101010101_pipe_1234"), []byte("table data"), 0644)).To(Succeed())
+
+			Expect(getStringAt(backend, dataFile, 6)).To(Equal("data"))
+			Expect(storage.SupportsOffsets(backend)).To(BeTrue())
+		})
+		It("restores data that was not backed up in parts", func() {
+			Expect(ioutil.WriteFile(filepath.Join(storeDir, "gpbackup_0_20170101010101_pipe_1234"), []byte("table data"), 0644)).To(Succeed())
+
+			Expect(storage.StatFile(backend, dataFile)).To(Equal(storage.FileStat{Size: 10, Parts: 0}))
+			Expect(getString(backend, dataFile)).To(Equal("table data"))
+		})
+		It("streams data in a single part when using one stream", func() {
+			backend.Streams = 1
+
+			putString(backend, dataFile, "table data")
+
+			Expect(ioutil.ReadFile(filepath.Join(storeDir, "gpbackup_0_20170101010101_pipe_1234"))).To(Equal([]byte("table data")))
+		})
+		It("lists the backups stored by the plugin", func() {
+			Expect(storage.ListBackups(backend)).To(Equal([]string{"20170101010101", "20170102010101"}))
+		})
+		It("does not list backups if the plugin does not have the capability", func() {
+			backend.Capabilities = nil
+
+			_, err := storage.ListBackups(backend)
+
+			Expect(err).To(MatchError(fmt.Sprintf("Plugin %s does not support listing backups", backend.ExecutablePath)))
+		})
+		It("returns the structured error written by the plugin", func() {
+			backend.Capabilities = []string{storage.CapabilityJSONErrors}
+
+			_, err := getString(backend, "/data/gpseg0/backups/20170101/20170101010101/missing")
+
+			Expect(err).To(Equal(&storage.PluginError{Message: "Unable to find /data/gpseg0/backups/20170101/20170101010101/missing", Code: storage.ErrorCodeNotFound}))
+			Expect(storage.IsNotFound(err)).To(BeTrue())
+		})
+	})
+	Describe("PluginBackend retries and timeouts", func() {
+		var backend *storage.PluginBackend
+		var attemptsFile string
+		// The plugin fails with the given exit code and output until it has been run the given number of times
+		writePlugin := func(failures int, exitCode int, stderr string) {
+			script := fmt.Sprintf(`#!/bin/bash
+echo x >> %[1]s
+case $1 in
+	stat_file) sleep 10 ;;
+	*) if [[ $(cat %[1]s | wc -l) -le %[2]d ]]; then echo '%[4]s' >&2; exit %[3]d; fi ;;
+esac
+`, attemptsFile, failures, exitCode, stderr)
+			Expect(ioutil.WriteFile(backend.ExecutablePath, []byte(script), 0755)).To(Succeed())
+		}
+		attempts := func() int {
+			contents, _ := ioutil.ReadFile(attemptsFile)
+			return bytes.Count(contents, []byte("\n"))
+		}
+		BeforeEach(func() {
+			attemptsFile = filepath.Join(tempDir, "attempts")
+			backend = &storage.PluginBackend{
+				ExecutablePath: filepath.Join(tempDir, "test_plugin"),
+				ConfigPath:     "/tmp/test_plugin_config.yaml",
+				Capabilities:   storage.BuiltinCapabilities,
+				MaxRetries:     3,
+			}
+		})
+		It("retries a command that exits with the retryable exit code", func() {
+			writePlugin(2, storage.RetryableExitCode, "Bucket is unavailable")
+
+			Expect(storage.DeleteBackup(backend, "20170101010101")).To(Succeed())
+			Expect(attempts()).To(Equal(3))
+		})
+		It("retries a command that reports a retryable structured error", func() {
+			writePlugin(1, 1, `{"error": "Bucket is unavailable", "retryable": true}`)
+
+			Expect(storage.DeleteBackup(backend, "20170101010101")).To(Succeed())
+			Expect(attempts()).To(Equal(2))
+		})
+		It("does not retry a command that fails with a fatal error", func() {
+			writePlugin(1, 1, "Access denied")
+
+			err := storage.DeleteBackup(backend, "20170101010101")
+
+			Expect(err).To(MatchError("Access denied: exit status 1"))
+			Expect(attempts()).To(Equal(1))
+		})
+		It("does not retry a command that reports a structured error that is not retryable", func() {
+			writePlugin(1, storage.RetryableExitCode, `{"error": "Access denied", "code": "access_denied"}`)
+
+			err := storage.DeleteBackup(backend, "20170101010101")
+
+			Expect(err).To(Equal(&storage.PluginError{Message: "Access denied", Code: "access_denied"}))
+			Expect(attempts()).To(Equal(1))
+		})
+		It("returns the last error once the retries are exhausted", func() {
+			writePlugin(10, storage.RetryableExitCode, "Bucket is unavailable")
+
+			err := storage.DeleteBackup(backend, "20170101010101")
+
+			Expect(err).To(MatchError("Plugin command delete_backup failed after 4 attempts: Bucket is unavailable: exit status 75"))
+			Expect(storage.IsRetryable(err)).To(BeTrue())
+			Expect(attempts()).To(Equal(4))
+		})
+		It("does not retry data commands", func() {
+			writePlugin(1, storage.RetryableExitCode, "Bucket is unavailable")
+			backend.Capabilities = nil
+
+			_, err := getString(backend, dataFile)
+
+			Expect(err).To(MatchError("Bucket is unavailable: exit status 75"))
+			Expect(attempts()).To(Equal(1))
+		})
+		It("kills a command that runs for longer than its timeout", func() {
+			writePlugin(0, 0, "")
+			backend.MaxRetries = 0
+			backend.Timeouts = map[string]time.Duration{"stat_file": 100 * time.Millisecond}
+
+			start := time.Now()
+			_, err := storage.StatFile(backend, dataFile)
+
+			Expect(err).To(Equal(&storage.PluginError{Message: "Plugin command stat_file timed out after 100ms", Code: storage.ErrorCodeTimeout, Retryable: true}))
+			Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+		})
+	})
+	Describe("CopyBackupFile", func() {
+		var source *storage.FilesystemBackend
+		var dest *storage.LocalBackend
+		var sourcePath string
+		BeforeEach(func() {
+			source = &storage.FilesystemBackend{}
+			dest, _ = storage.NewLocalBackend(map[string]string{"directory": filepath.Join(tempDir, "store")})
+			sourcePath = filepath.Join(tempDir, "backups/20170101/20170101010101/gpbackup_0_20170101010101_toc.yaml")
+			putString(source, sourcePath, "contents")
+		})
+		It("streams a data file to the destination and checksums it", func() {
+			result, err := storage.CopyBackupFile(source, dest, storage.CopyEntry{SourcePath: sourcePath, DestPath: dataFile, IsData: true})
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(Equal(storage.CopyResult{
+				Path:     dataFile,
+				Size:     8,
+				Checksum: "d1b2a59fbea7e20077af9f91b27e95e865061b270be03ff539ab3b73587882e8",
+			}))
+			Expect(getString(dest, dataFile)).To(Equal("contents"))
+		})
+		It("copies a whole file to a different path before storing it", func() {
+			destPath := filepath.Join(tempDir, "copy/gpbackup_0_20170101010101_toc.yaml")
+
+			result, err := storage.CopyBackupFile(source, dest, storage.CopyEntry{SourcePath: sourcePath, DestPath: destPath})
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.Size).To(Equal(int64(8)))
+			Expect(ioutil.ReadFile(destPath)).To(Equal([]byte("contents")))
+			Expect(getString(dest, destPath)).To(Equal("contents"))
+			Expect(destPath + "_gpbackup_verify").ToNot(BeAnExistingFile())
+		})
+		It("retrieves a whole file from the source and removes it once copied", func() {
+			destPath := filepath.Join(tempDir, "copy/gpbackup_0_20170101010101_toc.yaml")
+			Expect(storage.PutFile(dest, sourcePath)).To(Succeed())
+			Expect(os.Remove(sourcePath)).To(Succeed())
+
+			_, err := storage.CopyBackupFile(dest, source, storage.CopyEntry{SourcePath: sourcePath, DestPath: destPath})
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ioutil.ReadFile(destPath)).To(Equal([]byte("contents")))
+			Expect(sourcePath).ToNot(BeAnExistingFile())
+		})
+		It("returns an error if the source file does not exist", func() {
+			_, err := storage.CopyBackupFile(source, dest, storage.CopyEntry{SourcePath: sourcePath + "_missing", DestPath: dataFile, IsData: true})
+
+			Expect(err).To(HaveOccurred())
+		})
+		Context("to a plugin", func() {
+			var plugin *storage.PluginBackend
+			BeforeEach(func() {
+				storeDir := filepath.Join(tempDir, "store")
+				_ = os.MkdirAll(storeDir, 0755)
+				// restore_data always fails, so a copy can only be verified without reading it back
+				script := fmt.Sprintf(`#!/bin/bash
+case $1 in
+	backup_data) cat > %[1]s/$(basename $3) ;;
+	restore_data) echo "not readable" >&2; exit 1 ;;
+	stat_file) echo "{\"size\": $(cat %[1]s/$(basename $3) | wc -c), \"parts\": 0}" ;;
+esac
+`, storeDir)
+				executablePath := filepath.Join(tempDir, "test_plugin")
+				Expect(ioutil.WriteFile(executablePath, []byte(script), 0755)).To(Succeed())
+				plugin = &storage.PluginBackend{ExecutablePath: executablePath, ConfigPath: "/tmp/test_plugin_config.yaml", Capabilities: []string{storage.CapabilityStatFile}}
+			})
+			It("verifies a data file by the size the plugin reports", func() {
+				result, err := storage.CopyBackupFile(source, plugin, storage.CopyEntry{SourcePath: sourcePath, DestPath: dataFile, IsData: true})
+
+				Expect(err).ToNot(HaveOccurred())
+				Expect(result.Size).To(Equal(int64(8)))
+			})
+			It("reads a data file back to verify it if the plugin cannot report sizes", func() {
+				plugin.Capabilities = []string{}
+
+				_, err := storage.CopyBackupFile(source, plugin, storage.CopyEntry{SourcePath: sourcePath, DestPath: dataFile, IsData: true})
+
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("Unable to verify copy of " + dataFile))
+			})
+		})
+	})
+	Describe("ParsePluginError", func() {
+		It("parses a structured error", func() {
+			err := storage.ParsePluginError(errors.New("exit status 1"), `{"error": "Bucket is unavailable", "code": "unavailable", "retryable": true}`+"\n")
+
+			Expect(err).To(Equal(&storage.PluginError{Message: "Bucket is unavailable", Code: "unavailable", Retryable: true}))
+		})
+		It("wraps the error with unstructured output", func() {
+			err := storage.ParsePluginError(errors.New("exit status 1"), "Bucket is unavailable\n")
+
+			Expect(err).To(MatchError("Bucket is unavailable: exit status 1"))
+		})
+	})
+	Describe("FormatPluginError", func() {
+		It("formats an error as a structured error", func() {
+			Expect(storage.FormatPluginError(errors.New("Bucket is unavailable"))).To(Equal(`{"error":"Bucket is unavailable","code":"unknown"}`))
+		})
+		It("reports a missing file with the not_found code", func() {
+			_, err := os.Open("/nonexistent/file")
+
+			Expect(storage.FormatPluginError(err)).To(Equal(`{"error":"open /nonexistent/file: no such file or directory","code":"not_found"}`))
+		})
+	})
+	Describe("S3Backend", func() {
+		var server *testutils.FakeS3Server
+		var backend *storage.S3Backend
+		BeforeEach(func() {
+			server = testutils.NewFakeS3Server("my_bucket")
+			backend, _ = storage.NewS3Backend(map[string]string{"endpoint": server.URL, "bucket": "my_bucket", "folder": "my/folder", "aws_access_key_id": "key", "aws_secret_access_key": "secret"})
+		})
+		AfterEach(func() {
+			server.Close()
+		})
+		It("streams data to and from the bucket", func() {
+			putString(backend, dataFile, "table data")
+
+			Expect(server.GetObject("my/folder/backups/20170101/20170101010101/gpbackup_0_20170101010101_pipe_1234")).To(Equal([]byte("table data")))
+			Expect(getString(backend, dataFile)).To(Equal("table data"))
+		})
+		It("streams data from an offset", func() {
+			putString(backend, dataFile, "table data")
+
+			Expect(getStringAt(backend, dataFile, 6)).To(Equal("data"))
+		})
+		It("returns an error for a missing file", func() {
+			_, err := backend.GetStream("/data/gpseg0/backups/20170101/20170101010101/missing")
+
+			Expect(err).To(MatchError("Unable to find my/folder/backups/20170101/20170101010101/missing: HTTP status 404"))
+		})
+		It("lists the files with a prefix as paths", func() {
+			server.PutObject("my/folder/backups/20170101/20170101010101/file1", []byte("data"))
+			server.PutObject("my/folder/backups/20170101/20170101020202/file1", []byte("data"))
+
+			objects, err := backend.List("/data/gpseg0/backups/20170101/20170101010101/")
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(objects).To(HaveLen(1))
+			Expect(objects[0].Key).To(Equal("/data/gpseg0/backups/20170101/20170101010101/file1"))
+		})
+	})
+	Describe("RunPluginCommand", func() {
+		var backend *storage.LocalBackend
+		BeforeEach(func() {
+			backend, _ = storage.NewLocalBackend(map[string]string{"directory": filepath.Join(tempDir, "store")})
+		})
+		It("backs up and restores streamed data", func() {
+			var stdout bytes.Buffer
+
+			Expect(storage.RunPluginCommand(backend, []string{"backup_data", "config.yaml", dataFile}, bytes.NewBufferString("table data"), nil)).To(Succeed())
+			Expect(storage.RunPluginCommand(backend, []string{"restore_data", "config.yaml", dataFile}, nil, &stdout)).To(Succeed())
+
+			Expect(stdout.String()).To(Equal("table data"))
+		})
+		It("creates the local backup directory during setup", func() {
+			backupDir := filepath.Join(tempDir, "backups", "20170101", "20170101010101")
+
+			Expect(storage.RunPluginCommand(backend, []string{"setup_plugin_for_restore", "config.yaml", backupDir, "master", "-1"}, nil, nil)).To(Succeed())
+
+			Expect(backupDir).To(BeADirectory())
+		})
+		It("deletes a backup", func() {
+			putString(backend, dataFile, "table data")
+
+			Expect(storage.RunPluginCommand(backend, []string{"delete_backup", "config.yaml", "20170101010101"}, nil, nil)).To(Succeed())
+
+			_, err := backend.Stat(dataFile)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+		It("backs up and restores data in parts", func() {
+			var stdout bytes.Buffer
+
+			Expect(storage.RunPluginCommand(backend, []string{"backup_data", "config.yaml", dataFile, "1"}, bytes.NewBufferString("table "), nil)).To(Succeed())
+			Expect(storage.RunPluginCommand(backend, []string{"backup_data", "config.yaml", dataFile, "2"}, bytes.NewBufferString("data"), nil)).To(Succeed())
+			Expect(storage.RunPluginCommand(backend, []string{"stat_file", "config.yaml", dataFile}, nil, &stdout)).To(Succeed())
+			Expect(storage.RunPluginCommand(backend, []string{"restore_data", "config.yaml", dataFile, "2"}, nil, &stdout)).To(Succeed())
+
+			Expect(stdout.String()).To(Equal("{\"size\":10,\"parts\":2}\ndata"))
+		})
+		It("restores data from an offset", func() {
+			var stdout bytes.Buffer
+			putString(backend, dataFile, "table data")
+
+			Expect(storage.RunPluginCommand(backend, []string{"restore_data_range", "config.yaml", dataFile, "6"}, nil, &stdout)).To(Succeed())
+
+			Expect(stdout.String()).To(Equal("data"))
+		})
+		It("restores data stored in parts from an offset in a later part", func() {
+			var stdout bytes.Buffer
+
+			Expect(storage.RunPluginCommand(backend, []string{"backup_data", "config.yaml", dataFile, "1"}, bytes.NewBufferString("table "), nil)).To(Succeed())
+			Expect(storage.RunPluginCommand(backend, []string{"backup_data", "config.yaml", dataFile, "2"}, bytes.NewBufferString("data"), nil)).To(Succeed())
+			Expect(storage.RunPluginCommand(backend, []string{"restore_data_range", "config.yaml", dataFile, "3"}, nil, &stdout)).To(Succeed())
+			Expect(storage.RunPluginCommand(backend, []string{"restore_data_range", "config.yaml", dataFile, "8"}, nil, &stdout)).To(Succeed())
+
+			Expect(stdout.String()).To(Equal("le datata"))
+		})
+		It("lists backups", func() {
+			var stdout bytes.Buffer
+			putString(backend, "/data/gpseg0/backups/20170102/20170102010101/gpbackup_20170102010101_config.yaml", "config")
+			putString(backend, dataFile, "table data")
+
+			Expect(storage.RunPluginCommand(backend, []string{"list_backups", "config.yaml"}, nil, &stdout)).To(Succeed())
+
+			Expect(stdout.String()).To(Equal("20170101010101\n20170102010101\n"))
+		})
+		It("returns an error for an unknown command", func() {
+			err := storage.RunPluginCommand(backend, []string{"unknown_command", "config.yaml", "arg"}, nil, nil)
+
+			Expect(err).To(MatchError("Unknown plugin command unknown_command"))
+		})
+	})
+})
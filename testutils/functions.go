@@ -148,6 +148,7 @@ var objNameToClassID = map[string]uint32{
 	"FUNCTION":                  1255,
 	"INDEX":                     2610,
 	"LANGUAGE":                  2612,
+	"MATERIALIZED VIEW":         1259,
 	"OPERATOR CLASS":            2616,
 	"OPERATOR FAMILY":           2753,
 	"OPERATOR":                  2617,
@@ -177,13 +178,13 @@ func ClassIDFromObjectName(objName string) uint32 {
 func DefaultACLForType(grantee string, objType string) backup.ACL {
 	return backup.ACL{
 		Grantee:    grantee,
-		Select:     objType == "PROTOCOL" || objType == "SEQUENCE" || objType == "TABLE" || objType == "VIEW" || objType == "FOREIGN TABLE",
-		Insert:     objType == "PROTOCOL" || objType == "TABLE" || objType == "VIEW" || objType == "FOREIGN TABLE",
-		Update:     objType == "SEQUENCE" || objType == "TABLE" || objType == "VIEW" || objType == "FOREIGN TABLE",
-		Delete:     objType == "TABLE" || objType == "VIEW" || objType == "FOREIGN TABLE",
-		Truncate:   objType == "TABLE" || objType == "VIEW",
-		References: objType == "TABLE" || objType == "VIEW" || objType == "FOREIGN TABLE",
-		Trigger:    objType == "TABLE" || objType == "VIEW" || objType == "FOREIGN TABLE",
+		Select:     objType == "PROTOCOL" || objType == "SEQUENCE" || objType == "TABLE" || objType == "VIEW" || objType == "MATERIALIZED VIEW" || objType == "FOREIGN TABLE",
+		Insert:     objType == "PROTOCOL" || objType == "TABLE" || objType == "VIEW" || objType == "MATERIALIZED VIEW" || objType == "FOREIGN TABLE",
+		Update:     objType == "SEQUENCE" || objType == "TABLE" || objType == "VIEW" || objType == "MATERIALIZED VIEW" || objType == "FOREIGN TABLE",
+		Delete:     objType == "TABLE" || objType == "VIEW" || objType == "MATERIALIZED VIEW" || objType == "FOREIGN TABLE",
+		Truncate:   objType == "TABLE" || objType == "VIEW" || objType == "MATERIALIZED VIEW",
+		References: objType == "TABLE" || objType == "VIEW" || objType == "MATERIALIZED VIEW" || objType == "FOREIGN TABLE",
+		Trigger:    objType == "TABLE" || objType == "VIEW" || objType == "MATERIALIZED VIEW" || objType == "FOREIGN TABLE",
 		Usage:      objType == "LANGUAGE" || objType == "SCHEMA" || objType == "SEQUENCE" || objType == "FOREIGN DATA WRAPPER" || objType == "FOREIGN SERVER",
 		Execute:    objType == "FUNCTION" || objType == "AGGREGATE",
 		Create:     objType == "DATABASE" || objType == "SCHEMA" || objType == "TABLESPACE",
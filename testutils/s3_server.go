@@ -0,0 +1,240 @@
+package testutils
+
+/*
+ * This file contains an in-memory stand-in for an S3-compatible object store,
+ * implementing the subset of the S3 API used by the built-in S3 backend.
+ */
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type FakeS3Object struct {
+	Data     []byte
+	ETag     string
+	Metadata http.Header
+}
+
+type fakeS3Upload struct {
+	key      string
+	parts    map[int][]byte
+	metadata http.Header
+}
+
+type FakeS3Server struct {
+	*httptest.Server
+	Bucket string
+	// The next FailRequests requests will fail with an internal server error
+	FailRequests int
+	NumRequests  int
+	Objects      map[string]*FakeS3Object
+	uploads      map[string]*fakeS3Upload
+	nextUploadID int
+	lock         sync.Mutex
+}
+
+func NewFakeS3Server(bucket string) *FakeS3Server {
+	server := &FakeS3Server{
+		Bucket:  bucket,
+		Objects: make(map[string]*FakeS3Object),
+		uploads: make(map[string]*fakeS3Upload),
+	}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.handleRequest))
+	return server
+}
+
+func (server *FakeS3Server) GetObject(key string) []byte {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	if object, ok := server.Objects[key]; ok {
+		return object.Data
+	}
+	return nil
+}
+
+func (server *FakeS3Server) PutObject(key string, data []byte) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.Objects[key] = &FakeS3Object{Data: data, ETag: md5Hex(data), Metadata: http.Header{}}
+}
+
+func (server *FakeS3Server) NumUploads() int {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return len(server.uploads)
+}
+
+func md5Hex(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func writeS3Error(writer http.ResponseWriter, status int, code string) {
+	writer.WriteHeader(status)
+	fmt.Fprintf(writer, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func (server *FakeS3Server) handleRequest(writer http.ResponseWriter, request *http.Request) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.NumRequests++
+	if server.FailRequests > 0 {
+		server.FailRequests--
+		writeS3Error(writer, http.StatusInternalServerError, "InternalError")
+		return
+	}
+	if !strings.HasPrefix(request.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=") {
+		writeS3Error(writer, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	pathParts := strings.SplitN(strings.TrimPrefix(request.URL.Path, "/"), "/", 2)
+	if pathParts[0] != server.Bucket {
+		writeS3Error(writer, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	key := ""
+	if len(pathParts) == 2 {
+		key = pathParts[1]
+	}
+	body, _ := ioutil.ReadAll(request.Body)
+	if contentMD5 := request.Header.Get("Content-MD5"); contentMD5 != "" {
+		sum := md5.Sum(body)
+		if contentMD5 != base64.StdEncoding.EncodeToString(sum[:]) {
+			writeS3Error(writer, http.StatusBadRequest, "BadDigest")
+			return
+		}
+	}
+	query := request.URL.Query()
+
+	switch {
+	case request.Method == "GET" && key == "":
+		server.listObjects(writer, query.Get("prefix"))
+	case request.Method == "POST" && query["uploads"] != nil:
+		server.nextUploadID++
+		uploadID := strconv.Itoa(server.nextUploadID)
+		metadata := http.Header{}
+		for name, values := range request.Header {
+			if strings.HasPrefix(name, "X-Amz-Meta-") {
+				metadata[name] = values
+			}
+		}
+		server.uploads[uploadID] = &fakeS3Upload{key: key, parts: make(map[int][]byte), metadata: metadata}
+		fmt.Fprintf(writer, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", uploadID)
+	case request.Method == "PUT" && query.Get("uploadId") != "":
+		upload, ok := server.uploads[query.Get("uploadId")]
+		if !ok {
+			writeS3Error(writer, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		partNumber, _ := strconv.Atoi(query.Get("partNumber"))
+		upload.parts[partNumber] = body
+		writer.Header().Set("ETag", fmt.Sprintf(`"%s"`, md5Hex(body)))
+	case request.Method == "POST" && query.Get("uploadId") != "":
+		server.completeUpload(writer, query.Get("uploadId"), body)
+	case request.Method == "DELETE" && query.Get("uploadId") != "":
+		delete(server.uploads, query.Get("uploadId"))
+		writer.WriteHeader(http.StatusNoContent)
+	case request.Method == "PUT":
+		server.Objects[key] = &FakeS3Object{Data: body, ETag: md5Hex(body), Metadata: http.Header{}}
+		writer.Header().Set("ETag", fmt.Sprintf(`"%s"`, md5Hex(body)))
+	case request.Method == "GET" || request.Method == "HEAD":
+		server.getObject(writer, request, key)
+	case request.Method == "DELETE":
+		delete(server.Objects, key)
+		writer.WriteHeader(http.StatusNoContent)
+	default:
+		writeS3Error(writer, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (server *FakeS3Server) completeUpload(writer http.ResponseWriter, uploadID string, body []byte) {
+	upload, ok := server.uploads[uploadID]
+	if !ok {
+		writeS3Error(writer, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+	completed := struct {
+		Parts []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}{}
+	if xml.Unmarshal(body, &completed) != nil || len(completed.Parts) == 0 {
+		writeS3Error(writer, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+	data := make([]byte, 0)
+	partMD5s := make([]byte, 0)
+	for i, part := range completed.Parts {
+		partData, ok := upload.parts[part.PartNumber]
+		if part.PartNumber != i+1 || !ok || strings.Trim(part.ETag, `"`) != md5Hex(partData) {
+			writeS3Error(writer, http.StatusBadRequest, "InvalidPart")
+			return
+		}
+		data = append(data, partData...)
+		partMD5 := md5.Sum(partData)
+		partMD5s = append(partMD5s, partMD5[:]...)
+	}
+	etag := fmt.Sprintf("%s-%d", md5Hex(partMD5s), len(completed.Parts))
+	server.Objects[upload.key] = &FakeS3Object{Data: data, ETag: etag, Metadata: upload.metadata}
+	delete(server.uploads, uploadID)
+	fmt.Fprintf(writer, "<CompleteMultipartUploadResult><Key>%s</Key><ETag>\"%s\"</ETag></CompleteMultipartUploadResult>", upload.key, etag)
+}
+
+func (server *FakeS3Server) getObject(writer http.ResponseWriter, request *http.Request, key string) {
+	object, ok := server.Objects[key]
+	if !ok {
+		writeS3Error(writer, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	for name, values := range object.Metadata {
+		writer.Header()[name] = values
+	}
+	writer.Header().Set("ETag", fmt.Sprintf(`"%s"`, object.ETag))
+	data := object.Data
+	status := http.StatusOK
+	if byteRange := request.Header.Get("Range"); byteRange != "" {
+		var start, end int
+		_, err := fmt.Sscanf(byteRange, "bytes=%d-%d", &start, &end)
+		if err != nil || start > end || start >= len(data) {
+			writeS3Error(writer, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+			return
+		}
+		if end >= len(data) {
+			end = len(data) - 1
+		}
+		data = data[start : end+1]
+		status = http.StatusPartialContent
+	}
+	writer.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	writer.WriteHeader(status)
+	if request.Method == "GET" {
+		_, _ = writer.Write(data)
+	}
+}
+
+func (server *FakeS3Server) listObjects(writer http.ResponseWriter, prefix string) {
+	keys := make([]string, 0)
+	for key := range server.Objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	fmt.Fprint(writer, "<ListBucketResult><IsTruncated>false</IsTruncated>")
+	for _, key := range keys {
+		fmt.Fprintf(writer, "<Contents><Key>%s</Key><Size>%d</Size><ETag>\"%s\"</ETag></Contents>", key, len(server.Objects[key].Data), server.Objects[key].ETag)
+	}
+	fmt.Fprint(writer, "</ListBucketResult>")
+}
@@ -0,0 +1,279 @@
+package utils
+
+/*
+ * This file contains the control channel of the gpbackup_helper agents.  Each
+ * agent serves RPCs on a Unix socket on its segment host, through which
+ * gpbackup and gprestore follow the progress of every table, learn of errors
+ * as soon as they happen, and stop the agent once it is no longer needed.
+ */
+
+import (
+	"io"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	AgentRunning  = "running"
+	AgentFinished = "finished"
+	AgentFailed   = "failed"
+)
+
+// A call to an agent that is blocked, e.g. on a hung plugin, must not block the coordinator with it
+var AgentCallTimeout = 30 * time.Second
+
+var AgentPollInterval = time.Second
+
+// How long to wait for agents that have stopped making progress before giving up on them
+var AgentWaitTimeout = 30 * time.Minute
+
+type TableProgress struct {
+	Oid   uint32
+	Bytes int64
+	Done  bool
+	Error string `json:",omitempty"`
+}
+
+type AgentStatus struct {
+	ContentID int
+	State     string
+	Tables    []TableProgress
+	Error     string `json:",omitempty"`
+}
+
+func (status AgentStatus) BytesProcessed() int64 {
+	numBytes := int64(0)
+	for _, table := range status.Tables {
+		numBytes += table.Bytes
+	}
+	return numBytes
+}
+
+func (status AgentStatus) TablesDone() int {
+	numTables := 0
+	for _, table := range status.Tables {
+		if table.Done {
+			numTables++
+		}
+	}
+	return numTables
+}
+
+/*
+ * AgentProgress is updated by the agent as it processes each table, and is
+ * read concurrently by the RPC server.
+ */
+type AgentProgress struct {
+	mutex    sync.Mutex
+	status   AgentStatus
+	tableMap map[uint32]int
+	lastCall time.Time
+}
+
+func NewAgentProgress(contentID int) *AgentProgress {
+	return &AgentProgress{
+		status:   AgentStatus{ContentID: contentID, State: AgentRunning, Tables: make([]TableProgress, 0)},
+		tableMap: make(map[uint32]int),
+		lastCall: time.Now(),
+	}
+}
+
+func (progress *AgentProgress) getTable(oid uint32) *TableProgress {
+	index, ok := progress.tableMap[oid]
+	if !ok {
+		index = len(progress.status.Tables)
+		progress.status.Tables = append(progress.status.Tables, TableProgress{Oid: oid})
+		progress.tableMap[oid] = index
+	}
+	return &progress.status.Tables[index]
+}
+
+func (progress *AgentProgress) StartTable(oid uint32) {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	progress.getTable(oid)
+}
+
+func (progress *AgentProgress) AddBytes(oid uint32, numBytes int64) {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	progress.getTable(oid).Bytes += numBytes
+}
+
+func (progress *AgentProgress) FinishTable(oid uint32, err error) {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	table := progress.getTable(oid)
+	table.Done = true
+	if err != nil {
+		table.Error = err.Error()
+	}
+}
+
+func (progress *AgentProgress) Finish(err error) {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	if err != nil {
+		progress.status.State = AgentFailed
+		progress.status.Error = err.Error()
+	} else {
+		progress.status.State = AgentFinished
+	}
+}
+
+func (progress *AgentProgress) Status() AgentStatus {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	status := progress.status
+	status.Tables = make([]TableProgress, len(progress.status.Tables))
+	copy(status.Tables, progress.status.Tables)
+	return status
+}
+
+func (progress *AgentProgress) LastCallTime() time.Time {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	return progress.lastCall
+}
+
+func (progress *AgentProgress) recordCall() {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	progress.lastCall = time.Now()
+}
+
+/*
+ * AgentService holds the methods callable over the control socket, which
+ * are registered under the name "Agent".
+ */
+type AgentService struct {
+	progress *AgentProgress
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+func (service *AgentService) Status(_ struct{}, reply *AgentStatus) error {
+	service.progress.recordCall()
+	*reply = service.progress.Status()
+	return nil
+}
+
+func (service *AgentService) Stop(_ struct{}, reply *bool) error {
+	service.progress.recordCall()
+	service.stopOnce.Do(func() { close(service.stop) })
+	*reply = true
+	return nil
+}
+
+type AgentServer struct {
+	SocketPath string
+	listener   net.Listener
+	service    *AgentService
+}
+
+/*
+ * Anyone who can connect to the socket can stop the agent or read its
+ * progress, so it is only created in a directory that is owned by the
+ * agent's user and that no other user can access.
+ */
+func EnsurePrivateDirectory(dir string) error {
+	err := os.Mkdir(dir, 0700)
+	if err != nil && !os.IsExist(err) {
+		return err
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.Errorf("%s is not a directory", dir)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() {
+		return errors.Errorf("Directory %s is owned by another user", dir)
+	}
+	if info.Mode().Perm() != 0700 {
+		return errors.Errorf("Directory %s has permissions %04o, but must only be accessible by its owner", dir, info.Mode().Perm())
+	}
+	return nil
+}
+
+func ServeAgentControl(socketPath string, progress *AgentProgress) (*AgentServer, error) {
+	err := EnsurePrivateDirectory(filepath.Dir(socketPath))
+	if err != nil {
+		return nil, err
+	}
+	// A socket left behind by an agent that did not exit cleanly would prevent listening
+	_ = os.Remove(socketPath)
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	service := &AgentService{progress: progress, stop: make(chan struct{})}
+	server := rpc.NewServer()
+	err = server.RegisterName("Agent", service)
+	if err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.ServeCodec(jsonrpc.NewServerCodec(conn))
+		}
+	}()
+	return &AgentServer{SocketPath: socketPath, listener: listener, service: service}, nil
+}
+
+func (agentServer *AgentServer) StopRequested() <-chan struct{} {
+	return agentServer.service.stop
+}
+
+func (agentServer *AgentServer) Close() error {
+	// Closing a Unix listener also removes its socket
+	return agentServer.listener.Close()
+}
+
+func DialAgent(socketPath string) (*rpc.Client, error) {
+	conn, err := net.DialTimeout("unix", socketPath, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return jsonrpc.NewClient(conn), nil
+}
+
+func GetAgentStatus(client *rpc.Client) (AgentStatus, error) {
+	status := AgentStatus{}
+	call := client.Go("Agent.Status", struct{}{}, &status, nil)
+	select {
+	case <-call.Done:
+		return status, call.Error
+	case <-time.After(AgentCallTimeout):
+		return status, errors.Errorf("No response from agent after %s", AgentCallTimeout)
+	}
+}
+
+func StopAgent(client *rpc.Client) error {
+	stopped := false
+	call := client.Go("Agent.Stop", struct{}{}, &stopped, nil)
+	select {
+	case <-call.Done:
+		// An agent may exit as soon as it is stopped, before its reply is sent
+		if call.Error == io.ErrUnexpectedEOF || call.Error == rpc.ErrShutdown {
+			return nil
+		}
+		return call.Error
+	case <-time.After(AgentCallTimeout):
+		return errors.Errorf("No response from agent after %s", AgentCallTimeout)
+	}
+}
@@ -0,0 +1,146 @@
+package utils_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/rpc"
+	"os"
+	"path/filepath"
+
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("agent control", func() {
+	var progress *utils.AgentProgress
+	BeforeEach(func() {
+		progress = utils.NewAgentProgress(0)
+	})
+	Describe("AgentProgress", func() {
+		It("records the bytes processed for each table", func() {
+			progress.StartTable(1)
+			progress.AddBytes(1, 10)
+			progress.AddBytes(1, 5)
+			progress.FinishTable(1, nil)
+			progress.StartTable(2)
+			progress.AddBytes(2, 7)
+
+			status := progress.Status()
+			Expect(status.State).To(Equal(utils.AgentRunning))
+			Expect(status.Tables).To(Equal([]utils.TableProgress{
+				{Oid: 1, Bytes: 15, Done: true},
+				{Oid: 2, Bytes: 7},
+			}))
+			Expect(status.BytesProcessed()).To(Equal(int64(22)))
+			Expect(status.TablesDone()).To(Equal(1))
+		})
+		It("records errors with tables and with the agent", func() {
+			progress.StartTable(1)
+			progress.FinishTable(1, errors.New("broken pipe"))
+			progress.Finish(errors.New("agent error"))
+
+			status := progress.Status()
+			Expect(status.State).To(Equal(utils.AgentFailed))
+			Expect(status.Error).To(Equal("agent error"))
+			Expect(status.Tables).To(Equal([]utils.TableProgress{{Oid: 1, Done: true, Error: "broken pipe"}}))
+		})
+		It("records that the agent finished", func() {
+			progress.Finish(nil)
+
+			Expect(progress.Status().State).To(Equal(utils.AgentFinished))
+		})
+	})
+	Describe("EnsurePrivateDirectory", func() {
+		var tempDir string
+		BeforeEach(func() {
+			tempDir, _ = ioutil.TempDir("", "agent")
+		})
+		AfterEach(func() {
+			_ = os.RemoveAll(tempDir)
+		})
+		It("creates a directory that only its owner can access", func() {
+			dir := filepath.Join(tempDir, "sockets")
+
+			Expect(utils.EnsurePrivateDirectory(dir)).To(Succeed())
+
+			info, err := os.Stat(dir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0700)))
+		})
+		It("accepts an existing directory that only its owner can access", func() {
+			dir := filepath.Join(tempDir, "sockets")
+			Expect(os.Mkdir(dir, 0700)).To(Succeed())
+
+			Expect(utils.EnsurePrivateDirectory(dir)).To(Succeed())
+		})
+		It("returns an error for an existing directory that other users can access", func() {
+			dir := filepath.Join(tempDir, "sockets")
+			Expect(os.Mkdir(dir, 0700)).To(Succeed())
+			Expect(os.Chmod(dir, 0777)).To(Succeed())
+
+			Expect(utils.EnsurePrivateDirectory(dir)).To(MatchError(fmt.Sprintf("Directory %s has permissions 0777, but must only be accessible by its owner", dir)))
+		})
+		It("returns an error for a symbolic link", func() {
+			dir := filepath.Join(tempDir, "sockets")
+			Expect(os.Symlink(tempDir, dir)).To(Succeed())
+
+			Expect(utils.EnsurePrivateDirectory(dir)).To(MatchError(fmt.Sprintf("%s is not a directory", dir)))
+		})
+	})
+	Describe("ServeAgentControl", func() {
+		var tempDir string
+		var server *utils.AgentServer
+		var client *rpc.Client
+		BeforeEach(func() {
+			tempDir, _ = ioutil.TempDir("", "agent")
+			var err error
+			server, err = utils.ServeAgentControl(filepath.Join(tempDir, "agent.sock"), progress)
+			Expect(err).ToNot(HaveOccurred())
+			client, err = utils.DialAgent(filepath.Join(tempDir, "agent.sock"))
+			Expect(err).ToNot(HaveOccurred())
+		})
+		AfterEach(func() {
+			_ = client.Close()
+			_ = server.Close()
+			_ = os.RemoveAll(tempDir)
+		})
+		It("returns the status of the agent", func() {
+			progress.StartTable(1)
+			progress.AddBytes(1, 10)
+
+			status, err := utils.GetAgentStatus(client)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(status).To(Equal(utils.AgentStatus{ContentID: 0, State: utils.AgentRunning, Tables: []utils.TableProgress{{Oid: 1, Bytes: 10}}}))
+		})
+		It("signals a stop request", func() {
+			Expect(server.StopRequested()).ToNot(BeClosed())
+
+			Expect(utils.StopAgent(client)).To(Succeed())
+
+			Expect(server.StopRequested()).To(BeClosed())
+			Expect(utils.StopAgent(client)).To(Succeed())
+		})
+		It("removes the socket when closed", func() {
+			Expect(server.Close()).To(Succeed())
+
+			Expect(filepath.Join(tempDir, "agent.sock")).ToNot(BeAnExistingFile())
+		})
+		It("replaces a socket left behind by another agent", func() {
+			otherServer, err := utils.ServeAgentControl(filepath.Join(tempDir, "agent.sock"), utils.NewAgentProgress(1))
+			Expect(err).ToNot(HaveOccurred())
+			defer otherServer.Close()
+			otherClient, err := utils.DialAgent(filepath.Join(tempDir, "agent.sock"))
+			Expect(err).ToNot(HaveOccurred())
+			defer otherClient.Close()
+
+			status, err := utils.GetAgentStatus(otherClient)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(status.ContentID).To(Equal(1))
+		})
+	})
+})
@@ -1,10 +1,18 @@
 package utils
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net/rpc"
+	"os"
+	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/greenplum-db/gp-common-go-libs/iohelper"
 
@@ -112,7 +120,7 @@ func VerifyHelperVersionOnSegments(version string, c *cluster.Cluster) {
 	}
 }
 
-func StartGpbackupHelpers(c *cluster.Cluster, fpInfo backup_filepath.FilePathInfo, operation string, pluginConfigFile string, compressStr string, onErrorContinue bool) {
+func StartGpbackupHelpers(c *cluster.Cluster, fpInfo backup_filepath.FilePathInfo, operation string, pluginConfigFile string, compressStr string, onErrorContinue bool, resizeCluster bool, origSegCount int, destSegCount int) {
 	gphomePath := operating.System.Getenv("GPHOME")
 	pluginStr := ""
 	if pluginConfigFile != "" {
@@ -123,40 +131,47 @@ func StartGpbackupHelpers(c *cluster.Cluster, fpInfo backup_filepath.FilePathInf
 	if onErrorContinue {
 		onErrorContinueStr = " --on-error-continue"
 	}
+	resizeStr := ""
+	if resizeCluster {
+		resizeStr = fmt.Sprintf(" --resize-cluster --orig-seg-count %d --dest-seg-count %d", origSegCount, destSegCount)
+	}
 	remoteOutput := c.GenerateAndExecuteCommand("Starting gpbackup_helper agent", func(contentID int) string {
 		tocFile := fpInfo.GetSegmentTOCFilePath(contentID)
 		oidFile := fpInfo.GetSegmentHelperFilePath(contentID, "oid")
-		scriptFile := fpInfo.GetSegmentHelperFilePath(contentID, "script")
+		socketFile := fpInfo.GetSegmentHelperSocketPath(contentID)
 		pipeFile := fpInfo.GetSegmentPipeFilePath(contentID)
 		backupFile := fpInfo.GetTableBackupFilePath(contentID, 0, GetPipeThroughProgram().Extension, true)
-		helperCmdStr := fmt.Sprintf("gpbackup_helper %s --toc-file %s --oid-file %s --pipe-file %s --data-file %s --content %d%s%s%s", operation, tocFile, oidFile, pipeFile, backupFile, contentID, pluginStr, compressStr, onErrorContinueStr)
-		// we run these commands in sequence to ensure that any failure is critical; the last command ensures the agent process was successfully started
-		return fmt.Sprintf(`cat << HEREDOC > %[1]s && chmod +x %[1]s && ( nohup %[1]s &> /dev/null &)
-#!/bin/bash
-source %[2]s/greenplum_path.sh
-%[2]s/bin/%s
-
-HEREDOC
-
-`, scriptFile, gphomePath, helperCmdStr)
+		helperCmdStr := fmt.Sprintf("gpbackup_helper %s --toc-file %s --oid-file %s --pipe-file %s --data-file %s --content %d --control-socket %s%s%s%s%s", operation, tocFile, oidFile, pipeFile, backupFile, contentID, socketFile, pluginStr, compressStr, onErrorContinueStr, resizeStr)
+		/*
+		 * The agent runs in the background, so we wait until it is listening on
+		 * its control socket to know that it started successfully.
+		 */
+		return fmt.Sprintf(`rm -f %[1]s && source %[2]s/greenplum_path.sh && ( nohup %[2]s/bin/%[3]s &> /dev/null & ) && for i in $(seq 1 300); do if [[ -S %[1]s ]]; then exit 0; fi; sleep 0.1; done; echo "gpbackup_helper is not listening on %[1]s" >&2; exit 1`,
+			socketFile, gphomePath, helperCmdStr)
 	}, cluster.ON_SEGMENTS)
 	c.CheckClusterError(remoteOutput, "Error starting gpbackup_helper agent", func(contentID int) string {
 		return "Error starting gpbackup_helper agent"
 	})
 }
 
-func CleanUpHelperFilesOnAllHosts(c *cluster.Cluster, fpInfo backup_filepath.FilePathInfo) {
-	remoteOutput := c.GenerateAndExecuteCommand("Removing oid list and helper script files from segment data directories", func(contentID int) string {
-		errorFile := fmt.Sprintf("%s_error", fpInfo.GetSegmentPipeFilePath(contentID))
-		oidFile := fpInfo.GetSegmentHelperFilePath(contentID, "oid")
-		scriptFile := fpInfo.GetSegmentHelperFilePath(contentID, "script")
-		return fmt.Sprintf("rm -f %s && rm -f %s && rm -f %s", errorFile, oidFile, scriptFile)
+func CleanUpHelperFilesOnAllHosts(c *cluster.Cluster, fpInfo backup_filepath.FilePathInfo, numHelpers int) {
+	remoteOutput := c.GenerateAndExecuteCommand("Removing oid list and helper socket files from segment hosts", func(contentID int) string {
+		removeCmds := make([]string, 0)
+		for i := 0; i < numHelpers; i++ {
+			helperFPInfo := fpInfo.ForHelper(i)
+			oidFile := helperFPInfo.GetSegmentHelperFilePath(contentID, "oid")
+			socketFile := helperFPInfo.GetSegmentHelperSocketPath(contentID)
+			removeCmds = append(removeCmds, fmt.Sprintf("rm -f %s && rm -f %s", oidFile, socketFile))
+		}
+		// The socket directory is shared by the segments on a host, so only the last of them removes it
+		removeCmds = append(removeCmds, fmt.Sprintf("(rmdir %s 2> /dev/null || true)", fpInfo.GetSegmentHelperSocketDir()))
+		return strings.Join(removeCmds, " && ")
 	}, cluster.ON_SEGMENTS)
 	errMsg := fmt.Sprintf("Unable to remove segment helper file(s). See %s for a complete list of segments with errors and remove manually.",
 		gplog.GetLogFilePath())
 	c.CheckClusterError(remoteOutput, errMsg, func(contentID int) string {
-		errorFile := fmt.Sprintf("%s_error", fpInfo.GetSegmentPipeFilePath(contentID))
-		return fmt.Sprintf("Unable to remove helper file %s on segment %d on host %s", errorFile, contentID, c.GetHostForContent(contentID))
+		oidFile := fpInfo.GetSegmentHelperFilePath(contentID, "oid")
+		return fmt.Sprintf("Unable to remove helper file %s on segment %d on host %s", oidFile, contentID, c.GetHostForContent(contentID))
 	}, true)
 }
 
@@ -176,27 +191,260 @@ func CleanUpSegmentHelperProcesses(c *cluster.Cluster, fpInfo backup_filepath.Fi
 	})
 }
 
-func CheckAgentErrorsOnSegments(c *cluster.Cluster, fpInfo backup_filepath.FilePathInfo) error {
-	remoteOutput := c.GenerateAndExecuteCommand("Checking whether segment agents had errors", func(contentID int) string {
-		errorFile := fmt.Sprintf("%s_error", fpInfo.GetSegmentPipeFilePath(contentID))
-		/*
-		 * If an error file exists we want to indicate an error, as that means
-		 * the agent errored out.  If no file exists, the agent was successful.
-		 */
-		return fmt.Sprintf("if [[ -f %s ]]; then echo 'error'; fi; rm -f %s", errorFile, errorFile)
-	}, cluster.ON_SEGMENTS)
+/*
+ * HelperAgents holds RPC connections to the control sockets of the agents on
+ * all segments, of which there may be several per segment when gprestore
+ * restores with more than one job.  The sockets are forwarded to the master
+ * with one ssh process per segment host, so checking on the agents does not
+ * require running a command on every host.
+ */
+type HelperAgents struct {
+	cluster   *cluster.Cluster
+	fpInfo    backup_filepath.FilePathInfo
+	clients   map[int][]*rpc.Client
+	tunnels   []*agentTunnel
+	tunnelDir string
+	mutex     sync.Mutex
+	reported  map[int]map[uint32]bool
+}
+
+type agentTunnel struct {
+	host   string
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+	exited chan struct{}
+}
+
+func NewHelperAgents(c *cluster.Cluster, fpInfo backup_filepath.FilePathInfo, clients map[int][]*rpc.Client) *HelperAgents {
+	return &HelperAgents{
+		cluster:  c,
+		fpInfo:   fpInfo,
+		clients:  clients,
+		tunnels:  make([]*agentTunnel, 0),
+		reported: make(map[int]map[uint32]bool),
+	}
+}
 
+func ConnectToHelperAgents(c *cluster.Cluster, fpInfo backup_filepath.FilePathInfo, numHelpers int) *HelperAgents {
+	gplog.Verbose("Connecting to gpbackup_helper agents")
+	agents := NewHelperAgents(c, fpInfo, make(map[int][]*rpc.Client))
+	tunnelDir, err := ioutil.TempDir("", "gpbackup_agents")
+	gplog.FatalOnError(err, "Cannot create directory for helper agent sockets")
+	agents.tunnelDir = tunnelDir
+
+	localSockets := make(map[int][]string)
+	forwardsByHost := make(map[string][]string)
+	hosts := make([]string, 0)
+	for _, contentID := range getSegmentContentIDs(c) {
+		host := c.GetHostForContent(contentID)
+		if _, ok := forwardsByHost[host]; !ok {
+			hosts = append(hosts, host)
+		}
+		for i := 0; i < numHelpers; i++ {
+			localSocket := filepath.Join(tunnelDir, fmt.Sprintf("%d_%d.sock", contentID, i))
+			localSockets[contentID] = append(localSockets[contentID], localSocket)
+			helperFPInfo := fpInfo.ForHelper(i)
+			forwardsByHost[host] = append(forwardsByHost[host], fmt.Sprintf("%s:%s", localSocket, helperFPInfo.GetSegmentHelperSocketPath(contentID)))
+		}
+	}
+	for _, host := range hosts {
+		tunnel, err := startAgentTunnel(host, forwardsByHost[host])
+		if err != nil {
+			agents.Close()
+			gplog.Fatal(err, "Unable to forward helper agent sockets from host %s", host)
+		}
+		agents.tunnels = append(agents.tunnels, tunnel)
+	}
+
+	for _, contentID := range getSegmentContentIDs(c) {
+		for _, localSocket := range localSockets[contentID] {
+			var client *rpc.Client
+			err = agents.waitForTunnel(contentID, localSocket)
+			if err == nil {
+				client, err = DialAgent(localSocket)
+			}
+			if err != nil {
+				agents.Close()
+				gplog.Fatal(err, "Unable to connect to helper agent on segment %d on host %s", contentID, c.GetHostForContent(contentID))
+			}
+			agents.clients[contentID] = append(agents.clients[contentID], client)
+		}
+	}
+	return agents
+}
+
+func getSegmentContentIDs(c *cluster.Cluster) []int {
+	contentIDs := make([]int, 0)
+	for contentID := range c.Segments {
+		if contentID != -1 {
+			contentIDs = append(contentIDs, contentID)
+		}
+	}
+	sort.Ints(contentIDs)
+	return contentIDs
+}
+
+func startAgentTunnel(host string, forwards []string) (*agentTunnel, error) {
+	args := []string{"-o", "BatchMode=yes", "-o", "ExitOnForwardFailure=yes", "-N"}
+	for _, forward := range forwards {
+		args = append(args, "-L", forward)
+	}
+	args = append(args, host)
+	tunnel := &agentTunnel{host: host, cmd: exec.Command("ssh", args...), stderr: &bytes.Buffer{}, exited: make(chan struct{})}
+	tunnel.cmd.Stderr = tunnel.stderr
+	err := tunnel.cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		_ = tunnel.cmd.Wait()
+		close(tunnel.exited)
+	}()
+	return tunnel, nil
+}
+
+func (agents *HelperAgents) waitForTunnel(contentID int, localSocket string) error {
+	host := agents.cluster.GetHostForContent(contentID)
+	for i := 0; i < 300; i++ {
+		if _, err := os.Stat(localSocket); err == nil {
+			return nil
+		}
+		for _, tunnel := range agents.tunnels {
+			select {
+			case <-tunnel.exited:
+				if tunnel.host == host {
+					return errors.Errorf("ssh exited before forwarding the agent socket: %s", strings.TrimSpace(tunnel.stderr.String()))
+				}
+			default:
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return errors.Errorf("Timed out waiting for ssh to forward %s", localSocket)
+}
+
+func (agents *HelperAgents) GetStatuses() (map[int][]AgentStatus, map[int][]error) {
+	statuses := make(map[int][]AgentStatus)
+	callErrors := make(map[int][]error)
+	for contentID, clients := range agents.clients {
+		for _, client := range clients {
+			status, err := GetAgentStatus(client)
+			if err != nil {
+				callErrors[contentID] = append(callErrors[contentID], err)
+			} else {
+				statuses[contentID] = append(statuses[contentID], status)
+			}
+		}
+	}
+	return statuses, callErrors
+}
+
+/*
+ * An agent that cannot be reached has exited or hung, so it is treated the
+ * same as an agent that reported an error.  Errors with individual tables
+ * are logged when first seen, as an agent restoring with --on-error-continue
+ * keeps going after them.
+ */
+func (agents *HelperAgents) CheckErrors() error {
+	statuses, callErrors := agents.GetStatuses()
+	agents.mutex.Lock()
+	defer agents.mutex.Unlock()
 	numErrors := 0
-	for contentID := range remoteOutput.Stdouts {
-		if strings.TrimSpace(remoteOutput.Stdouts[contentID]) == "error" {
-			gplog.Verbose("Error occurred with helper agent on segment %d on host %s.", contentID, c.GetHostForContent(contentID))
+	for _, contentID := range getSegmentContentIDs(agents.cluster) {
+		host := agents.cluster.GetHostForContent(contentID)
+		for _, err := range callErrors[contentID] {
+			gplog.Verbose("Unable to reach helper agent on segment %d on host %s: %v", contentID, host, err)
 			numErrors++
 		}
+		if agents.reported[contentID] == nil {
+			agents.reported[contentID] = make(map[uint32]bool)
+		}
+		for _, status := range statuses[contentID] {
+			for _, table := range status.Tables {
+				if table.Error != "" && !agents.reported[contentID][table.Oid] {
+					gplog.Verbose("Helper agent on segment %d on host %s encountered an error with table with oid %d: %s", contentID, host, table.Oid, table.Error)
+					agents.reported[contentID][table.Oid] = true
+				}
+			}
+			if status.State == AgentFailed {
+				gplog.Verbose("Error occurred with helper agent on segment %d on host %s: %s", contentID, host, status.Error)
+				numErrors++
+			}
+		}
 	}
 	if numErrors > 0 {
-		helperLogName := fpInfo.GetHelperLogPath()
+		helperLogName := agents.fpInfo.GetHelperLogPath()
 		return errors.Errorf("Encountered errors with %d helper agent(s).  See %s for a complete list of segments with errors, and see %s on the corresponding hosts for detailed error messages.",
 			numErrors, gplog.GetLogFilePath(), helperLogName)
 	}
 	return nil
 }
+
+/*
+ * When using a plugin, an agent may still be uploading data after the last
+ * COPY has finished, so we wait for every agent to finish before checking
+ * for errors.  An upload can take any amount of time, so the agents are
+ * only given up on once none of them has made progress for AgentWaitTimeout.
+ */
+func (agents *HelperAgents) WaitForAgents() error {
+	lastBytes := int64(-1)
+	lastProgressTime := time.Now()
+	for i := 0; ; i++ {
+		statuses, callErrors := agents.GetStatuses()
+		numRunning := 0
+		numBytes := int64(0)
+		for _, segmentStatuses := range statuses {
+			for _, status := range segmentStatuses {
+				if status.State == AgentRunning {
+					numRunning++
+				}
+				numBytes += status.BytesProcessed()
+			}
+		}
+		if numRunning == 0 || len(callErrors) > 0 {
+			break
+		}
+		if numBytes != lastBytes {
+			lastBytes = numBytes
+			lastProgressTime = time.Now()
+		} else if time.Since(lastProgressTime) >= AgentWaitTimeout {
+			return errors.Errorf("Timed out waiting for %d helper agent(s) to finish, as they have made no progress for %s", numRunning, AgentWaitTimeout)
+		}
+		if i%10 == 0 {
+			gplog.Verbose("Waiting for %d helper agent(s) to finish; %d bytes processed so far", numRunning, numBytes)
+		}
+		time.Sleep(AgentPollInterval)
+	}
+	return agents.CheckErrors()
+}
+
+/*
+ * Stopping an agent makes it exit immediately, whether or not it has
+ * finished, and removes its socket.
+ */
+func (agents *HelperAgents) Stop() {
+	for contentID, clients := range agents.clients {
+		for _, client := range clients {
+			err := StopAgent(client)
+			if err != nil {
+				gplog.Verbose("Unable to stop helper agent on segment %d: %v", contentID, err)
+			}
+		}
+	}
+	agents.Close()
+}
+
+func (agents *HelperAgents) Close() {
+	for _, clients := range agents.clients {
+		for _, client := range clients {
+			_ = client.Close()
+		}
+	}
+	for _, tunnel := range agents.tunnels {
+		_ = tunnel.cmd.Process.Kill()
+		<-tunnel.exited
+	}
+	if agents.tunnelDir != "" {
+		_ = os.RemoveAll(agents.tunnelDir)
+	}
+}
@@ -3,7 +3,12 @@ package utils_test
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net/rpc"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/greenplum-db/gpbackup/utils"
 
@@ -122,28 +127,144 @@ var _ = Describe("agent remote", func() {
 		})
 	})
 	Describe("StartGpbackupHelpers()", func() {
+		It("starts the agent in the background and waits for its control socket", func() {
+			utils.StartGpbackupHelpers(testCluster, fpInfo, "--backup-agent", "", "", false, false, 0, 0)
+
+			cc := testExecutor.ClusterCommands[0]
+			socketFile := fmt.Sprintf("/tmp/gpbackup_11112233445566_%[1]d/0_%[1]d.sock", fpInfo.PID)
+			Expect(cc[0][4]).To(HavePrefix(fmt.Sprintf("rm -f %s && source ", socketFile)))
+			Expect(cc[0][4]).To(ContainSubstring(fmt.Sprintf("gpbackup_helper --backup-agent --toc-file /data/gpseg0/backups/11112233/11112233445566/gpbackup_0_11112233445566_toc.yaml --oid-file /data/gpseg0/gpbackup_0_11112233445566_oid_%d", fpInfo.PID)))
+			Expect(cc[0][4]).To(ContainSubstring(fmt.Sprintf(" --control-socket %s", socketFile)))
+			Expect(cc[0][4]).To(ContainSubstring(fmt.Sprintf("if [[ -S %s ]]; then exit 0; fi", socketFile)))
+		})
 		It("Correctly propagates --on-error-continue flag to gpbackup_helper", func() {
-			utils.StartGpbackupHelpers(testCluster, fpInfo, "operation", "/tmp/pluginConfigFile.yml", " compressStr", true)
+			utils.StartGpbackupHelpers(testCluster, fpInfo, "operation", "/tmp/pluginConfigFile.yml", " compressStr", true, false, 0, 0)
 
 			cc := testExecutor.ClusterCommands[0]
 			Expect(cc[0][4]).To(ContainSubstring(" --on-error-continue"))
 		})
+		It("Correctly propagates segment counts to gpbackup_helper when resizing the cluster", func() {
+			utils.StartGpbackupHelpers(testCluster, fpInfo, "operation", "", "", false, true, 16, 4)
+
+			cc := testExecutor.ClusterCommands[0]
+			Expect(cc[0][4]).To(ContainSubstring(" --resize-cluster --orig-seg-count 16 --dest-seg-count 4"))
+		})
 	})
-	Describe("CheckAgentErrorsOnSegments", func() {
-		It("constructs the correct ssh call to check for the existance of an error file on each segment", func() {
-			err := utils.CheckAgentErrorsOnSegments(testCluster, fpInfo)
-			Expect(err).ToNot(HaveOccurred())
+	Describe("CleanUpHelperFilesOnAllHosts()", func() {
+		It("removes the oid list and the helper socket on each segment", func() {
+			utils.CleanUpHelperFilesOnAllHosts(testCluster, fpInfo, 1)
 
 			cc := testExecutor.ClusterCommands[0]
-			errorFile0 := fmt.Sprintf(`/data/gpseg0/gpbackup_0_11112233445566_pipe_%d_error`, fpInfo.PID)
-			expectedCmd0 := fmt.Sprintf(`if [[ -f %[1]s ]]; then echo 'error'; fi; rm -f %[1]s`, errorFile0)
-			Expect(cc[0][4]).To(Equal(expectedCmd0))
+			Expect(cc[0][4]).To(Equal(fmt.Sprintf("rm -f /data/gpseg0/gpbackup_0_11112233445566_oid_%[1]d && rm -f /tmp/gpbackup_11112233445566_%[1]d/0_%[1]d.sock && "+
+				"(rmdir /tmp/gpbackup_11112233445566_%[1]d 2> /dev/null || true)", fpInfo.PID)))
+		})
+		It("removes the files of every helper on each segment", func() {
+			utils.CleanUpHelperFilesOnAllHosts(testCluster, fpInfo, 2)
+
+			cc := testExecutor.ClusterCommands[0]
+			Expect(cc[0][4]).To(Equal(fmt.Sprintf("rm -f /data/gpseg0/gpbackup_0_11112233445566_oid_%[1]d && rm -f /tmp/gpbackup_11112233445566_%[1]d/0_%[1]d.sock && "+
+				"rm -f /data/gpseg0/gpbackup_0_11112233445566_oid_%[1]d_1 && rm -f /tmp/gpbackup_11112233445566_%[1]d/0_%[1]d_1.sock && "+
+				"(rmdir /tmp/gpbackup_11112233445566_%[1]d 2> /dev/null || true)", fpInfo.PID)))
+		})
+	})
+	Describe("HelperAgents", func() {
+		var (
+			tempDir  string
+			progress map[int]*utils.AgentProgress
+			servers  []*utils.AgentServer
+			clients  map[int][]*rpc.Client
+			agents   *utils.HelperAgents
+		)
+		BeforeEach(func() {
+			tempDir, _ = ioutil.TempDir("", "agents")
+			progress = make(map[int]*utils.AgentProgress)
+			servers = make([]*utils.AgentServer, 0)
+			clients = make(map[int][]*rpc.Client)
+			for _, contentID := range []int{0, 1} {
+				socketPath := filepath.Join(tempDir, fmt.Sprintf("%d.sock", contentID))
+				progress[contentID] = utils.NewAgentProgress(contentID)
+				server, err := utils.ServeAgentControl(socketPath, progress[contentID])
+				Expect(err).ToNot(HaveOccurred())
+				servers = append(servers, server)
+				client, err := utils.DialAgent(socketPath)
+				Expect(err).ToNot(HaveOccurred())
+				clients[contentID] = []*rpc.Client{client}
+			}
+			agents = utils.NewHelperAgents(testCluster, fpInfo, clients)
+			utils.AgentPollInterval = 10 * time.Millisecond
+		})
+		AfterEach(func() {
+			agents.Close()
+			for _, server := range servers {
+				_ = server.Close()
+			}
+			_ = os.RemoveAll(tempDir)
+			utils.AgentPollInterval = time.Second
+		})
+		It("does not return an error when all agents are running or finished", func() {
+			progress[0].Finish(nil)
+
+			Expect(agents.CheckErrors()).To(Succeed())
+		})
+		It("returns an error when an agent failed", func() {
+			progress[1].Finish(errors.New("plugin error"))
+
+			err := agents.CheckErrors()
+
+			Expect(err).To(MatchError(MatchRegexp("Encountered errors with 1 helper agent\\(s\\).  See .* for a complete list of segments with errors, and see .*/gpAdminLogs/gpbackup_helper_11112233.log on the corresponding hosts for detailed error messages.")))
+			Expect(string(logfile.Contents())).To(ContainSubstring("Error occurred with helper agent on segment 1 on host remotehost1: plugin error"))
+		})
+		It("returns an error when an agent cannot be reached", func() {
+			Expect(servers[0].Close()).To(Succeed())
+			agents.Close()
+
+			Expect(agents.CheckErrors()).To(MatchError(ContainSubstring("Encountered errors with 2 helper agent(s).")))
+		})
+		It("logs an error with a table once without returning an error", func() {
+			progress[0].FinishTable(1234, errors.New("broken pipe"))
+
+			Expect(agents.CheckErrors()).To(Succeed())
+			Expect(agents.CheckErrors()).To(Succeed())
+
+			Expect(strings.Count(string(logfile.Contents()), "Helper agent on segment 0 on host localhost encountered an error with table with oid 1234: broken pipe")).To(Equal(1))
+		})
+		It("waits for all agents to finish", func() {
+			go func() {
+				time.Sleep(50 * time.Millisecond)
+				progress[0].Finish(nil)
+				progress[1].Finish(nil)
+			}()
 
-			errorFile1 := fmt.Sprintf(`/data/gpseg1/gpbackup_1_11112233445566_pipe_%d_error`, fpInfo.PID)
-			expectedCmd1 := fmt.Sprintf(`if [[ -f %[1]s ]]; then echo 'error'; fi; rm -f %[1]s`, errorFile1)
-			Expect(cc[1][4]).To(Equal(expectedCmd1))
+			Expect(agents.WaitForAgents()).To(Succeed())
+			Expect(progress[0].Status().State).To(Equal(utils.AgentFinished))
+			Expect(progress[1].Status().State).To(Equal(utils.AgentFinished))
 		})
+		It("returns an error when the agents make no progress for too long", func() {
+			utils.AgentWaitTimeout = 50 * time.Millisecond
+			defer func() { utils.AgentWaitTimeout = 30 * time.Minute }()
 
+			Expect(agents.WaitForAgents()).To(MatchError("Timed out waiting for 2 helper agent(s) to finish, as they have made no progress for 50ms"))
+		})
+		It("checks every agent on a segment", func() {
+			socketPath := filepath.Join(tempDir, "0_1.sock")
+			secondProgress := utils.NewAgentProgress(0)
+			server, err := utils.ServeAgentControl(socketPath, secondProgress)
+			Expect(err).ToNot(HaveOccurred())
+			servers = append(servers, server)
+			client, err := utils.DialAgent(socketPath)
+			Expect(err).ToNot(HaveOccurred())
+			clients[0] = append(clients[0], client)
+			secondProgress.Finish(errors.New("plugin error"))
+
+			Expect(agents.CheckErrors()).To(MatchError(ContainSubstring("Encountered errors with 1 helper agent(s).")))
+		})
+		It("stops all agents", func() {
+			agents.Stop()
+
+			for _, server := range servers {
+				Expect(server.StopRequested()).To(BeClosed())
+			}
+		})
 	})
 })
 
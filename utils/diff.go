@@ -0,0 +1,198 @@
+package utils
+
+/*
+ * This file contains a line-based diff of two texts in the unified format,
+ * used to show how the DDL of an object differs between two backups or
+ * between a backup and a database.
+ */
+
+import (
+	"fmt"
+	"strings"
+)
+
+const diffContextLines = 3
+
+type diffLine struct {
+	op        byte
+	text      string
+	fromIndex int
+	toIndex   int
+}
+
+/*
+ * Returns the differences between fromText and toText in the unified diff
+ * format, or an empty string if they are the same.
+ */
+func UnifiedDiff(fromLabel string, toLabel string, fromText string, toText string) string {
+	if fromText == toText {
+		return ""
+	}
+	lines := diffLines(splitLines(fromText), splitLines(toText))
+
+	var diff strings.Builder
+	diff.WriteString(fmt.Sprintf("--- %s\n+++ %s\n", fromLabel, toLabel))
+	for start := 0; start < len(lines); {
+		for start < len(lines) && lines[start].op == ' ' {
+			start++
+		}
+		if start == len(lines) {
+			break
+		}
+		hunkStart := start - diffContextLines
+		if hunkStart < 0 {
+			hunkStart = 0
+		}
+		// A hunk continues until there are enough unchanged lines to end it and begin the next one
+		end := start
+		unchanged := 0
+		for end < len(lines) && unchanged <= 2*diffContextLines {
+			if lines[end].op == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+			end++
+		}
+		hunkEnd := end - unchanged + diffContextLines
+		if hunkEnd > len(lines) {
+			hunkEnd = len(lines)
+		}
+		writeHunk(&diff, lines[hunkStart:hunkEnd])
+		start = hunkEnd
+	}
+	return diff.String()
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+func diffLines(fromLines []string, toLines []string) []diffLine {
+	prefix := 0
+	for prefix < len(fromLines) && prefix < len(toLines) && fromLines[prefix] == toLines[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(fromLines)-prefix && suffix < len(toLines)-prefix &&
+		fromLines[len(fromLines)-1-suffix] == toLines[len(toLines)-1-suffix] {
+		suffix++
+	}
+
+	lines := make([]diffLine, 0, len(fromLines)+len(toLines)-prefix-suffix)
+	for i := 0; i < prefix; i++ {
+		lines = append(lines, diffLine{op: ' ', text: fromLines[i], fromIndex: i, toIndex: i})
+	}
+	lines = append(lines, shortestEdit(fromLines[:len(fromLines)-suffix], toLines[:len(toLines)-suffix], prefix)...)
+	for i := suffix; i > 0; i-- {
+		fromIndex, toIndex := len(fromLines)-i, len(toLines)-i
+		lines = append(lines, diffLine{op: ' ', text: fromLines[fromIndex], fromIndex: fromIndex, toIndex: toIndex})
+	}
+	return lines
+}
+
+/*
+ * Returns the shortest edit script from fromLines[start:] to toLines[start:],
+ * found with Myers' O(ND) algorithm so that the time and memory needed grow
+ * with the number of differences rather than with the size of the texts.
+ */
+func shortestEdit(fromLines []string, toLines []string, start int) []diffLine {
+	n, m := len(fromLines)-start, len(toLines)-start
+	// furthest[d][k+d] is the furthest index into fromLines[start:] reached on diagonal k with d edits
+	furthest := make([][]int, 0)
+	for d := 0; ; d++ {
+		reached := make([]int, 2*d+1)
+		done := false
+		for k := -d; k <= d && !done; k += 2 {
+			x := 0
+			if d > 0 {
+				previous := furthest[d-1]
+				if k == -d || (k != d && previous[k-1+d-1] < previous[k+1+d-1]) {
+					x = previous[k+1+d-1]
+				} else {
+					x = previous[k-1+d-1] + 1
+				}
+			}
+			y := x - k
+			for x < n && y < m && fromLines[start+x] == toLines[start+y] {
+				x++
+				y++
+			}
+			reached[k+d] = x
+			done = x >= n && y >= m
+		}
+		furthest = append(furthest, reached)
+		if done {
+			break
+		}
+	}
+
+	// Walk back from the end of both texts, collecting the edits in reverse
+	reversed := make([]diffLine, 0)
+	x, y := n, m
+	for d := len(furthest) - 1; d >= 0; d-- {
+		k := x - y
+		previousX, previousY := 0, 0
+		inserted := false
+		if d > 0 {
+			previous := furthest[d-1]
+			previousK := k - 1
+			if k == -d || (k != d && previous[k-1+d-1] < previous[k+1+d-1]) {
+				previousK = k + 1
+				inserted = true
+			}
+			previousX = previous[previousK+d-1]
+			previousY = previousX - previousK
+		}
+		for x > previousX && y > previousY {
+			x--
+			y--
+			reversed = append(reversed, diffLine{op: ' ', text: fromLines[start+x], fromIndex: start + x, toIndex: start + y})
+		}
+		if d == 0 {
+			break
+		}
+		if inserted {
+			y--
+			reversed = append(reversed, diffLine{op: '+', text: toLines[start+y], fromIndex: start + x, toIndex: start + y})
+		} else {
+			x--
+			reversed = append(reversed, diffLine{op: '-', text: fromLines[start+x], fromIndex: start + x, toIndex: start + y})
+		}
+	}
+	lines := make([]diffLine, len(reversed))
+	for i, line := range reversed {
+		lines[len(reversed)-1-i] = line
+	}
+	return lines
+}
+
+func writeHunk(diff *strings.Builder, lines []diffLine) {
+	fromCount, toCount := 0, 0
+	for _, line := range lines {
+		if line.op != '+' {
+			fromCount++
+		}
+		if line.op != '-' {
+			toCount++
+		}
+	}
+	diff.WriteString(fmt.Sprintf("@@ -%s +%s @@\n", hunkRange(lines[0].fromIndex, fromCount), hunkRange(lines[0].toIndex, toCount)))
+	for _, line := range lines {
+		diff.WriteString(fmt.Sprintf("%c%s\n", line.op, line.text))
+	}
+}
+
+// An empty range is given as the line before it, as in GNU diff
+func hunkRange(index int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", index)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", index+1)
+	}
+	return fmt.Sprintf("%d,%d", index+1, count)
+}
@@ -0,0 +1,82 @@
+package utils_test
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/diff tests", func() {
+	Describe("UnifiedDiff", func() {
+		It("returns an empty string for identical texts", func() {
+			Expect(utils.UnifiedDiff("a", "b", "line1\nline2\n", "line1\nline2\n")).To(Equal(""))
+		})
+		It("shows changed lines with their context", func() {
+			from := "CREATE TABLE public.foo (\n\ti integer,\n\tj text\n) DISTRIBUTED BY (i);"
+			to := "CREATE TABLE public.foo (\n\ti integer,\n\tj character varying(10)\n) DISTRIBUTED BY (i);"
+
+			Expect(utils.UnifiedDiff("before", "after", from, to)).To(Equal(`--- before
++++ after
+@@ -1,4 +1,4 @@
+ CREATE TABLE public.foo (
+ 	i integer,
+-	j text
++	j character varying(10)
+ ) DISTRIBUTED BY (i);
+`))
+		})
+		It("shows an added text as a single hunk", func() {
+			Expect(utils.UnifiedDiff("before", "after", "", "line1\nline2")).To(Equal(`--- before
++++ after
+@@ -0,0 +1,2 @@
++line1
++line2
+`))
+		})
+		It("splits changes far apart into separate hunks", func() {
+			from := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12"
+			to := "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve"
+
+			Expect(utils.UnifiedDiff("before", "after", from, to)).To(Equal(`--- before
++++ after
+@@ -1,4 +1,4 @@
+-1
++one
+ 2
+ 3
+ 4
+@@ -9,4 +9,4 @@
+ 9
+ 10
+ 11
+-12
++twelve
+`))
+		})
+		It("diffs long texts with few changes", func() {
+			fromLines := make([]string, 100000)
+			for i := range fromLines {
+				fromLines[i] = fmt.Sprintf("line%d", i)
+			}
+			toLines := append([]string{}, fromLines...)
+			toLines[50000] = "changed"
+
+			Expect(utils.UnifiedDiff("before", "after", strings.Join(fromLines, "\n"), strings.Join(toLines, "\n"))).To(Equal(`--- before
++++ after
+@@ -49998,7 +49998,7 @@
+ line49997
+ line49998
+ line49999
+-line50000
++changed
+ line50001
+ line50002
+ line50003
+`))
+		})
+	})
+})
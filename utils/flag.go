@@ -14,33 +14,55 @@ import (
 )
 
 const (
-	BACKUP_DIR            = "backup-dir"
-	COMPRESSION_LEVEL     = "compression-level"
-	DATA_ONLY             = "data-only"
-	DBNAME                = "dbname"
-	DEBUG                 = "debug"
-	EXCLUDE_RELATION      = "exclude-table"
-	EXCLUDE_RELATION_FILE = "exclude-table-file"
-	EXCLUDE_SCHEMA        = "exclude-schema"
-	FROM_TIMESTAMP        = "from-timestamp"
-	INCLUDE_RELATION      = "include-table"
-	INCLUDE_RELATION_FILE = "include-table-file"
-	INCLUDE_SCHEMA        = "include-schema"
-	INCREMENTAL           = "incremental"
-	JOBS                  = "jobs"
-	LEAF_PARTITION_DATA   = "leaf-partition-data"
-	METADATA_ONLY         = "metadata-only"
-	NO_COMPRESSION        = "no-compression"
-	PLUGIN_CONFIG         = "plugin-config"
-	QUIET                 = "quiet"
-	SINGLE_DATA_FILE      = "single-data-file"
-	VERBOSE               = "verbose"
-	WITH_STATS            = "with-stats"
-	CREATE_DB             = "create-db"
-	ON_ERROR_CONTINUE     = "on-error-continue"
-	REDIRECT_DB           = "redirect-db"
-	TIMESTAMP             = "timestamp"
-	WITH_GLOBALS          = "with-globals"
+	BACKUP_DIR              = "backup-dir"
+	COMPARE_TIMESTAMP       = "compare-timestamp"
+	COMPRESSION_LEVEL       = "compression-level"
+	COMPRESSION_WORKERS     = "compression-workers"
+	DATA_FORMAT             = "format"
+	DATA_ONLY               = "data-only"
+	DBNAME                  = "dbname"
+	DEBUG                   = "debug"
+	DECOMPRESSION_BLOCKS    = "decompression-blocks"
+	DEST_BACKUP_DIR         = "dest-backup-dir"
+	DEST_PLUGIN_CONFIG      = "dest-plugin-config"
+	DISTRIBUTED_RANDOMLY    = "distributed-randomly"
+	DISTRIBUTION_POLICY_MAP = "distribution-policy-map"
+	EXCLUDE_OBJECT_TYPE     = "exclude-object-type"
+	EXCLUDE_RELATION        = "exclude-table"
+	EXCLUDE_RELATION_FILE   = "exclude-table-file"
+	EXCLUDE_SCHEMA          = "exclude-schema"
+	FROM_TIMESTAMP          = "from-timestamp"
+	INCLUDE_DEPENDENCIES    = "include-dependencies"
+	INCLUDE_OBJECT_TYPE     = "include-object-type"
+	INCLUDE_RELATION        = "include-table"
+	INCLUDE_RELATION_FILE   = "include-table-file"
+	INCLUDE_SCHEMA          = "include-schema"
+	INCREMENTAL             = "incremental"
+	JOBS                    = "jobs"
+	LEAF_PARTITION_DATA     = "leaf-partition-data"
+	LOCK_BATCH_SIZE         = "lock-batch-size"
+	LOCK_RETRIES            = "lock-retries"
+	LOCK_WAIT_TIMEOUT       = "lock-wait-timeout"
+	METADATA_ONLY           = "metadata-only"
+	NO_COMPRESSION          = "no-compression"
+	PLUGIN_CONFIG           = "plugin-config"
+	QUIET                   = "quiet"
+	SINGLE_DATA_FILE        = "single-data-file"
+	STORAGE_OPTIONS_MAP     = "storage-options-map"
+	TARGET_FLAVOR           = "target-flavor"
+	TARGET_VERSION          = "target-version"
+	VERBOSE                 = "verbose"
+	WITH_STATS              = "with-stats"
+	CREATE_DB               = "create-db"
+	ON_ERROR_CONTINUE       = "on-error-continue"
+	OUTPUT_FILE             = "output-file"
+	REDIRECT_DB             = "redirect-db"
+	REFRESH_MATVIEWS        = "refresh-materialized-views"
+	RESIZE_CLUSTER          = "resize-cluster"
+	TIMESTAMP               = "timestamp"
+	WITH_GLOBALS            = "with-globals"
+	USE_LIST                = "use-list"
+	WRITE_LIST              = "write-list"
 )
 
 /*
@@ -0,0 +1,284 @@
+package utils
+
+/*
+ * This file contains a gzip writer that compresses blocks of its input on
+ * several goroutines at once, and a gzip reader that decompresses ahead of
+ * its consumer, which gpbackup_helper uses for single data file backups.
+ */
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	GzipBlockSize = 1 << 20
+	// The largest back-reference allowed by deflate, and so the most of the previous block a block can use
+	gzipDictSize = 32 << 10
+)
+
+type gzipBlock struct {
+	data   []byte
+	dict   []byte
+	output []byte
+	err    error
+	done   chan struct{}
+}
+
+/*
+ * ParallelGzipWriter writes a single standard gzip stream, so that the output
+ * can be read by gzip.Reader or the gzip utility.  Each block is compressed
+ * with the end of the previous block as its dictionary and ends with a sync
+ * flush, so the compressed blocks can simply be concatenated in order.
+ */
+type ParallelGzipWriter struct {
+	writer    io.Writer
+	level     int
+	block     []byte
+	lastBlock []byte
+	checksum  uint32
+	size      uint32
+	blocks    chan *gzipBlock
+	ordered   chan *gzipBlock
+	workers   sync.WaitGroup
+	finished  chan struct{}
+	mutex     sync.Mutex
+	err       error
+	closed    bool
+}
+
+func NewParallelGzipWriter(writer io.Writer, level int, numWorkers int) (*ParallelGzipWriter, error) {
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		return nil, errors.Errorf("Invalid gzip compression level: %d", level)
+	}
+	if numWorkers < 1 {
+		return nil, errors.Errorf("Invalid number of compression workers: %d", numWorkers)
+	}
+	gzipWriter := &ParallelGzipWriter{
+		writer:   writer,
+		level:    level,
+		block:    make([]byte, 0, GzipBlockSize),
+		blocks:   make(chan *gzipBlock, numWorkers),
+		ordered:  make(chan *gzipBlock, numWorkers),
+		finished: make(chan struct{}),
+	}
+	gzipWriter.workers.Add(numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		go gzipWriter.compressBlocks()
+	}
+	go gzipWriter.writeBlocks()
+	return gzipWriter, nil
+}
+
+func (gzipWriter *ParallelGzipWriter) compressBlocks() {
+	defer gzipWriter.workers.Done()
+	var output bytes.Buffer
+	for block := range gzipWriter.blocks {
+		output.Reset()
+		compressor, err := flate.NewWriterDict(&output, gzipWriter.level, block.dict)
+		if err == nil {
+			_, err = compressor.Write(block.data)
+		}
+		if err == nil {
+			err = compressor.Flush()
+		}
+		block.output = append([]byte(nil), output.Bytes()...)
+		block.err = err
+		close(block.done)
+	}
+}
+
+func (gzipWriter *ParallelGzipWriter) writeBlocks() {
+	defer close(gzipWriter.finished)
+	err := gzipWriter.writeHeader()
+	for block := range gzipWriter.ordered {
+		<-block.done
+		if err == nil {
+			err = block.err
+		}
+		if err == nil {
+			_, err = gzipWriter.writer.Write(block.output)
+		}
+		if err != nil {
+			gzipWriter.setError(err)
+		}
+	}
+}
+
+func (gzipWriter *ParallelGzipWriter) writeHeader() error {
+	header := []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 255}
+	if gzipWriter.level == gzip.BestCompression {
+		header[8] = 2
+	} else if gzipWriter.level == gzip.BestSpeed {
+		header[8] = 4
+	}
+	_, err := gzipWriter.writer.Write(header)
+	return err
+}
+
+func (gzipWriter *ParallelGzipWriter) setError(err error) {
+	gzipWriter.mutex.Lock()
+	defer gzipWriter.mutex.Unlock()
+	if gzipWriter.err == nil {
+		gzipWriter.err = err
+	}
+}
+
+func (gzipWriter *ParallelGzipWriter) getError() error {
+	gzipWriter.mutex.Lock()
+	defer gzipWriter.mutex.Unlock()
+	return gzipWriter.err
+}
+
+func (gzipWriter *ParallelGzipWriter) Write(p []byte) (int, error) {
+	if gzipWriter.closed {
+		return 0, errors.New("Write on closed gzip writer")
+	}
+	if err := gzipWriter.getError(); err != nil {
+		return 0, err
+	}
+	gzipWriter.checksum = crc32.Update(gzipWriter.checksum, crc32.IEEETable, p)
+	gzipWriter.size += uint32(len(p))
+	numWritten := 0
+	for numWritten < len(p) {
+		numCopied := copy(gzipWriter.block[len(gzipWriter.block):cap(gzipWriter.block)], p[numWritten:])
+		gzipWriter.block = gzipWriter.block[:len(gzipWriter.block)+numCopied]
+		numWritten += numCopied
+		if len(gzipWriter.block) == cap(gzipWriter.block) {
+			gzipWriter.sendBlock()
+		}
+	}
+	return numWritten, nil
+}
+
+func (gzipWriter *ParallelGzipWriter) sendBlock() {
+	block := &gzipBlock{data: gzipWriter.block, done: make(chan struct{})}
+	if len(gzipWriter.lastBlock) > gzipDictSize {
+		block.dict = gzipWriter.lastBlock[len(gzipWriter.lastBlock)-gzipDictSize:]
+	} else {
+		block.dict = gzipWriter.lastBlock
+	}
+	// A block is queued for writing before it is compressed, so that blocks are written in order
+	gzipWriter.ordered <- block
+	gzipWriter.blocks <- block
+	gzipWriter.lastBlock = gzipWriter.block
+	gzipWriter.block = make([]byte, 0, GzipBlockSize)
+}
+
+func (gzipWriter *ParallelGzipWriter) Close() error {
+	if gzipWriter.closed {
+		return nil
+	}
+	gzipWriter.closed = true
+	if len(gzipWriter.block) > 0 {
+		gzipWriter.sendBlock()
+	}
+	close(gzipWriter.blocks)
+	close(gzipWriter.ordered)
+	gzipWriter.workers.Wait()
+	<-gzipWriter.finished
+	if err := gzipWriter.getError(); err != nil {
+		return err
+	}
+
+	// Every block ends with a sync flush, so an empty final block ends the stream
+	var trailer bytes.Buffer
+	compressor, err := flate.NewWriter(&trailer, gzipWriter.level)
+	if err != nil {
+		return err
+	}
+	err = compressor.Close()
+	if err != nil {
+		return err
+	}
+	footer := make([]byte, 8)
+	binary.LittleEndian.PutUint32(footer[:4], gzipWriter.checksum)
+	binary.LittleEndian.PutUint32(footer[4:], gzipWriter.size)
+	trailer.Write(footer)
+	_, err = gzipWriter.writer.Write(trailer.Bytes())
+	return err
+}
+
+/*
+ * ReadAheadGzipReader decompresses up to numBlocks blocks ahead of its
+ * consumer on a single separate goroutine, so that decompression and writing
+ * the data out happen at the same time.  The decompression itself is not
+ * split across goroutines, as each block depends on those before it.
+ */
+type ReadAheadGzipReader struct {
+	blocks  chan []byte
+	errs    chan error
+	done    chan struct{}
+	current []byte
+	err     error
+	closed  sync.Once
+}
+
+func NewReadAheadGzipReader(reader io.Reader, numBlocks int) (*ReadAheadGzipReader, error) {
+	if numBlocks < 1 {
+		return nil, errors.Errorf("Invalid number of decompression blocks: %d", numBlocks)
+	}
+	gzipReader, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, err
+	}
+	readAheadReader := &ReadAheadGzipReader{
+		blocks: make(chan []byte, numBlocks),
+		errs:   make(chan error, 1),
+		done:   make(chan struct{}),
+	}
+	go readAheadReader.decompressBlocks(gzipReader)
+	return readAheadReader, nil
+}
+
+func (readAheadReader *ReadAheadGzipReader) decompressBlocks(gzipReader *gzip.Reader) {
+	defer close(readAheadReader.blocks)
+	for {
+		block := make([]byte, GzipBlockSize)
+		numBytes, err := io.ReadFull(gzipReader, block)
+		if numBytes > 0 {
+			select {
+			case readAheadReader.blocks <- block[:numBytes]:
+			case <-readAheadReader.done:
+				readAheadReader.errs <- errors.New("Read on closed gzip reader")
+				return
+			}
+		}
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		if err != nil {
+			readAheadReader.errs <- err
+			return
+		}
+	}
+}
+
+func (readAheadReader *ReadAheadGzipReader) Read(p []byte) (int, error) {
+	for len(readAheadReader.current) == 0 {
+		if readAheadReader.err != nil {
+			return 0, readAheadReader.err
+		}
+		block, ok := <-readAheadReader.blocks
+		if !ok {
+			readAheadReader.err = <-readAheadReader.errs
+			continue
+		}
+		readAheadReader.current = block
+	}
+	numRead := copy(p, readAheadReader.current)
+	readAheadReader.current = readAheadReader.current[numRead:]
+	return numRead, nil
+}
+
+func (readAheadReader *ReadAheadGzipReader) Close() error {
+	readAheadReader.closed.Do(func() { close(readAheadReader.done) })
+	return nil
+}
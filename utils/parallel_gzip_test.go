@@ -0,0 +1,108 @@
+package utils_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"math/rand"
+
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/parallel_gzip tests", func() {
+	var data []byte
+	BeforeEach(func() {
+		random := rand.New(rand.NewSource(1))
+		data = make([]byte, 3*utils.GzipBlockSize+1234)
+		for i := range data {
+			data[i] = byte('a' + random.Intn(8))
+		}
+	})
+	compress := func(input []byte, numWorkers int) []byte {
+		var output bytes.Buffer
+		gzipWriter, err := utils.NewParallelGzipWriter(&output, 6, numWorkers)
+		Expect(err).ToNot(HaveOccurred())
+		// Write in pieces that do not line up with the blocks
+		for start := 0; start < len(input); start += 100000 {
+			end := start + 100000
+			if end > len(input) {
+				end = len(input)
+			}
+			_, err = gzipWriter.Write(input[start:end])
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Expect(gzipWriter.Close()).To(Succeed())
+		return output.Bytes()
+	}
+	Describe("ParallelGzipWriter", func() {
+		It("writes a standard gzip stream", func() {
+			gzipReader, err := gzip.NewReader(bytes.NewReader(compress(data, 4)))
+			Expect(err).ToNot(HaveOccurred())
+
+			output, err := ioutil.ReadAll(gzipReader)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(output).To(Equal(data))
+		})
+		It("writes the same stream regardless of the number of workers", func() {
+			Expect(compress(data, 4)).To(Equal(compress(data, 1)))
+		})
+		It("writes a standard gzip stream with no data", func() {
+			gzipReader, err := gzip.NewReader(bytes.NewReader(compress([]byte{}, 2)))
+			Expect(err).ToNot(HaveOccurred())
+
+			output, err := ioutil.ReadAll(gzipReader)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(output).To(BeEmpty())
+		})
+		It("returns an error when writing the compressed data fails", func() {
+			gzipWriter, err := utils.NewParallelGzipWriter(testWriter{WriteErr: errors.New("disk full")}, 6, 2)
+			Expect(err).ToNot(HaveOccurred())
+			_, _ = gzipWriter.Write(data)
+
+			Expect(gzipWriter.Close()).To(MatchError("disk full"))
+		})
+		It("returns an error when given an invalid compression level", func() {
+			_, err := utils.NewParallelGzipWriter(&bytes.Buffer{}, 10, 2)
+
+			Expect(err).To(MatchError("Invalid gzip compression level: 10"))
+		})
+	})
+	Describe("ReadAheadGzipReader", func() {
+		It("reads a gzip stream", func() {
+			var compressed bytes.Buffer
+			gzipWriter := gzip.NewWriter(&compressed)
+			_, _ = gzipWriter.Write(data)
+			_ = gzipWriter.Close()
+			gzipReader, err := utils.NewReadAheadGzipReader(&compressed, 2)
+			Expect(err).ToNot(HaveOccurred())
+
+			output, err := ioutil.ReadAll(gzipReader)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(output).To(Equal(data))
+		})
+		It("returns an error when the stream is corrupt", func() {
+			compressed := compress(data, 2)
+			compressed[len(compressed)-8] ^= 0xff
+			gzipReader, err := utils.NewReadAheadGzipReader(bytes.NewReader(compressed), 2)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = ioutil.ReadAll(gzipReader)
+
+			Expect(err).To(Equal(gzip.ErrChecksum))
+		})
+		It("stops decompressing when closed", func() {
+			gzipReader, err := utils.NewReadAheadGzipReader(bytes.NewReader(compress(data, 2)), 1)
+			Expect(err).ToNot(HaveOccurred())
+			_, _ = gzipReader.Read(make([]byte, 10))
+
+			Expect(gzipReader.Close()).To(Succeed())
+		})
+	})
+})
@@ -1,12 +1,13 @@
 package utils
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -16,17 +17,35 @@ import (
 	"github.com/greenplum-db/gp-common-go-libs/iohelper"
 	"github.com/greenplum-db/gp-common-go-libs/operating"
 	"github.com/greenplum-db/gpbackup/backup_filepath"
+	"github.com/greenplum-db/gpbackup/storage"
 	"gopkg.in/yaml.v2"
 )
 
 const RequiredPluginVersion = "0.3.0"
+
+// Plugins implementing this version of the API or later report their capabilities
+const PluginAPIVersion2 = "2.0.0"
 const SecretKeyFile = ".encrypt"
 
+// Built-in backends are run by gpbackup_helper instead of a plugin executable
+const (
+	LocalBackend = "local"
+	S3Backend    = "s3"
+)
+
 type PluginConfig struct {
-	ExecutablePath      string            `yaml:"executablepath"`
-	ConfigPath          string            `yaml:"-"`
-	Options             map[string]string `yaml:"options"`
-	backupPluginVersion string            `yaml:"-"`
+	ExecutablePath      string                   `yaml:"executablepath"`
+	Backend             string                   `yaml:"backend,omitempty"`
+	ConfigPath          string                   `yaml:"-"`
+	Options             map[string]string        `yaml:"options"`
+	DataStreams         int                      `yaml:"data_streams,omitempty"`
+	Capabilities        []string                 `yaml:"capabilities,omitempty"`
+	CommandTimeouts     map[string]string        `yaml:"command_timeouts,omitempty"`
+	MaxRetries          *int                     `yaml:"max_retries,omitempty"`
+	RetryBaseDelay      string                   `yaml:"retry_base_delay,omitempty"`
+	timeouts            map[string]time.Duration `yaml:"-"`
+	retryBaseDelay      time.Duration            `yaml:"-"`
+	backupPluginVersion string                   `yaml:"-"`
 }
 
 type PluginScope string
@@ -47,8 +66,19 @@ func ReadPluginConfig(configFile string) (*PluginConfig, error) {
 	if err != nil {
 		return nil, err
 	}
-	config.ExecutablePath = os.ExpandEnv(config.ExecutablePath)
-	err = ValidateFullPath(config.ExecutablePath)
+	if config.Backend != "" {
+		if config.Backend != LocalBackend && config.Backend != S3Backend {
+			return nil, errors.Errorf("Unsupported plugin backend %s. Valid values are %s and %s.", config.Backend, LocalBackend, S3Backend)
+		}
+		config.ExecutablePath = fmt.Sprintf("%s --builtin-plugin", filepath.Join(operating.System.Getenv("GPHOME"), "bin", "gpbackup_helper"))
+	} else {
+		config.ExecutablePath = os.ExpandEnv(config.ExecutablePath)
+		err = ValidateFullPath(config.ExecutablePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = config.parseRetryOptions()
 	if err != nil {
 		return nil, err
 	}
@@ -57,11 +87,94 @@ func ReadPluginConfig(configFile string) (*PluginConfig, error) {
 	return config, nil
 }
 
+/*
+ * Timeouts are given per plugin command as durations such as "30s" or "10m",
+ * and commands without a timeout may run indefinitely.
+ */
+func (plugin *PluginConfig) parseRetryOptions() error {
+	plugin.timeouts = make(map[string]time.Duration)
+	for command, timeoutStr := range plugin.CommandTimeouts {
+		if !isPluginCommand(command) {
+			return errors.Errorf("Cannot set a timeout for unknown plugin command %s", command)
+		}
+		timeout, err := time.ParseDuration(timeoutStr)
+		if err != nil || timeout <= 0 {
+			return errors.Errorf("Invalid timeout %s for plugin command %s", timeoutStr, command)
+		}
+		plugin.timeouts[command] = timeout
+	}
+	if plugin.MaxRetries == nil {
+		maxRetries := storage.DefaultPluginMaxRetries
+		plugin.MaxRetries = &maxRetries
+	} else if *plugin.MaxRetries < 0 {
+		return errors.Errorf("max_retries must not be negative")
+	}
+	plugin.retryBaseDelay = storage.DefaultPluginRetryBaseDelay
+	if plugin.RetryBaseDelay != "" {
+		delay, err := time.ParseDuration(plugin.RetryBaseDelay)
+		if err != nil || delay < 0 {
+			return errors.Errorf("Invalid retry_base_delay %s", plugin.RetryBaseDelay)
+		}
+		plugin.retryBaseDelay = delay
+	}
+	return nil
+}
+
+func isPluginCommand(command string) bool {
+	for _, pluginCommand := range storage.PluginCommands {
+		if command == pluginCommand {
+			return true
+		}
+	}
+	return false
+}
+
+// Commands run through the shell on the cluster are limited with timeout(1)
+func (plugin *PluginConfig) timeoutPrefix(command string) string {
+	if timeout, ok := plugin.timeouts[command]; ok {
+		return fmt.Sprintf("timeout -s KILL %gs ", timeout.Seconds())
+	}
+	return ""
+}
+
+/*
+ * Built-in backends are used in-process; any other plugin is run as an
+ * executable implementing the plugin API.
+ */
+func (plugin *PluginConfig) GetStorageBackend() (storage.StorageBackend, error) {
+	switch plugin.Backend {
+	case LocalBackend:
+		return storage.NewLocalBackend(plugin.Options)
+	case S3Backend:
+		return storage.NewS3Backend(plugin.Options)
+	}
+	return &storage.PluginBackend{
+		ExecutablePath: plugin.ExecutablePath,
+		ConfigPath:     plugin.ConfigPath,
+		Capabilities:   plugin.Capabilities,
+		Streams:        plugin.DataStreams,
+		PartSize:       storage.DefaultPluginPartSize,
+		Timeouts:       plugin.timeouts,
+		MaxRetries:     plugin.maxRetries(),
+		RetryBaseDelay: plugin.retryBaseDelay,
+	}, nil
+}
+
+func (plugin *PluginConfig) maxRetries() int {
+	if plugin.MaxRetries == nil {
+		return 0
+	}
+	return *plugin.MaxRetries
+}
+
 func (plugin *PluginConfig) BackupFile(filenamePath string) error {
-	command := fmt.Sprintf("%s backup_file %s %s", plugin.ExecutablePath, plugin.ConfigPath, filenamePath)
-	output, err := exec.Command("bash", "-c", command).CombinedOutput()
+	backend, err := plugin.GetStorageBackend()
 	if err != nil {
-		return fmt.Errorf("Plugin failed to process %s. %s", filenamePath, string(output))
+		return err
+	}
+	err = storage.PutFile(backend, filenamePath)
+	if err != nil {
+		return err
 	}
 	err = operating.System.Chmod(filenamePath, 0755)
 	return err
@@ -76,9 +189,10 @@ func (plugin *PluginConfig) MustRestoreFile(filenamePath string) {
 	directory, _ := filepath.Split(filenamePath)
 	err := operating.System.MkdirAll(directory, 0755)
 	gplog.FatalOnError(err)
-	command := fmt.Sprintf("%s restore_file %s %s", plugin.ExecutablePath, plugin.ConfigPath, filenamePath)
-	output, err := exec.Command("bash", "-c", command).CombinedOutput()
-	gplog.FatalOnError(err, string(output))
+	backend, err := plugin.GetStorageBackend()
+	gplog.FatalOnError(err)
+	err = storage.GetFile(backend, filenamePath)
+	gplog.FatalOnError(err)
 }
 
 func (plugin *PluginConfig) CheckPluginExistsOnAllHosts(c *cluster.Cluster) string {
@@ -135,6 +249,33 @@ func (plugin *PluginConfig) checkPluginAPIVersion(c *cluster.Cluster) {
 		cluster.LogFatalClusterError("Plugin API version incorrect",
 			cluster.ON_HOSTS_AND_MASTER, numIncorrect)
 	}
+
+	// Capabilities are negotiated afresh, as they may differ from those of the plugin that took the backup
+	plugin.Capabilities = nil
+	version2, _ := semver.Make(PluginAPIVersion2)
+	if version.GE(version2) {
+		plugin.Capabilities = plugin.getPluginCapabilities(c)
+	}
+}
+
+/*
+ * The plugin API version is consistent across all hosts at this point, so
+ * the plugin on the master is assumed to have the same capabilities as the
+ * plugin on every other host.
+ */
+func (plugin *PluginConfig) getPluginCapabilities(c *cluster.Cluster) []string {
+	output, err := c.ExecuteLocalCommand(fmt.Sprintf("source %s/greenplum_path.sh && %s plugin_capabilities",
+		operating.System.Getenv("GPHOME"), plugin.ExecutablePath))
+	if err != nil {
+		gplog.Fatal(err, fmt.Sprintf("Unable to get capabilities of plugin %s", plugin.ExecutablePath))
+	}
+	capabilities := make([]string, 0)
+	err = json.Unmarshal([]byte(strings.TrimSpace(output)), &capabilities)
+	if err != nil {
+		gplog.Fatal(fmt.Errorf("Unable to parse plugin capabilities: %s", err.Error()), "")
+	}
+	gplog.Verbose("Plugin %s has capabilities %s", plugin.ExecutablePath, strings.Join(capabilities, ", "))
+	return capabilities
 }
 
 func (plugin *PluginConfig) getPluginNativeVersion(c *cluster.Cluster) string {
@@ -256,8 +397,8 @@ func (plugin *PluginConfig) buildHookString(command string,
 	}
 
 	backupDir := fpInfo.GetDirForContent(contentID)
-	return fmt.Sprintf("source %s/greenplum_path.sh && %s %s %s %s %s %s",
-		operating.System.Getenv("GPHOME"), plugin.ExecutablePath, command, plugin.ConfigPath, backupDir, scope,
+	return fmt.Sprintf("source %s/greenplum_path.sh && %s%s %s %s %s %s %s",
+		operating.System.Getenv("GPHOME"), plugin.timeoutPrefix(command), plugin.ExecutablePath, command, plugin.ConfigPath, backupDir, scope,
 		contentIDStr)
 }
 
@@ -369,19 +510,14 @@ func GetSecretKey(pluginName string, mdd string) (string, error) {
 
 }
 
+/*
+ * The agents have finished uploading their data by the time this is called,
+ * as gpbackup waits for them through their control sockets.
+ */
 func (plugin *PluginConfig) BackupSegmentTOCs(c *cluster.Cluster, fpInfo backup_filepath.FilePathInfo) {
-	remoteOutput := c.GenerateAndExecuteCommand("Waiting for remaining data to be uploaded to plugin destination", func(contentID int) string {
-		tocFile := fpInfo.GetSegmentTOCFilePath(contentID)
-		errorFile := fmt.Sprintf("%s_error", fpInfo.GetSegmentPipeFilePath(contentID))
-		return fmt.Sprintf(`while [[ ! -f "%s" && ! -f "%s" ]]; do sleep 1; done; ls "%s"`, tocFile, errorFile, tocFile)
-	}, cluster.ON_SEGMENTS)
-	c.CheckClusterError(remoteOutput, "Error occurred in gpbackup_helper", func(contentID int) string {
-		return "See gpAdminLog for gpbackup_helper on segment host for details: Error occurred with plugin"
-	})
-
-	remoteOutput = c.GenerateAndExecuteCommand("Processing segment TOC files with plugin", func(contentID int) string {
+	remoteOutput := c.GenerateAndExecuteCommand("Processing segment TOC files with plugin", func(contentID int) string {
 		tocFile := fpInfo.GetSegmentTOCFilePath(contentID)
-		return fmt.Sprintf("source %s/greenplum_path.sh && %s backup_file %s %s && chmod 0755 %s", operating.System.Getenv("GPHOME"), plugin.ExecutablePath, plugin.ConfigPath, tocFile, tocFile)
+		return fmt.Sprintf("source %s/greenplum_path.sh && %s%s backup_file %s %s && chmod 0755 %s", operating.System.Getenv("GPHOME"), plugin.timeoutPrefix("backup_file"), plugin.ExecutablePath, plugin.ConfigPath, tocFile, tocFile)
 	}, cluster.ON_SEGMENTS)
 	c.CheckClusterError(remoteOutput, "Unable to process segment TOC files using plugin", func(contentID int) string {
 		return "See gpAdminLog for gpbackup_helper on segment host for details: Error occurred with plugin"
@@ -391,7 +527,7 @@ func (plugin *PluginConfig) BackupSegmentTOCs(c *cluster.Cluster, fpInfo backup_
 func (plugin *PluginConfig) RestoreSegmentTOCs(c *cluster.Cluster, fpInfo backup_filepath.FilePathInfo) {
 	remoteOutput := c.GenerateAndExecuteCommand("Processing segment TOC files with plugin", func(contentID int) string {
 		tocFile := fpInfo.GetSegmentTOCFilePath(contentID)
-		return fmt.Sprintf("mkdir -p %s && source %s/greenplum_path.sh && %s restore_file %s %s", fpInfo.GetDirForContent(contentID), operating.System.Getenv("GPHOME"), plugin.ExecutablePath, plugin.ConfigPath, tocFile)
+		return fmt.Sprintf("mkdir -p %s && source %s/greenplum_path.sh && %s%s restore_file %s %s", fpInfo.GetDirForContent(contentID), operating.System.Getenv("GPHOME"), plugin.timeoutPrefix("restore_file"), plugin.ExecutablePath, plugin.ConfigPath, tocFile)
 	}, cluster.ON_SEGMENTS)
 	c.CheckClusterError(remoteOutput, "Unable to process segment TOC files using plugin", func(contentID int) string {
 		return fmt.Sprintf("Unable to process segment TOC files using plugin")
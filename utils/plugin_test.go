@@ -7,7 +7,9 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/greenplum-db/gpbackup/storage"
 	"github.com/greenplum-db/gpbackup/testutils"
 
 	"github.com/greenplum-db/gp-common-go-libs/iohelper"
@@ -20,6 +22,7 @@ import (
 	"github.com/greenplum-db/gpbackup/utils"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 )
 
@@ -97,6 +100,74 @@ var _ = Describe("utils/plugin tests", func() {
 			}
 		})
 	})
+	Describe("ReadPluginConfig", func() {
+		It("uses gpbackup_helper as the executable for the built-in s3 backend", func() {
+			operating.System.Getenv = func(key string) string {
+				return "/my/install/dir"
+			}
+			testConfigPath := filepath.Join(tempDir, "s3_config.yaml")
+			err := ioutil.WriteFile(testConfigPath, []byte("backend: s3\noptions:\n  bucket: my_bucket\n"), 0777)
+			Expect(err).To(Not(HaveOccurred()))
+
+			config, err := utils.ReadPluginConfig(testConfigPath)
+
+			Expect(err).To(Not(HaveOccurred()))
+			Expect(config.ExecutablePath).To(Equal("/my/install/dir/bin/gpbackup_helper --builtin-plugin"))
+			Expect(config.ConfigPath).To(Equal("/tmp/s3_config.yaml"))
+			Expect(config.Options).To(Equal(map[string]string{"bucket": "my_bucket"}))
+		})
+		It("returns an error for an unsupported backend", func() {
+			testConfigPath := filepath.Join(tempDir, "gcs_config.yaml")
+			err := ioutil.WriteFile(testConfigPath, []byte("backend: gcs\n"), 0777)
+			Expect(err).To(Not(HaveOccurred()))
+
+			_, err = utils.ReadPluginConfig(testConfigPath)
+
+			Expect(err).To(MatchError("Unsupported plugin backend gcs. Valid values are local and s3."))
+		})
+		It("parses command timeouts and retry options", func() {
+			testConfigPath := filepath.Join(tempDir, "retry_config.yaml")
+			err := ioutil.WriteFile(testConfigPath, []byte("executablepath: /tmp/fake_path\ncommand_timeouts:\n  backup_file: 10m\n  delete_backup: 30s\nmax_retries: 5\nretry_base_delay: 2s\n"), 0777)
+			Expect(err).To(Not(HaveOccurred()))
+
+			config, err := utils.ReadPluginConfig(testConfigPath)
+			Expect(err).To(Not(HaveOccurred()))
+			backend, err := config.GetStorageBackend()
+
+			Expect(err).To(Not(HaveOccurred()))
+			pluginBackend := backend.(*storage.PluginBackend)
+			Expect(pluginBackend.Timeouts).To(Equal(map[string]time.Duration{"backup_file": 10 * time.Minute, "delete_backup": 30 * time.Second}))
+			Expect(pluginBackend.MaxRetries).To(Equal(5))
+			Expect(pluginBackend.RetryBaseDelay).To(Equal(2 * time.Second))
+		})
+		It("retries commands by default", func() {
+			testConfigPath := filepath.Join(tempDir, "default_config.yaml")
+			err := ioutil.WriteFile(testConfigPath, []byte("executablepath: /tmp/fake_path\n"), 0777)
+			Expect(err).To(Not(HaveOccurred()))
+
+			config, err := utils.ReadPluginConfig(testConfigPath)
+			Expect(err).To(Not(HaveOccurred()))
+			backend, err := config.GetStorageBackend()
+
+			Expect(err).To(Not(HaveOccurred()))
+			Expect(backend.(*storage.PluginBackend).MaxRetries).To(Equal(storage.DefaultPluginMaxRetries))
+			Expect(backend.(*storage.PluginBackend).RetryBaseDelay).To(Equal(storage.DefaultPluginRetryBaseDelay))
+		})
+		DescribeTable("returns an error for invalid retry options", func(contents string, expectedError string) {
+			testConfigPath := filepath.Join(tempDir, "invalid_config.yaml")
+			err := ioutil.WriteFile(testConfigPath, []byte("backend: s3\n"+contents), 0777)
+			Expect(err).To(Not(HaveOccurred()))
+
+			_, err = utils.ReadPluginConfig(testConfigPath)
+
+			Expect(err).To(MatchError(expectedError))
+		},
+			Entry("unknown command", "command_timeouts:\n  copy_file: 10s\n", "Cannot set a timeout for unknown plugin command copy_file"),
+			Entry("invalid timeout", "command_timeouts:\n  backup_file: ten\n", "Invalid timeout ten for plugin command backup_file"),
+			Entry("negative retries", "max_retries: -1\n", "max_retries must not be negative"),
+			Entry("invalid delay", "retry_base_delay: soon\n", "Invalid retry_base_delay soon"),
+		)
+	})
 	Describe("copy plugin config", func() {
 		It("successfully copies to all hosts, appending PGPORT and the --version of the plugin", func() {
 			testConfigPath := "/tmp/my_plugin_config.yaml"
@@ -192,6 +263,41 @@ options:
 				_ = subject.CheckPluginExistsOnAllHosts(testCluster)
 			})
 		})
+		When("version supports capabilities", func() {
+			It("negotiates the capabilities of the plugin", func() {
+				operating.System.Getenv = func(key string) string {
+					return "my/install/dir"
+				}
+				executor.ClusterOutputs[0].Stdouts[-1] = utils.PluginAPIVersion2
+				executor.ClusterOutputs[0].Stdouts[0] = utils.PluginAPIVersion2
+				executor.ClusterOutputs[0].Stdouts[1] = utils.PluginAPIVersion2
+				executor.LocalOutput = `["list_backups", "stat_file"]`
+
+				_ = subject.CheckPluginExistsOnAllHosts(testCluster)
+
+				Expect(executor.LocalCommands).To(Equal([]string{"source my/install/dir/greenplum_path.sh && /a/b/myPlugin plugin_capabilities"}))
+				Expect(subject.Capabilities).To(Equal([]string{"list_backups", "stat_file"}))
+			})
+			It("panics with message when the capabilities cannot be parsed", func() {
+				executor.ClusterOutputs[0].Stdouts[-1] = utils.PluginAPIVersion2
+				executor.ClusterOutputs[0].Stdouts[0] = utils.PluginAPIVersion2
+				executor.ClusterOutputs[0].Stdouts[1] = utils.PluginAPIVersion2
+				executor.LocalOutput = "list_backups"
+				defer testhelper.ShouldPanicWithMessage("Unable to parse plugin capabilities")
+
+				_ = subject.CheckPluginExistsOnAllHosts(testCluster)
+			})
+		})
+		When("version does not support capabilities", func() {
+			It("does not use any capabilities", func() {
+				subject.Capabilities = []string{"list_backups"}
+
+				_ = subject.CheckPluginExistsOnAllHosts(testCluster)
+
+				Expect(executor.NumLocalExecutions).To(Equal(0))
+				Expect(subject.Capabilities).To(BeNil())
+			})
+		})
 		When("version is too low", func() {
 			It("panics with message", func() {
 				executor.ClusterOutputs[0].Stdouts[-1] = "0.2.0"
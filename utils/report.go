@@ -29,6 +29,7 @@ import (
 type Report struct {
 	BackupParamsString string
 	DatabaseSize       string
+	ConnectionCount    int
 	backup_history.BackupConfig
 }
 
@@ -90,15 +91,22 @@ func (report *Report) ConstructBackupParamsString() {
 	if report.WithStatistics {
 		statsStr = "Yes"
 	}
+	snapshotStr := "No"
+	if report.ConnectionCount == 1 {
+		snapshotStr = "Not Applicable"
+	} else if report.SynchronizedSnapshot {
+		snapshotStr = "Yes"
+	}
 	backupParamsTemplate := `compression: %s
 plugin executable: %s
 backup section: %s
 object filtering: %s
 includes statistics: %s
 data file format: %s
+synchronized snapshot: %s
 %s`
 	report.BackupParamsString = fmt.Sprintf(backupParamsTemplate, compressStr, pluginStr, sectionStr, filterStr,
-		statsStr, filesStr, report.constructIncrementalSection())
+		statsStr, filesStr, snapshotStr, report.constructIncrementalSection())
 }
 
 func (report *Report) constructIncrementalSection() string {
@@ -162,6 +162,23 @@ tables      42
 types       1000`))
 		})
 	})
+	Describe("ConstructBackupParamsString", func() {
+		It("reports a snapshot shared by all connections", func() {
+			backupReport := &utils.Report{ConnectionCount: 2, BackupConfig: backup_history.BackupConfig{SynchronizedSnapshot: true}}
+			backupReport.ConstructBackupParamsString()
+			Expect(backupReport.BackupParamsString).To(ContainSubstring("synchronized snapshot: Yes\n"))
+		})
+		It("reports a separate snapshot per connection", func() {
+			backupReport := &utils.Report{ConnectionCount: 2, BackupConfig: backup_history.BackupConfig{SynchronizedSnapshot: false}}
+			backupReport.ConstructBackupParamsString()
+			Expect(backupReport.BackupParamsString).To(ContainSubstring("synchronized snapshot: No\n"))
+		})
+		It("reports a snapshot as not applicable to a single connection", func() {
+			backupReport := &utils.Report{ConnectionCount: 1}
+			backupReport.ConstructBackupParamsString()
+			Expect(backupReport.BackupParamsString).To(ContainSubstring("synchronized snapshot: Not Applicable\n"))
+		})
+	})
 	Describe("AppendBackupParams", func() {
 		It("correctly parses the string and appends to the LineInfo array", func() {
 			testParamsStr := `compression: exampleStr
@@ -303,8 +320,10 @@ restore status:      Success but non-fatal errors occurred. See log file .+ for
 				DatabaseVersion:      "5.0.0 build test",
 				IncludeSchemas:       []string{},
 				IncludeRelations:     []string{"public.foobar"},
+				IncludeObjectTypes:   []string{},
 				ExcludeSchemas:       []string{},
 				ExcludeRelations:     []string{},
+				ExcludeObjectTypes:   []string{},
 				Plugin:               "/tmp/plugin.sh",
 				Timestamp:            "timestamp1",
 				IncludeTableFiltered: true,
@@ -0,0 +1,199 @@
+package utils
+
+/*
+ * This file contains functions for writing the contents of a TOC to an
+ * editable restore list file and for reading an edited list back in, so
+ * that gprestore can restore a hand-picked subset of a backup in a
+ * user-specified order.
+ */
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const TABLE_DATA_OBJECT_TYPE = "TABLE DATA"
+
+/*
+ * Sections are listed in the order in which gprestore restores them.  The
+ * "data" section refers to TOC.DataEntries, while all other sections refer
+ * to the metadata entries of the same name.
+ */
+var RestoreListSections = []string{"global", "predata", "data", "postdata", "statistics"}
+
+type RestoreListEntry struct {
+	Section         string
+	Index           int
+	ObjectType      string
+	Schema          string
+	Name            string
+	ReferenceObject string
+}
+
+func (entry RestoreListEntry) String() string {
+	return fmt.Sprintf("%s %d; %s; %s; %s; %s", entry.Section, entry.Index, entry.ObjectType,
+		listFieldOrDash(entry.Schema), listFieldOrDash(entry.Name), listFieldOrDash(entry.ReferenceObject))
+}
+
+func listFieldOrDash(field string) string {
+	if field == "" {
+		return "-"
+	}
+	return field
+}
+
+func listFieldOrEmpty(field string) string {
+	field = strings.TrimSpace(field)
+	if field == "-" {
+		return ""
+	}
+	return field
+}
+
+func (toc *TOC) GetRestoreListEntries() []RestoreListEntry {
+	listEntries := make([]RestoreListEntry, 0)
+	for _, section := range RestoreListSections {
+		if section == "data" {
+			for i, entry := range toc.DataEntries {
+				listEntries = append(listEntries, RestoreListEntry{Section: section, Index: i, ObjectType: TABLE_DATA_OBJECT_TYPE, Schema: entry.Schema, Name: entry.Name})
+			}
+			continue
+		}
+		for i, entry := range *toc.metadataEntryMap[section] {
+			listEntries = append(listEntries, RestoreListEntry{Section: section, Index: i, ObjectType: entry.ObjectType, Schema: entry.Schema, Name: entry.Name, ReferenceObject: entry.ReferenceObject})
+		}
+	}
+	return listEntries
+}
+
+func (toc *TOC) WriteRestoreList(writer io.Writer, timestamp string) {
+	MustPrintf(writer, `;
+; Restore list for backup with timestamp %s
+;
+; Each line refers to one entry in the backup's table of contents, in the form
+;   section index; object type; schema; name; reference object
+; Delete lines to skip restoring those entries, or move lines within a section
+; to change the order in which they are restored.  Only the section and index
+; are used to identify an entry; the remaining fields are informational.
+; Lines beginning with a semicolon are ignored.
+;
+`, timestamp)
+	for _, entry := range toc.GetRestoreListEntries() {
+		MustPrintln(writer, entry.String())
+	}
+}
+
+func ParseRestoreList(lines []string) ([]RestoreListEntry, error) {
+	listEntries := make([]RestoreListEntry, 0)
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+		fields := strings.SplitN(line, ";", 5)
+		identifier := strings.Fields(fields[0])
+		if len(identifier) != 2 {
+			return nil, errors.Errorf("Invalid restore list entry on line %d: %s", i+1, line)
+		}
+		index, err := strconv.Atoi(identifier[1])
+		if err != nil || index < 0 {
+			return nil, errors.Errorf("Invalid restore list entry on line %d: %s", i+1, line)
+		}
+		entry := RestoreListEntry{Section: identifier[0], Index: index}
+		if len(fields) > 1 {
+			entry.ObjectType = strings.TrimSpace(fields[1])
+		}
+		if len(fields) > 2 {
+			entry.Schema = listFieldOrEmpty(fields[2])
+		}
+		if len(fields) > 3 {
+			entry.Name = listFieldOrEmpty(fields[3])
+		}
+		if len(fields) > 4 {
+			entry.ReferenceObject = listFieldOrEmpty(fields[4])
+		}
+		listEntries = append(listEntries, entry)
+	}
+	return listEntries, nil
+}
+
+/*
+ * Checks each list entry against the TOC and replaces its informational
+ * fields with the values from the TOC, so that callers never act on a
+ * schema or name that was changed by hand in the list file.
+ */
+func (toc *TOC) ResolveRestoreList(listEntries []RestoreListEntry) ([]RestoreListEntry, error) {
+	tocEntries := make(map[string][]RestoreListEntry, len(RestoreListSections))
+	for _, entry := range toc.GetRestoreListEntries() {
+		tocEntries[entry.Section] = append(tocEntries[entry.Section], entry)
+	}
+	seen := make(map[string]bool, len(listEntries))
+	resolvedEntries := make([]RestoreListEntry, 0, len(listEntries))
+	for _, listEntry := range listEntries {
+		sectionEntries, ok := tocEntries[listEntry.Section]
+		if !ok && !isRestoreListSection(listEntry.Section) {
+			return nil, errors.Errorf("Unrecognized section %s in restore list. Valid sections are: %s", listEntry.Section, strings.Join(RestoreListSections, ", "))
+		}
+		if listEntry.Index >= len(sectionEntries) {
+			return nil, errors.Errorf("Restore list entry \"%s\" does not exist in the backup", listEntry)
+		}
+		tocEntry := sectionEntries[listEntry.Index]
+		if listEntry.ObjectType != "" && listEntry.ObjectType != tocEntry.ObjectType {
+			return nil, errors.Errorf("Restore list entry \"%s\" does not match backup entry \"%s\"", listEntry, tocEntry)
+		}
+		key := fmt.Sprintf("%s %d", tocEntry.Section, tocEntry.Index)
+		if seen[key] {
+			return nil, errors.Errorf("Restore list entry \"%s\" is listed more than once", tocEntry)
+		}
+		seen[key] = true
+		resolvedEntries = append(resolvedEntries, tocEntry)
+	}
+	return resolvedEntries, nil
+}
+
+func isRestoreListSection(section string) bool {
+	for _, validSection := range RestoreListSections {
+		if section == validSection {
+			return true
+		}
+	}
+	return false
+}
+
+func (toc *TOC) GetSQLStatementForRestoreList(section string, metadataFile io.ReaderAt, listEntries []RestoreListEntry) []StatementWithType {
+	entries := *toc.metadataEntryMap[section]
+	statements := make([]StatementWithType, 0)
+	for _, listEntry := range listEntries {
+		if listEntry.Section != section {
+			continue
+		}
+		statements = append(statements, readStatementForEntry(metadataFile, entries[listEntry.Index]))
+	}
+	return statements
+}
+
+/*
+ * Data entries may come from the TOC of an earlier incremental backup, so
+ * they are matched to list entries by name rather than by index.  Entries
+ * are returned in the order in which they appear in the list.
+ */
+func FilterDataEntriesByRestoreList(dataEntries []MasterDataEntry, listEntries []RestoreListEntry) []MasterDataEntry {
+	entriesByFQN := make(map[string]MasterDataEntry, len(dataEntries))
+	for _, entry := range dataEntries {
+		entriesByFQN[MakeFQN(entry.Schema, entry.Name)] = entry
+	}
+	filteredEntries := make([]MasterDataEntry, 0)
+	for _, listEntry := range listEntries {
+		if listEntry.Section != "data" {
+			continue
+		}
+		if entry, ok := entriesByFQN[MakeFQN(listEntry.Schema, listEntry.Name)]; ok {
+			filteredEntries = append(filteredEntries, entry)
+		}
+	}
+	return filteredEntries
+}
@@ -0,0 +1,136 @@
+package utils_test
+
+import (
+	"bytes"
+
+	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/utils"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("utils/restore_list tests", func() {
+	schema := utils.StatementWithType{Name: "schema", ObjectType: "SCHEMA", Statement: "CREATE SCHEMA schema;"}
+	table := utils.StatementWithType{Schema: "schema", Name: "table1", ObjectType: "TABLE", Statement: "CREATE TABLE schema.table1 (i int);"}
+	view := utils.StatementWithType{Schema: "schema", Name: "view1", ObjectType: "VIEW", Statement: "CREATE VIEW schema.view1 AS SELECT 1;"}
+	index := utils.StatementWithType{Schema: "schema", Name: "index1", ObjectType: "INDEX", ReferenceObject: "schema.table1", Statement: "CREATE INDEX index1 ON schema.table1(i);"}
+	var metadataFile *bytes.Reader
+
+	BeforeEach(func() {
+		toc, backupfile = testutils.InitializeTestTOC(buffer, "metadata")
+		startCount := uint64(0)
+		for _, statement := range []utils.StatementWithType{schema, table, view} {
+			endCount := startCount + uint64(len(statement.Statement))
+			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: statement.Schema, Name: statement.Name, ObjectType: statement.ObjectType}, startCount, endCount)
+			startCount = endCount
+		}
+		endCount := startCount + uint64(len(index.Statement))
+		toc.AddMetadataEntry("postdata", utils.MetadataEntry{Schema: index.Schema, Name: index.Name, ObjectType: index.ObjectType, ReferenceObject: index.ReferenceObject}, startCount, endCount)
+		toc.AddMasterDataEntry("schema", "table1", 1, "(i)", 0, "", false)
+		metadataFile = bytes.NewReader([]byte(schema.Statement + table.Statement + view.Statement + index.Statement))
+	})
+	Describe("WriteRestoreList", func() {
+		It("writes one line per TOC entry, in restore order", func() {
+			listBuffer := gbytes.NewBuffer()
+
+			toc.WriteRestoreList(listBuffer, "20180101010101")
+
+			Expect(listBuffer).To(gbytes.Say("; Restore list for backup with timestamp 20180101010101"))
+			Expect(listBuffer).To(gbytes.Say(`predata 0; SCHEMA; -; schema; -
+predata 1; TABLE; schema; table1; -
+predata 2; VIEW; schema; view1; -
+data 0; TABLE DATA; schema; table1; -
+postdata 0; INDEX; schema; index1; schema.table1
+`))
+		})
+	})
+	Describe("ParseRestoreList", func() {
+		It("parses entries and skips comments and blank lines", func() {
+			lines := []string{"; a comment", "", "  postdata 0; INDEX; schema; index1; schema.table1", "predata 2; VIEW; schema; view1; -"}
+
+			listEntries, err := utils.ParseRestoreList(lines)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(listEntries).To(Equal([]utils.RestoreListEntry{
+				{Section: "postdata", Index: 0, ObjectType: "INDEX", Schema: "schema", Name: "index1", ReferenceObject: "schema.table1"},
+				{Section: "predata", Index: 2, ObjectType: "VIEW", Schema: "schema", Name: "view1"},
+			}))
+		})
+		It("accepts entries containing only a section and index", func() {
+			listEntries, err := utils.ParseRestoreList([]string{"data 0"})
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(listEntries).To(Equal([]utils.RestoreListEntry{{Section: "data", Index: 0}}))
+		})
+		It("returns an error if an entry has no index", func() {
+			_, err := utils.ParseRestoreList([]string{"; a comment", "predata; VIEW; schema; view1; -"})
+
+			Expect(err).To(MatchError("Invalid restore list entry on line 2: predata; VIEW; schema; view1; -"))
+		})
+		It("returns an error if an entry has a non-numeric index", func() {
+			_, err := utils.ParseRestoreList([]string{"predata two; VIEW; schema; view1; -"})
+
+			Expect(err).To(MatchError("Invalid restore list entry on line 1: predata two; VIEW; schema; view1; -"))
+		})
+	})
+	Describe("ResolveRestoreList", func() {
+		It("fills in entry fields from the TOC", func() {
+			listEntries := []utils.RestoreListEntry{{Section: "data", Index: 0}, {Section: "predata", Index: 1, ObjectType: "TABLE", Schema: "edited", Name: "edited"}}
+
+			resolvedEntries, err := toc.ResolveRestoreList(listEntries)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resolvedEntries).To(Equal([]utils.RestoreListEntry{
+				{Section: "data", Index: 0, ObjectType: "TABLE DATA", Schema: "schema", Name: "table1"},
+				{Section: "predata", Index: 1, ObjectType: "TABLE", Schema: "schema", Name: "table1"},
+			}))
+		})
+		It("returns an error for an unrecognized section", func() {
+			_, err := toc.ResolveRestoreList(parseListLines("predata 0", "globals 0"))
+
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("Unrecognized section globals in restore list"))
+		})
+		It("returns an error for an index outside the section", func() {
+			_, err := toc.ResolveRestoreList(parseListLines("statistics 0"))
+
+			Expect(err).To(MatchError(`Restore list entry "statistics 0; ; -; -; -" does not exist in the backup`))
+		})
+		It("returns an error if the object type does not match the TOC", func() {
+			_, err := toc.ResolveRestoreList(parseListLines("predata 1; VIEW; schema; table1; -"))
+
+			Expect(err).To(MatchError(`Restore list entry "predata 1; VIEW; schema; table1; -" does not match backup entry "predata 1; TABLE; schema; table1; -"`))
+		})
+		It("returns an error if an entry is listed twice", func() {
+			_, err := toc.ResolveRestoreList(parseListLines("predata 1", "predata 2", "predata 1"))
+
+			Expect(err).To(MatchError(`Restore list entry "predata 1; TABLE; schema; table1; -" is listed more than once`))
+		})
+	})
+	Describe("GetSQLStatementForRestoreList", func() {
+		It("returns statements for the given section in list order", func() {
+			listEntries, _ := toc.ResolveRestoreList(parseListLines("predata 2", "postdata 0", "predata 0"))
+
+			statements := toc.GetSQLStatementForRestoreList("predata", metadataFile, listEntries)
+
+			Expect(statements).To(Equal([]utils.StatementWithType{view, schema}))
+		})
+	})
+	Describe("FilterDataEntriesByRestoreList", func() {
+		It("returns listed data entries in list order", func() {
+			dataEntries := []utils.MasterDataEntry{{Schema: "schema", Name: "table1", Oid: 1}, {Schema: "schema", Name: "table2", Oid: 2}, {Schema: "schema", Name: "table3", Oid: 3}}
+			listEntries := []utils.RestoreListEntry{{Section: "data", Schema: "schema", Name: "table3"}, {Section: "predata", Schema: "schema", Name: "table2"}, {Section: "data", Schema: "schema", Name: "table1"}}
+
+			filteredEntries := utils.FilterDataEntriesByRestoreList(dataEntries, listEntries)
+
+			Expect(filteredEntries).To(Equal([]utils.MasterDataEntry{dataEntries[2], dataEntries[0]}))
+		})
+	})
+})
+
+func parseListLines(lines ...string) []utils.RestoreListEntry {
+	listEntries, err := utils.ParseRestoreList(lines)
+	Expect(err).ToNot(HaveOccurred())
+	return listEntries
+}
@@ -3,22 +3,27 @@ package utils
 import (
 	"fmt"
 	"io"
-	"regexp"
 	"os"
+	"regexp"
+	"sort"
+	"strings"
 
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
 	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
 )
 
 type TOC struct {
-	metadataEntryMap    map[string]*[]MetadataEntry
-	GlobalEntries       []MetadataEntry
-	PredataEntries      []MetadataEntry
-	PostdataEntries     []MetadataEntry
-	StatisticsEntries   []MetadataEntry
-	DataEntries         []MasterDataEntry
-	IncrementalMetadata IncrementalEntries
+	metadataEntryMap     map[string]*[]MetadataEntry
+	includedDependencies *FilterSet
+	GlobalEntries        []MetadataEntry
+	PredataEntries       []MetadataEntry
+	PostdataEntries      []MetadataEntry
+	StatisticsEntries    []MetadataEntry
+	DataEntries          []MasterDataEntry
+	Dependencies         map[string][]string
+	IncrementalMetadata  IncrementalEntries
 }
 
 type SegmentTOC struct {
@@ -34,6 +39,17 @@ type MetadataEntry struct {
 	EndByte         uint64
 }
 
+/*
+ * Identifies a metadata entry in TOC.Dependencies.  Entries have no OID once
+ * they are written to the TOC, so the object type and name are used instead.
+ */
+func (entry MetadataEntry) DependencyKey() string {
+	if entry.Schema == "" {
+		return fmt.Sprintf("%s %s", entry.ObjectType, entry.Name)
+	}
+	return fmt.Sprintf("%s %s", entry.ObjectType, MakeFQN(entry.Schema, entry.Name))
+}
+
 type MasterDataEntry struct {
 	Schema          string
 	Name            string
@@ -41,11 +57,27 @@ type MasterDataEntry struct {
 	AttributeString string
 	RowsCopied      int64
 	PartitionRoot   string
+	// Every segment holds a full copy of the data of a replicated table
+	IsReplicated bool
 }
 
+/*
+ * StartByte and EndByte are offsets in the uncompressed data.  When the data
+ * file is compressed, the data of each table is compressed as a separate gzip
+ * member, which starts at FrameStartByte and ends at FrameEndByte in the file,
+ * so that a table can be restored without decompressing the tables before it.
+ * Both are 0 for uncompressed data files and for backups taken before tables
+ * were compressed separately.
+ */
 type SegmentDataEntry struct {
-	StartByte uint64
-	EndByte   uint64
+	StartByte      uint64
+	EndByte        uint64
+	FrameStartByte uint64 `yaml:",omitempty"`
+	FrameEndByte   uint64 `yaml:",omitempty"`
+}
+
+func (entry SegmentDataEntry) HasFrame() bool {
+	return entry.FrameEndByte > 0
 }
 
 type IncrementalEntries struct {
@@ -134,6 +166,61 @@ type StatementWithType struct {
 	Statement       string
 }
 
+var distributedClauseRegex = regexp.MustCompile(`^DISTRIBUTED (BY \((?:[^()"]|"(?:[^"]|"")*")*\)|RANDOMLY|REPLICATED)`)
+
+/*
+ * Returns the DISTRIBUTED clause of a CREATE TABLE statement, or "" if there
+ * is none.  Only the table options between the column list and the end of the
+ * statement are searched, so that text in comments, column defaults, or other
+ * statements of the same TOC entry is not matched.
+ */
+func GetDistributionPolicy(statement string) string {
+	depth := 0
+	var quote byte
+	columnsEnd := -1
+	for i := 0; i < len(statement); i++ {
+		if quote != 0 {
+			if statement[i] == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch statement[i] {
+		case '\'', '"':
+			quote = statement[i]
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 && columnsEnd == -1 {
+				columnsEnd = i
+			}
+		case ';':
+			if depth == 0 {
+				return ""
+			}
+		case 'D':
+			if depth == 0 && columnsEnd != -1 {
+				if clause := distributedClauseRegex.FindString(statement[i:]); clause != "" {
+					return clause
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// Returns the tables whose CREATE TABLE statements give them a replicated distribution policy
+func GetReplicatedTables(statements []StatementWithType) map[string]bool {
+	replicatedTables := make(map[string]bool)
+	for _, statement := range statements {
+		if statement.ObjectType == "TABLE" && GetDistributionPolicy(statement.Statement) == "DISTRIBUTED REPLICATED" {
+			replicatedTables[MakeFQN(statement.Schema, statement.Name)] = true
+		}
+	}
+	return replicatedTables
+}
+
 func GetIncludedPartitionRoots(tocDataEntries []MasterDataEntry, includeRelations []string) []string {
 	if len(includeRelations) == 0 {
 		return []string{}
@@ -162,23 +249,108 @@ func (toc *TOC) GetSQLStatementForObjectTypes(section string, metadataFile io.Re
 	objectSet, schemaSet, relationSet := constructFilterSets(includeObjectTypes, excludeObjectTypes, includeSchemas, excludeSchemas, includeRelations, excludeRelations)
 	statements := make([]StatementWithType, 0)
 	for _, entry := range entries {
-		if shouldIncludeStatement(entry, objectSet, schemaSet, relationSet) {
-			contents := make([]byte, entry.EndByte-entry.StartByte)
-			_, err := metadataFile.ReadAt(contents, int64(entry.StartByte))
-			gplog.FatalOnError(err)
-			statements = append(statements, StatementWithType{Schema: entry.Schema, Name: entry.Name, ObjectType: entry.ObjectType, ReferenceObject: entry.ReferenceObject, Statement: string(contents)})
+		if shouldIncludeStatement(entry, objectSet, schemaSet, relationSet) || toc.isIncludedDependency(entry, objectSet) {
+			statements = append(statements, readStatementForEntry(metadataFile, entry))
 		}
 	}
 	return statements
 }
 
-func constructFilterSets(includeObjectTypes []string, excludeObjectTypes []string, includeSchemas []string, excludeSchemas []string, includeRelations []string, excludeRelations []string) (*FilterSet, *FilterSet, *FilterSet) {
-	var objectSet, schemaSet, relationSet *FilterSet
+func readStatementForEntry(metadataFile io.ReaderAt, entry MetadataEntry) StatementWithType {
+	contents := make([]byte, entry.EndByte-entry.StartByte)
+	_, err := metadataFile.ReadAt(contents, int64(entry.StartByte))
+	gplog.FatalOnError(err)
+	return StatementWithType{Schema: entry.Schema, Name: entry.Name, ObjectType: entry.ObjectType, ReferenceObject: entry.ReferenceObject, Statement: string(contents)}
+}
+
+/*
+ * These are all of the values that gpbackup writes to the ObjectType field of
+ * a MetadataEntry, and thus all of the values that may be passed to the
+ * --include-object-type and --exclude-object-type flags.
+ */
+var TOCObjectTypes = []string{
+	"AGGREGATE",
+	"CAST",
+	"COLLATION",
+	"CONSTRAINT",
+	"CONVERSION",
+	"DATABASE",
+	"DATABASE GUC",
+	"DATABASE METADATA",
+	"DEFAULT PRIVILEGES",
+	"DOMAIN",
+	"EVENT TRIGGER",
+	"EXCHANGE PARTITION",
+	"EXTENSION",
+	"FOREIGN DATA WRAPPER",
+	"FOREIGN SERVER",
+	"FOREIGN TABLE",
+	"FUNCTION",
+	"INDEX",
+	"LANGUAGE",
+	"MATERIALIZED VIEW",
+	"OPERATOR",
+	"OPERATOR CLASS",
+	"OPERATOR FAMILY",
+	"PROTOCOL",
+	"RESOURCE GROUP",
+	"RESOURCE QUEUE",
+	"ROLE",
+	"ROLE GRANT",
+	"ROLE GUCS",
+	"RULE",
+	"SCHEMA",
+	"SEQUENCE",
+	"SEQUENCE OWNER",
+	"SESSION GUCS",
+	"STATISTICS",
+	"TABLE",
+	"TABLESPACE",
+	"TEXT SEARCH CONFIGURATION",
+	"TEXT SEARCH DICTIONARY",
+	"TEXT SEARCH PARSER",
+	"TEXT SEARCH TEMPLATE",
+	"TRIGGER",
+	"TYPE",
+	"USER MAPPING",
+	"VIEW",
+}
+
+func ValidateObjectTypes(objectTypes []string) error {
+	validTypes := NewSet(TOCObjectTypes)
+	invalidTypes := make([]string, 0)
+	for _, objectType := range objectTypes {
+		if !validTypes.MatchesFilter(objectType) {
+			invalidTypes = append(invalidTypes, objectType)
+		}
+	}
+	if len(invalidTypes) > 0 {
+		return errors.Errorf("Unrecognized object type(s): %s. Valid object types are: %s", strings.Join(invalidTypes, ", "), strings.Join(TOCObjectTypes, ", "))
+	}
+	return nil
+}
+
+func NewObjectTypeFilterSet(includeObjectTypes []string, excludeObjectTypes []string) *FilterSet {
 	if len(includeObjectTypes) > 0 {
-		objectSet = NewIncludeSet(includeObjectTypes)
-	} else {
-		objectSet = NewExcludeSet(excludeObjectTypes)
+		return NewIncludeSet(includeObjectTypes)
 	}
+	return NewExcludeSet(excludeObjectTypes)
+}
+
+func FilterStatementsByObjectType(statements []StatementWithType, includeObjectTypes []string, excludeObjectTypes []string) []StatementWithType {
+	objectSet := NewObjectTypeFilterSet(includeObjectTypes, excludeObjectTypes)
+	filteredStatements := make([]StatementWithType, 0)
+	for _, statement := range statements {
+		if objectSet.MatchesFilter(statement.ObjectType) {
+			filteredStatements = append(filteredStatements, statement)
+		}
+	}
+	return filteredStatements
+}
+
+func constructFilterSets(includeObjectTypes []string, excludeObjectTypes []string, includeSchemas []string, excludeSchemas []string, includeRelations []string, excludeRelations []string) (*FilterSet, *FilterSet, *FilterSet) {
+	var schemaSet, relationSet *FilterSet
+	objectSet := NewObjectTypeFilterSet(includeObjectTypes, excludeObjectTypes)
 	if len(includeSchemas) > 0 {
 		schemaSet = NewIncludeSet(includeSchemas)
 	} else {
@@ -196,14 +368,70 @@ func shouldIncludeStatement(entry MetadataEntry, objectSet *FilterSet, schemaSet
 	shouldIncludeObject := objectSet.MatchesFilter(entry.ObjectType)
 	shouldIncludeSchema := schemaSet.MatchesFilter(entry.Schema)
 	relationFQN := MakeFQN(entry.Schema, entry.Name)
-	shouldIncludeRelation := (relationSet.IsExclude && entry.ObjectType != "TABLE" && entry.ObjectType != "VIEW" && entry.ObjectType != "SEQUENCE" && entry.ReferenceObject == "") ||
-		((entry.ObjectType == "TABLE" || entry.ObjectType == "VIEW" || entry.ObjectType == "SEQUENCE") && relationSet.MatchesFilter(relationFQN) && entry.ReferenceObject == "") || // Relations should match the filter
+	isRelation := entry.ObjectType == "TABLE" || entry.ObjectType == "VIEW" || entry.ObjectType == "MATERIALIZED VIEW" || entry.ObjectType == "SEQUENCE"
+	shouldIncludeRelation := (relationSet.IsExclude && !isRelation && entry.ReferenceObject == "") ||
+		(isRelation && relationSet.MatchesFilter(relationFQN) && entry.ReferenceObject == "") || // Relations should match the filter
 		(entry.ObjectType != "SEQUENCE OWNER" && entry.ReferenceObject != "" && relationSet.MatchesFilter(entry.ReferenceObject)) || // Include relations that filtered tables depend on
 		(entry.ObjectType == "SEQUENCE OWNER" && relationSet.MatchesFilter(relationFQN) && relationSet.MatchesFilter(entry.ReferenceObject)) //Include sequence owners if both table and sequence are being restored
 
 	return shouldIncludeObject && shouldIncludeSchema && shouldIncludeRelation
 }
 
+func (toc *TOC) isIncludedDependency(entry MetadataEntry, objectSet *FilterSet) bool {
+	return toc.includedDependencies != nil && objectSet.MatchesFilter(entry.ObjectType) && toc.includedDependencies.MatchesFilter(entry.DependencyKey())
+}
+
+/*
+ * Returns the pre-data entries that the given relations depend on, directly
+ * or indirectly, in TOC order.  The relations themselves are not included.
+ */
+func (toc *TOC) GetDependencyClosure(relationFQNs []string) []MetadataEntry {
+	relationSet := NewSet(relationFQNs)
+	visited := make(map[string]bool)
+	queue := make([]string, 0)
+	for _, entry := range toc.PredataEntries {
+		if entry.ReferenceObject == "" && relationSet.MatchesFilter(MakeFQN(entry.Schema, entry.Name)) &&
+			(entry.ObjectType == "TABLE" || entry.ObjectType == "FOREIGN TABLE" || entry.ObjectType == "VIEW" ||
+				entry.ObjectType == "MATERIALIZED VIEW" || entry.ObjectType == "SEQUENCE") {
+			key := entry.DependencyKey()
+			visited[key] = true
+			queue = append(queue, key)
+		}
+	}
+	startingKeys := make(map[string]bool, len(visited))
+	for key := range visited {
+		startingKeys[key] = true
+	}
+	for len(queue) > 0 {
+		key := queue[0]
+		queue = queue[1:]
+		for _, dependency := range toc.Dependencies[key] {
+			if !visited[dependency] {
+				visited[dependency] = true
+				queue = append(queue, dependency)
+			}
+		}
+	}
+
+	closure := make([]MetadataEntry, 0)
+	for _, entry := range toc.PredataEntries {
+		key := entry.DependencyKey()
+		if entry.ReferenceObject == "" && visited[key] && !startingKeys[key] {
+			closure = append(closure, entry)
+		}
+	}
+	return closure
+}
+
+/*
+ * Entries whose dependency keys are passed to this function are restored
+ * along with the filtered relations, as long as they also match the object
+ * type filters.
+ */
+func (toc *TOC) IncludeDependencies(dependencyKeys []string) {
+	toc.includedDependencies = NewSet(dependencyKeys)
+}
+
 func getLeafPartitions(tableFQNs []string, tocDataEntries []MasterDataEntry) (leafPartitions []string) {
 	tableSet := NewSet(tableFQNs)
 
@@ -301,11 +529,24 @@ func (toc *TOC) AddMetadataEntry(section string, entry MetadataEntry, start, end
 	*toc.metadataEntryMap[section] = append(*toc.metadataEntryMap[section], entry)
 }
 
-func (toc *TOC) AddMasterDataEntry(schema string, name string, oid uint32, attributeString string, rowsCopied int64, PartitionRoot string) {
-	toc.DataEntries = append(toc.DataEntries, MasterDataEntry{schema, name, oid, attributeString, rowsCopied, PartitionRoot})
+func (toc *TOC) AddDependency(objectKey string, referenceKey string) {
+	if toc.Dependencies == nil {
+		toc.Dependencies = make(map[string][]string)
+	}
+	for _, existingKey := range toc.Dependencies[objectKey] {
+		if existingKey == referenceKey {
+			return
+		}
+	}
+	toc.Dependencies[objectKey] = append(toc.Dependencies[objectKey], referenceKey)
+	sort.Strings(toc.Dependencies[objectKey])
+}
+
+func (toc *TOC) AddMasterDataEntry(schema string, name string, oid uint32, attributeString string, rowsCopied int64, PartitionRoot string, isReplicated bool) {
+	toc.DataEntries = append(toc.DataEntries, MasterDataEntry{schema, name, oid, attributeString, rowsCopied, PartitionRoot, isReplicated})
 }
 
-func (toc *SegmentTOC) AddSegmentDataEntry(oid uint, startByte uint64, endByte uint64) {
+func (toc *SegmentTOC) AddSegmentDataEntry(oid uint, startByte uint64, endByte uint64, frameStartByte uint64, frameEndByte uint64) {
 	// We use uint for oid since the flags package does not have a uint32 flag
-	toc.DataEntries[oid] = SegmentDataEntry{startByte, endByte}
+	toc.DataEntries[oid] = SegmentDataEntry{startByte, endByte, frameStartByte, frameEndByte}
 }
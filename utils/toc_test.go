@@ -114,6 +114,20 @@ var _ = Describe("utils/toc tests", func() {
 
 			Expect(statements).To(Equal([]utils.StatementWithType{table1, capsTable, table2, index}))
 		})
+		It("returns statements for included dependencies of an included table, in TOC order", func() {
+			toc.IncludeDependencies([]string{"SEQUENCE schema.sequence", "VIEW schema.view"})
+
+			statements := toc.GetSQLStatementForObjectTypes("predata", metadataFile, noInObj, noExObj, noInSchema, noExSchema, []string{"schema.table1"}, noExRelation)
+
+			Expect(statements).To(Equal([]utils.StatementWithType{table1, view, sequence}))
+		})
+		It("does not return statements for included dependencies that are filtered out by object type", func() {
+			toc.IncludeDependencies([]string{"SEQUENCE schema.sequence", "VIEW schema.view"})
+
+			statements := toc.GetSQLStatementForObjectTypes("predata", metadataFile, noInObj, []string{"VIEW"}, noInSchema, noExSchema, []string{"schema.table1"}, noExRelation)
+
+			Expect(statements).To(Equal([]utils.StatementWithType{table1, sequence}))
+		})
 		It("returns no statements for a non-relation object with matching name from relation list", func() {
 			statements := toc.GetSQLStatementForObjectTypes("predata", metadataFile, noInObj, noExObj, noInSchema, noExSchema, []string{"schema.someindex"}, noExRelation)
 
@@ -171,11 +185,11 @@ var _ = Describe("utils/toc tests", func() {
 	})
 	Describe("GetDataEntriesMatching", func() {
 		BeforeEach(func() {
-			toc.AddMasterDataEntry("schema1", "table1", 1, "(i)", 0, "")
-			toc.AddMasterDataEntry("schema2", "table2", 1, "(i)", 0, "")
-			toc.AddMasterDataEntry("schema3", "table3", 1, "(i)", 0, "")
-			toc.AddMasterDataEntry("schema3", "table3_partition1", 1, "(i)", 0, "table3")
-			toc.AddMasterDataEntry("schema3", "table3_partition2", 1, "(i)", 0, "table3")
+			toc.AddMasterDataEntry("schema1", "table1", 1, "(i)", 0, "", false)
+			toc.AddMasterDataEntry("schema2", "table2", 1, "(i)", 0, "", false)
+			toc.AddMasterDataEntry("schema3", "table3", 1, "(i)", 0, "", false)
+			toc.AddMasterDataEntry("schema3", "table3_partition1", 1, "(i)", 0, "table3", false)
+			toc.AddMasterDataEntry("schema3", "table3_partition2", 1, "(i)", 0, "table3", false)
 		})
 		Context("Non-empty restore plan", func() {
 			restorePlanTableFQNs := []string{"schema1.table1", "schema2.table2", "schema3.table3", "schema3.table3_partition1", "schema3.table3_partition2"}
@@ -374,24 +388,149 @@ COMMENT ON DATABASE "db-special-chär$" IS 'this is a database comment';`}
 			Expect(resultStatements).To(Equal([]utils.StatementWithType{user1, user2}))
 		})
 	})
+	Describe("ValidateObjectTypes", func() {
+		It("accepts object types that are written to the TOC", func() {
+			err := utils.ValidateObjectTypes([]string{"FUNCTION", "TEXT SEARCH PARSER", "VIEW"})
+
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("accepts an empty list", func() {
+			err := utils.ValidateObjectTypes([]string{})
+
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("returns an error listing every unrecognized object type", func() {
+			err := utils.ValidateObjectTypes([]string{"FUNCTION", "function", "WIDGET"})
+
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("Unrecognized object type(s): function, WIDGET. Valid object types are: AGGREGATE, CAST"))
+		})
+	})
+	Describe("FilterStatementsByObjectType", func() {
+		function := utils.StatementWithType{Schema: "schema", Name: "func", ObjectType: "FUNCTION", Statement: "CREATE FUNCTION schema.func()"}
+		trigger := utils.StatementWithType{Schema: "schema", Name: "trig", ObjectType: "TRIGGER", Statement: "CREATE TRIGGER trig"}
+		rule := utils.StatementWithType{Schema: "schema", Name: "rule", ObjectType: "RULE", Statement: "CREATE RULE rule"}
+		statements := []utils.StatementWithType{function, trigger, rule}
+
+		It("returns all statements if no object types are specified", func() {
+			resultStatements := utils.FilterStatementsByObjectType(statements, []string{}, []string{})
+
+			Expect(resultStatements).To(Equal([]utils.StatementWithType{function, trigger, rule}))
+		})
+		It("returns only statements with an included object type", func() {
+			resultStatements := utils.FilterStatementsByObjectType(statements, []string{"FUNCTION", "VIEW"}, []string{})
+
+			Expect(resultStatements).To(Equal([]utils.StatementWithType{function}))
+		})
+		It("returns only statements without an excluded object type", func() {
+			resultStatements := utils.FilterStatementsByObjectType(statements, []string{}, []string{"TRIGGER", "RULE"})
+
+			Expect(resultStatements).To(Equal([]utils.StatementWithType{function}))
+		})
+	})
+	Describe("DependencyKey", func() {
+		It("includes the schema for schema-qualified objects", func() {
+			entry := utils.MetadataEntry{Schema: "schema", Name: "func(integer)", ObjectType: "FUNCTION"}
+
+			Expect(entry.DependencyKey()).To(Equal("FUNCTION schema.func(integer)"))
+		})
+		It("omits the schema for objects that have none", func() {
+			entry := utils.MetadataEntry{Name: "plpythonu", ObjectType: "LANGUAGE"}
+
+			Expect(entry.DependencyKey()).To(Equal("LANGUAGE plpythonu"))
+		})
+	})
+	Describe("AddDependency", func() {
+		It("records each dependency once, in sorted order", func() {
+			toc.AddDependency("TABLE schema.table1", "TYPE schema.type2")
+			toc.AddDependency("TABLE schema.table1", "TYPE schema.type1")
+			toc.AddDependency("TABLE schema.table1", "TYPE schema.type2")
+
+			Expect(toc.Dependencies).To(Equal(map[string][]string{"TABLE schema.table1": {"TYPE schema.type1", "TYPE schema.type2"}}))
+		})
+	})
+	Describe("GetDependencyClosure", func() {
+		BeforeEach(func() {
+			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: "schema", Name: "type1", ObjectType: "TYPE"}, 0, 0)
+			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: "schema", Name: "func(schema.type1)", ObjectType: "FUNCTION"}, 0, 0)
+			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: "schema", Name: "sequence", ObjectType: "SEQUENCE"}, 0, 0)
+			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: "schema", Name: "parent", ObjectType: "TABLE"}, 0, 0)
+			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: "schema", Name: "child", ObjectType: "TABLE"}, 0, 0)
+			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: "schema", Name: "unrelated", ObjectType: "TABLE"}, 0, 0)
+			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: "schema", Name: "constraint", ObjectType: "CONSTRAINT", ReferenceObject: "schema.child"}, 0, 0)
+			toc.AddDependency("TABLE schema.child", "TABLE schema.parent")
+			toc.AddDependency("TABLE schema.child", "SEQUENCE schema.sequence")
+			toc.AddDependency("TABLE schema.parent", "FUNCTION schema.func(schema.type1)")
+			toc.AddDependency("FUNCTION schema.func(schema.type1)", "TYPE schema.type1")
+		})
+		It("returns direct and indirect dependencies in TOC order", func() {
+			closure := toc.GetDependencyClosure([]string{"schema.child"})
+
+			Expect(closure).To(Equal([]utils.MetadataEntry{
+				{Schema: "schema", Name: "type1", ObjectType: "TYPE"},
+				{Schema: "schema", Name: "func(schema.type1)", ObjectType: "FUNCTION"},
+				{Schema: "schema", Name: "sequence", ObjectType: "SEQUENCE"},
+				{Schema: "schema", Name: "parent", ObjectType: "TABLE"},
+			}))
+		})
+		It("does not return relations that were included directly", func() {
+			closure := toc.GetDependencyClosure([]string{"schema.child", "schema.parent"})
+
+			Expect(closure).To(Equal([]utils.MetadataEntry{
+				{Schema: "schema", Name: "type1", ObjectType: "TYPE"},
+				{Schema: "schema", Name: "func(schema.type1)", ObjectType: "FUNCTION"},
+				{Schema: "schema", Name: "sequence", ObjectType: "SEQUENCE"},
+			}))
+		})
+		It("returns nothing for a relation without dependencies", func() {
+			closure := toc.GetDependencyClosure([]string{"schema.unrelated"})
+
+			Expect(closure).To(BeEmpty())
+		})
+	})
+	Describe("GetDistributionPolicy", func() {
+		It("returns the distribution clause of a table", func() {
+			Expect(utils.GetDistributionPolicy("CREATE TABLE public.foo (\n\ti integer,\n\t\"j,k\" text\n) WITH (appendonly=true) DISTRIBUTED BY (i, \"j,k\");")).To(Equal(`DISTRIBUTED BY (i, "j,k")`))
+			Expect(utils.GetDistributionPolicy("CREATE TABLE public.foo (\n\ti integer\n) DISTRIBUTED REPLICATED;")).To(Equal("DISTRIBUTED REPLICATED"))
+		})
+		It("returns the distribution clause of a partitioned table", func() {
+			Expect(utils.GetDistributionPolicy("CREATE TABLE public.foo (\n\ti integer\n) DISTRIBUTED RANDOMLY PARTITION BY LIST(i) (PARTITION p1 VALUES(1) WITH (tablename='foo_1_prt_p1'));")).To(Equal("DISTRIBUTED RANDOMLY"))
+		})
+		It("does not match the text of column defaults or of other statements", func() {
+			Expect(utils.GetDistributionPolicy("CREATE TABLE public.foo (\n\tnote text DEFAULT 'DISTRIBUTED REPLICATED'::text\n) DISTRIBUTED RANDOMLY;")).To(Equal("DISTRIBUTED RANDOMLY"))
+			Expect(utils.GetDistributionPolicy("CREATE TABLE public.foo (\n\ti integer\n);\nCOMMENT ON TABLE public.foo IS 'DISTRIBUTED REPLICATED';")).To(Equal(""))
+			Expect(utils.GetDistributionPolicy("ALTER TABLE public.foo OWNER TO testrole;")).To(Equal(""))
+		})
+	})
+	Describe("GetReplicatedTables", func() {
+		It("returns the tables with a replicated distribution policy", func() {
+			statements := []utils.StatementWithType{
+				{Schema: "public", Name: "replicated", ObjectType: "TABLE", Statement: "CREATE TABLE public.replicated (\n\ti integer\n) DISTRIBUTED REPLICATED;"},
+				{Schema: "public", Name: "hashed", ObjectType: "TABLE", Statement: "CREATE TABLE public.hashed (\n\tnote text DEFAULT 'DISTRIBUTED REPLICATED'::text\n) DISTRIBUTED BY (note);"},
+				{Schema: "public", Name: "hashed", ObjectType: "TABLE", Statement: "COMMENT ON TABLE public.hashed IS 'DISTRIBUTED REPLICATED';"},
+			}
+
+			Expect(utils.GetReplicatedTables(statements)).To(Equal(map[string]bool{"public.replicated": true}))
+		})
+	})
 	Describe("GetIncludedPartitionRoots", func() {
 		It("does not return anything if relations are not leaf partitions", func() {
-			toc.AddMasterDataEntry("schema0", "name0", 0, "attribute0", 1, "")
-			toc.AddMasterDataEntry("schema1", "name1", 1, "attribute0", 1, "")
+			toc.AddMasterDataEntry("schema0", "name0", 0, "attribute0", 1, "", false)
+			toc.AddMasterDataEntry("schema1", "name1", 1, "attribute0", 1, "", false)
 			roots := utils.GetIncludedPartitionRoots(toc.DataEntries, []string{"schema0.name0", "schema1.name1"})
 			Expect(roots).To(BeEmpty())
 		})
 		It("returns root parition of leaf partitions", func() {
-			toc.AddMasterDataEntry("schema0", "name0", 2, "attribute0", 1, "root0")
-			toc.AddMasterDataEntry("schema1", "name1", 3, "attribute0", 1, "root1")
+			toc.AddMasterDataEntry("schema0", "name0", 2, "attribute0", 1, "root0", false)
+			toc.AddMasterDataEntry("schema1", "name1", 3, "attribute0", 1, "root1", false)
 			roots := utils.GetIncludedPartitionRoots(toc.DataEntries, []string{"schema0.name0", "schema1.name1"})
 			Expect(roots).To(ConsistOf("schema0.root0", "schema1.root1"))
 		})
 		It("only returns root partitions of leaf partitions", func() {
-			toc.AddMasterDataEntry("schema0", "name0", 0, "attribute0", 1, "")
-			toc.AddMasterDataEntry("schema1", "name1", 1, "attribute0", 1, "")
-			toc.AddMasterDataEntry("schema2", "name2", 2, "attribute0", 1, "root2")
-			toc.AddMasterDataEntry("schema3", "name3", 3, "attribute0", 1, "root3")
+			toc.AddMasterDataEntry("schema0", "name0", 0, "attribute0", 1, "", false)
+			toc.AddMasterDataEntry("schema1", "name1", 1, "attribute0", 1, "", false)
+			toc.AddMasterDataEntry("schema2", "name2", 2, "attribute0", 1, "root2", false)
+			toc.AddMasterDataEntry("schema3", "name3", 3, "attribute0", 1, "root3", false)
 			roots := utils.GetIncludedPartitionRoots(toc.DataEntries, []string{"schema2.name2", "schema3.name3"})
 			Expect(roots).To(ConsistOf("schema2.root2", "schema3.root3"))
 		})
@@ -400,18 +539,18 @@ COMMENT ON DATABASE "db-special-chär$" IS 'this is a database comment';`}
 			Expect(roots).To(BeEmpty())
 		})
 		It("returns nothing if relation is not part of TOC data entries", func() {
-			toc.AddMasterDataEntry("schema0", "name0", 0, "attribute0", 1, "")
-			toc.AddMasterDataEntry("schema1", "name1", 1, "attribute0", 1, "")
-			toc.AddMasterDataEntry("schema2", "name2", 2, "attribute0", 1, "root2")
-			toc.AddMasterDataEntry("schema3", "name3", 3, "attribute0", 1, "root3")
+			toc.AddMasterDataEntry("schema0", "name0", 0, "attribute0", 1, "", false)
+			toc.AddMasterDataEntry("schema1", "name1", 1, "attribute0", 1, "", false)
+			toc.AddMasterDataEntry("schema2", "name2", 2, "attribute0", 1, "root2", false)
+			toc.AddMasterDataEntry("schema3", "name3", 3, "attribute0", 1, "root3", false)
 			roots := utils.GetIncludedPartitionRoots(toc.DataEntries, []string{"schema4.name4", "schema5.name5"})
 			Expect(roots).To(BeEmpty())
 		})
 		It("returns empty if no relations are passed in", func() {
-			toc.AddMasterDataEntry("schema0", "name0", 0, "attribute0", 1, "")
-			toc.AddMasterDataEntry("schema1", "name1", 1, "attribute0", 1, "")
-			toc.AddMasterDataEntry("schema2", "name2", 2, "attribute0", 1, "root2")
-			toc.AddMasterDataEntry("schema3", "name3", 3, "attribute0", 1, "root3")
+			toc.AddMasterDataEntry("schema0", "name0", 0, "attribute0", 1, "", false)
+			toc.AddMasterDataEntry("schema1", "name1", 1, "attribute0", 1, "", false)
+			toc.AddMasterDataEntry("schema2", "name2", 2, "attribute0", 1, "root2", false)
+			toc.AddMasterDataEntry("schema3", "name3", 3, "attribute0", 1, "root3", false)
 			roots := utils.GetIncludedPartitionRoots(toc.DataEntries, []string{})
 			Expect(roots).To(BeEmpty())
 		})